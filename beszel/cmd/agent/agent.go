@@ -3,14 +3,26 @@ package main
 import (
 	"beszel"
 	"beszel/internal/agent"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
+// shutdownTimeout is how long to wait for in-flight sessions and collectors on shutdown
+const shutdownTimeout = 10 * time.Second
+
 // cli options
 type cmdOptions struct {
 	key    string // key is the public key(s) for SSH authentication.
@@ -68,21 +80,42 @@ func (opts *cmdOptions) parse() bool {
 }
 
 // loadPublicKeys loads the public keys from the command line flag, environment variable, or key file.
+// Keys from the BESZEL_AUTHORIZED_KEYS_FILE env var are merged with any of the above.
 func (opts *cmdOptions) loadPublicKeys() ([]ssh.PublicKey, error) {
+	var authorizedKeys []ssh.PublicKey
+	if path, ok := agent.GetEnvFallback("BESZEL_AGENT_AUTHORIZED_KEYS_FILE", "BESZEL_AUTHORIZED_KEYS_FILE"); ok {
+		var err error
+		if authorizedKeys, err = agent.ParseKeysFromFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	keys, err := opts.loadKeys()
+	if err != nil && !(errors.Is(err, errNoKey) && len(authorizedKeys) > 0) {
+		return nil, err
+	}
+	return append(keys, authorizedKeys...), nil
+}
+
+// errNoKey is returned when no key source is configured
+var errNoKey = errors.New("no key provided: must set -key flag, KEY env var, KEY_FILE env var, or BESZEL_AUTHORIZED_KEYS_FILE env var. Use 'beszel-agent help' for usage")
+
+// loadKeys loads the public keys from the command line flag, KEY env var, or KEY_FILE env var.
+func (opts *cmdOptions) loadKeys() ([]ssh.PublicKey, error) {
 	// Try command line flag first
 	if opts.key != "" {
 		return agent.ParseKeys(opts.key)
 	}
 
 	// Try environment variable
-	if key, ok := agent.GetEnv("KEY"); ok && key != "" {
+	if key, ok := agent.GetEnvFallback("BESZEL_AGENT_KEY", "BESZEL_KEY", "KEY"); ok {
 		return agent.ParseKeys(key)
 	}
 
 	// Try key file
 	keyFile, ok := agent.GetEnv("KEY_FILE")
 	if !ok {
-		return nil, fmt.Errorf("no key provided: must set -key flag, KEY env var, or KEY_FILE env var. Use 'beszel-agent help' for usage")
+		return nil, errNoKey
 	}
 
 	pubKey, err := os.ReadFile(keyFile)
@@ -96,7 +129,98 @@ func (opts *cmdOptions) getAddress() string {
 	return agent.GetAddress(opts.listen)
 }
 
+// getKeyRotationWindow returns how long replaced keys are accepted after a reload,
+// from the KEY_ROTATION_WINDOW environment variable (e.g. "5m").
+func getKeyRotationWindow() (time.Duration, error) {
+	window, ok := agent.GetEnv("KEY_ROTATION_WINDOW")
+	if !ok || window == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(window)
+}
+
+// getUnixSocketMode returns the unix socket file permissions from the
+// UNIX_SOCKET_MODE environment variable in octal (e.g. "0660"), or 0 for the default.
+func getUnixSocketMode() (os.FileMode, error) {
+	mode, ok := agent.GetEnv("UNIX_SOCKET_MODE")
+	if !ok || mode == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || value > 0o777 {
+		return 0, fmt.Errorf("invalid mode %q", mode)
+	}
+	return os.FileMode(value), nil
+}
+
+// reloadKeysOnSignal reloads the public keys when the process receives SIGHUP.
+func (opts *cmdOptions) reloadKeysOnSignal(a *agent.Agent) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	for range sigChan {
+		keys, err := opts.loadPublicKeys()
+		if err != nil {
+			log.Println("Failed to reload public keys:", err)
+			continue
+		}
+		a.ReloadKeys(keys)
+	}
+}
+
+// newLogger creates a logger writing to w with the level and format set by the
+// BESZEL_LOG_LEVEL (debug, info, warn, error) and BESZEL_LOG_FORMAT (text, json)
+// env vars. LOG_LEVEL is still read for older setups. The returned logger uses
+// the defaults if a value is invalid.
+func newLogger(w io.Writer) (*slog.Logger, error) {
+	var err error
+	level := slog.LevelInfo
+	if levelStr, ok := agent.GetEnvFallback("BESZEL_AGENT_LOG_LEVEL", "BESZEL_LOG_LEVEL", "LOG_LEVEL"); ok {
+		if levelErr := level.UnmarshalText([]byte(levelStr)); levelErr != nil {
+			level = slog.LevelInfo
+			err = fmt.Errorf("invalid LOG_LEVEL %q", levelStr)
+		}
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	format, _ := agent.GetEnvFallback("BESZEL_AGENT_LOG_FORMAT", "BESZEL_LOG_FORMAT")
+	switch format = strings.ToLower(format); format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, handlerOpts)), err
+	case "", "text":
+	default:
+		err = errors.Join(err, fmt.Errorf("invalid LOG_FORMAT %q", format))
+	}
+	return slog.New(slog.NewTextHandler(w, handlerOpts)), err
+}
+
+// shutdownOnSignal shuts down the agent when the process receives SIGINT or SIGTERM.
+// The returned channel is closed once shutdown is complete.
+func shutdownOnSignal(a *agent.Agent) <-chan struct{} {
+	done := make(chan struct{})
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		defer close(done)
+		sig := <-sigChan
+		signal.Stop(sigChan)
+		slog.Info("Shutting down", "signal", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := a.Shutdown(ctx); err != nil {
+			slog.Error("Error shutting down", "err", err)
+		}
+	}()
+	return done
+}
+
 func main() {
+	// set up logging before anything else so all components inherit it
+	logger, err := newLogger(os.Stderr)
+	slog.SetDefault(logger)
+	if err != nil {
+		slog.Warn("Using default log config", "err", err)
+	}
+
 	var opts cmdOptions
 	subcommandHandled := opts.parse()
 
@@ -105,18 +229,59 @@ func main() {
 	}
 
 	var serverConfig agent.ServerOptions
-	var err error
 	serverConfig.Keys, err = opts.loadPublicKeys()
 	if err != nil {
 		log.Fatal("Failed to load public keys:", err)
 	}
 
+	serverConfig.KeyRotationWindow, err = getKeyRotationWindow()
+	if err != nil {
+		log.Fatal("Invalid KEY_ROTATION_WINDOW:", err)
+	}
+
 	addr := opts.getAddress()
 	serverConfig.Addr = addr
 	serverConfig.Network = agent.GetNetwork(addr)
 
+	serverConfig.UnixSocketMode, err = getUnixSocketMode()
+	if err != nil {
+		log.Fatal("Invalid UNIX_SOCKET_MODE:", err)
+	}
+	serverConfig.UnixSocketOwner, _ = agent.GetEnv("UNIX_SOCKET_OWNER")
+	// agents polled by several hubs must allow concurrent stats sessions
+	allowMultipleSessions, _ := agent.GetEnv("ALLOW_MULTIPLE_SESSIONS")
+	serverConfig.AllowMultipleSessions = allowMultipleSessions == "true"
+
+	// SNMP is optional and has no default community, since it is sent in plain text
+	snmpAddr, snmpEnabled := agent.GetEnv("SNMP_ADDR")
+	snmpCommunity, _ := agent.GetEnv("SNMP_COMMUNITY")
+	if snmpEnabled && snmpCommunity == "" {
+		log.Fatal("SNMP_COMMUNITY is required with SNMP_ADDR")
+	}
+
+	// the status page is optional, for checking on the agent without the hub
+	statusAddr, statusEnabled := agent.GetEnv("STATUS_ADDR")
+
 	agent := agent.NewAgent()
+	go opts.reloadKeysOnSignal(agent)
+	shutdownDone := shutdownOnSignal(agent)
+	if snmpEnabled {
+		go func() {
+			if err := agent.StartSNMPServer(snmpAddr, snmpCommunity); err != nil {
+				slog.Error("SNMP server stopped", "err", err)
+			}
+		}()
+	}
+	if statusEnabled {
+		go func() {
+			if err := agent.StartStatusPage(statusAddr); err != nil {
+				slog.Error("Status page stopped", "err", err)
+			}
+		}()
+	}
 	if err := agent.StartServer(serverConfig); err != nil {
 		log.Fatal("Failed to start server:", err)
 	}
+	// StartServer returns once the listener is closed during shutdown
+	<-shutdownDone
 }
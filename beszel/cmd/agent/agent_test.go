@@ -2,11 +2,16 @@ package main
 
 import (
 	"beszel/internal/agent"
+	"bytes"
 	"crypto/ed25519"
+	"encoding/json"
 	"flag"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -55,6 +60,24 @@ func TestGetAddress(t *testing.T) {
 			},
 			expected: ":7070",
 		},
+		{
+			name: "prefixed LISTEN takes precedence over PORT",
+			opts: cmdOptions{},
+			envVars: map[string]string{
+				"BESZEL_AGENT_LISTEN": "127.0.0.1:9090",
+				"PORT":                "7070",
+			},
+			expected: "127.0.0.1:9090",
+		},
+		{
+			name: "empty LISTEN falls back to PORT",
+			opts: cmdOptions{},
+			envVars: map[string]string{
+				"LISTEN": "",
+				"PORT":   "7070",
+			},
+			expected: ":7070",
+		},
 		{
 			name: "use unix socket from env var",
 			opts: cmdOptions{
@@ -119,6 +142,12 @@ func TestLoadPublicKeys(t *testing.T) {
 				"KEY": string(pubKey),
 			},
 		},
+		{
+			name: "load key from BESZEL_KEY env var",
+			envVars: map[string]string{
+				"BESZEL_KEY": string(pubKey),
+			},
+		},
 		{
 			name: "load key from file",
 			envVars: map[string]string{
@@ -186,6 +215,47 @@ func TestLoadPublicKeys(t *testing.T) {
 	}
 }
 
+func TestLoadPublicKeysAuthorizedKeysFile(t *testing.T) {
+	newKey := func() ssh.PublicKey {
+		_, priv, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+		signer, err := ssh.NewSignerFromKey(priv)
+		require.NoError(t, err)
+		return signer.PublicKey()
+	}
+	inlineKey := newKey()
+	fileKey := newKey()
+
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	content := "# hub keys\nno-pty,no-port-forwarding " + string(ssh.MarshalAuthorizedKey(fileKey))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	t.Run("file only", func(t *testing.T) {
+		t.Setenv("BESZEL_AUTHORIZED_KEYS_FILE", path)
+		keys, err := (&cmdOptions{}).loadPublicKeys()
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		assert.Equal(t, fileKey.Marshal(), keys[0].Marshal())
+	})
+
+	t.Run("merged with inline key", func(t *testing.T) {
+		t.Setenv("BESZEL_AUTHORIZED_KEYS_FILE", path)
+		t.Setenv("KEY", string(ssh.MarshalAuthorizedKey(inlineKey)))
+		keys, err := (&cmdOptions{}).loadPublicKeys()
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+		assert.Equal(t, inlineKey.Marshal(), keys[0].Marshal())
+		assert.Equal(t, fileKey.Marshal(), keys[1].Marshal())
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		t.Setenv("BESZEL_AUTHORIZED_KEYS_FILE", filepath.Join(t.TempDir(), "missing"))
+		t.Setenv("KEY", string(ssh.MarshalAuthorizedKey(inlineKey)))
+		_, err := (&cmdOptions{}).loadPublicKeys()
+		assert.ErrorContains(t, err, "failed to read authorized keys file")
+	})
+}
+
 func TestGetNetwork(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -301,3 +371,217 @@ func TestParseFlags(t *testing.T) {
 		})
 	}
 }
+
+func TestGetKeyRotationWindow(t *testing.T) {
+	tests := []struct {
+		name     string
+		envValue string
+		setEnv   bool
+		expected time.Duration
+		wantErr  bool
+	}{
+		{
+			name:     "not set",
+			expected: 0,
+		},
+		{
+			name:     "valid duration",
+			envValue: "5m",
+			setEnv:   true,
+			expected: 5 * time.Minute,
+		},
+		{
+			name:     "invalid duration",
+			envValue: "five minutes",
+			setEnv:   true,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setEnv {
+				t.Setenv("BESZEL_AGENT_KEY_ROTATION_WINDOW", tt.envValue)
+			}
+			window, err := getKeyRotationWindow()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, window)
+		})
+	}
+}
+
+func TestGetUnixSocketMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		envValue string
+		setEnv   bool
+		expected os.FileMode
+		wantErr  bool
+	}{
+		{
+			name:     "not set",
+			expected: 0,
+		},
+		{
+			name:     "octal mode",
+			envValue: "0660",
+			setEnv:   true,
+			expected: 0o660,
+		},
+		{
+			name:     "without leading zero",
+			envValue: "666",
+			setEnv:   true,
+			expected: 0o666,
+		},
+		{
+			name:     "not octal",
+			envValue: "rw-rw----",
+			setEnv:   true,
+			wantErr:  true,
+		},
+		{
+			name:     "too large",
+			envValue: "7777",
+			setEnv:   true,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setEnv {
+				t.Setenv("BESZEL_AGENT_UNIX_SOCKET_MODE", tt.envValue)
+			}
+			mode, err := getUnixSocketMode()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, mode)
+		})
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		name      string
+		envVars   map[string]string
+		wantJSON  bool
+		wantDebug bool
+		wantInfo  bool
+		wantErr   bool
+	}{
+		{
+			name:     "defaults to text at info",
+			wantInfo: true,
+		},
+		{
+			name:      "json at debug",
+			envVars:   map[string]string{"BESZEL_LOG_LEVEL": "debug", "BESZEL_LOG_FORMAT": "json"},
+			wantJSON:  true,
+			wantDebug: true,
+			wantInfo:  true,
+		},
+		{
+			name:    "text at warn",
+			envVars: map[string]string{"BESZEL_LOG_LEVEL": "warn", "BESZEL_LOG_FORMAT": "text"},
+		},
+		{
+			name:      "BESZEL_AGENT_ prefix takes precedence",
+			envVars:   map[string]string{"BESZEL_AGENT_LOG_LEVEL": "debug", "BESZEL_LOG_LEVEL": "warn", "BESZEL_AGENT_LOG_FORMAT": "json"},
+			wantJSON:  true,
+			wantDebug: true,
+			wantInfo:  true,
+		},
+		{
+			name:      "legacy LOG_LEVEL env var",
+			envVars:   map[string]string{"LOG_LEVEL": "debug"},
+			wantDebug: true,
+			wantInfo:  true,
+		},
+		{
+			name:     "invalid values use defaults",
+			envVars:  map[string]string{"BESZEL_LOG_LEVEL": "verbose", "BESZEL_LOG_FORMAT": "xml"},
+			wantInfo: true,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.envVars {
+				t.Setenv(k, v)
+			}
+
+			var buf bytes.Buffer
+			logger, err := newLogger(&buf)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			logger.Debug("debug message")
+			logger.Info("info message")
+			logger.Warn("warn message")
+			output := buf.String()
+
+			assert.Equal(t, tt.wantDebug, strings.Contains(output, "debug message"))
+			assert.Equal(t, tt.wantInfo, strings.Contains(output, "info message"))
+			assert.Contains(t, output, "warn message")
+
+			for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+				var entry map[string]any
+				isJSON := json.Unmarshal([]byte(line), &entry) == nil
+				assert.Equal(t, tt.wantJSON, isJSON, line)
+			}
+		})
+	}
+}
+
+func TestShutdownOnSignal(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+
+	socketPath := filepath.Join(t.TempDir(), "beszel.sock")
+	a := agent.NewAgent()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- a.StartServer(agent.ServerOptions{
+			Network: "unix",
+			Addr:    socketPath,
+			Keys:    []ssh.PublicKey{signer.PublicKey()},
+		})
+	}()
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(socketPath)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	done := shutdownOnSignal(a)
+	process, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	require.NoError(t, process.Signal(syscall.SIGTERM))
+
+	select {
+	case err := <-serverErr:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("StartServer did not return after SIGTERM")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not complete")
+	}
+	assert.NoFileExists(t, socketPath)
+}
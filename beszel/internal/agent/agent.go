@@ -4,67 +4,153 @@ package agent
 import (
 	"beszel"
 	"beszel/internal/entities/system"
+	"context"
+	"errors"
 	"log/slog"
+	"net/http"
 	"os"
-	"strings"
+	"regexp"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 type Agent struct {
-	sync.Mutex                               // Used to lock agent while collecting data
-	debug         bool                       // true if LOG_LEVEL is set to debug
-	zfs           bool                       // true if system has arcstats
-	memCalc       string                     // Memory calculation formula
-	fsNames       []string                   // List of filesystem device names being monitored
-	fsStats       map[string]*system.FsStats // Keeps track of disk stats for each filesystem
-	netInterfaces map[string]struct{}        // Stores all valid network interfaces
-	netIoStats    system.NetIoStats          // Keeps track of bandwidth usage
-	dockerManager *dockerManager             // Manages Docker API requests
-	sensorConfig  *SensorConfig              // Sensors config
-	systemInfo    system.Info                // Host system info
-	gpuManager    *GPUManager                // Manages GPU data
-	cache         *SessionCache              // Cache for system stats based on primary session ID
+	sync.Mutex                                            // Used to lock agent while collecting data
+	debug             bool                                // true if the logger is enabled for debug
+	log               *slog.Logger                        // Logger set with WithLogger, see logger
+	zfs               bool                                // true if system has arcstats
+	memCalc           string                              // Memory calculation formula
+	fsNames           []string                            // List of filesystem device names being monitored
+	fsStats           map[string]*system.FsStats          // Keeps track of disk stats for each filesystem
+	netInterfaces     map[string]struct{}                 // Stores all valid network interfaces
+	netIoStats        system.NetIoStats                   // Keeps track of bandwidth usage
+	netCounters       map[string]netCounters              // Bytes per network interface at the last collection
+	netInclude        []*regexp.Regexp                    // Only interfaces matching NET_INCLUDE are collected if set
+	netExclude        []*regexp.Regexp                    // Interfaces matching NET_EXCLUDE are never collected
+	ebpfNet           *EBPFNetCollector                   // Counts packets per protocol, nil unless enabled
+	dockerManager     *dockerManager                      // Manages Docker API requests
+	sensorConfig      *SensorConfig                       // Sensors config
+	systemInfo        system.Info                         // Host system info
+	meta              system.AgentMeta                    // Agent version and build metadata
+	tags              map[string]string                   // Labels from BESZEL_TAGS, set once at startup and never modified
+	gpuManager        *GPUManager                         // Manages GPU data
+	alerter           *SyslogAlerter                      // Sends alerts to syslog, nil unless enabled
+	notifier          *NotificationDialer                 // Pushes alerts to the hub, nil unless BESZEL_HUB_ADDR is set
+	nats              *NATSPublisher                      // Publishes stats to NATS, nil unless BESZEL_NATS_URL is set
+	remoteGPU         *RemoteGPUCollector                 // Runs nvidia-smi over SSH, nil unless BESZEL_REMOTE_GPU_HOSTS is set
+	attachMu          sync.Mutex                          // Guards gpuTopoSession, capabilities, and capsSession
+	gpuTopoSession    string                              // SSH session that last received the GPU topology
+	capabilities      *system.AgentCapabilities           // Static host features, read on the first connection
+	capsSession       string                              // SSH session that last received the capabilities
+	cpuThermal        *CPUThermalCollector                // Reads CPU temperatures from hwmon
+	perf              *PerfCollector                      // Reads hardware cache and branch counters, nil unless enabled
+	irq               *IRQCollector                       // Computes interrupt rates, nil if /proc/interrupts is missing
+	remoteFS          *RemoteFSCollector                  // Reads NFS and CIFS mount I/O, nil if mountstats is missing
+	schedStat         *SchedStatCollector                 // Computes runqueue latency, nil if /proc/schedstat is missing
+	ipmi              *IPMICollector                      // Reads BMC sensors, nil unless enabled
+	kubelet           *KubeletCollector                   // Reads pod stats, nil unless configured
+	cache             *SessionCache                       // Cache for system stats based on primary session ID
+	delta             deltaState                          // Last stats sent to the hub in delta mode
+	statsBuffer       *StatsRingBuffer                    // Stats collected while the hub is unreachable, nil if disabled
+	lastStatsRequest  atomic.Int64                        // Time of the last stats request (unix nanoseconds)
+	keys              atomic.Pointer[keySet]              // Public keys accepted by the SSH server
+	auditLogger       AuditLogger                         // Records SSH authentication events
+	metrics           collectionMetrics                   // Collection latency per subsystem
+	startTime         time.Time                           // When the agent was created, reported in diagnostics
+	collectionCycles  atomic.Uint64                       // Stats collections completed by gatherStats, excluding cached responses
+	collectionTime    atomic.Int64                        // Nanoseconds spent in those collections
+	lastCollection    atomic.Int64                        // Time the last stats collection finished (unix nanoseconds)
+	cpuStats          *subsystem                          // CPU usage, on demand or in the background
+	diskStats         *subsystem                          // Disk usage and I/O, on demand or in the background
+	netStats          *subsystem                          // Network bandwidth, on demand or in the background
+	prewarmInterval   time.Duration                       // How often stats are gathered ahead of hub requests, 0 to disable
+	collectionTimeout time.Duration                       // How long gatherStats waits for a collection, 0 to wait indefinitely
+	lastCompleted     atomic.Pointer[system.CombinedData] // Copy of the last completed collection, sent if a collection times out
+	stalled           atomic.Pointer[chan struct{}]       // Closed when the last timed out collection finishes, nil if none
+	prewarmed         atomic.Pointer[system.CombinedData] // Latest pre-warmed stats, nil until the first collection
+	collectionCancel  context.CancelFunc                  // Stops background subsystem collection
+	collectionWg      sync.WaitGroup                      // Background subsystem collection goroutines
+	server            atomic.Pointer[ssh.Server]          // Running SSH server, used by Shutdown
+	snmpServer        atomic.Pointer[snmpServer]          // Running SNMP server, used by Shutdown
+	statusServer      atomic.Pointer[http.Server]         // Running status page server, used by Shutdown
+	keepAliveInterval time.Duration                       // How often keepalives are sent on open sessions, 0 to disable
+	multiSession      bool                                // Set from ServerOptions.AllowMultipleSessions
+	activeSession     atomic.Bool                         // True while a stats session is handled, unless multiSession is set
+	sessions          sessionGroup                        // In-flight SSH sessions
+	activeConns       atomic.Int64                        // Number of SSH sessions being handled
+	socketPath        string                              // Unix socket file to remove on shutdown
 }
 
-func NewAgent() *Agent {
+// NewAgent creates an agent configured from environment variables and opts, and
+// starts collecting stats in the background
+func NewAgent(opts ...AgentOption) *Agent {
 	agent := &Agent{
-		fsStats: make(map[string]*system.FsStats),
-		cache:   NewSessionCache(69 * time.Second),
+		fsStats:   make(map[string]*system.FsStats),
+		cache:     NewSessionCache(69 * time.Second),
+		startTime: time.Now(),
 	}
 	agent.memCalc, _ = GetEnv("MEM_CALC")
+	if tags, exists := GetEnvFallback("BESZEL_AGENT_TAGS", "BESZEL_TAGS"); exists {
+		agent.tags = parseTags(tags)
+	}
 	agent.sensorConfig = agent.newSensorConfig()
-	// Set up slog with a log level determined by the LOG_LEVEL env var
-	if logLevelStr, exists := GetEnv("LOG_LEVEL"); exists {
-		switch strings.ToLower(logLevelStr) {
-		case "debug":
-			agent.debug = true
-			slog.SetLogLoggerLevel(slog.LevelDebug)
-		case "warn":
-			slog.SetLogLoggerLevel(slog.LevelWarn)
-		case "error":
-			slog.SetLogLoggerLevel(slog.LevelError)
-		}
+	agent.cpuThermal = newCPUThermalCollector(cpuHwmonPath())
+	agent.perf = newPerfCollector()
+	agent.irq = newIRQCollector(procInterrupts)
+	agent.remoteFS = newRemoteFSCollector("/proc")
+	agent.schedStat = newSchedStatCollector(procSchedstat)
+	agent.ipmi = newIPMICollector()
+	agent.kubelet = newKubeletCollector()
+	agent.auditLogger = newAuditLogger()
+	if deltaMode, _ := GetEnv("DELTA_MODE"); deltaMode == "true" {
+		agent.EnableDeltaMode(true)
 	}
+	if size := statsBufferSize(); size > 0 {
+		agent.statsBuffer = NewStatsRingBuffer(size)
+	}
+	agent.initializeSubsystems(collectionConfigFromEnv())
+	for _, opt := range opts {
+		opt(agent)
+	}
+	// log level is configured in main via the default logger, unless set with WithLogger
+	agent.debug = agent.logger().Enabled(context.Background(), slog.LevelDebug)
 
-	slog.Debug(beszel.Version)
+	agent.logger().Debug(beszel.Version)
 
 	// initialize system info / docker manager
 	agent.initializeSystemInfo()
 	agent.initializeDiskInfo()
+	agent.netInclude = netPatternsFromEnv("BESZEL_AGENT_NET_INCLUDE", "BESZEL_NET_INCLUDE")
+	agent.netExclude = netPatternsFromEnv("BESZEL_AGENT_NET_EXCLUDE", "BESZEL_NET_EXCLUDE")
 	agent.initializeNetIoStats()
+	agent.ebpfNet = newEBPFNetCollector(agent.netInterfaces)
 	agent.dockerManager = newDockerManager(agent)
+	agent.notifier = newNotificationDialer(agent.systemInfo.Hostname, func(key gossh.PublicKey) bool {
+		return agent.isAuthorizedKey(key)
+	})
+	agent.alerter = newSyslogAlerter(agent.systemInfo.Hostname, agent.notifier)
+	agent.nats = newNATSPublisher(agent.systemInfo.Hostname)
+	agent.remoteGPU = newRemoteGPUCollector(agent.alerter)
 
-	// initialize GPU manager
-	if gm, err := NewGPUManager(); err != nil {
-		slog.Debug("GPU", "err", err)
-	} else {
-		agent.gpuManager = gm
+	// initialize GPU manager, unless set with WithGPUManager
+	if agent.gpuManager == nil {
+		if gm, err := NewGPUManager(GPUManagerOptions{Alerter: agent.alerter}); err != nil {
+			agent.logger().Debug("GPU", "err", err)
+		} else {
+			agent.gpuManager = gm
+		}
 	}
 
+	agent.startBackgroundCollection()
+
 	// if debugging, print stats
 	if agent.debug {
-		slog.Debug("Stats", "data", agent.gatherStats(""))
+		stats, err := agent.gatherStats("")
+		agent.logger().Debug("Stats", "data", stats, "err", err)
 	}
 
 	return agent
@@ -79,39 +165,133 @@ func GetEnv(key string) (value string, exists bool) {
 	return os.LookupEnv(key)
 }
 
-func (a *Agent) gatherStats(sessionID string) *system.CombinedData {
+// GetEnvFallback returns the value of the first environment variable in names that
+// is set to a non-empty value. Names are used as is, without the "BESZEL_AGENT_" prefix.
+func GetEnvFallback(names ...string) (value string, exists bool) {
+	for _, name := range names {
+		if value, exists = os.LookupEnv(name); exists && value != "" {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// errStatsTimeout is returned by gatherStats if a collection times out before
+// any collection has completed, so there are no stats to send
+var errStatsTimeout = errors.New("stats collection timed out")
+
+// gatherStats returns the stats for sessionID, collecting them unless they are
+// cached. If the collection doesn't finish within the collection timeout, a copy
+// of the stats of the last completed collection is returned and the collection
+// continues in the background. Requests made while a timed out collection is
+// still running wait for it rather than starting another collection.
+func (a *Agent) gatherStats(sessionID string) (*system.CombinedData, error) {
+	if a.collectionTimeout <= 0 {
+		return a.collectStats(context.Background(), sessionID), nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.collectionTimeout)
+	defer cancel()
+	if stalled := a.stalled.Load(); stalled != nil {
+		select {
+		case <-*stalled:
+			a.stalled.CompareAndSwap(stalled, nil)
+		case <-ctx.Done():
+			return a.lastStats(sessionID)
+		}
+	}
+	// collectors that don't support ctx can block, so wait in a select
+	result := make(chan *system.CombinedData, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		result <- a.collectStats(ctx, sessionID)
+	}()
+	select {
+	case data := <-result:
+		return data, nil
+	case <-ctx.Done():
+	}
+	select {
+	case data := <-result:
+		return data, nil
+	default:
+	}
+	a.stalled.Store(&done)
+	return a.lastStats(sessionID)
+}
+
+// lastStats returns a copy of the stats of the last completed collection, with
+// the GPU topology and capabilities attached for sessionID, or errStatsTimeout
+// if no collection has completed
+func (a *Agent) lastStats(sessionID string) (*system.CombinedData, error) {
+	a.logger().Warn("Stats collection timed out, sending the last stats", "timeout", a.collectionTimeout, "session", sessionID)
+	last := a.lastCompleted.Load()
+	if last == nil {
+		return nil, errStatsTimeout
+	}
+	stats := *last
+	stats.Stats.GPUTopology = nil
+	stats.Capabilities = nil
+	a.attachGPUTopology(sessionID, &stats.Stats)
+	a.attachCapabilities(sessionID, &stats)
+	return &stats, nil
+}
+
+// collectStats gathers the stats for sessionID, passing ctx to collectors that
+// support cancellation
+func (a *Agent) collectStats(ctx context.Context, sessionID string) *system.CombinedData {
 	a.Lock()
 	defer a.Unlock()
 
 	cachedData, ok := a.cache.Get(sessionID)
 	if ok {
-		slog.Debug("Cached stats", "session", sessionID)
+		a.logger().Debug("Cached stats", "session", sessionID)
 		return cachedData
 	}
 
+	start := time.Now()
+	defer func() {
+		a.collectionCycles.Add(1)
+		a.collectionTime.Add(int64(time.Since(start)))
+		a.lastCollection.Store(time.Now().UnixNano())
+	}()
+
+	trackSystem := a.metrics.track("system")
 	*cachedData = system.CombinedData{
-		Stats: a.getSystemStats(),
+		Stats: a.getSystemStats(ctx),
 		Info:  a.systemInfo,
+		Meta:  a.meta,
+		Tags:  a.tags,
 	}
-	slog.Debug("System stats", "data", cachedData)
+	a.attachGPUTopology(sessionID, &cachedData.Stats)
+	a.attachCapabilities(sessionID, cachedData)
+	trackSystem()
+	a.logger().Debug("System stats", "data", cachedData)
 
 	if a.dockerManager != nil {
-		if containerStats, err := a.dockerManager.getDockerStats(); err == nil {
+		trackDocker := a.metrics.track("docker")
+		containerStats, err := a.dockerManager.getDockerStats(ctx)
+		trackDocker()
+		if err == nil {
 			cachedData.Containers = containerStats
-			slog.Debug("Docker stats", "data", cachedData.Containers)
+			a.logger().Debug("Docker stats", "data", cachedData.Containers)
 		} else {
-			slog.Debug("Docker stats", "err", err)
+			a.logger().Debug("Docker stats", "err", err)
 		}
 	}
 
 	cachedData.Stats.ExtraFs = make(map[string]*system.FsStats)
 	for name, stats := range a.fsStats {
 		if !stats.Root && stats.DiskTotal > 0 {
-			cachedData.Stats.ExtraFs[name] = stats
+			// copy since disk stats may be updated in the background
+			statsCopy := *stats
+			cachedData.Stats.ExtraFs[name] = &statsCopy
 		}
 	}
-	slog.Debug("Extra filesystems", "data", cachedData.Stats.ExtraFs)
+	a.logger().Debug("Extra filesystems", "data", cachedData.Stats.ExtraFs)
 
 	a.cache.Set(sessionID, cachedData)
+	completed := *cachedData
+	a.lastCompleted.Store(&completed)
 	return cachedData
 }
@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"beszel"
+	"beszel/internal/entities/system"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGatherStatsIncludesAgentMeta(t *testing.T) {
+	agent := NewAgent()
+
+	encoded, err := json.Marshal(gatherStats(t, agent, ""))
+	require.NoError(t, err)
+
+	var data system.CombinedData
+	require.NoError(t, json.Unmarshal(encoded, &data))
+	assert.NotEmpty(t, data.Meta)
+	assert.Equal(t, beszel.Version, data.Meta.Version)
+	assert.Equal(t, runtime.Version(), data.Meta.GoVersion)
+	assert.Equal(t, runtime.GOARCH, data.Meta.GOARCH)
+	assert.Equal(t, runtime.GOOS, data.Meta.GOOS)
+}
+
+func TestParseTags(t *testing.T) {
+	assert.Equal(t, map[string]string{"dc": "fra1", "rack": "r12", "env": ""},
+		parseTags(" dc=fra1, rack = r12 ,env=,"))
+	// invalid keys and pairs without a value are skipped
+	assert.Equal(t, map[string]string{"ok_2": "yes"},
+		parseTags("Bad=1,2nd=2,has-dash=3,novalue,ok_2=yes"))
+	assert.Nil(t, parseTags(""))
+}
+
+func TestGatherStatsIncludesTags(t *testing.T) {
+	t.Setenv("BESZEL_TAGS", "datacenter=fra1,rack=r12,environment=production,team=infra,"+
+		"region=eu_central,zone=b,cluster=k8s_prod,tier=backend,owner=ops,cost_center=4711")
+	agent := NewAgent()
+
+	encoded, err := json.Marshal(gatherStats(t, agent, ""))
+	require.NoError(t, err)
+
+	var data system.CombinedData
+	require.NoError(t, json.Unmarshal(encoded, &data))
+	assert.Equal(t, map[string]string{
+		"datacenter":  "fra1",
+		"rack":        "r12",
+		"environment": "production",
+		"team":        "infra",
+		"region":      "eu_central",
+		"zone":        "b",
+		"cluster":     "k8s_prod",
+		"tier":        "backend",
+		"owner":       "ops",
+		"cost_center": "4711",
+	}, data.Tags)
+}
+
+func TestIsValidHostname(t *testing.T) {
+	for _, name := range []string{"web-01", "db1.example.com", "a", "host.", "1host"} {
+		assert.True(t, isValidHostname(name), name)
+	}
+	for _, name := range []string{"", "-web", "web-", "under_score", "a..b", "spa ce", strings.Repeat("a", 64)} {
+		assert.False(t, isValidHostname(name), name)
+	}
+}
+
+func TestGatherStatsHostnameOverride(t *testing.T) {
+	systemHostname, _ := os.Hostname()
+
+	t.Run("override", func(t *testing.T) {
+		t.Setenv("BESZEL_HOSTNAME", "web-01.example.com")
+		assert.Equal(t, "web-01.example.com", gatherStats(t, NewAgent(), "").Info.Hostname)
+	})
+
+	t.Run("invalid override uses system hostname", func(t *testing.T) {
+		t.Setenv("BESZEL_HOSTNAME", "not_valid")
+		assert.Equal(t, systemHostname, gatherStats(t, NewAgent(), "").Info.Hostname)
+	})
+
+	t.Run("container HOSTNAME is ignored", func(t *testing.T) {
+		t.Setenv("HOSTNAME", "3f4e8a9b2c1d")
+		assert.Equal(t, systemHostname, gatherStats(t, NewAgent(), "").Info.Hostname)
+	})
+}
+
+func TestGetEnvFallback(t *testing.T) {
+	t.Setenv("BESZEL_TEST_FIRST", "")
+	t.Setenv("BESZEL_TEST_SECOND", "second")
+	t.Setenv("BESZEL_TEST_THIRD", "third")
+
+	// first non-empty value wins, in the order given
+	value, exists := GetEnvFallback("BESZEL_TEST_MISSING", "BESZEL_TEST_FIRST", "BESZEL_TEST_SECOND", "BESZEL_TEST_THIRD")
+	assert.True(t, exists)
+	assert.Equal(t, "second", value)
+
+	value, exists = GetEnvFallback("BESZEL_TEST_THIRD", "BESZEL_TEST_SECOND")
+	assert.True(t, exists)
+	assert.Equal(t, "third", value)
+
+	// empty values don't count as set
+	value, exists = GetEnvFallback("BESZEL_TEST_FIRST", "BESZEL_TEST_MISSING")
+	assert.False(t, exists)
+	assert.Empty(t, value)
+
+	value, exists = GetEnvFallback()
+	assert.False(t, exists)
+	assert.Empty(t, value)
+}
+
+func TestNewAgentOptions(t *testing.T) {
+	t.Setenv("BESZEL_AGENT_BUFFER_SIZE", "10")
+	t.Setenv("BESZEL_AGENT_DISK_INTERVAL", "5s")
+
+	gm := &GPUManager{GpuDataMap: map[string]*system.GPUData{"0": {Name: "RTX 4090", Usage: 50, Count: 1}}}
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	agent := NewAgent(
+		WithGPUManager(gm),
+		WithLogger(logger),
+		WithCollectionInterval(time.Hour),
+		WithStatsBuffer(3),
+	)
+	t.Cleanup(func() { agent.Shutdown(context.Background()) })
+
+	assert.Same(t, gm, agent.gpuManager)
+	assert.True(t, agent.debug, "debug follows the logger")
+	assert.Contains(t, logs.String(), "System stats")
+	for _, s := range []*subsystem{agent.cpuStats, agent.diskStats, agent.netStats} {
+		assert.Equal(t, time.Hour, s.interval, s.name)
+	}
+	require.NotNil(t, agent.statsBuffer)
+	assert.Len(t, agent.statsBuffer.samples, 3)
+
+	// defaults come from the environment
+	defaults := NewAgent(WithGPUManager(gm))
+	t.Cleanup(func() { defaults.Shutdown(context.Background()) })
+	assert.Len(t, defaults.statsBuffer.samples, 10)
+	assert.Equal(t, 5*time.Second, defaults.diskStats.interval)
+	assert.Zero(t, defaults.cpuStats.interval)
+
+	unbuffered := NewAgent(WithGPUManager(gm), WithStatsBuffer(0))
+	t.Cleanup(func() { unbuffered.Shutdown(context.Background()) })
+	assert.Nil(t, unbuffered.statsBuffer)
+}
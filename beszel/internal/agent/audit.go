@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"log/slog"
+	"os"
+)
+
+// AuditLogger records SSH authentication events
+type AuditLogger interface {
+	LogAuth(ip string, fingerprint string, success bool)
+}
+
+// slogAuditLogger logs authentication events with the default slog logger
+type slogAuditLogger struct{}
+
+func (slogAuditLogger) LogAuth(ip string, fingerprint string, success bool) {
+	if success {
+		slog.Info("SSH auth accepted", "ip", ip, "fingerprint", fingerprint)
+	} else {
+		slog.Warn("SSH auth rejected", "ip", ip, "fingerprint", fingerprint)
+	}
+}
+
+// fileAuditLogger appends authentication events to a file as JSON lines
+type fileAuditLogger struct {
+	logger *slog.Logger
+}
+
+func newFileAuditLogger(path string) (*fileAuditLogger, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileAuditLogger{logger: slog.New(slog.NewJSONHandler(file, nil))}, nil
+}
+
+func (l *fileAuditLogger) LogAuth(ip string, fingerprint string, success bool) {
+	l.logger.Info("ssh_auth", "ip", ip, "fingerprint", fingerprint, "success", success)
+}
+
+// newAuditLogger returns a file audit logger if BESZEL_AUDIT_LOG_PATH is set,
+// otherwise an audit logger that uses slog.
+func newAuditLogger() AuditLogger {
+	if path, ok := GetEnvFallback("BESZEL_AGENT_AUDIT_LOG_PATH", "BESZEL_AUDIT_LOG_PATH"); ok {
+		logger, err := newFileAuditLogger(path)
+		if err == nil {
+			return logger
+		}
+		slog.Error("Error opening audit log", "path", path, "err", err)
+	}
+	return slogAuditLogger{}
+}
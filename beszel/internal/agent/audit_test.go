@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+type authEvent struct {
+	ip          string
+	fingerprint string
+	success     bool
+}
+
+// recordingAuditLogger stores authentication events for inspection
+type recordingAuditLogger struct {
+	mu     sync.Mutex
+	events []authEvent
+}
+
+func (r *recordingAuditLogger) LogAuth(ip string, fingerprint string, success bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, authEvent{ip, fingerprint, success})
+}
+
+func (r *recordingAuditLogger) getEvents() []authEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]authEvent(nil), r.events...)
+}
+
+func TestAuditLoggerRecordsAuthEvents(t *testing.T) {
+	_, goodPrivKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	goodSigner, err := ssh.NewSignerFromKey(goodPrivKey)
+	require.NoError(t, err)
+	_, badPrivKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	badSigner, err := ssh.NewSignerFromKey(badPrivKey)
+	require.NoError(t, err)
+
+	recorder := &recordingAuditLogger{}
+	agent := NewAgent()
+	agent.auditLogger = recorder
+
+	addr := "127.0.0.1:45994"
+	go agent.StartServer(ServerOptions{
+		Network: "tcp",
+		Addr:    addr,
+		Keys:    []ssh.PublicKey{goodSigner.PublicKey()},
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	dial := func(signer ssh.Signer) error {
+		client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+			User:            "a",
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         4 * time.Second,
+		})
+		if err == nil {
+			client.Close()
+		}
+		return err
+	}
+
+	require.Error(t, dial(badSigner))
+	require.NoError(t, dial(goodSigner))
+
+	events := recorder.getEvents()
+	require.Len(t, events, 2)
+
+	assert.False(t, events[0].success)
+	assert.Equal(t, ssh.FingerprintSHA256(badSigner.PublicKey()), events[0].fingerprint)
+	assert.Contains(t, events[0].ip, "127.0.0.1:")
+
+	assert.True(t, events[1].success)
+	assert.Equal(t, ssh.FingerprintSHA256(goodSigner.PublicKey()), events[1].fingerprint)
+}
+
+func TestNewAuditLogger(t *testing.T) {
+	t.Run("defaults to slog", func(t *testing.T) {
+		assert.IsType(t, slogAuditLogger{}, newAuditLogger())
+	})
+
+	t.Run("file logger", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		t.Setenv("BESZEL_AUDIT_LOG_PATH", path)
+
+		logger := newAuditLogger()
+		require.IsType(t, &fileAuditLogger{}, logger)
+		logger.LogAuth("10.0.0.1:50000", "SHA256:abc", false)
+
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal(content, &entry))
+		assert.Equal(t, "ssh_auth", entry["msg"])
+		assert.Equal(t, "10.0.0.1:50000", entry["ip"])
+		assert.Equal(t, "SHA256:abc", entry["fingerprint"])
+		assert.Equal(t, false, entry["success"])
+	})
+
+	t.Run("falls back to slog if file cannot be opened", func(t *testing.T) {
+		t.Setenv("BESZEL_AGENT_AUDIT_LOG_PATH", filepath.Join(t.TempDir(), "missing", "audit.log"))
+		assert.IsType(t, slogAuditLogger{}, newAuditLogger())
+	})
+}
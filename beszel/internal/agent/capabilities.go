@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cpuVulnerabilitiesPath has a file per CPU side channel vulnerability with its
+// mitigation status, e.g. "spectre_v2" containing "Mitigation: Enhanced IBRS"
+var cpuVulnerabilitiesPath = "/sys/devices/system/cpu/vulnerabilities"
+
+// readCPUVulnerabilities returns the status of each vulnerability in dir by name,
+// or an empty map if dir doesn't exist, e.g. on non-Linux systems
+func readCPUVulnerabilities(dir string) map[string]string {
+	vulnerabilities := make(map[string]string)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return vulnerabilities
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		status, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		vulnerabilities[entry.Name()] = strings.TrimSpace(string(status))
+	}
+	return vulnerabilities
+}
+
+// attachCapabilities adds the host capabilities to data for the first request of
+// each SSH session, since they don't change and only need to be sent once. They
+// are read on the first request.
+func (a *Agent) attachCapabilities(sessionID string, data *system.CombinedData) {
+	a.attachMu.Lock()
+	defer a.attachMu.Unlock()
+	if a.capsSession == sessionID && a.capabilities != nil {
+		return
+	}
+	if a.capabilities == nil {
+		a.capabilities = &system.AgentCapabilities{
+			CPUVulnerabilities: readCPUVulnerabilities(cpuVulnerabilitiesPath),
+		}
+	}
+	data.Capabilities = a.capabilities
+	a.capsSession = sessionID
+}
@@ -0,0 +1,83 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeVulnerabilities(t *testing.T, dir string) {
+	t.Helper()
+	files := map[string]string{
+		"meltdown":             "Not affected\n",
+		"spectre_v1":           "Mitigation: usercopy/swapgs barriers and __user pointer sanitization\n",
+		"spectre_v2":           "Mitigation: Enhanced / Automatic IBRS; IBPB: conditional; RSB filling\n",
+		"spec_store_bypass":    "Mitigation: Speculative Store Bypass disabled via prctl\n",
+		"gather_data_sampling": "Vulnerable: No microcode\n",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+}
+
+func TestReadCPUVulnerabilities(t *testing.T) {
+	dir := t.TempDir()
+	writeVulnerabilities(t, dir)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "subdir"), 0o755))
+
+	vulnerabilities := readCPUVulnerabilities(dir)
+	assert.Len(t, vulnerabilities, 5)
+	assert.Equal(t, "Not affected", vulnerabilities["meltdown"])
+	assert.Equal(t, "Mitigation: Enhanced / Automatic IBRS; IBPB: conditional; RSB filling", vulnerabilities["spectre_v2"])
+	assert.Equal(t, "Vulnerable: No microcode", vulnerabilities["gather_data_sampling"])
+
+	missing := readCPUVulnerabilities(filepath.Join(dir, "missing"))
+	assert.NotNil(t, missing)
+	assert.Empty(t, missing)
+}
+
+func TestAttachCapabilities(t *testing.T) {
+	dir := t.TempDir()
+	writeVulnerabilities(t, dir)
+	origPath := cpuVulnerabilitiesPath
+	cpuVulnerabilitiesPath = dir
+	defer func() { cpuVulnerabilitiesPath = origPath }()
+
+	a := &Agent{}
+	// only the first stats of each session include the capabilities
+	var data system.CombinedData
+	a.attachCapabilities("session1", &data)
+	require.NotNil(t, data.Capabilities)
+	assert.Equal(t, "Not affected", data.Capabilities.CPUVulnerabilities["meltdown"])
+	data = system.CombinedData{}
+	a.attachCapabilities("session1", &data)
+	assert.Nil(t, data.Capabilities)
+
+	// read once, even if the files change
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "meltdown"), []byte("Vulnerable\n"), 0o644))
+	a.attachCapabilities("session2", &data)
+	require.NotNil(t, data.Capabilities)
+	assert.Equal(t, "Not affected", data.Capabilities.CPUVulnerabilities["meltdown"])
+
+	encoded, err := json.Marshal(data)
+	require.NoError(t, err)
+	assert.Contains(t, string(encoded), `"caps":{"cv":{`)
+	encoded, err = json.Marshal(system.CombinedData{})
+	require.NoError(t, err)
+	assert.NotContains(t, string(encoded), `"caps"`)
+
+	// an empty map without the sysfs directory
+	cpuVulnerabilitiesPath = filepath.Join(dir, "missing")
+	data = system.CombinedData{}
+	(&Agent{}).attachCapabilities("", &data)
+	require.NotNil(t, data.Capabilities)
+	assert.Empty(t, data.Capabilities.CPUVulnerabilities)
+}
@@ -0,0 +1,218 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"context"
+	"log/slog"
+	"time"
+)
+
+// defaultCollectionTimeout bounds how long a stats request waits for collection
+const defaultCollectionTimeout = 10 * time.Second
+
+// CollectionConfig sets how often subsystems are collected. A subsystem with an
+// interval is collected in a background goroutine and stats requests use its
+// latest result, so rates are computed over the interval rather than the time
+// between requests. A zero interval collects the subsystem on each request.
+//
+// GPU data is always collected in the background by GPUManager.
+type CollectionConfig struct {
+	CPU     SubsystemConfig
+	Disk    SubsystemConfig
+	Network SubsystemConfig
+	// Prewarm gathers all stats in the background, so hub requests are answered
+	// with the latest result without waiting for collection
+	Prewarm SubsystemConfig
+	// Timeout bounds how long gatherStats waits for a collection, so a hung
+	// collector can't block stats requests. Zero disables the timeout.
+	Timeout time.Duration
+}
+
+// SubsystemConfig configures the collection of a single subsystem
+type SubsystemConfig struct {
+	Interval time.Duration
+}
+
+// subsystem collects a group of system.Stats fields
+type subsystem struct {
+	name     string
+	interval time.Duration
+	collect  func(stats *system.Stats)    // sets the subsystem's fields
+	copy     func(dst, src *system.Stats) // copies the subsystem's fields
+	latest   *system.Stats                // latest background result, guarded by the agent lock
+}
+
+// collectionConfigFromEnv reads the subsystem intervals from CPU_INTERVAL,
+// DISK_INTERVAL, NETWORK_INTERVAL, and PREWARM_INTERVAL, e.g. "1s", and the
+// timeout from COLLECTION_TIMEOUT, defaulting to 10s
+func collectionConfigFromEnv() CollectionConfig {
+	timeout := defaultCollectionTimeout
+	if value, exists := GetEnv("COLLECTION_TIMEOUT"); exists {
+		d, err := time.ParseDuration(value)
+		if err == nil && d >= 0 {
+			timeout = d
+		} else {
+			slog.Warn("Invalid collection timeout", "value", value)
+		}
+	}
+	interval := func(key string) time.Duration {
+		value, exists := GetEnv(key)
+		if !exists {
+			return 0
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			slog.Warn("Invalid collection interval", "key", key, "value", value)
+			return 0
+		}
+		return d
+	}
+	return CollectionConfig{
+		CPU:     SubsystemConfig{Interval: interval("CPU_INTERVAL")},
+		Disk:    SubsystemConfig{Interval: interval("DISK_INTERVAL")},
+		Network: SubsystemConfig{Interval: interval("NETWORK_INTERVAL")},
+		Prewarm: SubsystemConfig{Interval: interval("PREWARM_INTERVAL")},
+		Timeout: timeout,
+	}
+}
+
+// initializeSubsystems creates the subsystems with the intervals from config
+func (a *Agent) initializeSubsystems(config CollectionConfig) {
+	a.prewarmInterval = config.Prewarm.Interval
+	a.collectionTimeout = config.Timeout
+	a.cpuStats = &subsystem{
+		name:     "cpu",
+		interval: config.CPU.Interval,
+		collect:  a.collectCpu,
+		copy: func(dst, src *system.Stats) {
+			dst.Cpu = src.Cpu
+			dst.LLCMissRate = src.LLCMissRate
+			dst.BranchMissRate = src.BranchMissRate
+			dst.IRQRates = src.IRQRates
+			dst.IRQAffinity = src.IRQAffinity
+			dst.SchedLatency = src.SchedLatency
+		},
+	}
+	a.diskStats = &subsystem{
+		name:     "disk",
+		interval: config.Disk.Interval,
+		collect:  a.collectDisk,
+		copy: func(dst, src *system.Stats) {
+			dst.DiskTotal = src.DiskTotal
+			dst.DiskUsed = src.DiskUsed
+			dst.DiskPct = src.DiskPct
+			dst.DiskReadPs = src.DiskReadPs
+			dst.DiskWritePs = src.DiskWritePs
+			dst.RemoteFSStats = src.RemoteFSStats
+		},
+	}
+	a.netStats = &subsystem{
+		name:     "network",
+		interval: config.Network.Interval,
+		collect:  a.collectNetwork,
+		copy: func(dst, src *system.Stats) {
+			dst.NetworkSent = src.NetworkSent
+			dst.NetworkRecv = src.NetworkRecv
+			dst.NetProtoStats = src.NetProtoStats
+			dst.SocketStats = src.SocketStats
+		},
+	}
+}
+
+// collectSubsystem sets the fields of s in stats, from the latest background
+// result if s has an interval, or by collecting them now
+func (a *Agent) collectSubsystem(s *subsystem, stats *system.Stats) {
+	if s.interval > 0 {
+		if s.latest != nil {
+			s.copy(stats, s.latest)
+		}
+		return
+	}
+	track := a.metrics.track(s.name)
+	s.collect(stats)
+	track()
+}
+
+// startBackgroundCollection starts a goroutine for each subsystem with an interval,
+// and the goroutines to pre-warm stats, buffer stats while the hub is unreachable,
+// and publish stats to NATS if enabled
+func (a *Agent) startBackgroundCollection() {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.collectionCancel = cancel
+	for _, s := range []*subsystem{a.cpuStats, a.diskStats, a.netStats} {
+		if s.interval <= 0 {
+			continue
+		}
+		a.collectionWg.Add(1)
+		go func() {
+			defer a.collectionWg.Done()
+			a.runSubsystem(ctx, s)
+		}()
+	}
+	if a.prewarmInterval > 0 {
+		a.collectionWg.Add(1)
+		go func() {
+			defer a.collectionWg.Done()
+			a.runPrewarm(ctx)
+		}()
+	}
+	if a.statsBuffer != nil {
+		a.collectionWg.Add(1)
+		go func() {
+			defer a.collectionWg.Done()
+			a.runStatsBuffer(ctx)
+		}()
+	}
+	if a.nats != nil {
+		a.collectionWg.Add(1)
+		go func() {
+			defer a.collectionWg.Done()
+			a.runNATSPublisher(ctx)
+		}()
+	}
+	if a.remoteGPU != nil {
+		a.collectionWg.Add(1)
+		go func() {
+			defer a.collectionWg.Done()
+			a.remoteGPU.start(ctx)
+		}()
+	}
+	if a.notifier != nil {
+		a.collectionWg.Add(1)
+		go func() {
+			defer a.collectionWg.Done()
+			a.notifier.run(ctx)
+		}()
+	}
+}
+
+// runSubsystem collects s every interval until ctx is cancelled
+func (a *Agent) runSubsystem(ctx context.Context, s *subsystem) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		// collect right away so the first request has data
+		var stats system.Stats
+		a.Lock()
+		track := a.metrics.track(s.name)
+		s.collect(&stats)
+		track()
+		s.latest = &stats
+		a.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// stopBackgroundCollection stops all background collection goroutines and waits for them to exit
+func (a *Agent) stopBackgroundCollection() {
+	if a.collectionCancel == nil {
+		return
+	}
+	a.collectionCancel()
+	a.collectionWg.Wait()
+}
@@ -0,0 +1,289 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gatherStats gathers the stats of a for sessionID, failing the test on error
+func gatherStats(t *testing.T, a *Agent, sessionID string) *system.CombinedData {
+	t.Helper()
+	stats, err := a.gatherStats(sessionID)
+	require.NoError(t, err)
+	return stats
+}
+
+func TestCollectionConfigFromEnv(t *testing.T) {
+	t.Setenv("BESZEL_AGENT_DISK_INTERVAL", "1s")
+	t.Setenv("BESZEL_AGENT_CPU_INTERVAL", "invalid")
+	t.Setenv("BESZEL_AGENT_NETWORK_INTERVAL", "-2s")
+	t.Setenv("PREWARM_INTERVAL", "30s")
+
+	config := collectionConfigFromEnv()
+	assert.Equal(t, time.Second, config.Disk.Interval)
+	assert.Zero(t, config.CPU.Interval)
+	assert.Zero(t, config.Network.Interval)
+	assert.Equal(t, 30*time.Second, config.Prewarm.Interval)
+	assert.Equal(t, defaultCollectionTimeout, config.Timeout)
+
+	t.Setenv("BESZEL_AGENT_COLLECTION_TIMEOUT", "30s")
+	assert.Equal(t, 30*time.Second, collectionConfigFromEnv().Timeout)
+	t.Setenv("BESZEL_AGENT_COLLECTION_TIMEOUT", "invalid")
+	assert.Equal(t, defaultCollectionTimeout, collectionConfigFromEnv().Timeout)
+}
+
+func TestCollectSubsystem(t *testing.T) {
+	agent := &Agent{}
+	calls := 0
+	s := &subsystem{
+		name: "cpu",
+		collect: func(stats *system.Stats) {
+			calls++
+			stats.Cpu = float64(calls)
+		},
+		copy: func(dst, src *system.Stats) {
+			dst.Cpu = src.Cpu
+		},
+	}
+
+	t.Run("on demand", func(t *testing.T) {
+		var stats system.Stats
+		agent.collectSubsystem(s, &stats)
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, 1.0, stats.Cpu)
+	})
+
+	t.Run("uses cached background value", func(t *testing.T) {
+		s.interval = time.Second
+		s.latest = &system.Stats{Cpu: 42}
+		var stats system.Stats
+		agent.collectSubsystem(s, &stats)
+		assert.Equal(t, 1, calls, "collect should not be called")
+		assert.Equal(t, 42.0, stats.Cpu)
+	})
+
+	t.Run("no background value yet", func(t *testing.T) {
+		s.latest = nil
+		var stats system.Stats
+		agent.collectSubsystem(s, &stats)
+		assert.Equal(t, 1, calls)
+		assert.Zero(t, stats.Cpu)
+	})
+}
+
+// fillNonZero sets every field of v to an arbitrary non-zero value
+func fillNonZero(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := range v.NumField() {
+			if v.Type().Field(i).IsExported() {
+				fillNonZero(v.Field(i))
+			}
+		}
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+		fillNonZero(v.Elem())
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		key, value := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+		fillNonZero(key)
+		fillNonZero(value)
+		v.SetMapIndex(key, value)
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		fillNonZero(v.Index(0))
+	case reflect.Array:
+		for i := range v.Len() {
+			fillNonZero(v.Index(i))
+		}
+	case reflect.String:
+		v.SetString("sentinel")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(123)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(123)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(123.456)
+	}
+}
+
+// TestBackgroundSubsystemFields checks that every field a subsystem collects is
+// copied from its background result, so no stats are dropped with an interval
+func TestBackgroundSubsystemFields(t *testing.T) {
+	t.Setenv("BESZEL_AGENT_CPU_INTERVAL", "1h")
+	t.Setenv("BESZEL_AGENT_DISK_INTERVAL", "1h")
+	t.Setenv("BESZEL_AGENT_NETWORK_INTERVAL", "1h")
+	agent := NewAgent()
+	t.Cleanup(func() { agent.Shutdown(context.Background()) })
+
+	for _, s := range []*subsystem{agent.cpuStats, agent.diskStats, agent.netStats} {
+		t.Run(s.name, func(t *testing.T) {
+			require.Equal(t, time.Hour, s.interval)
+			var sentinel system.Stats
+			fillNonZero(reflect.ValueOf(&sentinel).Elem())
+			// fields still set to the sentinel after collecting are not written by the subsystem
+			collected := sentinel
+			agent.Lock()
+			s.collect(&collected)
+			s.latest = &collected
+			var stats system.Stats
+			agent.collectSubsystem(s, &stats)
+			agent.Unlock()
+
+			written := 0
+			statsType := reflect.TypeFor[system.Stats]()
+			for i := range statsType.NumField() {
+				field := statsType.Field(i)
+				value := reflect.ValueOf(collected).Field(i).Interface()
+				if reflect.DeepEqual(value, reflect.ValueOf(sentinel).Field(i).Interface()) {
+					continue
+				}
+				written++
+				assert.Equal(t, value, reflect.ValueOf(stats).Field(i).Interface(), "%s is not copied", field.Name)
+			}
+			assert.NotZero(t, written)
+		})
+	}
+
+	// socket stats are collected with the network stats
+	if _, err := os.Stat(procSockstat); err == nil {
+		var stats system.Stats
+		agent.Lock()
+		agent.collectSubsystem(agent.netStats, &stats)
+		agent.Unlock()
+		assert.NotNil(t, stats.SocketStats)
+	}
+}
+
+func TestBackgroundCollection(t *testing.T) {
+	agent := &Agent{}
+	agent.initializeSubsystems(CollectionConfig{})
+	calls := 0
+	agent.diskStats = &subsystem{
+		name:     "disk",
+		interval: 10 * time.Millisecond,
+		collect: func(stats *system.Stats) {
+			calls++
+			stats.DiskReadPs = float64(calls)
+		},
+		copy: func(dst, src *system.Stats) {
+			dst.DiskReadPs = src.DiskReadPs
+		},
+	}
+
+	agent.startBackgroundCollection()
+	assert.Eventually(t, func() bool {
+		agent.Lock()
+		defer agent.Unlock()
+		return calls >= 3
+	}, time.Second, 5*time.Millisecond)
+	agent.stopBackgroundCollection()
+
+	// requests read the latest background result without collecting
+	agent.Lock()
+	defer agent.Unlock()
+	collected := calls
+	var stats system.Stats
+	agent.collectSubsystem(agent.diskStats, &stats)
+	assert.Equal(t, collected, calls)
+	require.NotNil(t, agent.diskStats.latest)
+	assert.Equal(t, float64(collected), stats.DiskReadPs)
+}
+
+func TestGatherStatsTimeout(t *testing.T) {
+	agent := NewAgent(WithCollectionTimeout(100 * time.Millisecond))
+	t.Cleanup(func() { agent.Shutdown(context.Background()) })
+	completed := gatherStats(t, agent, "")
+	require.NotEmpty(t, completed.Info.Hostname)
+
+	t.Run("collector without context", func(t *testing.T) {
+		unblock := make(chan struct{})
+		agent.Lock()
+		agent.ipmi = &IPMICollector{run: func(context.Context, ...string) ([]byte, error) {
+			<-unblock
+			return nil, errors.New("bmc timeout")
+		}}
+		agent.Unlock()
+		cycles := agent.Diagnostics().CollectionCycles
+
+		start := time.Now()
+		stats := gatherStats(t, agent, "")
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Equal(t, completed.Info.Hostname, stats.Info.Hostname, "last completed stats are sent")
+		assert.Equal(t, cycles, agent.Diagnostics().CollectionCycles)
+
+		// the last stats are copied, with the capabilities attached for new sessions
+		stats.Info.Hostname = "changed"
+		assert.Equal(t, completed.Info.Hostname, agent.lastCompleted.Load().Info.Hostname)
+		assert.NotNil(t, gatherStats(t, agent, "session-2").Capabilities)
+
+		// requests wait for the stalled collection instead of starting another
+		goroutines := runtime.NumGoroutine()
+		for range 5 {
+			gatherStats(t, agent, "")
+		}
+		assert.LessOrEqual(t, runtime.NumGoroutine(), goroutines)
+		assert.Equal(t, cycles, agent.Diagnostics().CollectionCycles)
+
+		// the collection finishes in the background once the collector returns
+		close(unblock)
+		assert.Eventually(t, func() bool {
+			return agent.Diagnostics().CollectionCycles == cycles+1
+		}, time.Second, 10*time.Millisecond)
+		agent.Lock()
+		agent.ipmi = nil
+		agent.Unlock()
+	})
+
+	t.Run("collector with context", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}))
+		defer server.Close()
+		agent.Lock()
+		agent.kubelet = &KubeletCollector{addr: server.URL, client: server.Client()}
+		agent.Unlock()
+		cycles := agent.Diagnostics().CollectionCycles
+
+		start := time.Now()
+		gatherStats(t, agent, "")
+		assert.Less(t, time.Since(start), time.Second)
+		// the request is cancelled with the collection context
+		assert.Eventually(t, func() bool {
+			return agent.Diagnostics().CollectionCycles == cycles+1
+		}, time.Second, 10*time.Millisecond)
+		agent.Lock()
+		agent.kubelet = nil
+		agent.Unlock()
+	})
+	t.Run("no completed collection", func(t *testing.T) {
+		agent := NewAgent()
+		t.Cleanup(func() { agent.Shutdown(context.Background()) })
+		assert.Equal(t, defaultCollectionTimeout, agent.collectionTimeout, "default without COLLECTION_TIMEOUT")
+		agent.collectionTimeout = 100 * time.Millisecond
+		unblock := make(chan struct{})
+		defer close(unblock)
+		agent.ipmi = &IPMICollector{run: func(context.Context, ...string) ([]byte, error) {
+			<-unblock
+			return nil, errors.New("bmc timeout")
+		}}
+
+		// there are no stats to send rather than empty stats
+		stats, err := agent.gatherStats("")
+		assert.ErrorIs(t, err, errStatsTimeout)
+		assert.Nil(t, stats)
+	})
+}
@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+// computeModeCycles is the number of nvidia-smi samples between compute mode
+// queries, since the mode rarely changes
+const computeModeCycles = 10
+
+// ComputeModeCollector queries the compute mode of Nvidia GPUs, which controls
+// whether several processes can use a GPU, and sets it on GPUManager data
+type ComputeModeCollector struct {
+	gm      *GPUManager
+	run     func(ctx context.Context) ([]byte, error)
+	cycles  int           // nvidia-smi samples parsed, guarded by the GPUManager lock
+	refresh chan struct{} // signals start to query the compute modes
+}
+
+func newComputeModeCollector(gm *GPUManager) *ComputeModeCollector {
+	return &ComputeModeCollector{
+		gm:      gm,
+		refresh: make(chan struct{}, 1),
+		run: func(ctx context.Context) ([]byte, error) {
+			return newGPUCommandContext(ctx, nvidiaSmiCmd, "--query-gpu=index,compute_mode", "--format=csv,noheader").Output()
+		},
+	}
+}
+
+// tick is called for each nvidia-smi sample and requests a query for the first
+// sample and every computeModeCycles samples after. The caller must hold the
+// GPUManager lock.
+func (c *ComputeModeCollector) tick() {
+	if c == nil {
+		return
+	}
+	c.cycles++
+	if c.cycles%computeModeCycles != 1 {
+		return
+	}
+	select {
+	case c.refresh <- struct{}{}:
+	default:
+		// a query is already pending
+	}
+}
+
+// start queries the compute modes when requested by tick until ctx is cancelled
+func (c *ComputeModeCollector) start(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.refresh:
+		}
+		output, err := c.run(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Debug("GPU compute mode", "err", err)
+			continue
+		}
+		c.update(parseNvidiaComputeModes(output))
+	}
+}
+
+// update sets the compute mode of each GPU, logging changes
+func (c *ComputeModeCollector) update(modes map[string]string) {
+	c.gm.Lock()
+	defer c.gm.Unlock()
+	defer c.gm.publishSnapshot(c.gm.beginUpdate())
+	for id, mode := range modes {
+		gpu, ok := c.gm.GpuDataMap[id]
+		if !ok {
+			continue
+		}
+		if gpu.ComputeMode != mode {
+			slog.Info("GPU compute mode", "gpu", gpu.Name, "mode", mode)
+			gpu.ComputeMode = mode
+		}
+	}
+}
+
+// parseNvidiaComputeModes returns the compute mode of each GPU keyed by index, e.g.
+//
+//	0, Default
+//	1, Exclusive_Process
+func parseNvidiaComputeModes(output []byte) map[string]string {
+	modes := make(map[string]string)
+	for line := range strings.Lines(string(output)) {
+		id, mode, ok := strings.Cut(strings.TrimSpace(line), ", ")
+		if !ok || mode == "" || strings.Contains(mode, "N/A") {
+			continue
+		}
+		modes[id] = mode
+	}
+	return modes
+}
@@ -0,0 +1,86 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// `nvidia-smi --query-gpu=index,compute_mode --format=csv,noheader` on a shared HPC node
+const computeModeFixture = `0, Exclusive_Process
+1, Default
+2, [N/A]
+`
+
+func TestParseNvidiaComputeModes(t *testing.T) {
+	assert.Equal(t, map[string]string{"0": "Exclusive_Process", "1": "Default"}, parseNvidiaComputeModes([]byte(computeModeFixture)))
+	assert.Empty(t, parseNvidiaComputeModes(nil))
+}
+
+func TestComputeModeCollector(t *testing.T) {
+	logs := captureLogs(t)
+
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	queries := make(chan struct{}, computeModeCycles)
+	c := newComputeModeCollector(gm)
+	c.run = func(context.Context) ([]byte, error) {
+		queries <- struct{}{}
+		return []byte(computeModeFixture), nil
+	}
+	gm.modeQuery = c
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.start(ctx)
+		close(done)
+	}()
+
+	line := []byte("0, NVIDIA A100, 50, 8192, 40960, 30, 200\n1, NVIDIA A100, 50, 8192, 40960, 30, 200")
+	require.True(t, gm.parseNvidiaData(line))
+	select {
+	case <-queries:
+	case <-time.After(time.Second):
+		t.Fatal("compute modes not queried after the first sample")
+	}
+	require.Eventually(t, func() bool {
+		gm.Lock()
+		defer gm.Unlock()
+		return gm.GpuDataMap["0"].ComputeMode == "Exclusive_Process"
+	}, time.Second, 10*time.Millisecond)
+	gm.Lock()
+	assert.Equal(t, "Default", gm.GpuDataMap["1"].ComputeMode)
+	gm.Unlock()
+	assert.Equal(t, 2, strings.Count(logs.String(), "GPU compute mode"), "logged when first read")
+
+	// only queried again after computeModeCycles samples
+	for range computeModeCycles - 1 {
+		require.True(t, gm.parseNvidiaData(line))
+	}
+	assert.Never(t, func() bool { return len(queries) > 0 }, 50*time.Millisecond, 10*time.Millisecond)
+	require.True(t, gm.parseNvidiaData(line))
+	select {
+	case <-queries:
+	case <-time.After(time.Second):
+		t.Fatal("compute modes not queried after computeModeCycles samples")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("collector did not stop after cancel")
+	}
+	assert.Equal(t, 2, strings.Count(logs.String(), "GPU compute mode"), "unchanged modes are not logged again")
+
+	c.update(map[string]string{"0": "Prohibited"})
+	assert.Equal(t, 3, strings.Count(logs.String(), "GPU compute mode"))
+	require.True(t, gm.parseNvidiaData(line))
+	assert.Equal(t, "Prohibited", gm.GetCurrentData()["0"].ComputeMode)
+}
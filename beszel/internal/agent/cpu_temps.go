@@ -0,0 +1,152 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// hwmon driver names that report CPU temperatures (Intel and AMD)
+var cpuHwmonNames = map[string]struct{}{
+	"coretemp": {},
+	"k10temp":  {},
+}
+
+// maximum number of CCD temperatures reported by k10temp, in temp3_input to temp10_input
+const maxZenCCDs = 8
+
+// CPUThermalCollector reads per-package and per-core CPU temperatures from hwmon
+type CPUThermalCollector struct {
+	sensors []cpuTempSensor
+	k10temp string // first k10temp hwmon device, empty on CPUs other than AMD Zen
+}
+
+// cpuTempSensor is a tempN_input file of a CPU hwmon device
+type cpuTempSensor struct {
+	label     string
+	inputPath string
+}
+
+// cpuHwmonPath returns the hwmon class directory, using the SYS_SENSORS sys location if set
+func cpuHwmonPath() string {
+	sysPath, _ := GetEnv("SYS_SENSORS")
+	if sysPath == "" {
+		sysPath = "/sys"
+	}
+	return filepath.Join(sysPath, "class", "hwmon")
+}
+
+// newCPUThermalCollector finds the CPU temperature sensors in hwmonPath,
+// usually /sys/class/hwmon
+func newCPUThermalCollector(hwmonPath string) *CPUThermalCollector {
+	c := &CPUThermalCollector{}
+	devices, err := os.ReadDir(hwmonPath)
+	if err != nil {
+		slog.Debug("CPU temperatures", "err", err)
+		return c
+	}
+	labels := make(map[string]int)
+	for _, device := range devices {
+		devicePath := filepath.Join(hwmonPath, device.Name())
+		name, err := os.ReadFile(filepath.Join(devicePath, "name"))
+		if err != nil {
+			continue
+		}
+		driver := strings.TrimSpace(string(name))
+		if _, ok := cpuHwmonNames[driver]; !ok {
+			continue
+		}
+		if driver == "k10temp" && c.k10temp == "" {
+			c.k10temp = devicePath
+		}
+		for _, sensor := range findCPUTempSensors(devicePath) {
+			// multi-socket systems have one device per package with the same core labels
+			labels[sensor.label]++
+			if labels[sensor.label] > 1 {
+				sensor.label = sensor.label + " (" + device.Name() + ")"
+			}
+			c.sensors = append(c.sensors, sensor)
+		}
+	}
+	slog.Debug("CPU temperatures", "sensors", len(c.sensors))
+	return c
+}
+
+// findCPUTempSensors returns the temperature inputs of a hwmon device ordered by index
+func findCPUTempSensors(devicePath string) []cpuTempSensor {
+	inputs, _ := filepath.Glob(filepath.Join(devicePath, "temp*_input"))
+	index := func(path string) int {
+		n, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "temp"), "_input"))
+		return n
+	}
+	slices.SortFunc(inputs, func(a, b string) int {
+		return index(a) - index(b)
+	})
+
+	sensors := make([]cpuTempSensor, 0, len(inputs))
+	for _, input := range inputs {
+		label := strings.TrimSuffix(filepath.Base(input), "_input")
+		// tempN_label holds names such as "Package id 0", "Core 0", or "Tctl"
+		if content, err := os.ReadFile(strings.TrimSuffix(input, "_input") + "_label"); err == nil {
+			label = strings.TrimSpace(string(content))
+		}
+		sensors = append(sensors, cpuTempSensor{label: label, inputPath: input})
+	}
+	return sensors
+}
+
+// Collect reads the current CPU temperatures
+func (c *CPUThermalCollector) Collect() []system.CPUTemp {
+	if c == nil || len(c.sensors) == 0 {
+		return nil
+	}
+	temps := make([]system.CPUTemp, 0, len(c.sensors))
+	for _, sensor := range c.sensors {
+		if temp, ok := readHwmonTemp(sensor.inputPath); ok {
+			temps = append(temps, system.CPUTemp{Label: sensor.label, TempC: temp})
+		}
+	}
+	return temps
+}
+
+// ZenTemps reads the die and CCD temperatures of an AMD Zen CPU from k10temp, or
+// returns zeros if there is no k10temp device. temp1 is Tctl, which equals Tdie
+// except on some Zen 1 and Zen+ models that report a separate temp2 labeled Tdie.
+// On multi-socket systems only the first package is read.
+func (c *CPUThermalCollector) ZenTemps() system.ZenCPUTemps {
+	var temps system.ZenCPUTemps
+	if c == nil || c.k10temp == "" {
+		return temps
+	}
+	temps.Tdie, _ = readHwmonTemp(filepath.Join(c.k10temp, "temp1_input"))
+	if label, err := os.ReadFile(filepath.Join(c.k10temp, "temp2_label")); err == nil && strings.TrimSpace(string(label)) == "Tdie" {
+		if tdie, ok := readHwmonTemp(filepath.Join(c.k10temp, "temp2_input")); ok {
+			temps.Tdie = tdie
+		}
+	}
+	// temp3 to temp10 are Tccd1 to Tccd8, present only for the CCDs of the CPU
+	for i := 3; i < 3+maxZenCCDs; i++ {
+		if tccd, ok := readHwmonTemp(filepath.Join(c.k10temp, "temp"+strconv.Itoa(i)+"_input")); ok {
+			temps.Tccd = append(temps.Tccd, tccd)
+		}
+	}
+	return temps
+}
+
+// readHwmonTemp reads a hwmon temperature input in Celsius
+func readHwmonTemp(path string) (float64, bool) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	// hwmon reports millidegrees Celsius
+	milliC, err := strconv.ParseFloat(strings.TrimSpace(string(content)), 64)
+	if err != nil {
+		return 0, false
+	}
+	return twoDecimals(milliC / 1000), true
+}
@@ -0,0 +1,118 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeHwmonDevice creates a mock hwmon device with the given name and files
+func writeHwmonDevice(t *testing.T, hwmonPath, device, name string, files map[string]string) {
+	t.Helper()
+	devicePath := filepath.Join(hwmonPath, device)
+	require.NoError(t, os.MkdirAll(devicePath, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(devicePath, "name"), []byte(name+"\n"), 0644))
+	for file, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(devicePath, file), []byte(content+"\n"), 0644))
+	}
+}
+
+func TestCPUThermalCollector(t *testing.T) {
+	hwmonPath := t.TempDir()
+	writeHwmonDevice(t, hwmonPath, "hwmon0", "acpitz", map[string]string{
+		"temp1_input": "27800",
+	})
+	writeHwmonDevice(t, hwmonPath, "hwmon1", "coretemp", map[string]string{
+		"temp1_input":  "45000",
+		"temp1_label":  "Package id 0",
+		"temp2_input":  "43000",
+		"temp2_label":  "Core 0",
+		"temp3_input":  "44500",
+		"temp3_label":  "Core 1",
+		"temp10_input": "41250",
+	})
+
+	collector := newCPUThermalCollector(hwmonPath)
+	temps := collector.Collect()
+	assert.Equal(t, []system.CPUTemp{
+		{Label: "Package id 0", TempC: 45},
+		{Label: "Core 0", TempC: 43},
+		{Label: "Core 1", TempC: 44.5},
+		{Label: "temp10", TempC: 41.25},
+	}, temps)
+
+	// readings are refreshed on every collection
+	require.NoError(t, os.WriteFile(filepath.Join(hwmonPath, "hwmon1", "temp2_input"), []byte("50000\n"), 0644))
+	assert.Equal(t, 50.0, collector.Collect()[1].TempC)
+}
+
+func TestCPUThermalCollectorMultiSocket(t *testing.T) {
+	hwmonPath := t.TempDir()
+	for _, device := range []string{"hwmon2", "hwmon3"} {
+		writeHwmonDevice(t, hwmonPath, device, "coretemp", map[string]string{
+			"temp2_input": "40000",
+			"temp2_label": "Core 0",
+		})
+	}
+
+	temps := newCPUThermalCollector(hwmonPath).Collect()
+	require.Len(t, temps, 2)
+	assert.Equal(t, "Core 0", temps[0].Label)
+	assert.Equal(t, "Core 0 (hwmon3)", temps[1].Label)
+}
+
+func TestZenTemps(t *testing.T) {
+	hwmonPath := t.TempDir()
+	writeHwmonDevice(t, hwmonPath, "hwmon0", "nvme", map[string]string{
+		"temp1_input": "38850",
+	})
+	// Ryzen 9 7950X with two CCDs
+	writeHwmonDevice(t, hwmonPath, "hwmon1", "k10temp", map[string]string{
+		"temp1_input": "62750",
+		"temp1_label": "Tctl",
+		"temp3_input": "55500",
+		"temp3_label": "Tccd1",
+		"temp4_input": "48125",
+		"temp4_label": "Tccd2",
+	})
+
+	collector := newCPUThermalCollector(hwmonPath)
+	assert.Equal(t, system.ZenCPUTemps{Tdie: 62.75, Tccd: []float64{55.5, 48.13}}, collector.ZenTemps())
+
+	// Zen 1 with a Tctl offset reports Tdie separately
+	writeHwmonDevice(t, hwmonPath, "hwmon1", "k10temp", map[string]string{
+		"temp1_input": "72000",
+		"temp2_input": "52000",
+		"temp2_label": "Tdie",
+	})
+	assert.Equal(t, 52.0, collector.ZenTemps().Tdie)
+
+	// Intel CPUs have no k10temp device
+	hwmonPath = t.TempDir()
+	writeHwmonDevice(t, hwmonPath, "hwmon0", "coretemp", map[string]string{
+		"temp1_input": "45000",
+	})
+	assert.Zero(t, newCPUThermalCollector(hwmonPath).ZenTemps())
+	var nilCollector *CPUThermalCollector
+	assert.Zero(t, nilCollector.ZenTemps())
+}
+
+func TestCPUThermalCollectorNoDevices(t *testing.T) {
+	assert.Nil(t, newCPUThermalCollector(filepath.Join(t.TempDir(), "missing")).Collect())
+
+	var collector *CPUThermalCollector
+	assert.Nil(t, collector.Collect())
+}
+
+func TestCPUHwmonPath(t *testing.T) {
+	assert.Equal(t, "/sys/class/hwmon", cpuHwmonPath())
+	t.Setenv("SYS_SENSORS", "/host/sys")
+	assert.Equal(t, "/host/sys/class/hwmon", cpuHwmonPath())
+}
@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"beszel/internal/common"
+	"beszel/internal/entities/system"
+	"bytes"
+	"encoding/json"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// minimum relative change for a value to be included in a delta
+const deltaThreshold = 0.01
+
+// deltaState holds the stats last sent to a hub, as the hub sees them after applying deltas
+type deltaState struct {
+	enabled atomic.Bool
+	mu      sync.Mutex
+	session string         // SSH session ID of the hub that received lastStats
+	last    map[string]any // decoded JSON of the last stats sent
+}
+
+// EnableDeltaMode sets whether stats are sent as a delta of the previous payload.
+// Deltas are only sent to hubs that set common.DeltaEnv on the session, and the
+// first request on each SSH connection always returns the full stats.
+func (a *Agent) EnableDeltaMode(enabled bool) {
+	a.delta.enabled.Store(enabled)
+	if !enabled {
+		a.delta.mu.Lock()
+		a.delta.session, a.delta.last = "", nil
+		a.delta.mu.Unlock()
+	}
+}
+
+// wantsDelta returns true if delta mode is enabled and the hub supports deltas
+func (a *Agent) wantsDelta(s ssh.Session) bool {
+	return a.delta.enabled.Load() && slices.Contains(s.Environ(), common.DeltaEnv+"=1")
+}
+
+// statsDelta returns the stats to send to the hub in sessionID: a delta with only
+// the values that changed by more than deltaThreshold if the hub received the
+// previous stats, or the full stats otherwise.
+func (a *Agent) statsDelta(sessionID string, stats *system.CombinedData) (any, error) {
+	var buf bytes.Buffer
+	if err := statsEncoder.Encode(&buf, stats, system.EncodingJSON); err != nil {
+		return nil, err
+	}
+	encoded := buf.Bytes()
+	var current map[string]any
+	if err := json.Unmarshal(encoded, &current); err != nil {
+		return nil, err
+	}
+
+	a.delta.mu.Lock()
+	defer a.delta.mu.Unlock()
+	if a.delta.session != sessionID || a.delta.last == nil {
+		a.delta.session, a.delta.last = sessionID, current
+		return json.RawMessage(encoded), nil
+	}
+	patch, next := common.DiffJSON(a.delta.last, current, deltaThreshold)
+	patch[common.DeltaKey] = true
+	a.delta.last = next
+	return patch, nil
+}
@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"beszel/internal/common"
+	"beszel/internal/entities/system"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func deltaTestStats() *system.CombinedData {
+	stats := &system.CombinedData{
+		Stats: system.Stats{
+			Cpu:     12.5,
+			Mem:     31.2,
+			MemUsed: 12.1,
+			ExtraFs: make(map[string]*system.FsStats),
+			GPUData: make(map[string]system.GPUData),
+		},
+		Info: system.Info{Hostname: "dgx", Cores: 64, Cpu: 12.5, AgentVersion: "0.11.1"},
+		Meta: system.AgentMeta{Version: "0.11.1", GoVersion: "go1.24", GOARCH: "amd64", GOOS: "linux"},
+	}
+	for _, name := range []string{"sda", "sdb", "sdc", "sdd"} {
+		stats.Stats.ExtraFs[name] = &system.FsStats{DiskTotal: 1863.01, DiskUsed: 912.4, DiskReadPs: 3.2}
+	}
+	for _, id := range []string{"0", "1", "2", "3"} {
+		stats.Stats.GPUData[id] = system.GPUData{Name: "A100", Usage: 90, Power: 300, MemoryUsed: 30000}
+	}
+	return stats
+}
+
+func encodeDelta(t *testing.T, payload any) []byte {
+	encoded, err := json.Marshal(payload)
+	require.NoError(t, err)
+	return encoded
+}
+
+func TestStatsDelta(t *testing.T) {
+	agent := &Agent{}
+	agent.EnableDeltaMode(true)
+
+	stats := deltaTestStats()
+	first, err := agent.statsDelta("session-a", stats)
+	require.NoError(t, err)
+	full := encodeDelta(t, first)
+	assert.NotContains(t, string(full), `"delta"`, "first payload should be the full stats")
+
+	// hub keeps the full stats as the base for deltas
+	var hubState map[string]any
+	require.NoError(t, json.Unmarshal(full, &hubState))
+
+	t.Run("no change is small", func(t *testing.T) {
+		payload, err := agent.statsDelta("session-a", deltaTestStats())
+		require.NoError(t, err)
+		encoded := encodeDelta(t, payload)
+		assert.Less(t, len(encoded), 100)
+		assert.Less(t, len(encoded), len(full))
+		assert.JSONEq(t, `{"delta":true}`, string(encoded))
+	})
+
+	t.Run("only significant changes are sent", func(t *testing.T) {
+		stats := deltaTestStats()
+		stats.Stats.Cpu = 50
+		stats.Stats.MemUsed = 12.15 // under 1%
+		gpu := stats.Stats.GPUData["2"]
+		gpu.Power = 150
+		stats.Stats.GPUData["2"] = gpu
+		delete(stats.Stats.ExtraFs, "sdd")
+
+		payload, err := agent.statsDelta("session-a", stats)
+		require.NoError(t, err)
+		encoded := encodeDelta(t, payload)
+		assert.JSONEq(t, `{
+			"delta": true,
+			"stats": {"cpu": 50, "g": {"2": {"p": 150}}, "efs": {"sdd": null}}
+		}`, string(encoded))
+
+		// the hub's copy matches the agent's stats after applying the delta
+		var patch map[string]any
+		require.NoError(t, json.Unmarshal(encoded, &patch))
+		delete(patch, common.DeltaKey)
+		common.ApplyJSONPatch(hubState, patch)
+		merged, err := json.Marshal(hubState)
+		require.NoError(t, err)
+		var result system.CombinedData
+		require.NoError(t, json.Unmarshal(merged, &result))
+		assert.Equal(t, 50.0, result.Stats.Cpu)
+		assert.Equal(t, 12.1, result.Stats.MemUsed)
+		assert.Equal(t, 150.0, result.Stats.GPUData["2"].Power)
+		assert.Len(t, result.Stats.ExtraFs, 3)
+	})
+
+	t.Run("new session gets full stats", func(t *testing.T) {
+		payload, err := agent.statsDelta("session-b", deltaTestStats())
+		require.NoError(t, err)
+		assert.NotContains(t, string(encodeDelta(t, payload)), `"delta"`)
+	})
+
+	t.Run("disabling resets state", func(t *testing.T) {
+		agent.EnableDeltaMode(false)
+		assert.Nil(t, agent.delta.last)
+		assert.Empty(t, agent.delta.session)
+	})
+}
@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"encoding/json"
+	"log/slog"
+	"runtime"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// diagnosticsCommand is the SSH command that returns agent diagnostics instead of stats
+const diagnosticsCommand = "BESZEL_DIAGNOSTICS"
+
+// Diagnostics holds internal agent metrics for troubleshooting
+type Diagnostics struct {
+	Latencies         map[string][]time.Duration `json:"latencies"`                // Recent collection latencies by subsystem
+	GpuCollectors     map[string]CollectorStats  `json:"gpu_collectors,omitempty"` // GPU collector parse counters by command
+	GpuErrors         []GPUParseError            `json:"gpu_errors,omitempty"`     // Recent GPU tool output that could not be parsed
+	ActiveConnections int64                      `json:"active_connections"`       // SSH sessions being handled, including this one
+	Runtime           RuntimeStats               `json:"runtime"`                  // Go runtime stats of the agent process
+	Agent             AgentDiagnostics           `json:"agent"`                    // Uptime and stats collection totals
+}
+
+// AgentDiagnostics holds how long the agent has been running and how much stats
+// collection it has done, to diagnose performance regressions
+type AgentDiagnostics struct {
+	StartTime           time.Time     `json:"start_time"`            // When the agent was created
+	CollectionCycles    uint64        `json:"collection_cycles"`     // Stats collections completed, excluding cached responses
+	TotalCollectionTime time.Duration `json:"total_collection_time"` // Time spent in those collections
+}
+
+// Diagnostics returns the start time of the agent and its stats collection totals
+func (a *Agent) Diagnostics() AgentDiagnostics {
+	return AgentDiagnostics{
+		StartTime:           a.startTime,
+		CollectionCycles:    a.collectionCycles.Load(),
+		TotalCollectionTime: time.Duration(a.collectionTime.Load()),
+	}
+}
+
+// RuntimeStats holds Go runtime stats, to diagnose memory leaks and GC pressure in the agent
+type RuntimeStats struct {
+	Goroutines  int    `json:"goroutines"`    // Number of goroutines
+	HeapAlloc   uint64 `json:"heap_alloc"`    // Bytes of allocated heap objects
+	NumGC       uint32 `json:"num_gc"`        // Number of completed GC cycles
+	LastPauseNs uint64 `json:"last_pause_ns"` // Stop-the-world pause of the last GC cycle, 0 if none
+}
+
+// RuntimeStats returns the current Go runtime stats. It briefly stops the world
+// to read memory stats, so it is only collected for diagnostics.
+func (a *Agent) RuntimeStats() RuntimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := RuntimeStats{
+		Goroutines: runtime.NumGoroutine(),
+		HeapAlloc:  mem.HeapAlloc,
+		NumGC:      mem.NumGC,
+	}
+	if mem.NumGC > 0 {
+		// PauseNs is a circular buffer with the most recent pause at (NumGC+255)%256
+		stats.LastPauseNs = mem.PauseNs[(mem.NumGC+255)%256]
+	}
+	return stats
+}
+
+// getDiagnostics returns the current agent diagnostics
+func (a *Agent) getDiagnostics() Diagnostics {
+	diagnostics := Diagnostics{
+		Latencies:         a.metrics.GetLatencies(),
+		ActiveConnections: a.ActiveConnections(),
+		Runtime:           a.RuntimeStats(),
+		Agent:             a.Diagnostics(),
+	}
+	if a.gpuManager != nil {
+		diagnostics.GpuCollectors = a.gpuManager.CollectorStats()
+		diagnostics.GpuErrors = a.gpuManager.RecentErrors()
+	}
+	return diagnostics
+}
+
+// handleDiagnostics writes the agent diagnostics to the session
+func (a *Agent) handleDiagnostics(s ssh.Session) {
+	diagnostics := a.getDiagnostics()
+	if err := json.NewEncoder(s).Encode(diagnostics); err != nil {
+		slog.Error("Error encoding diagnostics", "err", err)
+		s.Exit(1)
+		return
+	}
+	s.Exit(0)
+}
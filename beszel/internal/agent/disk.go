@@ -4,8 +4,12 @@ import (
 	"beszel/internal/entities/system"
 	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,6 +21,7 @@ func (a *Agent) initializeDiskInfo() {
 	filesystem, _ := GetEnv("FILESYSTEM")
 	efPath := "/extra-filesystems"
 	hasRoot := false
+	filter := newDiskFilter()
 
 	partitions, err := disk.Partitions(false)
 	if err != nil {
@@ -44,6 +49,10 @@ func (a *Agent) initializeDiskInfo() {
 			key = filepath.Base(device)
 		}
 		var ioMatch bool
+		if filter.excluded(mountpoint) {
+			slog.Debug("Excluding filesystem", "device", device, "mountpoint", mountpoint)
+			return
+		}
 		if _, exists := a.fsStats[key]; !exists {
 			if root {
 				slog.Info("Detected root device", "name", key)
@@ -141,15 +150,75 @@ func (a *Agent) initializeDiskInfo() {
 	}
 
 	// If no root filesystem set, use fallback
-	if !hasRoot {
+	if !hasRoot && !filter.excluded("/") {
 		rootDevice, _ := findIoDevice(filepath.Base(filesystem), diskIoCounters, a.fsStats)
 		slog.Info("Root disk", "mountpoint", "/", "io", rootDevice)
 		a.fsStats[rootDevice] = &system.FsStats{Root: true, Mountpoint: "/"}
 	}
 
+	for _, stats := range a.fsStats {
+		stats.FSType = filesystemType(stats.Mountpoint)
+	}
+
 	a.initializeDiskIoStats(diskIoCounters)
 }
 
+// diskFilter excludes mount points from disk stats using glob patterns from
+// DISK_EXCLUDE, unless they also match a pattern from DISK_INCLUDE
+type diskFilter struct {
+	exclude []string
+	include []string
+}
+
+// newDiskFilter parses the comma separated patterns in BESZEL_DISK_EXCLUDE and
+// BESZEL_DISK_INCLUDE, e.g. "/snap/*,/proc,/sys"
+func newDiskFilter() diskFilter {
+	exclude, _ := GetEnvFallback("BESZEL_AGENT_DISK_EXCLUDE", "BESZEL_DISK_EXCLUDE")
+	include, _ := GetEnvFallback("BESZEL_AGENT_DISK_INCLUDE", "BESZEL_DISK_INCLUDE")
+	return diskFilter{
+		exclude: parseMountPatterns(exclude),
+		include: parseMountPatterns(include),
+	}
+}
+
+// parseMountPatterns splits a comma separated list of path.Match patterns,
+// skipping invalid patterns
+func parseMountPatterns(value string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			slog.Warn("Invalid mount point pattern", "pattern", pattern, "err", err)
+			continue
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// excluded returns true if disk stats should not be collected for mountpoint
+func (f diskFilter) excluded(mountpoint string) bool {
+	matches := func(pattern string) bool { return matchMountPattern(pattern, mountpoint) }
+	return slices.ContainsFunc(f.exclude, matches) && !slices.ContainsFunc(f.include, matches)
+}
+
+// matchMountPattern returns true if pattern matches mountpoint or one of its
+// parent directories other than the root, so /snap/* matches /snap/core/1234
+// but / only matches the root mount
+func matchMountPattern(pattern, mountpoint string) bool {
+	for p := path.Clean(mountpoint); ; p = path.Dir(p) {
+		if matched, _ := path.Match(pattern, p); matched {
+			return true
+		}
+		if parent := path.Dir(p); parent == p || parent == "/" {
+			return false
+		}
+	}
+}
+
 // Returns matching device from /proc/diskstats,
 // or the device with the most reads if no match is found.
 // bool is true if a match was found.
@@ -184,7 +253,74 @@ func (a *Agent) initializeDiskIoStats(diskIoCounters map[string]disk.IOCountersS
 		stats.Time = time.Now()
 		stats.TotalRead = d.ReadBytes
 		stats.TotalWrite = d.WriteBytes
+		if runtime.GOOS == "linux" {
+			stats.Model, stats.Serial = readDiskModelSerial("/sys", device)
+			stats.QueueDepth = readDiskQueueDepth("/sys", device)
+			slog.Debug("Disk", "device", device, "model", stats.Model, "serial", stats.Serial, "queue", stats.QueueDepth)
+		}
 		// add to list of valid io device names
 		a.fsNames = append(a.fsNames, device)
 	}
 }
+
+// NVMe namespace block device, e.g. nvme0n1 on controller nvme0
+var nvmeNamespacePattern = regexp.MustCompile(`^(nvme\d+)n\d+$`)
+
+// sysfsDiskName returns the name of the disk in sysfs at sysPath for a block
+// device from /proc/diskstats, which is the parent disk of a partition, or an
+// empty string if it isn't found
+func sysfsDiskName(sysPath, device string) string {
+	if _, err := os.Stat(filepath.Join(sysPath, "block", device)); err == nil {
+		return device
+	}
+	// partitions are listed under their parent disk, e.g. /sys/block/sda/sda1
+	matches, _ := filepath.Glob(filepath.Join(sysPath, "block", "*", device))
+	if len(matches) == 0 {
+		return ""
+	}
+	return filepath.Base(filepath.Dir(matches[0]))
+}
+
+// readDiskModelSerial returns the model and serial number of the disk backing
+// a block device from /proc/diskstats, read from sysfs at sysPath (usually /sys).
+func readDiskModelSerial(sysPath, device string) (model, serial string) {
+	diskName := sysfsDiskName(sysPath, device)
+	if diskName == "" {
+		return "", ""
+	}
+	deviceDir := filepath.Join(sysPath, "block", diskName, "device")
+	// NVMe model and serial belong to the controller
+	if matches := nvmeNamespacePattern.FindStringSubmatch(diskName); matches != nil {
+		deviceDir = filepath.Join(sysPath, "class", "nvme", matches[1])
+	}
+	return readSysfsValue(filepath.Join(deviceDir, "model")), readSysfsValue(filepath.Join(deviceDir, "serial"))
+}
+
+// readDiskQueueDepth returns the maximum number of requests queued for the disk
+// backing a block device, from queue/nr_requests, or the queue depth of the
+// controller for NVMe disks that report it. Returns 0 if neither is available,
+// e.g. for virtual devices.
+func readDiskQueueDepth(sysPath, device string) uint32 {
+	diskName := sysfsDiskName(sysPath, device)
+	if diskName == "" {
+		return 0
+	}
+	path := filepath.Join(sysPath, "block", diskName, "queue", "nr_requests")
+	if matches := nvmeNamespacePattern.FindStringSubmatch(diskName); matches != nil {
+		controllerPath := filepath.Join(sysPath, "class", "nvme", matches[1], "device", "queue_depth")
+		if _, err := os.Stat(controllerPath); err == nil {
+			path = controllerPath
+		}
+	}
+	depth, _ := strconv.ParseUint(readSysfsValue(path), 10, 32)
+	return uint32(depth)
+}
+
+// readSysfsValue returns the trimmed content of a sysfs file, or an empty string if it can't be read
+func readSysfsValue(path string) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
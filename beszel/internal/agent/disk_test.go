@@ -0,0 +1,106 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadDiskModelSerial(t *testing.T) {
+	sysPath := t.TempDir()
+	writeFile := func(path, content string) {
+		fullPath := filepath.Join(sysPath, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fullPath), 0755))
+		require.NoError(t, os.WriteFile(fullPath, []byte(content), 0644))
+	}
+	// SATA disk with a partition
+	writeFile("block/sda/device/model", "Samsung SSD 870 \n")
+	writeFile("block/sda/device/serial", "S5Y1NX0R123456\n")
+	require.NoError(t, os.MkdirAll(filepath.Join(sysPath, "block/sda/sda1"), 0755))
+	// NVMe namespace with model and serial on the controller
+	require.NoError(t, os.MkdirAll(filepath.Join(sysPath, "block/nvme0n1/nvme0n1p2"), 0755))
+	writeFile("class/nvme/nvme0/model", "WD_BLACK SN850X 2000GB                  \n")
+	writeFile("class/nvme/nvme0/serial", "23123A800123        \n")
+	// virtual disk without model or serial
+	require.NoError(t, os.MkdirAll(filepath.Join(sysPath, "block/vda"), 0755))
+
+	tests := []struct {
+		device string
+		model  string
+		serial string
+	}{
+		{"sda", "Samsung SSD 870", "S5Y1NX0R123456"},
+		{"sda1", "Samsung SSD 870", "S5Y1NX0R123456"},
+		{"nvme0n1", "WD_BLACK SN850X 2000GB", "23123A800123"},
+		{"nvme0n1p2", "WD_BLACK SN850X 2000GB", "23123A800123"},
+		{"vda", "", ""},
+		{"dm-0", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.device, func(t *testing.T) {
+			model, serial := readDiskModelSerial(sysPath, tt.device)
+			assert.Equal(t, tt.model, model)
+			assert.Equal(t, tt.serial, serial)
+		})
+	}
+}
+
+func TestReadDiskQueueDepth(t *testing.T) {
+	sysPath := t.TempDir()
+	writeFile := func(path, content string) {
+		fullPath := filepath.Join(sysPath, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fullPath), 0755))
+		require.NoError(t, os.WriteFile(fullPath, []byte(content), 0644))
+	}
+	// SATA disk with a partition
+	writeFile("block/sda/queue/nr_requests", "128\n")
+	require.NoError(t, os.MkdirAll(filepath.Join(sysPath, "block/sda/sda1"), 0755))
+	// NVMe namespace whose controller reports its queue depth
+	writeFile("block/nvme0n1/queue/nr_requests", "1023\n")
+	writeFile("class/nvme/nvme0/device/queue_depth", "4096\n")
+	// NVMe namespace without a controller queue depth
+	writeFile("block/nvme1n1/queue/nr_requests", "255\n")
+	// virtual disk without a queue
+	require.NoError(t, os.MkdirAll(filepath.Join(sysPath, "block/vda"), 0755))
+
+	assert.Equal(t, uint32(128), readDiskQueueDepth(sysPath, "sda"))
+	assert.Equal(t, uint32(128), readDiskQueueDepth(sysPath, "sda1"))
+	assert.Equal(t, uint32(4096), readDiskQueueDepth(sysPath, "nvme0n1"))
+	assert.Equal(t, uint32(255), readDiskQueueDepth(sysPath, "nvme1n1"))
+	assert.Zero(t, readDiskQueueDepth(sysPath, "vda"))
+	assert.Zero(t, readDiskQueueDepth(sysPath, "dm-0"))
+}
+
+func TestFsTypeName(t *testing.T) {
+	assert.Equal(t, "ext4", fsTypeName(0xef53))
+	assert.Equal(t, "tmpfs", fsTypeName(0x01021994))
+	assert.Equal(t, "xfs", fsTypeName(0x58465342))
+	assert.Equal(t, "0x12345678", fsTypeName(0x12345678), "unknown types are kept as hex")
+}
+
+func TestDiskFilter(t *testing.T) {
+	t.Setenv("BESZEL_DISK_EXCLUDE", "/snap/*, /proc,/sys,[invalid,/*")
+	t.Setenv("BESZEL_DISK_INCLUDE", "/ ")
+	filter := newDiskFilter()
+	assert.Equal(t, []string{"/snap/*", "/proc", "/sys", "/*"}, filter.exclude)
+	assert.Equal(t, []string{"/"}, filter.include)
+
+	for _, mountpoint := range []string{"/snap/core/1234", "/snap/core", "/proc", "/sys/fs/cgroup", "/home", "/extra-filesystems/sdb1"} {
+		assert.True(t, filter.excluded(mountpoint), mountpoint)
+	}
+	assert.False(t, filter.excluded("/"), "root is forced with DISK_INCLUDE")
+
+	// the root is only excluded by patterns that match it directly
+	filter = diskFilter{exclude: parseMountPatterns("/snap/*,/proc")}
+	assert.True(t, filter.excluded("/snap/core/1234"))
+	assert.False(t, filter.excluded("/"))
+	assert.False(t, filter.excluded("/home"))
+	assert.False(t, filter.excluded("/snap"), "/snap/* only matches mounts below /snap")
+	assert.False(t, diskFilter{}.excluded("/snap/core/1234"))
+}
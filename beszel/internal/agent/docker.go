@@ -57,9 +57,9 @@ func (d *dockerManager) dequeue() {
 	}
 }
 
-// Returns stats for all running containers
-func (dm *dockerManager) getDockerStats() ([]*container.Stats, error) {
-	resp, err := dm.client.Get("http://localhost/containers/json")
+// Returns stats for all running containers. Requests are cancelled if ctx is done.
+func (dm *dockerManager) getDockerStats(ctx context.Context) ([]*container.Stats, error) {
+	resp, err := dm.get(ctx, "http://localhost/containers/json")
 	if err != nil {
 		return nil, err
 	}
@@ -95,7 +95,7 @@ func (dm *dockerManager) getDockerStats() ([]*container.Stats, error) {
 		dm.queue()
 		go func() {
 			defer dm.dequeue()
-			err := dm.updateContainerStats(ctr)
+			err := dm.updateContainerStats(ctx, ctr)
 			// if error, delete from map and add to failed list to retry
 			if err != nil {
 				dm.containerStatsMutex.Lock()
@@ -115,7 +115,7 @@ func (dm *dockerManager) getDockerStats() ([]*container.Stats, error) {
 			dm.queue()
 			go func() {
 				defer dm.dequeue()
-				err = dm.updateContainerStats(ctr)
+				err = dm.updateContainerStats(ctx, ctr)
 				if err != nil {
 					slog.Error("Error getting container stats", "err", err)
 				}
@@ -138,10 +138,10 @@ func (dm *dockerManager) getDockerStats() ([]*container.Stats, error) {
 }
 
 // Updates stats for individual container
-func (dm *dockerManager) updateContainerStats(ctr *container.ApiInfo) error {
+func (dm *dockerManager) updateContainerStats(ctx context.Context, ctr *container.ApiInfo) error {
 	name := ctr.Names[0][1:]
 
-	resp, err := dm.client.Get("http://localhost/containers/" + ctr.IdShort + "/stats?stream=0&one-shot=1")
+	resp, err := dm.get(ctx, "http://localhost/containers/"+ctr.IdShort+"/stats?stream=0&one-shot=1")
 	if err != nil {
 		return err
 	}
@@ -205,8 +205,13 @@ func (dm *dockerManager) updateContainerStats(ctr *container.ApiInfo) error {
 	// prevent first run from sending all prev sent/recv bytes
 	if initialized {
 		secondsElapsed := time.Since(stats.PrevRead).Seconds()
-		sent_delta = float64(total_sent-stats.PrevNet.Sent) / secondsElapsed
-		recv_delta = float64(total_recv-stats.PrevNet.Recv) / secondsElapsed
+		sent, sentOk := counterDelta(stats.PrevNet.Sent, total_sent)
+		recv, recvOk := counterDelta(stats.PrevNet.Recv, total_recv)
+		// counters are reset when the container restarts, so skip the sample
+		if sentOk && recvOk {
+			sent_delta = float64(sent) / secondsElapsed
+			recv_delta = float64(recv) / secondsElapsed
+		}
 	}
 	stats.PrevNet.Sent = total_sent
 	stats.PrevNet.Recv = total_recv
@@ -220,6 +225,15 @@ func (dm *dockerManager) updateContainerStats(ctr *container.ApiInfo) error {
 	return nil
 }
 
+// get sends a GET request to the Docker API
+func (dm *dockerManager) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return dm.client.Do(req)
+}
+
 // Delete container stats from map using mutex
 func (dm *dockerManager) deleteContainerStatsSync(id string) {
 	dm.containerStatsMutex.Lock()
@@ -0,0 +1,153 @@
+//go:build linux
+
+package agent
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+//go:generate llvm-mc -triple bpfel -filetype=obj -o netproto_bpf.o netproto_bpf.asm
+
+// compiled from netproto_bpf.asm
+//
+//go:embed netproto_bpf.o
+var netProtoBpf []byte
+
+// names reported for IP protocol numbers, anything else is counted as "other"
+var ipProtoNames = map[uint64]string{
+	1:  "icmp",
+	6:  "tcp",
+	17: "udp",
+	58: "icmp", // ICMPv6
+}
+
+// EBPFNetCollector counts packets per protocol on each network interface
+// using a TC program attached to ingress and egress
+type EBPFNetCollector struct {
+	prog       *ebpf.Program
+	counts     *ebpf.Map
+	links      []link.Link
+	interfaces map[uint32]string // interface names by index
+}
+
+// newEBPFNetCollector returns a collector if BESZEL_EBPF_NET is "true", or nil otherwise.
+// Requires kernel 6.6+ (TCX) and CAP_BPF / CAP_NET_ADMIN.
+func newEBPFNetCollector(interfaces map[string]struct{}) *EBPFNetCollector {
+	if enabled, _ := GetEnvFallback("BESZEL_AGENT_EBPF_NET", "BESZEL_EBPF_NET"); enabled != "true" {
+		return nil
+	}
+	names := make([]string, 0, len(interfaces))
+	for name := range interfaces {
+		names = append(names, name)
+	}
+	c, err := loadEBPFNetCollector(names)
+	if err != nil {
+		slog.Warn("eBPF network stats unavailable", "err", err)
+		return nil
+	}
+	return c
+}
+
+// loadEBPFNetCollector loads the embedded program and attaches it to the given interfaces
+func loadEBPFNetCollector(interfaces []string) (*EBPFNetCollector, error) {
+	spec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(netProtoBpf))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse eBPF object: %w", err)
+	}
+	progSpec, ok := spec.Programs["count_protocols"]
+	if !ok {
+		return nil, errors.New("eBPF program count_protocols not found")
+	}
+	progSpec.Type = ebpf.SchedCLS
+
+	coll, err := ebpf.NewCollection(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load eBPF program: %w", err)
+	}
+	c := &EBPFNetCollector{
+		prog:       coll.DetachProgram("count_protocols"),
+		counts:     coll.DetachMap("counts"),
+		interfaces: make(map[uint32]string, len(interfaces)),
+	}
+	coll.Close()
+
+	for _, name := range interfaces {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			slog.Debug("eBPF network stats", "iface", name, "err", err)
+			continue
+		}
+		for _, attach := range []ebpf.AttachType{ebpf.AttachTCXIngress, ebpf.AttachTCXEgress} {
+			l, err := link.AttachTCX(link.TCXOptions{
+				Interface: iface.Index,
+				Program:   c.prog,
+				Attach:    attach,
+			})
+			if err != nil {
+				c.Close()
+				return nil, fmt.Errorf("failed to attach eBPF program to %s: %w", name, err)
+			}
+			c.links = append(c.links, l)
+		}
+		c.interfaces[uint32(iface.Index)] = name
+	}
+	if len(c.links) == 0 {
+		c.Close()
+		return nil, errors.New("no network interfaces to attach to")
+	}
+	return c, nil
+}
+
+// Collect returns total packet counts keyed by "<interface>/<protocol>"
+func (c *EBPFNetCollector) Collect() map[string]uint64 {
+	if c == nil {
+		return nil
+	}
+	stats := make(map[string]uint64)
+	var key, count uint64
+	iter := c.counts.Iterate()
+	for iter.Next(&key, &count) {
+		name, ok := c.interfaces[uint32(key>>32)]
+		if !ok {
+			continue
+		}
+		proto, ok := ipProtoNames[key&0xff]
+		if !ok {
+			proto = "other"
+		}
+		stats[name+"/"+proto] += count
+	}
+	if err := iter.Err(); err != nil {
+		slog.Debug("eBPF network stats", "err", err)
+	}
+	return stats
+}
+
+// Close detaches the program from all interfaces and releases its resources
+func (c *EBPFNetCollector) Close() error {
+	if c == nil {
+		return nil
+	}
+	var errs []error
+	for _, l := range c.links {
+		if err := l.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	c.links = nil
+	if err := c.prog.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.counts.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
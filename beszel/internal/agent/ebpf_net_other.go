@@ -0,0 +1,19 @@
+//go:build !linux
+
+package agent
+
+import "log/slog"
+
+// EBPFNetCollector is only supported on Linux
+type EBPFNetCollector struct{}
+
+func newEBPFNetCollector(map[string]struct{}) *EBPFNetCollector {
+	if enabled, _ := GetEnvFallback("BESZEL_AGENT_EBPF_NET", "BESZEL_EBPF_NET"); enabled == "true" {
+		slog.Warn("eBPF network stats are only supported on Linux")
+	}
+	return nil
+}
+
+func (c *EBPFNetCollector) Collect() map[string]uint64 { return nil }
+
+func (c *EBPFNetCollector) Close() error { return nil }
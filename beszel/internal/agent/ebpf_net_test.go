@@ -0,0 +1,50 @@
+//go:build testing && linux
+// +build testing,linux
+
+package agent
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEBPFNetCollectorDisabled(t *testing.T) {
+	t.Setenv("BESZEL_AGENT_EBPF_NET", "")
+	t.Setenv("BESZEL_EBPF_NET", "")
+	assert.Nil(t, newEBPFNetCollector(map[string]struct{}{"lo": {}}))
+
+	var c *EBPFNetCollector
+	assert.Nil(t, c.Collect())
+	assert.NoError(t, c.Close())
+}
+
+func TestEBPFNetCollector(t *testing.T) {
+	c, err := loadEBPFNetCollector([]string{"lo"})
+	if err != nil {
+		t.Skipf("eBPF not available: %v", err)
+	}
+	defer c.Close()
+
+	// send a few UDP packets over loopback
+	server, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer server.Close()
+	client, err := net.Dial("udp4", server.LocalAddr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	for range 5 {
+		_, err := client.Write([]byte("ping"))
+		require.NoError(t, err)
+	}
+
+	assert.Eventually(t, func() bool {
+		// each packet is seen on both egress and ingress
+		return c.Collect()["lo/udp"] >= 10
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, c.Close())
+}
@@ -0,0 +1,38 @@
+package agent
+
+import "strconv"
+
+// Filesystem magic numbers from statfs(2), see linux/magic.h
+var fsTypeNames = map[int64]string{
+	0x9123683e: "btrfs",
+	0x28cd3d45: "cramfs",
+	0xef53:     "ext4", // also ext2 and ext3
+	0xf2f52010: "f2fs",
+	0x4d44:     "vfat",
+	0x65735546: "fuse",
+	0x01021997: "v9fs",
+	0x4244:     "hfs",
+	0x958458f6: "hugetlbfs",
+	0x9660:     "iso9660",
+	0x6969:     "nfs",
+	0x5346544e: "ntfs",
+	0x794c7630: "overlay",
+	0x9fa0:     "proc",
+	0x52654973: "reiserfs",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x73717368: "squashfs",
+	0x62656572: "sysfs",
+	0x01021994: "tmpfs",
+	0x58465342: "xfs",
+	0x2fc12fc1: "zfs",
+}
+
+// fsTypeName returns the name of a filesystem statfs magic number, or the
+// number in hex if it is unknown
+func fsTypeName(magic int64) string {
+	if name, ok := fsTypeNames[magic]; ok {
+		return name
+	}
+	return "0x" + strconv.FormatInt(magic, 16)
+}
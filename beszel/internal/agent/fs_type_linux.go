@@ -0,0 +1,17 @@
+//go:build linux
+
+package agent
+
+import "syscall"
+
+// filesystemType returns the type of the filesystem mounted at path, e.g.
+// "ext4" or "tmpfs", or an empty string if it can't be read
+func filesystemType(path string) string {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return ""
+	}
+	// Type is int32 on some architectures, and magic numbers above 0x7fffffff
+	// must not be sign extended
+	return fsTypeName(int64(uint32(stat.Type)))
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package agent
+
+// filesystemType is only supported on Linux, where statfs reports magic numbers
+func filesystemType(string) string {
+	return ""
+}
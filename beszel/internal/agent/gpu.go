@@ -4,16 +4,24 @@ import (
 	"beszel/internal/entities/system"
 	"bufio"
 	"bytes"
+	"cmp"
+	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"os"
 	"os/exec"
+	"reflect"
 	"regexp"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"golang.org/x/exp/slog"
 )
 
 const (
@@ -30,33 +38,241 @@ const (
 	// Command retry and timeout constants
 	retryWaitTime     = 5 * time.Second
 	maxFailureRetries = 5
+	gpuWatchInterval  = 30 * time.Second // how often to check for GPUs after all collectors exit
+	gpuProbeTimeout   = 10 * time.Second // how long probeGPUs waits for each tool
+
+	// Default temperature alert thresholds in Celsius
+	defaultTempWarnThreshold = 85.0
+	defaultTempCritThreshold = 95.0
+
+	// Fraction of the Nvidia max power limit above which a warning is logged
+	maxPowerWarnRatio = 0.95
+
+	// Default minimum change for a value to be included in GetCurrentDataDiff
+	defaultDiffEpsilon = 0.1
 
 	cmdBufferSize = 10 * 1024
 
+	// PATH used for GPU tool subprocesses instead of the inherited PATH
+	gpuCmdPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
 	// Unit Conversions
 	mebibytesInAMegabyte = 1.024  // nvidia-smi reports memory in MiB
 	milliwattsInAWatt    = 1000.0 // tegrastats reports power in mW
 )
 
+// GPUManagerOptions configures optional GPUManager behavior
+type GPUManagerOptions struct {
+	// ExpectedGPUCount is used to pre-allocate GpuDataMap. If 0, the count
+	// is detected with nvidia-smi when available.
+	ExpectedGPUCount int
+	// AggregationWindow limits averaging to samples collected within the window.
+	// If 0, all samples since the last GetCurrentData call are averaged.
+	AggregationWindow time.Duration
+	// TempWarnThreshold and TempCritThreshold log a warning or error when a GPU
+	// temperature exceeds them. Defaults to 85°C and 95°C.
+	TempWarnThreshold float64
+	TempCritThreshold float64
+	// DiffEpsilon is the minimum change for GetCurrentDataDiff to treat a value
+	// as changed. Defaults to 0.1.
+	DiffEpsilon float64
+	// RetryPolicies overrides the retry policy of a collector, keyed by command
+	// (nvidia-smi, rocm-smi, or tegrastats).
+	RetryPolicies map[string]RetryPolicy
+	// SmoothingAlpha applies an exponential moving average to the usage and power
+	// reported by GetCurrentData, so a single spike is spread over several calls.
+	// Higher values follow changes faster, e.g. 0.3 for moderate smoothing. If 0,
+	// the averages of each call are reported as is.
+	SmoothingAlpha float64
+	// Alerter forwards temperature and ECC error alerts to syslog. If nil, they
+	// are only logged.
+	Alerter *SyslogAlerter
+	// StatsPrecision is the number of decimal places of the values reported by
+	// GetCurrentData: 0, 1, or 2. Lower precision compresses better in time series
+	// databases. If nil, values are rounded to two decimals.
+	StatsPrecision *int
+}
+
+// RetryPolicy controls how a GPU collector retries after the command fails
+type RetryPolicy struct {
+	MaxRetries  int           // consecutive retries before the collector stops, 0 for no limit
+	BackoffBase time.Duration // wait after the first failure, doubled for each consecutive failure
+	BackoffMax  time.Duration // upper limit for the wait between retries, ignored if below BackoffBase
+}
+
+// DefaultRetryPolicy retries indefinitely with a fixed wait
+var DefaultRetryPolicy = RetryPolicy{
+	BackoffBase: retryWaitTime,
+	BackoffMax:  retryWaitTime,
+}
+
+// rocmRetryPolicy stops the rocm-smi collector after repeated failures,
+// retrying at the polling interval
+var rocmRetryPolicy = RetryPolicy{
+	MaxRetries:  maxFailureRetries,
+	BackoffBase: rocmSmiInterval,
+	BackoffMax:  rocmSmiInterval,
+}
+
+// exhausted returns true if the collector should stop after the given number of consecutive failures
+func (p RetryPolicy) exhausted(failures int) bool {
+	return p.MaxRetries > 0 && failures > p.MaxRetries
+}
+
+// backoff returns the wait before retrying after the given number of consecutive failures
+func (p RetryPolicy) backoff(failures int) time.Duration {
+	wait := p.BackoffBase
+	for i := 1; i < failures && wait < p.BackoffMax; i++ {
+		wait *= 2
+	}
+	return max(min(wait, p.BackoffMax), p.BackoffBase)
+}
+
 // GPUManager manages data collection for GPUs (either Nvidia or AMD)
 type GPUManager struct {
-	sync.Mutex
+	sync.Mutex // Serializes writers to GpuDataMap and the aggregates
 	nvidiaSmi  bool
 	rocmSmi    bool
 	tegrastats bool
+	rockchip   bool // Rockchip Mali GPU, read from sysfs
+	mali       bool // other Mali GPUs, read from devfreq
+	opts       GPUManagerOptions
+	nvidiaCols nvidiaColumnIndex      // columns of the fields queried from nvidia-smi, all fields if nil
+	amdGpuIDs  map[string]struct{}    // ids of GPUs reported by rocm-smi
+	amdFailed  bool                   // true while AMD GPUs are marked with an error after rocm-smi stopped
+	topology   []system.GPULink       // links between AMD GPUs, detected once at startup
+	amdParts   map[string]RocmSmiJson // partition modes of AMD GPUs keyed by id, detected once at startup
+	powerWarn  map[string]bool        // Nvidia GPUs near their max power limit, so the warning is logged once
+	tempLevels sync.Map               // temperature level of each GPU by name, so only changes are logged
+	modeQuery  *ComputeModeCollector  // queries Nvidia compute modes every few samples, nil until nvidia-smi starts
+	namePrefix string                 // prepended to the names of new Nvidia GPUs, e.g. the host of remote GPUs
+	errorLog   gpuErrorLog            // recent output that could not be parsed
 	GpuDataMap map[string]*system.GPUData
+	// aggregates holds the aggregation window and moving averages of each GPU,
+	// keyed by id like GpuDataMap, since they are not sent to the hub
+	aggregates map[string]*gpuAggregate
+	// snapshot is read by GetCurrentData without the lock, see gpuSnapshot
+	snapshot  atomic.Pointer[gpuSnapshot]
+	published *gpuSnapshot // last snapshot matching GpuDataMap, see beginUpdate
+	// initialized is closed once the first parse has populated GpuDataMap
+	initialized chan struct{}
+	initOnce    sync.Once
+	// activeCollectors is the number of collector goroutines still running
+	activeCollectors atomic.Int32
+	collectors       sync.Map // map[string]*gpuCollector keyed by command
+	// ctx is cancelled by Stop to end collection; wg tracks collector and watcher goroutines
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// nvidiaColumnGroup is a group of fields queried from nvidia-smi with --query-gpu
+type nvidiaColumnGroup struct {
+	fields   []string
+	optional bool // only queried if the driver supports the fields, see detectNvidiaColumns
+}
+
+// nvidiaColumns are the fields queried from nvidia-smi, in the order of its output.
+// The first group is required by parseNvidiaData.
+var nvidiaColumns = []nvidiaColumnGroup{
+	{fields: []string{"index", "name", "temperature.gpu", "memory.used", "memory.total", "utilization.gpu", "power.draw"}},
+	{fields: []string{"encoder.stats.sessionCount"}, optional: true},
+	{fields: []string{"power.limit"}, optional: true},
+	{fields: []string{"pcie.link.gen.current", "pcie.link.width.current"}, optional: true},
+	{fields: []string{"ecc.errors.uncorrected.volatile.total"}, optional: true},
+	{fields: []string{"utilization.memory"}, optional: true},
+	{fields: []string{"power.max_limit"}, optional: true},
+	{fields: []string{"memory.reserved"}, optional: true},
+	// the largest mappable BAR1 block is used to estimate memory fragmentation
+	{fields: []string{"memory.free", "bar1.memory.free", "bar1.memory.total"}, optional: true},
+}
+
+// defaultNvidiaColumns is the column index of nvidia-smi output with all fields
+var defaultNvidiaColumns = newNvidiaColumnIndex(allNvidiaFields())
+
+// nvidiaColumnIndex maps the fields queried from nvidia-smi to their column in its output
+type nvidiaColumnIndex map[string]int
+
+// newNvidiaColumnIndex returns the index of fields, in the order they are queried
+func newNvidiaColumnIndex(fields []string) nvidiaColumnIndex {
+	index := make(nvidiaColumnIndex, len(fields))
+	for i, field := range fields {
+		index[field] = i
+	}
+	return index
+}
+
+// value returns the value of field in the columns of an output line, or false if
+// the field was not queried
+func (c nvidiaColumnIndex) value(columns []string, field string) (string, bool) {
+	i, ok := c[field]
+	if !ok || i >= len(columns) {
+		return "", false
+	}
+	return columns[i], true
+}
+
+// allNvidiaFields returns the fields of all nvidia-smi column groups
+func allNvidiaFields() []string {
+	var fields []string
+	for _, group := range nvidiaColumns {
+		fields = append(fields, group.fields...)
+	}
+	return fields
+}
+
+// nvidiaQuery returns the nvidia-smi argument that queries fields
+func nvidiaQuery(fields []string) string {
+	return "--query-gpu=" + strings.Join(fields, ",")
+}
+
+// detectNvidiaColumns returns the fields to query from nvidia-smi, leaving out the
+// optional groups that the driver doesn't support. nvidia-smi rejects the whole
+// query if any field is unknown, so the optional groups are probed together, and
+// one by one only if that fails. probe runs nvidia-smi with a --query-gpu
+// argument and returns true if it succeeds.
+func detectNvidiaColumns(probe func(query string) bool) []string {
+	if fields := allNvidiaFields(); probe(nvidiaQuery(fields)) {
+		return fields
+	}
+	var fields []string
+	for _, group := range nvidiaColumns {
+		if group.optional && !probe(nvidiaQuery(group.fields)) {
+			slog.Info("nvidia-smi fields not supported", "fields", group.fields)
+			continue
+		}
+		fields = append(fields, group.fields...)
+	}
+	return fields
+}
+
+// probeNvidiaQuery returns true if the local nvidia-smi accepts the --query-gpu argument query
+func probeNvidiaQuery(query string) bool {
+	return newGPUCommand(nvidiaSmiCmd, query, "--format=csv,noheader,nounits").Run() == nil
 }
 
 // RocmSmiJson represents the JSON structure of rocm-smi output
 type RocmSmiJson struct {
-	ID           string `json:"GUID"`
-	Name         string `json:"Card series"`
-	Temperature  string `json:"Temperature (Sensor edge) (C)"`
-	MemoryUsed   string `json:"VRAM Total Used Memory (B)"`
-	MemoryTotal  string `json:"VRAM Total Memory (B)"`
-	Usage        string `json:"GPU use (%)"`
-	PowerPackage string `json:"Average Graphics Package Power (W)"`
-	PowerSocket  string `json:"Current Socket Graphics Package Power (W)"`
+	ID                string `json:"GUID"`
+	Name              string `json:"Card series"`
+	Temperature       string `json:"Temperature (Sensor edge) (C)"`
+	MemoryTemperature string `json:"Temperature (Sensor memory) (C)"`
+	MemoryUsed        string `json:"VRAM Total Used Memory (B)"`
+	MemoryTotal       string `json:"VRAM Total Memory (B)"`
+	Usage             string `json:"GPU use (%)"`
+	PowerPackage      string `json:"Average Graphics Package Power (W)"`
+	PowerSocket       string `json:"Current Socket Graphics Package Power (W)"`
+	PCIeTxBW          string `json:"Estimated maximum PCIe bandwidth over the last second (Tx) (MB/s)"`
+	PCIeRxBW          string `json:"Estimated maximum PCIe bandwidth over the last second (Rx) (MB/s)"`
+	PowerLimit        string `json:"Max Graphics Package Power (W)"`
+	// XGMI bandwidth is only reported with --showxgmibw by ROCm versions that support it
+	XGMIReadBW  string `json:"XGMI read bandwidth (MB/s)"`
+	XGMIWriteBW string `json:"XGMI write bandwidth (MB/s)"`
+	// partition modes are only read once, with --showcomputepartition and --showmemorypartition
+	ComputePartition string `json:"Compute Partition"`
+	MemoryPartition  string `json:"Memory Partition"`
+	// power management mode from --showperflevel: auto, low, high, manual, or a profile
+	PerformanceLevel string `json:"Performance Level"`
 }
 
 // gpuCollector defines a collector for a specific GPU management utility (nvidia-smi or rocm-smi)
@@ -65,29 +281,137 @@ type gpuCollector struct {
 	cmdArgs []string
 	parse   func([]byte) bool // returns true if valid data was found
 	buf     []byte
+	retry   RetryPolicy
+	// read returns a sample instead of running the command, if set
+	read func() ([]byte, error)
+	// parse results, kept across collector restarts
+	totalSuccessfulParses atomic.Uint64
+	totalFailedParses     atomic.Uint64
+	lastError             atomic.Pointer[string]
+}
+
+// CollectorStats holds the parse counters and last error of a GPU collector
+type CollectorStats struct {
+	SuccessfulParses uint64 `json:"successful_parses"`
+	FailedParses     uint64 `json:"failed_parses"`
+	LastError        string `json:"last_error,omitempty"`
 }
 
 var errNoValidData = fmt.Errorf("no valid GPU data found") // Error for missing data
 
+// GPU lines in `nvidia-smi -L` and `nvidia-smi nvlink` output, e.g.
+//
+//	GPU 0: NVIDIA A100-SXM4-40GB (UUID: GPU-5c89852c-d268-c3f3-1b07-005d5ae1dc3f)
+var nvidiaGpuListPattern = regexp.MustCompile(`^GPU (\d+):`)
+
+// tegrastats output patterns
+var (
+	jetsonRamPattern  = regexp.MustCompile(`RAM (\d+)/(\d+)MB`)
+	jetsonGr3dPattern = regexp.MustCompile(`GR3D_FREQ (\d+)%`)
+	jetsonTempPattern = regexp.MustCompile(`tj@(\d+\.?\d*)C`)
+	// Orin Nano / NX do not have GPU specific power monitor, so only the combined
+	// CPU_GPU_CV domain is available, see getJetsonParser
+	jetsonPowerPattern = regexp.MustCompile(`(GPU_SOC|CPU_GPU_CV) (\d+)mW`)
+	// thermal zones such as cpu@53.968C, soc0@50.75C, tj@53.968C (Xavier / Orin)
+	jetsonThermalZonePattern = regexp.MustCompile(`(\w+)@(\d+\.?\d*)C`)
+)
+
+// jetsonCombinedPowerLabel is the PowerLabel of Jetsons that report CPU_GPU_CV power
+const jetsonCombinedPowerLabel = "CPU+GPU+CV"
+
+// jetsonModelPath holds the board model, e.g. "NVIDIA Orin NX 16GB"
+var jetsonModelPath = "/proc/device-tree/model"
+
 // starts and manages the ongoing collection of GPU data for the specified GPU management utility
-func (c *gpuCollector) start() {
+func (c *gpuCollector) start(ctx context.Context) {
+	failures := 0
 	for {
-		err := c.collect()
-		if err != nil {
-			if err == errNoValidData {
-				slog.Warn(c.name + " found no valid GPU data, stopping")
-				break
-			}
-			slog.Warn(c.name+" failed, restarting", "err", err)
-			time.Sleep(retryWaitTime)
+		err := c.collect(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			failures = 0
 			continue
 		}
+		if err == errNoValidData {
+			slog.Warn(c.name + " found no valid GPU data, stopping")
+			break
+		}
+		failures++
+		if c.retry.exhausted(failures) {
+			slog.Warn(c.name+" failed too many times, stopping", "err", err)
+			break
+		}
+		slog.Warn(c.name+" failed, restarting", "err", err)
+		if !sleepContext(ctx, c.retry.backoff(failures)) {
+			return
+		}
+	}
+}
+
+// sleepContext waits for the duration and returns false if ctx is cancelled first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// newGPUCommand creates a command for a GPU management tool with a minimal
+// environment, so variables such as LD_PRELOAD or a modified PATH are not inherited
+func newGPUCommand(name string, args ...string) *exec.Cmd {
+	return newGPUCommandContext(context.Background(), name, args...)
+}
+
+// newGPUCommandContext is like newGPUCommand but kills the command when ctx is cancelled
+func newGPUCommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = gpuCommandEnv()
+	return cmd
+}
+
+// gpuCommandEnv returns the environment for GPU tool subprocesses.
+// Returns nil (inherit the environment) on Windows, where tools rely on system variables.
+func gpuCommandEnv() []string {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	env := []string{"PATH=" + gpuCmdPath}
+	// HOME and DISPLAY are needed by some GPU tools
+	for _, key := range []string{"HOME", "DISPLAY"} {
+		if value, ok := os.LookupEnv(key); ok {
+			env = append(env, key+"="+value)
+		}
 	}
+	return env
 }
 
 // collect executes the command, parses output with the assigned parser function
-func (c *gpuCollector) collect() error {
-	cmd := exec.Command(c.name, c.cmdArgs...)
+func (c *gpuCollector) collect(ctx context.Context) (err error) {
+	defer func() {
+		if err != nil && ctx.Err() == nil {
+			errStr := err.Error()
+			c.lastError.Store(&errStr)
+		}
+	}()
+	if c.read != nil {
+		output, err := c.read()
+		if err != nil {
+			return err
+		}
+		if !c.parse(output) {
+			c.totalFailedParses.Add(1)
+			return errNoValidData
+		}
+		c.totalSuccessfulParses.Add(1)
+		return nil
+	}
+	cmd := newGPUCommandContext(ctx, c.name, c.cmdArgs...)
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return err
@@ -105,8 +429,10 @@ func (c *gpuCollector) collect() error {
 	for scanner.Scan() {
 		hasValidData := c.parse(scanner.Bytes())
 		if !hasValidData {
+			c.totalFailedParses.Add(1)
 			return errNoValidData
 		}
+		c.totalSuccessfulParses.Add(1)
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -115,57 +441,118 @@ func (c *gpuCollector) collect() error {
 	return cmd.Wait()
 }
 
+// stats returns the current parse counters and last error of the collector
+func (c *gpuCollector) stats() CollectorStats {
+	stats := CollectorStats{
+		SuccessfulParses: c.totalSuccessfulParses.Load(),
+		FailedParses:     c.totalFailedParses.Load(),
+	}
+	if lastError := c.lastError.Load(); lastError != nil {
+		stats.LastError = *lastError
+	}
+	return stats
+}
+
+// CollectorStats returns the parse counters of each GPU collector keyed by command
+func (gm *GPUManager) CollectorStats() map[string]CollectorStats {
+	stats := make(map[string]CollectorStats)
+	gm.collectors.Range(func(key, value any) bool {
+		stats[key.(string)] = value.(*gpuCollector).stats()
+		return true
+	})
+	return stats
+}
+
 // getJetsonParser returns a function to parse the output of tegrastats and update the GPUData map
 func (gm *GPUManager) getJetsonParser() func(output []byte) bool {
-	// use closure to avoid recompiling the regex
-	ramPattern := regexp.MustCompile(`RAM (\d+)/(\d+)MB`)
-	gr3dPattern := regexp.MustCompile(`GR3D_FREQ (\d+)%`)
-	tempPattern := regexp.MustCompile(`tj@(\d+\.?\d*)C`)
-	// Orin Nano / NX do not have GPU specific power monitor
-	// TODO: Maybe use VDD_IN for Nano / NX and add a total system power chart
-	powerPattern := regexp.MustCompile(`(GPU_SOC|CPU_GPU_CV) (\d+)mW`)
-
 	// jetson devices have only one gpu so we'll just initialize here
-	gpuData := &system.GPUData{Name: "GPU"}
+	gpuData := &system.GPUData{Name: cmp.Or(gpuDetection.getJetsonModel(), "GPU")}
+	gm.Lock()
 	gm.GpuDataMap["0"] = gpuData
+	gm.Unlock()
+	// the power domain is detected from the first line of output
+	powerDetected := false
 
 	return func(output []byte) bool {
 		gm.Lock()
 		defer gm.Unlock()
+		defer gm.publishSnapshot(gm.beginUpdate())
+		// Orin Nano / NX have no GPU_SOC rail, so their power includes the CPU and CV
+		// engines. It is labeled so it isn't mistaken for GPU power.
+		if !powerDetected {
+			powerDetected = true
+			if !bytes.Contains(output, []byte("GPU_SOC")) && jetsonPowerPattern.Match(output) {
+				gpuData.PowerLabel = jetsonCombinedPowerLabel
+				slog.Debug("Jetson power", "domain", jetsonCombinedPowerLabel)
+			}
+		}
+		now := time.Now()
+		gm.rollWindow("0", gpuData, now)
+		gpuData.LastUpdated = now
 		// Parse RAM usage
-		ramMatches := ramPattern.FindSubmatch(output)
+		ramMatches := jetsonRamPattern.FindSubmatch(output)
 		if ramMatches != nil {
 			gpuData.MemoryUsed, _ = strconv.ParseFloat(string(ramMatches[1]), 64)
 			gpuData.MemoryTotal, _ = strconv.ParseFloat(string(ramMatches[2]), 64)
 		}
 		// Parse GR3D (GPU) usage
-		gr3dMatches := gr3dPattern.FindSubmatch(output)
+		gr3dMatches := jetsonGr3dPattern.FindSubmatch(output)
 		if gr3dMatches != nil {
 			gr3dUsage, _ := strconv.ParseFloat(string(gr3dMatches[1]), 64)
-			gpuData.Usage += gr3dUsage
+			gm.addUsage("0", gpuData, gr3dUsage)
 		}
 		// Parse temperature
-		tempMatches := tempPattern.FindSubmatch(output)
+		tempMatches := jetsonTempPattern.FindSubmatch(output)
 		if tempMatches != nil {
-			gpuData.Temperature, _ = strconv.ParseFloat(string(tempMatches[1]), 64)
+			temp, _ := strconv.ParseFloat(string(tempMatches[1]), 64)
+			gm.setTemperature(gpuData, temp)
 		}
 		// Parse power usage
-		powerMatches := powerPattern.FindSubmatch(output)
+		powerMatches := jetsonPowerPattern.FindSubmatch(output)
 		if powerMatches != nil {
 			power, _ := strconv.ParseFloat(string(powerMatches[2]), 64)
-			gpuData.Power += power / milliwattsInAWatt
+			gm.addPower("0", gpuData, power/milliwattsInAWatt)
+		}
+		// Parse thermal zone temperatures
+		for _, zoneMatches := range jetsonThermalZonePattern.FindAllSubmatch(output, -1) {
+			zoneTemp, err := strconv.ParseFloat(string(zoneMatches[2]), 64)
+			if err != nil {
+				continue
+			}
+			if gpuData.ThermalZones == nil {
+				gpuData.ThermalZones = make(map[string]float64)
+			}
+			gpuData.ThermalZones[string(zoneMatches[1])] += zoneTemp
 		}
 		gpuData.Count++
 		return true
 	}
 }
 
+// detectJetsonModel returns the Jetson board model from the device tree, since
+// tegrastats doesn't report a name and nvidia-smi doesn't work without a discrete GPU
+func detectJetsonModel() string {
+	model, err := os.ReadFile(jetsonModelPath)
+	if err != nil {
+		slog.Debug("Jetson model", "err", err)
+		return ""
+	}
+	// device tree strings are null terminated
+	return strings.TrimSpace(strings.TrimRight(string(model), "\x00"))
+}
+
 // parseNvidiaData parses the output of nvidia-smi and updates the GPUData map
 func (gm *GPUManager) parseNvidiaData(output []byte) bool {
 	gm.Lock()
 	defer gm.Unlock()
+	defer gm.publishSnapshot(gm.beginUpdate())
+	columns := gm.nvidiaCols
+	if columns == nil {
+		columns = defaultNvidiaColumns
+	}
 	scanner := bufio.NewScanner(bytes.NewReader(output))
 	var valid bool
+	now := time.Now()
 	for scanner.Scan() {
 		line := scanner.Text() // Or use scanner.Bytes() for []byte
 		fields := strings.Split(strings.TrimSpace(line), ", ")
@@ -179,31 +566,135 @@ func (gm *GPUManager) parseNvidiaData(output []byte) bool {
 		totalMemory, _ := strconv.ParseFloat(fields[4], 64)
 		usage, _ := strconv.ParseFloat(fields[5], 64)
 		power, _ := strconv.ParseFloat(fields[6], 64)
+		// encoder sessions and power limit are N/A on GPUs that do not support them,
+		// and not queried if the driver doesn't
+		var encoderSessions uint64
+		var powerLimit float64
+		if value, ok := columns.value(fields, "encoder.stats.sessionCount"); ok {
+			encoderSessions, _ = strconv.ParseUint(value, 10, 32)
+		}
+		if value, ok := columns.value(fields, "power.limit"); ok {
+			powerLimit, _ = strconv.ParseFloat(value, 64)
+		}
 		// add gpu if not exists
 		if _, ok := gm.GpuDataMap[id]; !ok {
 			name := strings.TrimPrefix(fields[1], "NVIDIA ")
-			gm.GpuDataMap[id] = &system.GPUData{Name: strings.TrimSuffix(name, " Laptop GPU")}
+			gm.GpuDataMap[id] = &system.GPUData{Name: gm.namePrefix + strings.TrimSuffix(name, " Laptop GPU")}
 		}
 		// update gpu data
 		gpu := gm.GpuDataMap[id]
-		gpu.Temperature = temp
+		gm.rollWindow(id, gpu, now)
+		gpu.LastUpdated = now
+		gm.setTemperature(gpu, temp)
 		gpu.MemoryUsed = memoryUsage / mebibytesInAMegabyte
 		gpu.MemoryTotal = totalMemory / mebibytesInAMegabyte
-		gpu.Usage += usage
-		gpu.Power += power
+		gm.addUsage(id, gpu, usage)
+		gm.addPower(id, gpu, power)
+		gpu.EncoderSessions = uint32(encoderSessions)
+		gpu.PowerLimit = powerLimit
+		// PCIe link gen and width are N/A on GPUs that are not PCIe devices, such as SXM without a bridge
+		genValue, okGen := columns.value(fields, "pcie.link.gen.current")
+		widthValue, okWidth := columns.value(fields, "pcie.link.width.current")
+		if okGen && okWidth {
+			gen, _ := strconv.ParseUint(genValue, 10, 8)
+			width, _ := strconv.ParseUint(widthValue, 10, 8)
+			// the link rarely changes, so only log it for new GPUs or when it's renegotiated
+			if uint8(gen) != gpu.PCIeGen || uint8(width) != gpu.PCIeWidth {
+				slog.Info("GPU PCIe link", "gpu", gpu.Name, "gen", gen, "width", width)
+			}
+			gpu.PCIeGen, gpu.PCIeWidth = uint8(gen), uint8(width)
+		}
+		// uncorrected ECC errors are N/A on GPUs without ECC memory or with ECC disabled
+		if value, ok := columns.value(fields, "ecc.errors.uncorrected.volatile.total"); ok {
+			if eccErrors, err := strconv.ParseUint(value, 10, 64); err == nil {
+				gm.opts.Alerter.ECCErrors(gpu.Name+" "+id, eccErrors)
+			}
+		}
+		// utilization.memory is the percentage of the sample period in which device
+		// memory was being read or written, i.e. how busy the memory controller and
+		// copy engines are. It is unrelated to memory.used, which is the memory
+		// allocated, so a GPU can be near 100% while using little memory, or idle
+		// with its memory full.
+		if value, ok := columns.value(fields, "utilization.memory"); ok {
+			gpu.CopyEngineUsage, _ = strconv.ParseFloat(value, 64)
+		}
+		// power.max_limit is the highest cap the board supports, while power.limit is the
+		// cap currently set. A low max limit, such as the 75W a PCIe slot provides, can
+		// mean supplemental power connectors are missing.
+		if value, ok := columns.value(fields, "power.max_limit"); ok {
+			gpu.MaxPowerLimit, _ = strconv.ParseFloat(value, 64)
+			gm.checkMaxPower(id, gpu.Name, power, gpu.MaxPowerLimit)
+		}
+		// memory.reserved is held by the driver and context overhead rather than by
+		// applications, so it is reported separately from memory.used
+		if value, ok := columns.value(fields, "memory.reserved"); ok {
+			reserved, _ := strconv.ParseFloat(value, 64)
+			gpu.MemoryReserved = reserved / mebibytesInAMegabyte
+			gpu.MemoryAvailable = max(0, gpu.MemoryTotal-gpu.MemoryUsed-gpu.MemoryReserved)
+		}
+		// memory.free, bar1.memory.free and bar1.memory.total are only queried if BAR1 is supported
+		freeValue, okFree := columns.value(fields, "memory.free")
+		bar1FreeValue, okBar1Free := columns.value(fields, "bar1.memory.free")
+		bar1TotalValue, okBar1Total := columns.value(fields, "bar1.memory.total")
+		if okFree && okBar1Free && okBar1Total {
+			freeMemory, _ := strconv.ParseFloat(freeValue, 64)
+			bar1Free, _ := strconv.ParseFloat(bar1FreeValue, 64)
+			bar1Total, _ := strconv.ParseFloat(bar1TotalValue, 64)
+			// a BAR1 aperture smaller than VRAM (no resizable BAR) says nothing about fragmentation
+			if bar1Total >= totalMemory {
+				gpu.MemoryFragmentation = memoryFragmentation(bar1Free, freeMemory)
+			}
+		}
 		gpu.Count++
 	}
-	return valid
+	if !valid {
+		gm.errorLog.add(nvidiaSmiCmd, output)
+		return false
+	}
+	gm.modeQuery.tick()
+	return true
+}
+
+// checkMaxPower logs a warning when the power draw of an Nvidia GPU rises above
+// maxPowerWarnRatio of its max power limit. The caller must hold the lock.
+func (gm *GPUManager) checkMaxPower(id, name string, power, maxLimit float64) {
+	near := maxLimit > 0 && power > maxLimit*maxPowerWarnRatio
+	if near && !gm.powerWarn[id] {
+		slog.Warn("GPU power near max limit", "gpu", name, "power", power, "max", maxLimit)
+	}
+	if gm.powerWarn == nil {
+		gm.powerWarn = make(map[string]bool)
+	}
+	gm.powerWarn[id] = near
+}
+
+// memoryFragmentation returns 1 - largestFreeBlock/totalFree, so 0 means all free
+// memory is available in a single block. Returns 0 if there is no free memory.
+func memoryFragmentation(largestFreeBlock, totalFree float64) float64 {
+	if totalFree <= 0 || largestFreeBlock >= totalFree {
+		return 0
+	}
+	return 1 - max(largestFreeBlock, 0)/totalFree
 }
 
 // parseAmdData parses the output of rocm-smi and updates the GPUData map
 func (gm *GPUManager) parseAmdData(output []byte) bool {
 	var rocmSmiInfo map[string]RocmSmiJson
 	if err := json.Unmarshal(output, &rocmSmiInfo); err != nil || len(rocmSmiInfo) == 0 {
+		gm.errorLog.add(rocmSmiCmd, output)
 		return false
 	}
 	gm.Lock()
 	defer gm.Unlock()
+	defer gm.publishSnapshot(gm.beginUpdate())
+	if gm.amdFailed {
+		gm.amdFailed = false
+		slog.Info("AMD GPU collector recovered")
+	}
+	if gm.amdGpuIDs == nil {
+		gm.amdGpuIDs = make(map[string]struct{}, len(rocmSmiInfo))
+	}
+	now := time.Now()
 	for _, v := range rocmSmiInfo {
 		var power float64
 		if v.PowerPackage != "" {
@@ -215,59 +706,589 @@ func (gm *GPUManager) parseAmdData(output []byte) bool {
 		totalMemory, _ := strconv.ParseFloat(v.MemoryTotal, 64)
 		usage, _ := strconv.ParseFloat(v.Usage, 64)
 
-		if _, ok := gm.GpuDataMap[v.ID]; !ok {
-			gm.GpuDataMap[v.ID] = &system.GPUData{Name: v.Name}
+		if gpu, ok := gm.GpuDataMap[v.ID]; !ok || gpu.Error != "" {
+			part := gm.amdParts[v.ID]
+			gm.GpuDataMap[v.ID] = &system.GPUData{
+				Name:             v.Name,
+				ComputePartition: part.ComputePartition,
+				MemoryPartition:  part.MemoryPartition,
+			}
+			if part.ComputePartition != "" || part.MemoryPartition != "" {
+				slog.Info("AMD GPU partition", "gpu", v.Name, "id", v.ID, "compute", part.ComputePartition, "memory", part.MemoryPartition)
+			}
 		}
+		gm.amdGpuIDs[v.ID] = struct{}{}
 		gpu := gm.GpuDataMap[v.ID]
-		gpu.Temperature, _ = strconv.ParseFloat(v.Temperature, 64)
+		gm.rollWindow(v.ID, gpu, now)
+		gpu.LastUpdated = now
+		temp, _ := strconv.ParseFloat(v.Temperature, 64)
+		gm.setTemperature(gpu, temp)
+		// memory temperature is not reported by CDNA cards
+		gpu.MemoryTemp, _ = strconv.ParseFloat(v.MemoryTemperature, 64)
+		gpu.PCIeTxBandwidth, _ = strconv.ParseFloat(v.PCIeTxBW, 64)
+		gpu.PCIeRxBandwidth, _ = strconv.ParseFloat(v.PCIeRxBW, 64)
+		gpu.XGMIReadBW, _ = strconv.ParseFloat(v.XGMIReadBW, 64)
+		gpu.XGMIWriteBW, _ = strconv.ParseFloat(v.XGMIWriteBW, 64)
+		gpu.PowerLimit, _ = strconv.ParseFloat(v.PowerLimit, 64)
+		// manual disables automatic power management, and is easily left set after tuning
+		if v.PerformanceLevel == "manual" && gpu.PerformanceLevel != "manual" {
+			slog.Warn("AMD GPU performance level is manual", "gpu", v.Name, "id", v.ID)
+		}
+		gpu.PerformanceLevel = v.PerformanceLevel
 		gpu.MemoryUsed = bytesToMegabytes(memoryUsage)
 		gpu.MemoryTotal = bytesToMegabytes(totalMemory)
-		gpu.Usage += usage
-		gpu.Power += power
+		gm.addUsage(v.ID, gpu, usage)
+		gm.addPower(v.ID, gpu, power)
 		gpu.Count++
 	}
 	return true
 }
 
-// sums and resets the current GPU utilization data since the last update
-func (gm *GPUManager) GetCurrentData() map[string]system.GPUData {
+// detectAmdPartitions returns the compute and memory partition modes of AMD GPUs
+// that support partitioning (e.g. MI300X), keyed by id. Modes can only change
+// with a reboot or driver reload, so they are only read once.
+func detectAmdPartitions() map[string]RocmSmiJson {
+	output, err := newGPUCommand(rocmSmiCmd, "--showid", "--showcomputepartition", "--showmemorypartition", "--json").Output()
+	if err != nil {
+		slog.Debug("AMD GPU partitions", "err", err)
+		return nil
+	}
+	partitions, err := parseAmdPartitions(output)
+	if err != nil {
+		slog.Debug("AMD GPU partitions", "err", err)
+		return nil
+	}
+	return partitions
+}
+
+// parseAmdPartitions parses the output of `rocm-smi --showid --showcomputepartition
+// --showmemorypartition --json`. GPUs without partitioning report N/A and are left out.
+func parseAmdPartitions(output []byte) (map[string]RocmSmiJson, error) {
+	var rocmSmiInfo map[string]RocmSmiJson
+	if err := json.Unmarshal(output, &rocmSmiInfo); err != nil {
+		return nil, err
+	}
+	partitions := make(map[string]RocmSmiJson, len(rocmSmiInfo))
+	for _, v := range rocmSmiInfo {
+		if v.ComputePartition == "N/A" {
+			v.ComputePartition = ""
+		}
+		if v.MemoryPartition == "N/A" {
+			v.MemoryPartition = ""
+		}
+		if v.ID == "" || (v.ComputePartition == "" && v.MemoryPartition == "") {
+			continue
+		}
+		partitions[v.ID] = RocmSmiJson{ComputePartition: v.ComputePartition, MemoryPartition: v.MemoryPartition}
+	}
+	return partitions, nil
+}
+
+// addUsageSample counts a usage sample in the histogram bucket for its 10% range.
+// Buckets stop counting at 255 samples.
+func addUsageSample(gpu *system.GPUData, usage float64) {
+	bucket := min(max(int(usage/10), 0), len(gpu.UsageHistogram)-1)
+	if gpu.UsageHistogram[bucket] < math.MaxUint8 {
+		gpu.UsageHistogram[bucket]++
+	}
+}
+
+// addUsage adds a usage sample to gpu, and keeps it as the latest usage of the
+// GPU with id if there is an aggregation window. The caller must hold the lock.
+func (gm *GPUManager) addUsage(id string, gpu *system.GPUData, usage float64) {
+	gpu.Usage += usage
+	addUsageSample(gpu, usage)
+	if gm.opts.AggregationWindow > 0 {
+		gm.aggregate(id).lastUsage = usage
+	}
+}
+
+// addPower adds a power sample to gpu, and keeps it as the latest power of the
+// GPU with id if there is an aggregation window. The caller must hold the lock.
+func (gm *GPUManager) addPower(id string, gpu *system.GPUData, power float64) {
+	gpu.Power += power
+	if gm.opts.AggregationWindow > 0 {
+		gm.aggregate(id).lastPower = power
+	}
+}
+
+// markAmdFailed replaces the data of AMD GPUs with zero values and sets their
+// Error field, so stale values are not reported after rocm-smi stops
+func (gm *GPUManager) markAmdFailed(err error) {
 	gm.Lock()
 	defer gm.Unlock()
+	defer gm.publishSnapshot(gm.beginUpdate())
+	slog.Error("AMD GPU collector stopped, reporting GPUs as unavailable", "err", err, "gpus", len(gm.amdGpuIDs))
+	gm.amdFailed = true
+	for id := range gm.amdGpuIDs {
+		gpu, ok := gm.GpuDataMap[id]
+		if !ok {
+			continue
+		}
+		// count of 1 so the zero values are averaged to zero
+		gm.GpuDataMap[id] = &system.GPUData{Name: gpu.Name, Count: 1, Error: err.Error(), LastUpdated: gpu.LastUpdated}
+	}
+}
+
+// gpuAggregate is the agent-side state of a GPU that is kept between reports but
+// not sent to the hub
+type gpuAggregate struct {
+	windowStart   time.Time // start of the current aggregation window
+	smoothedUsage float64   // moving average of Usage, if smoothing is enabled
+	smoothedPower float64   // moving average of Power, if smoothing is enabled
+	smoothed      bool      // set once the moving averages have a value
+	lastUsage     float64   // latest usage sample, reported once the window has expired
+	lastPower     float64   // latest power sample, reported once the window has expired
+}
+
+// aggregate returns the agent-side state of the GPU with id, creating it if
+// needed. The caller must hold the lock.
+func (gm *GPUManager) aggregate(id string) *gpuAggregate {
+	if gm.aggregates == nil {
+		gm.aggregates = make(map[string]*gpuAggregate)
+	}
+	agg, ok := gm.aggregates[id]
+	if !ok {
+		agg = &gpuAggregate{}
+		gm.aggregates[id] = agg
+	}
+	return agg
+}
+
+// rollWindow starts a new aggregation window for gpu if the current one has
+// expired, discarding the samples accumulated in it. The caller must hold the lock.
+func (gm *GPUManager) rollWindow(id string, gpu *system.GPUData, now time.Time) {
+	if gm.opts.AggregationWindow <= 0 {
+		return
+	}
+	agg := gm.aggregate(id)
+	if agg.windowStart.IsZero() {
+		agg.windowStart = now
+		return
+	}
+	if now.Sub(agg.windowStart) > gm.opts.AggregationWindow {
+		gpu.Usage = 0
+		gpu.UsageHistogram = [10]uint8{}
+		gpu.Power = 0
+		gpu.Count = 0
+		gpu.TemperatureMin, gpu.TemperatureMax = 0, 0
+		clear(gpu.ThermalZones)
+		agg.windowStart = now
+	}
+}
+
+// windowExpired returns true if all samples of a GPU with the aggregate agg are
+// older than the aggregation window
+func (gm *GPUManager) windowExpired(agg gpuAggregate, now time.Time) bool {
+	return gm.opts.AggregationWindow > 0 && now.Sub(agg.windowStart) > gm.opts.AggregationWindow
+}
+
+// gpuSnapshot is an immutable copy of GpuDataMap and the aggregates. Writers
+// publish one after every update, and GetCurrentData replaces it with a copy
+// whose reported values are reset, which the next update adopts.
+type gpuSnapshot struct {
+	gpus       map[string]system.GPUData
+	aggregates map[string]gpuAggregate
+}
+
+// copySnapshot returns a copy of GpuDataMap and the aggregates. The caller must
+// hold the lock.
+func (gm *GPUManager) copySnapshot() *gpuSnapshot {
+	snapshot := &gpuSnapshot{
+		gpus:       make(map[string]system.GPUData, len(gm.GpuDataMap)),
+		aggregates: make(map[string]gpuAggregate, len(gm.aggregates)),
+	}
+	for id, gpu := range gm.GpuDataMap {
+		gpuCopy := *gpu
+		gpuCopy.ThermalZones = maps.Clone(gpu.ThermalZones)
+		snapshot.gpus[id] = gpuCopy
+	}
+	for id, agg := range gm.aggregates {
+		snapshot.aggregates[id] = *agg
+	}
+	return snapshot
+}
+
+// restoreSnapshot copies snapshot back into GpuDataMap and the aggregates. The
+// caller must hold the lock.
+func (gm *GPUManager) restoreSnapshot(snapshot *gpuSnapshot) {
+	for id, data := range snapshot.gpus {
+		// parsers may keep pointers to their GPUs, so the values are copied in place
+		if gpu, ok := gm.GpuDataMap[id]; ok {
+			*gpu = data
+			gpu.ThermalZones = maps.Clone(data.ThermalZones)
+		}
+	}
+	for id, agg := range snapshot.aggregates {
+		*gm.aggregate(id) = agg
+	}
+	gm.published = snapshot
+}
+
+// beginUpdate adopts the values reset by GetCurrentData since the last update, so
+// reported samples are not reported again, and returns the snapshot the update
+// starts from. The caller must hold the lock and pass the result to
+// publishSnapshot once GpuDataMap is updated, usually with
+//
+//	defer gm.publishSnapshot(gm.beginUpdate())
+func (gm *GPUManager) beginUpdate() *gpuSnapshot {
+	base := gm.snapshot.Load()
+	// every update publishes a snapshot, so GpuDataMap still matches the last one
+	// and the reset copy can replace it as is
+	if base != nil && base != gm.published {
+		gm.restoreSnapshot(base)
+	}
+	return base
+}
+
+// publishSnapshot publishes a copy of GpuDataMap and the aggregates in place of
+// base. If GetCurrentData reset base during the update, its resets are kept and
+// the samples of the update are added to them. The caller must hold the lock.
+func (gm *GPUManager) publishSnapshot(base *gpuSnapshot) {
+	next := gm.copySnapshot()
+	merged := false
+	for !gm.snapshot.CompareAndSwap(base, next) {
+		reset := gm.snapshot.Load()
+		next = mergeReset(base, reset, next)
+		base, merged = reset, true
+	}
+	if merged {
+		gm.restoreSnapshot(next)
+	}
+	gm.published = next
+	gm.markInitialized()
+}
+
+// mergeReset returns next with the resets that GetCurrentData made to base, which
+// are in reset, and the samples that were added to base to get next. GPUs whose
+// samples were discarded since base, e.g. when the aggregation window rolled over,
+// are taken from next as they are.
+func mergeReset(base, reset, next *gpuSnapshot) *gpuSnapshot {
+	merged := &gpuSnapshot{
+		gpus:       make(map[string]system.GPUData, len(next.gpus)),
+		aggregates: make(map[string]gpuAggregate, len(next.aggregates)),
+	}
+	for id, agg := range next.aggregates {
+		// the moving averages are only updated by GetCurrentData
+		if r, ok := reset.aggregates[id]; ok {
+			agg.smoothedUsage, agg.smoothedPower, agg.smoothed = r.smoothedUsage, r.smoothedPower, r.smoothed
+		}
+		merged.aggregates[id] = agg
+	}
+	for id, gpu := range next.gpus {
+		before, okBase := base.gpus[id]
+		after, okReset := reset.gpus[id]
+		restarted := gpu.Count < before.Count || gpu.Error != before.Error ||
+			next.aggregates[id].windowStart != base.aggregates[id].windowStart
+		if !okBase || !okReset || restarted {
+			merged.gpus[id] = gpu
+			continue
+		}
+		gpu.Usage = after.Usage + gpu.Usage - before.Usage
+		gpu.Power = after.Power + gpu.Power - before.Power
+		gpu.Count = after.Count + gpu.Count - before.Count
+		gpu.OverTempEvents = after.OverTempEvents + gpu.OverTempEvents - before.OverTempEvents
+		for i := range gpu.UsageHistogram {
+			gpu.UsageHistogram[i] = after.UsageHistogram[i] + gpu.UsageHistogram[i] - before.UsageHistogram[i]
+		}
+		for zone, temp := range gpu.ThermalZones {
+			gpu.ThermalZones[zone] = after.ThermalZones[zone] + temp - before.ThermalZones[zone]
+		}
+		// the temperature range starts again from the reading of this update, if any
+		gpu.TemperatureMin, gpu.TemperatureMax = after.TemperatureMin, after.TemperatureMax
+		if gpu.LastUpdated.After(before.LastUpdated) && gpu.Temperature > 0 {
+			gpu.TemperatureMin, gpu.TemperatureMax = gpu.Temperature, gpu.Temperature
+		}
+		merged.gpus[id] = gpu
+	}
+	return merged
+}
+
+// markInitialized closes the initialized channel the first time GpuDataMap has
+// data. The caller must hold the lock.
+func (gm *GPUManager) markInitialized() {
+	if gm.initialized == nil || len(gm.GpuDataMap) == 0 {
+		return
+	}
+	gm.initOnce.Do(func() {
+		close(gm.initialized)
+	})
+}
+
+// WaitForInit blocks until the first GPU data has been parsed or the context is done.
+// NewGPUManager does not wait for collectors, so callers that need initial data
+// (e.g. GPU names) should call this first.
+func (gm *GPUManager) WaitForInit(ctx context.Context) error {
+	select {
+	case <-gm.initialized:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
+// GetCurrentData returns the averages of the data collected since the last call
+// and resets the accumulated sums. It reads the published snapshot without the
+// lock, so it never waits for a collector.
+func (gm *GPUManager) GetCurrentData() map[string]system.GPUData {
+	for {
+		snapshot := gm.snapshot.Load()
+		if snapshot == nil {
+			return map[string]system.GPUData{}
+		}
+		gpuData, reset := gm.averageSnapshot(snapshot)
+		// if a collector published a new snapshot in the meantime, average that one
+		// instead, so its samples are not reported again after the reset
+		if !gm.snapshot.CompareAndSwap(snapshot, reset) {
+			continue
+		}
+		for _, gpu := range gpuData {
+			gm.checkTemperature(gpu.Name, gpu.Temperature)
+		}
+		slog.Debug("GPU", "data", gpuData)
+		return gpuData
+	}
+}
+
+// averageSnapshot returns the averages of the data in snapshot, and a copy of
+// snapshot with the accumulated sums reset
+func (gm *GPUManager) averageSnapshot(snapshot *gpuSnapshot) (map[string]system.GPUData, *gpuSnapshot) {
 	// check for GPUs with the same name
 	nameCounts := make(map[string]int)
-	for _, gpu := range gm.GpuDataMap {
+	for _, gpu := range snapshot.gpus {
 		nameCounts[gpu.Name]++
 	}
 
-	// copy / reset the data
-	gpuData := make(map[string]system.GPUData, len(gm.GpuDataMap))
-	for id, gpu := range gm.GpuDataMap {
-		// sum the data
-		gpu.Temperature = twoDecimals(gpu.Temperature)
-		gpu.MemoryUsed = twoDecimals(gpu.MemoryUsed)
-		gpu.MemoryTotal = twoDecimals(gpu.MemoryTotal)
-		gpu.Usage = twoDecimals(gpu.Usage / gpu.Count)
-		gpu.Power = twoDecimals(gpu.Power / gpu.Count)
-		// reset the count
-		gpu.Count = 1
-		// dereference to avoid overwriting anything else
-		gpuCopy := *gpu
+	round := gm.statsRounder()
+	now := time.Now()
+	gpuData := make(map[string]system.GPUData, len(snapshot.gpus))
+	reset := &gpuSnapshot{
+		gpus:       make(map[string]system.GPUData, len(snapshot.gpus)),
+		aggregates: maps.Clone(snapshot.aggregates),
+	}
+	for id, gpu := range snapshot.gpus {
+		agg := snapshot.aggregates[id]
+		// copied so the snapshot is not changed
+		gpuCopy := gpu
+		gpuCopy.Temperature = round(gpu.Temperature)
+		gpuCopy.TemperatureMin = round(gpu.TemperatureMin)
+		gpuCopy.TemperatureMax = round(gpu.TemperatureMax)
+		gpuCopy.MemoryTemp = round(gpu.MemoryTemp)
+		gpuCopy.MemoryUsed = round(gpu.MemoryUsed)
+		gpuCopy.MemoryTotal = round(gpu.MemoryTotal)
+		gpuCopy.MemoryReserved = round(gpu.MemoryReserved)
+		gpuCopy.MemoryAvailable = round(gpu.MemoryAvailable)
+		gpuCopy.PCIeTxBandwidth = round(gpu.PCIeTxBandwidth)
+		gpuCopy.PCIeRxBandwidth = round(gpu.PCIeRxBandwidth)
+		gpuCopy.NVLinkTxBandwidth = round(gpu.NVLinkTxBandwidth)
+		gpuCopy.NVLinkRxBandwidth = round(gpu.NVLinkRxBandwidth)
+		gpuCopy.XGMIReadBW = round(gpu.XGMIReadBW)
+		gpuCopy.XGMIWriteBW = round(gpu.XGMIWriteBW)
+		// a ratio from 0 to 1, so it keeps two decimals at any precision
+		gpuCopy.MemoryFragmentation = twoDecimals(gpu.MemoryFragmentation)
+		gpuCopy.PowerLimit = round(gpu.PowerLimit)
+		gpuCopy.MaxPowerLimit = round(gpu.MaxPowerLimit)
+		gpuCopy.CopyEngineUsage = round(gpu.CopyEngineUsage)
+		gpuCopy.Frequency = round(gpu.Frequency)
+		gpuCopy.MaxFrequency = round(gpu.MaxFrequency)
+		gpuCopy.NPUUsage = round(gpu.NPUUsage)
+		usage, power := gpu.Usage/gpu.Count, gpu.Power/gpu.Count
+		// all samples are older than the aggregation window, so only the latest
+		// readings are reported until the next sample starts a new window
+		if gpu.Error == "" && gm.windowExpired(agg, now) {
+			usage, power = agg.lastUsage, agg.lastPower
+		}
+		if alpha := gm.opts.SmoothingAlpha; alpha > 0 {
+			if agg.smoothed {
+				usage = alpha*usage + (1-alpha)*agg.smoothedUsage
+				power = alpha*power + (1-alpha)*agg.smoothedPower
+			}
+			agg.smoothedUsage, agg.smoothedPower, agg.smoothed = usage, power, true
+			reset.aggregates[id] = agg
+		}
+		gpuCopy.Usage = round(usage)
+		gpuCopy.Power = round(power)
+		gpuCopy.Count = 1
+		if len(gpu.ThermalZones) > 0 {
+			gpuCopy.ThermalZones = make(map[string]float64, len(gpu.ThermalZones))
+			for zone, temp := range gpu.ThermalZones {
+				gpuCopy.ThermalZones[zone] = round(temp / gpu.Count)
+			}
+		}
 		// append id to the name if there are multiple GPUs with the same name
 		if nameCounts[gpu.Name] > 1 {
 			gpuCopy.Name = fmt.Sprintf("%s %s", gpu.Name, id)
 		}
 		gpuData[id] = gpuCopy
+		reset.gpus[id] = resetAccumulated(gpu)
+	}
+	return gpuData, reset
+}
+
+// statsRounder returns the function that rounds the values reported by
+// GetCurrentData to StatsPrecision decimal places
+func (gm *GPUManager) statsRounder() func(float64) float64 {
+	precision := gm.opts.StatsPrecision
+	switch {
+	case precision == nil || *precision >= 2:
+		return twoDecimals
+	case *precision == 1:
+		return oneDecimal
+	default:
+		return noDecimals
+	}
+}
+
+// GetCurrentDataDiff returns the current GPU data for GPUs where any value changed
+// by more than DiffEpsilon compared to prev. GPUs not in prev are always included.
+func (gm *GPUManager) GetCurrentDataDiff(prev map[string]system.GPUData) map[string]system.GPUData {
+	epsilon := gm.opts.DiffEpsilon
+	if epsilon == 0 {
+		epsilon = defaultDiffEpsilon
+	}
+	current := gm.GetCurrentData()
+	diff := make(map[string]system.GPUData, len(current))
+	for id, gpu := range current {
+		if prevGpu, ok := prev[id]; ok && !gpuDataChanged(prevGpu, gpu, epsilon) {
+			continue
+		}
+		diff[id] = gpu
+	}
+	return diff
+}
+
+// gpuDataChangeIgnored are the GPUData fields that change with every sample
+// even when the reported values don't
+var gpuDataChangeIgnored = []string{"LastUpdated", "Count"}
+
+// gpuDataChanged returns true if any reported value differs, by more than epsilon
+// for numbers. Every exported field is compared so new fields are not missed.
+func gpuDataChanged(a, b system.GPUData, epsilon float64) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := range va.NumField() {
+		field := va.Type().Field(i)
+		if !field.IsExported() || slices.Contains(gpuDataChangeIgnored, field.Name) {
+			continue
+		}
+		if valueChanged(va.Field(i), vb.Field(i), epsilon) {
+			return true
+		}
+	}
+	return false
+}
+
+// valueChanged returns true if x and y differ, by more than epsilon for floats
+// and the floats in maps
+func valueChanged(x, y reflect.Value, epsilon float64) bool {
+	switch x.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return math.Abs(x.Float()-y.Float()) > epsilon
+	case reflect.Map:
+		if x.Len() != y.Len() {
+			return true
+		}
+		for iter := x.MapRange(); iter.Next(); {
+			other := y.MapIndex(iter.Key())
+			if !other.IsValid() || valueChanged(iter.Value(), other, epsilon) {
+				return true
+			}
+		}
+		return false
+	default:
+		return !reflect.DeepEqual(x.Interface(), y.Interface())
+	}
+}
+
+// GPU temperature levels, see checkTemperature
+const (
+	tempNormal = iota
+	tempWarning
+	tempCritical
+)
+
+// checkTemperature logs a warning or error when temp crosses the configured
+// thresholds, and sends an alert if the GPU wasn't already above the threshold.
+// Each level is only logged when the GPU enters it.
+func (gm *GPUManager) checkTemperature(name string, temp float64) {
+	if temp <= 0 {
+		return
+	}
+	level := tempNormal
+	switch {
+	case temp > gm.opts.TempCritThreshold:
+		level = tempCritical
+		gm.opts.Alerter.GPUTemperature(name, temp, true)
+	case temp > gm.opts.TempWarnThreshold:
+		level = tempWarning
+		gm.opts.Alerter.GPUTemperature(name, temp, false)
+	default:
+		gm.opts.Alerter.GPUTemperatureNormal(name)
+	}
+	last := tempNormal
+	if prev, ok := gm.tempLevels.Swap(name, level); ok {
+		last = prev.(int)
+	}
+	if level == last {
+		return
+	}
+	switch level {
+	case tempCritical:
+		slog.Error("GPU temperature critical", "gpu", name, "temp", temp)
+	case tempWarning:
+		slog.Warn("GPU temperature warning", "gpu", name, "temp", temp)
+	default:
+		slog.Info("GPU temperature normal", "gpu", name, "temp", temp)
 	}
-	slog.Debug("GPU", "data", gpuData)
-	return gpuData
+}
+
+// setTemperature stores the latest temperature reading of gpu, updates the range
+// of readings since the last report, and counts it as an over temperature event if
+// it rose above the warning threshold, so spikes are reported even if they don't
+// show in the latest reading. The caller must hold the lock.
+func (gm *GPUManager) setTemperature(gpu *system.GPUData, temp float64) {
+	if warn := gm.opts.TempWarnThreshold; warn > 0 && temp > warn && gpu.Temperature <= warn {
+		gpu.OverTempEvents++
+	}
+	gpu.Temperature = temp
+	// a reading of 0 means the sensor is unavailable, so 0 also means no readings
+	if temp > 0 {
+		if gpu.TemperatureMin == 0 || temp < gpu.TemperatureMin {
+			gpu.TemperatureMin = temp
+		}
+		gpu.TemperatureMax = max(gpu.TemperatureMax, temp)
+	}
+}
+
+// resetAccumulated returns gpu with its sums replaced by their averages, kept as a
+// single sample, and the values that are only reported once cleared
+func resetAccumulated(gpu system.GPUData) system.GPUData {
+	gpu.Usage = twoDecimals(gpu.Usage / gpu.Count)
+	gpu.Power = twoDecimals(gpu.Power / gpu.Count)
+	// the zones are copied, since gpu shares its map with the snapshot
+	if len(gpu.ThermalZones) > 0 {
+		thermalZones := make(map[string]float64, len(gpu.ThermalZones))
+		for zone, temp := range gpu.ThermalZones {
+			thermalZones[zone] = twoDecimals(temp / gpu.Count)
+		}
+		gpu.ThermalZones = thermalZones
+	}
+	gpu.Count = 1
+	clear(gpu.UsageHistogram[:])
+	// the temperature range starts again with the next reading
+	gpu.TemperatureMin, gpu.TemperatureMax = 0, 0
+	gpu.OverTempEvents = 0
+	return gpu
 }
 
 // detectGPUs checks for the presence of GPU management tools (nvidia-smi, rocm-smi, tegrastats)
-// in the system path. It sets the corresponding flags in the GPUManager struct if any of these
-// tools are found. If none of the tools are found, it returns an error indicating that no GPU
-// management tools are available.
+// in the system path, and for Rockchip and other Mali GPUs in sysfs. It sets the corresponding
+// flags in the GPUManager struct if any of these are found. If none are found, it returns an error
+// indicating that no GPU management tools are available.
 func (gm *GPUManager) detectGPUs() error {
+	gm.nvidiaSmi, gm.rocmSmi, gm.tegrastats = false, false, false
+	gm.rockchip = detectRockchip()
+	// the Rockchip collector also reads the NPU, so a Rockchip GPU isn't collected twice
+	gm.mali = !gm.rockchip && detectMali()
 	if _, err := exec.LookPath(nvidiaSmiCmd); err == nil {
 		gm.nvidiaSmi = true
 	}
@@ -278,57 +1299,176 @@ func (gm *GPUManager) detectGPUs() error {
 		gm.tegrastats = true
 		gm.nvidiaSmi = false
 	}
-	if gm.nvidiaSmi || gm.rocmSmi || gm.tegrastats {
+	if gm.nvidiaSmi || gm.rocmSmi || gm.tegrastats || gm.rockchip || gm.mali {
 		return nil
 	}
 	return fmt.Errorf("no GPU found - install nvidia-smi, rocm-smi, or tegrastats")
 }
 
-// startCollector starts the appropriate GPU data collector based on the command
-func (gm *GPUManager) startCollector(command string) {
-	collector := gpuCollector{
-		name: command,
-	}
-	switch command {
-	case nvidiaSmiCmd:
-		collector.cmdArgs = []string{
-			"-l", nvidiaSmiInterval,
-			"--query-gpu=index,name,temperature.gpu,memory.used,memory.total,utilization.gpu,power.draw",
-			"--format=csv,noheader,nounits",
-		}
-		collector.parse = gm.parseNvidiaData
-		go collector.start()
-	case tegraStatsCmd:
-		collector.cmdArgs = []string{"--interval", tegraStatsInterval}
-		collector.parse = gm.getJetsonParser()
-		go collector.start()
-	case rocmSmiCmd:
-		collector.cmdArgs = []string{"--showid", "--showtemp", "--showuse", "--showpower", "--showproductname", "--showmeminfo", "vram", "--json"}
-		collector.parse = gm.parseAmdData
-		go func() {
-			failures := 0
-			for {
-				if err := collector.collect(); err != nil {
-					failures++
-					if failures > maxFailureRetries {
-						break
-					}
-					slog.Warn("Error collecting AMD GPU data", "err", err)
+// collectorDef describes how to collect data from a GPU management tool
+type collectorDef struct {
+	Name        string            // command, also the key of the collector in GPUManager.collectors
+	Args        []string          // command arguments
+	Parse       func([]byte) bool // parses a line of output, returns true if valid data was found
+	Interval    time.Duration     // wait between runs of a tool that exits after one sample, 0 if it keeps running
+	RetryPolicy RetryPolicy
+	// Read returns a sample instead of running a command, for GPUs without a tool
+	Read func() ([]byte, error)
+	// prepare is called before each start to detect features that can change
+	// after a driver reload, and may modify the definition
+	prepare func(def *collectorDef)
+	// companions run alongside the collector and stop with it
+	companions []func(ctx context.Context)
+	// exhausted is called when a polled tool stops after failing too many times
+	exhausted func(err error)
+}
+
+// collectorDefs returns the definitions of all supported GPU management tools.
+// Adding a vendor only requires adding its definition and detecting its tool.
+func (gm *GPUManager) collectorDefs() []collectorDef {
+	return []collectorDef{
+		{
+			Name:        nvidiaSmiCmd,
+			Parse:       gm.parseNvidiaData,
+			RetryPolicy: DefaultRetryPolicy,
+			prepare: func(def *collectorDef) {
+				fields := detectNvidiaColumns(probeNvidiaQuery)
+				def.Args = []string{"-l", nvidiaSmiInterval, nvidiaQuery(fields), "--format=csv,noheader,nounits"}
+				// NVLink counters, MIG instances and compute modes are queried separately
+				// and stop with the nvidia-smi collector
+				if detectNvidiaNVLink() {
+					def.companions = append(def.companions, newNVLinkCollector(gm).start)
 				}
-				time.Sleep(rocmSmiInterval)
-			}
-		}()
+				if migGPUs := detectMIGMode(); len(migGPUs) > 0 {
+					slog.Info("MIG mode enabled", "gpus", migGPUs)
+					def.companions = append(def.companions, newMIGCollector(gm, migGPUs).start)
+				}
+				gm.Lock()
+				gm.nvidiaCols = newNvidiaColumnIndex(fields)
+				gm.modeQuery = newComputeModeCollector(gm)
+				def.companions = append(def.companions, gm.modeQuery.start)
+				gm.Unlock()
+			},
+		},
+		{
+			Name:        tegraStatsCmd,
+			Args:        []string{"--interval", tegraStatsInterval},
+			RetryPolicy: DefaultRetryPolicy,
+			prepare: func(def *collectorDef) {
+				def.Parse = gm.getJetsonParser()
+			},
+		},
+		{
+			Name:        rocmSmiCmd,
+			Args:        []string{"--showid", "--showtemp", "--showuse", "--showpower", "--showproductname", "--showmeminfo", "vram", "--showpciebw", "--showmaxpower", "--showxgmibw", "--showperflevel", "--json"},
+			Parse:       gm.parseAmdData,
+			Interval:    rocmSmiInterval,
+			RetryPolicy: rocmRetryPolicy,
+			exhausted:   gm.markAmdFailed,
+		},
+		{
+			Name:        rockchipCollectorName,
+			Parse:       gm.parseRockchipData,
+			Interval:    rockchipInterval,
+			RetryPolicy: DefaultRetryPolicy,
+			prepare: func(def *collectorDef) {
+				def.Read = newRockchipCollector(rockchipRoot).read
+			},
+		},
+		{
+			Name:        maliCollectorName,
+			Interval:    maliInterval,
+			RetryPolicy: DefaultRetryPolicy,
+			prepare: func(def *collectorDef) {
+				// if the GPU is gone, e.g. after the driver is unloaded, reads fail and are retried
+				c := cmp.Or(newMaliCollector(maliRoot), &MaliCollector{name: "Mali"})
+				def.Read = c.read
+				def.Parse = gm.getMaliParser(c.name)
+			},
+		},
 	}
 }
 
-// NewGPUManager creates and initializes a new GPUManager
-func NewGPUManager() (*GPUManager, error) {
-	var gm GPUManager
-	if err := gm.detectGPUs(); err != nil {
-		return nil, err
+// startCollector starts the collector for the GPU management tool command
+func (gm *GPUManager) startCollector(command string) {
+	defs := gm.collectorDefs()
+	i := slices.IndexFunc(defs, func(def collectorDef) bool { return def.Name == command })
+	if i < 0 {
+		return
+	}
+	def := defs[i]
+	if def.prepare != nil {
+		def.prepare(&def)
+	}
+	// reuse the collector from a previous run so its counters are kept
+	value, _ := gm.collectors.LoadOrStore(command, &gpuCollector{name: command})
+	collector := value.(*gpuCollector)
+	collector.cmdArgs = def.Args
+	collector.parse = def.Parse
+	collector.read = def.Read
+	collector.retry = def.RetryPolicy
+	if policy, ok := gm.opts.RetryPolicies[command]; ok {
+		collector.retry = policy
+	}
+	ctx := gm.context()
+	gm.activeCollectors.Add(1)
+	gm.wg.Add(1)
+	go func() {
+		defer gm.wg.Done()
+		defer gm.activeCollectors.Add(-1)
+		gm.runCollector(ctx, collector, def)
+	}()
+}
+
+// runCollector runs the collector until it stops or ctx is cancelled, along with
+// the definition's companions
+func (gm *GPUManager) runCollector(ctx context.Context, collector *gpuCollector, def collectorDef) {
+	if len(def.companions) > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		for _, companion := range def.companions {
+			gm.wg.Add(1)
+			go func() {
+				defer gm.wg.Done()
+				companion(ctx)
+			}()
+		}
+	}
+	collector.run(ctx, def)
+}
+
+// run starts a tool that keeps running, or polls a tool that exits after each
+// sample every def.Interval
+func (c *gpuCollector) run(ctx context.Context, def collectorDef) {
+	if def.Interval <= 0 {
+		c.start(ctx)
+		return
 	}
-	gm.GpuDataMap = make(map[string]*system.GPUData)
+	failures := 0
+	for {
+		wait := def.Interval
+		if err := c.collect(ctx); err != nil && ctx.Err() == nil {
+			failures++
+			if c.retry.exhausted(failures) {
+				if def.exhausted != nil {
+					def.exhausted(err)
+				}
+				break
+			}
+			slog.Warn("Error collecting GPU data", "cmd", c.name, "err", err)
+			wait = c.retry.backoff(failures)
+		} else {
+			failures = 0
+		}
+		if !sleepContext(ctx, wait) {
+			break
+		}
+	}
+}
 
+// startCollectors starts collectors for all detected GPU management tools
+func (gm *GPUManager) startCollectors() {
 	if gm.nvidiaSmi {
 		gm.startCollector(nvidiaSmiCmd)
 	}
@@ -338,6 +1478,176 @@ func NewGPUManager() (*GPUManager, error) {
 	if gm.tegrastats {
 		gm.startCollector(tegraStatsCmd)
 	}
+	if gm.rockchip {
+		gm.startCollector(rockchipCollectorName)
+	}
+	if gm.mali {
+		gm.startCollector(maliCollectorName)
+	}
+}
+
+// watchGPUs restarts collection when all collectors have exited, e.g. after a
+// driver reload, by checking for usable GPUs again every interval
+func (gm *GPUManager) watchGPUs(interval time.Duration) {
+	ctx := gm.context()
+	for sleepContext(ctx, interval) {
+		if gm.activeCollectors.Load() > 0 {
+			continue
+		}
+		err := gm.detectGPUs()
+		if err == nil {
+			err = gm.probeGPUs(ctx)
+		}
+		if err != nil {
+			slog.Debug("GPU", "err", err)
+			continue
+		}
+		slog.Info("GPU collectors stopped, restarting")
+		gm.startCollectors()
+	}
+}
+
+// probeGPUs checks that the GPU tools found by detectGPUs can reach a GPU, since
+// e.g. nvidia-smi is often installed without a loaded driver or in containers
+// without GPU devices. Tools that fail are disabled, and an error is returned if
+// none are left. Tegrastats and sysfs collectors need no probe.
+func (gm *GPUManager) probeGPUs(ctx context.Context) error {
+	probe := func(name string, args ...string) bool {
+		ctx, cancel := context.WithTimeout(ctx, gpuProbeTimeout)
+		defer cancel()
+		output, err := newGPUCommandContext(ctx, name, args...).Output()
+		if err != nil {
+			slog.Debug("GPU probe", "cmd", name, "err", err)
+			return false
+		}
+		return len(bytes.TrimSpace(output)) > 0
+	}
+	if gm.nvidiaSmi {
+		gm.nvidiaSmi = probe(nvidiaSmiCmd, "-L")
+	}
+	if gm.rocmSmi {
+		gm.rocmSmi = probe(rocmSmiCmd, "--showid")
+	}
+	if !gm.nvidiaSmi && !gm.rocmSmi && !gm.tegrastats && !gm.rockchip && !gm.mali {
+		return fmt.Errorf("no usable GPU found - GPU tools are installed but could not reach a GPU")
+	}
+	return nil
+}
+
+// gpuDetectionCache holds the GPU management tools found in the path and the
+// Jetson model, which are detected once and shared by all GPUManagers
+type gpuDetectionCache struct {
+	detectOnce                     sync.Once
+	nvidiaSmi, rocmSmi, tegrastats bool
+	rockchip, mali                 bool
+	err                            error
+	jetsonOnce                     sync.Once
+	jetsonModel                    string
+}
+
+var gpuDetection = &gpuDetectionCache{}
+
+// ClearGPUDetectionCache makes the next NewGPUManager detect GPUs again. It is
+// meant for tests that change the available GPU tools.
+func ClearGPUDetectionCache() {
+	gpuDetection = &gpuDetectionCache{}
+}
+
+// detectGPUs sets the GPU management tool flags of gm from the cache,
+// detecting them on the first call
+func (c *gpuDetectionCache) detectGPUs(gm *GPUManager) error {
+	c.detectOnce.Do(func() {
+		var detected GPUManager
+		c.err = detected.detectGPUs()
+		c.nvidiaSmi, c.rocmSmi, c.tegrastats = detected.nvidiaSmi, detected.rocmSmi, detected.tegrastats
+		c.rockchip, c.mali = detected.rockchip, detected.mali
+	})
+	gm.nvidiaSmi, gm.rocmSmi, gm.tegrastats = c.nvidiaSmi, c.rocmSmi, c.tegrastats
+	gm.rockchip, gm.mali = c.rockchip, c.mali
+	return c.err
+}
+
+// getJetsonModel returns the Jetson model, reading it on the first call
+func (c *gpuDetectionCache) getJetsonModel() string {
+	c.jetsonOnce.Do(func() {
+		c.jetsonModel = detectJetsonModel()
+	})
+	return c.jetsonModel
+}
+
+// detectNvidiaGPUCount returns the number of GPUs reported by nvidia-smi, or 0 if unknown
+func detectNvidiaGPUCount() int {
+	output, err := newGPUCommand(nvidiaSmiCmd, "--query-gpu=count", "--format=csv,noheader").Output()
+	if err != nil {
+		slog.Debug("Error detecting Nvidia GPU count", "err", err)
+		return 0
+	}
+	// count is repeated once per GPU, so only the first line is needed
+	line, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	count, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		return 0
+	}
+	return count
+}
+
+// NewGPUManager creates and initializes a new GPUManager
+func NewGPUManager(opts GPUManagerOptions) (*GPUManager, error) {
+	if opts.TempWarnThreshold == 0 {
+		opts.TempWarnThreshold = defaultTempWarnThreshold
+	}
+	if opts.TempCritThreshold == 0 {
+		opts.TempCritThreshold = defaultTempCritThreshold
+	}
+	gm := GPUManager{opts: opts}
+	if err := gpuDetection.detectGPUs(&gm); err != nil {
+		return nil, err
+	}
+	if gm.opts.ExpectedGPUCount == 0 && gm.nvidiaSmi {
+		gm.opts.ExpectedGPUCount = detectNvidiaGPUCount()
+	}
+	gm.GpuDataMap = make(map[string]*system.GPUData, gm.opts.ExpectedGPUCount)
+	gm.initialized = make(chan struct{})
+	if gm.rocmSmi {
+		gm.topology = detectAmdTopology()
+		gm.amdParts = detectAmdPartitions()
+	}
+
+	gm.ctx, gm.cancel = context.WithCancel(context.Background())
+
+	gm.startCollectors()
+	gm.wg.Add(1)
+	go func() {
+		defer gm.wg.Done()
+		gm.watchGPUs(gpuWatchInterval)
+	}()
 
 	return &gm, nil
 }
+
+// context returns the context that ends collection when cancelled by Stop
+func (gm *GPUManager) context() context.Context {
+	if gm.ctx == nil {
+		return context.Background()
+	}
+	return gm.ctx
+}
+
+// Stop cancels all collectors, killing running GPU tool commands, and waits
+// for them to exit or for ctx to be done.
+func (gm *GPUManager) Stop(ctx context.Context) error {
+	if gm.cancel != nil {
+		gm.cancel()
+	}
+	done := make(chan struct{})
+	go func() {
+		gm.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	// number of parse errors kept by each GPU manager
+	gpuErrorLogSize = 100
+	// maximum bytes of raw output kept for each parse error
+	gpuErrorDataSize = 256
+)
+
+// GPUParseError holds output of a GPU tool that could not be parsed
+type GPUParseError struct {
+	Time      time.Time `json:"time"`
+	Collector string    `json:"collector"` // command that produced the output, e.g. nvidia-smi
+	Data      []byte    `json:"data"`      // raw output, truncated to gpuErrorDataSize bytes
+}
+
+// MarshalJSON writes Data as text rather than base64 so it can be read in diagnostics
+func (e GPUParseError) MarshalJSON() ([]byte, error) {
+	type alias GPUParseError
+	return json.Marshal(struct {
+		alias
+		Data string `json:"data"`
+	}{alias(e), string(e.Data)})
+}
+
+// gpuErrorLog keeps the most recent GPU parse errors, overwriting the oldest when full
+type gpuErrorLog struct {
+	mu      sync.Mutex
+	entries []GPUParseError
+	head    int // index of the next entry to write
+	count   int // number of entries stored, up to gpuErrorLogSize
+}
+
+// add records output from collector that could not be parsed. The output is
+// copied since collectors reuse their read buffer.
+func (l *gpuErrorLog) add(collector string, output []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.entries == nil {
+		l.entries = make([]GPUParseError, gpuErrorLogSize)
+	}
+	data := make([]byte, min(len(output), gpuErrorDataSize))
+	copy(data, output)
+	l.entries[l.head] = GPUParseError{Time: time.Now(), Collector: collector, Data: data}
+	l.head = (l.head + 1) % len(l.entries)
+	l.count = min(l.count+1, len(l.entries))
+}
+
+// recent returns the stored entries, oldest first
+func (l *gpuErrorLog) recent() []GPUParseError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := make([]GPUParseError, 0, l.count)
+	start := (l.head - l.count + len(l.entries)) % max(len(l.entries), 1)
+	for i := range l.count {
+		entries = append(entries, l.entries[(start+i)%len(l.entries)])
+	}
+	return entries
+}
+
+// RecentErrors returns the last gpuErrorLogSize outputs of GPU tools that could
+// not be parsed, oldest first
+func (gm *GPUManager) RecentErrors() []GPUParseError {
+	return gm.errorLog.recent()
+}
@@ -0,0 +1,63 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGPUErrorLog(t *testing.T) {
+	var log gpuErrorLog
+	assert.Empty(t, log.recent())
+
+	for i := range gpuErrorLogSize + 5 {
+		log.add(nvidiaSmiCmd, []byte("line "+strconv.Itoa(i)))
+	}
+	entries := log.recent()
+	require.Len(t, entries, gpuErrorLogSize)
+	// the five oldest entries were evicted
+	assert.Equal(t, "line 5", string(entries[0].Data))
+	assert.Equal(t, "line 104", string(entries[len(entries)-1].Data))
+	for i := 1; i < len(entries); i++ {
+		assert.False(t, entries[i].Time.Before(entries[i-1].Time), "entries are oldest first")
+	}
+
+	t.Run("truncates and copies output", func(t *testing.T) {
+		var log gpuErrorLog
+		output := bytes.Repeat([]byte("x"), 1000)
+		log.add(rocmSmiCmd, output)
+		output[0] = 'y'
+		entries := log.recent()
+		require.Len(t, entries, 1)
+		assert.Equal(t, rocmSmiCmd, entries[0].Collector)
+		assert.Equal(t, bytes.Repeat([]byte("x"), gpuErrorDataSize), entries[0].Data)
+	})
+}
+
+func TestGPUParseErrorsRecorded(t *testing.T) {
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	assert.False(t, gm.parseNvidiaData([]byte("Failed to initialize NVML: Driver/library version mismatch")))
+	assert.False(t, gm.parseAmdData([]byte("WARNING: AMD GPU device(s) is/are in a low-power state")))
+	assert.True(t, gm.parseNvidiaData([]byte("0, NVIDIA GeForce RTX 3050 Ti Laptop GPU, 48, 12, 4096, 26.3, 12.73")))
+
+	errors := gm.RecentErrors()
+	require.Len(t, errors, 2)
+	assert.Equal(t, nvidiaSmiCmd, errors[0].Collector)
+	assert.Equal(t, "Failed to initialize NVML: Driver/library version mismatch", string(errors[0].Data))
+	assert.Equal(t, rocmSmiCmd, errors[1].Collector)
+	assert.WithinDuration(t, time.Now(), errors[1].Time, time.Second)
+
+	// output is readable in diagnostics
+	encoded, err := json.Marshal(errors[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(encoded), `"data":"Failed to initialize NVML`)
+}
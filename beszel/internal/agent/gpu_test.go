@@ -5,8 +5,18 @@ package agent
 
 import (
 	"beszel/internal/entities/system"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,6 +24,29 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// captureLogs sends the default logger to a buffer until the test ends
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+	return &logs
+}
+
+// setJetsonModelPath reads the Jetson model from path until the test ends, so
+// tests get the default name even when run on a Jetson
+func setJetsonModelPath(t *testing.T, path string) {
+	t.Helper()
+	origPath := jetsonModelPath
+	t.Cleanup(func() {
+		jetsonModelPath = origPath
+		ClearGPUDetectionCache()
+	})
+	jetsonModelPath = path
+	ClearGPUDetectionCache()
+}
+
 func TestParseNvidiaData(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -201,6 +234,7 @@ func TestParseAmdData(t *testing.T) {
 				"38294": {
 					Name:        "Navi 31 [Radeon RX 7900 XT]",
 					Temperature: 49.0,
+					MemoryTemp:  62.0,
 					MemoryUsed:  794341376.0 / (1024 * 1024),
 					MemoryTotal: 25753026560.0 / (1024 * 1024),
 					Usage:       20.3,
@@ -236,6 +270,7 @@ func TestParseAmdData(t *testing.T) {
 					require.NotNil(t, got)
 					assert.Equal(t, want.Name, got.Name)
 					assert.InDelta(t, want.Temperature, got.Temperature, 0.01)
+					assert.InDelta(t, want.MemoryTemp, got.MemoryTemp, 0.01)
 					assert.InDelta(t, want.MemoryUsed, got.MemoryUsed, 0.01)
 					assert.InDelta(t, want.MemoryTotal, got.MemoryTotal, 0.01)
 					assert.InDelta(t, want.Usage, got.Usage, 0.01)
@@ -248,6 +283,8 @@ func TestParseAmdData(t *testing.T) {
 }
 
 func TestParseJetsonData(t *testing.T) {
+	setJetsonModelPath(t, filepath.Join(t.TempDir(), "model"))
+
 	tests := []struct {
 		name        string
 		input       string
@@ -276,6 +313,7 @@ func TestParseJetsonData(t *testing.T) {
 				Usage:       63.0,
 				Temperature: 53.968,
 				Power:       4.667,
+				PowerLabel:  "CPU+GPU+CV",
 				Count:       1,
 			},
 		},
@@ -312,11 +350,20 @@ func TestParseJetsonData(t *testing.T) {
 				assert.InDelta(t, tt.wantMetrics.Temperature, got.Temperature, 0.01)
 			}
 			assert.InDelta(t, tt.wantMetrics.Power, got.Power, 0.01)
+			assert.Equal(t, tt.wantMetrics.PowerLabel, got.PowerLabel)
 			assert.Equal(t, tt.wantMetrics.Count, got.Count)
 		})
 	}
 }
 
+// publishGPUData adopts the values reset by GetCurrentData into GpuDataMap and
+// publishes it, for tests that fill or read GpuDataMap directly
+func publishGPUData(gm *GPUManager) {
+	gm.Lock()
+	defer gm.Unlock()
+	gm.publishSnapshot(gm.beginUpdate())
+}
+
 func TestGetCurrentData(t *testing.T) {
 	gm := &GPUManager{
 		GpuDataMap: map[string]*system.GPUData{
@@ -341,6 +388,7 @@ func TestGetCurrentData(t *testing.T) {
 		},
 	}
 
+	publishGPUData(gm)
 	result := gm.GetCurrentData()
 
 	// Verify name disambiguation
@@ -354,18 +402,49 @@ func TestGetCurrentData(t *testing.T) {
 	assert.InDelta(t, 60.0, result["1"].Power, 0.01)
 
 	// Verify reset counts
+	publishGPUData(gm)
 	assert.Equal(t, float64(1), gm.GpuDataMap["0"].Count)
 	assert.Equal(t, float64(1), gm.GpuDataMap["1"].Count)
 }
 
-func TestDetectGPUs(t *testing.T) {
-	// Save original PATH
-	origPath := os.Getenv("PATH")
-	defer os.Setenv("PATH", origPath)
+func TestGetCurrentDataDuringUpdate(t *testing.T) {
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA GeForce RTX 3080, 50, 5000, 10000, 30, 200")))
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA GeForce RTX 3080, 60, 6000, 10000, 50, 300")))
+
+	// a read while a collector holds the lock doesn't wait for it
+	gm.Lock()
+	base := gm.beginUpdate()
+	gpu := gm.GpuDataMap["0"]
+	gpu.Usage += 90
+	gpu.Power += 400
+	gpu.Count++
+	gpu.LastUpdated = time.Now()
+	gm.setTemperature(gpu, 70)
+	result := gm.GetCurrentData()
+	gm.publishSnapshot(base)
+	gm.Unlock()
+	assert.InDelta(t, 40.0, result["0"].Usage, 0.01, "samples published before the read")
+	assert.InDelta(t, 250.0, result["0"].Power, 0.01)
+
+	// the reset is kept, and the sample added during the read is reported next
+	publishGPUData(gm)
+	assert.Equal(t, 2.0, gm.GpuDataMap["0"].Count, "average plus the sample added during the read")
+	assert.Equal(t, 70.0, gm.GpuDataMap["0"].TemperatureMax)
+	result = gm.GetCurrentData()
+	assert.InDelta(t, 65.0, result["0"].Usage, 0.01)
+	assert.InDelta(t, 325.0, result["0"].Power, 0.01)
+
+	// reading again without new samples repeats the averages
+	result = gm.GetCurrentData()
+	assert.InDelta(t, 65.0, result["0"].Usage, 0.01)
+	assert.Zero(t, result["0"].TemperatureMax)
+}
 
+func TestDetectGPUs(t *testing.T) {
 	// Set up temp dir with the commands
 	tempDir := t.TempDir()
-	os.Setenv("PATH", tempDir)
+	t.Setenv("PATH", tempDir)
 
 	tests := []struct {
 		name           string
@@ -436,7 +515,7 @@ echo "test"`
 		{
 			name: "no gpu tools available",
 			setupCommands: func() error {
-				os.Setenv("PATH", "")
+				t.Setenv("PATH", "")
 				return nil
 			},
 			wantErr: true,
@@ -468,13 +547,9 @@ echo "test"`
 }
 
 func TestStartCollector(t *testing.T) {
-	// Save original PATH
-	origPath := os.Getenv("PATH")
-	defer os.Setenv("PATH", origPath)
-
 	// Set up temp dir with the commands
 	dir := t.TempDir()
-	os.Setenv("PATH", dir)
+	t.Setenv("PATH", dir)
 
 	tests := []struct {
 		name     string
@@ -571,6 +646,22 @@ echo "11-14-2024 22:54:33 RAM 1024/4096MB GR3D_FREQ 80% tj@70C VDD_GPU_SOC 1000m
 	}
 }
 
+func TestCollectorDefs(t *testing.T) {
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	var names []string
+	for _, def := range gm.collectorDefs() {
+		names = append(names, def.Name)
+		assert.NotZero(t, def.RetryPolicy, def.Name)
+	}
+	assert.ElementsMatch(t, []string{nvidiaSmiCmd, rocmSmiCmd, tegraStatsCmd, rockchipCollectorName, maliCollectorName}, names)
+
+	// unknown commands are ignored
+	gm.startCollector("xpu-smi")
+	assert.Zero(t, gm.activeCollectors.Load())
+	_, ok := gm.collectors.Load("xpu-smi")
+	assert.False(t, ok)
+}
+
 // TestAccumulationTableDriven tests the accumulation behavior for all three GPU types
 func TestAccumulation(t *testing.T) {
 	type expectedGPUValues struct {
@@ -725,3 +816,1393 @@ func TestAccumulation(t *testing.T) {
 		})
 	}
 }
+
+// BenchmarkGPUManager measures GetCurrentData, which reads the published snapshot
+// without the lock, while 4 parsers write concurrently, and a single parse of
+// nvidia-smi output, which includes publishing the snapshot
+func BenchmarkGPUManager(b *testing.B) {
+	sample := []byte("0, NVIDIA A10, 45, 19676, 23028, 0, 58.98\n1, NVIDIA A10, 45, 19638, 23028, 0, 62.35\n2, NVIDIA A10, 44, 21700, 23028, 0, 59.57\n3, NVIDIA A10, 45, 18222, 23028, 0, 61.76")
+	newManager := func() *GPUManager {
+		gm := &GPUManager{
+			GpuDataMap: make(map[string]*system.GPUData),
+			opts:       GPUManagerOptions{TempWarnThreshold: 80, TempCritThreshold: 90},
+		}
+		gm.parseNvidiaData(sample)
+		return gm
+	}
+
+	b.Run("GetCurrentData", func(b *testing.B) {
+		gm := newManager()
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+						gm.parseNvidiaData(sample)
+					}
+				}
+			}()
+		}
+		for b.Loop() {
+			gm.GetCurrentData()
+		}
+		b.StopTimer()
+		close(done)
+		wg.Wait()
+	})
+	b.Run("parse", func(b *testing.B) {
+		gm := newManager()
+		for b.Loop() {
+			gm.parseNvidiaData(sample)
+		}
+	})
+}
+
+func TestJetsonPatternsSharedAcrossManagers(t *testing.T) {
+	ClearGPUDetectionCache()
+	t.Cleanup(ClearGPUDetectionCache)
+
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	script := `#!/bin/sh
+echo "11-14-2024 22:54:33 RAM 1024/4096MB GR3D_FREQ 80% tj@70C VDD_GPU_SOC 1000mW"`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tegrastats"), []byte(script), 0755))
+
+	patterns := []*regexp.Regexp{jetsonRamPattern, jetsonGr3dPattern, jetsonTempPattern, jetsonPowerPattern}
+
+	for range 2 {
+		gm, err := NewGPUManager(GPUManagerOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, gm)
+	}
+
+	// creating managers must not recompile the patterns
+	assert.Same(t, patterns[0], jetsonRamPattern)
+	assert.Same(t, patterns[1], jetsonGr3dPattern)
+	assert.Same(t, patterns[2], jetsonTempPattern)
+	assert.Same(t, patterns[3], jetsonPowerPattern)
+}
+
+func TestGPUDetectionCache(t *testing.T) {
+	ClearGPUDetectionCache()
+	t.Cleanup(ClearGPUDetectionCache)
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	script := filepath.Join(dir, "tegrastats")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\nsleep 10\n"), 0755))
+
+	gm, err := NewGPUManager(GPUManagerOptions{})
+	require.NoError(t, err)
+	defer gm.Stop(context.Background())
+	assert.True(t, gm.tegrastats)
+
+	// the second manager uses the cached detection instead of looking in the path again
+	require.NoError(t, os.Remove(script))
+	gm2, err := NewGPUManager(GPUManagerOptions{})
+	require.NoError(t, err)
+	defer gm2.Stop(context.Background())
+	assert.True(t, gm2.tegrastats)
+
+	ClearGPUDetectionCache()
+	_, err = NewGPUManager(GPUManagerOptions{})
+	assert.Error(t, err, "detects again after clearing the cache")
+}
+
+func TestWaitForInit(t *testing.T) {
+	gm := &GPUManager{
+		GpuDataMap:  make(map[string]*system.GPUData),
+		initialized: make(chan struct{}),
+	}
+
+	// times out before any data has been parsed
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, gm.WaitForInit(ctx), context.DeadlineExceeded)
+
+	// invalid data does not mark the manager initialized
+	assert.False(t, gm.parseNvidiaData([]byte("bad, data, here")))
+	select {
+	case <-gm.initialized:
+		t.Fatal("initialized channel closed without valid data")
+	default:
+	}
+
+	// first valid parse closes the channel
+	assert.True(t, gm.parseNvidiaData([]byte("0, NVIDIA GeForce RTX 3080, 50, 5000, 10000, 30, 200")))
+	select {
+	case <-gm.initialized:
+	default:
+		t.Fatal("initialized channel not closed after first parse")
+	}
+	require.NoError(t, gm.WaitForInit(context.Background()))
+	assert.Equal(t, "GeForce RTX 3080", gm.GetCurrentData()["0"].Name)
+
+	// later parses do not close the channel again
+	assert.NotPanics(t, func() {
+		gm.parseNvidiaData([]byte("0, NVIDIA GeForce RTX 3080, 50, 5000, 10000, 30, 200"))
+	})
+}
+
+func TestDetectNvidiaGPUCount(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+
+	// missing nvidia-smi
+	assert.Equal(t, 0, detectNvidiaGPUCount())
+
+	// count is printed once per GPU
+	script := `#!/bin/sh
+printf "8\n8\n8\n8\n8\n8\n8\n8\n"`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "nvidia-smi"), []byte(script), 0755))
+	assert.Equal(t, 8, detectNvidiaGPUCount())
+
+	// invalid output
+	script = `#!/bin/sh
+echo "No devices were found"`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "nvidia-smi"), []byte(script), 0755))
+	assert.Equal(t, 0, detectNvidiaGPUCount())
+}
+
+func TestExpectedGPUCountPreallocation(t *testing.T) {
+	var lines []string
+	for i := range 16 {
+		lines = append(lines, fmt.Sprintf("%d, NVIDIA A10, 45, 19676, 23028, 0, 58.98", i))
+	}
+	sample := []byte(strings.Join(lines, "\n"))
+
+	// allocations made while creating the map and inserting 16 GPUs
+	insertAllocs := func(expected int) float64 {
+		return testing.AllocsPerRun(20, func() {
+			gm := &GPUManager{
+				GpuDataMap: make(map[string]*system.GPUData, expected),
+			}
+			gm.parseNvidiaData(sample)
+		})
+	}
+
+	// a pre-allocated map does not grow, so it needs fewer allocations
+	assert.Less(t, insertAllocs(16), insertAllocs(0))
+}
+
+func TestJetsonPowerLabel(t *testing.T) {
+	// Orin AGX reports GPU power separately
+	agx := "RAM 4300/30698MB GR3D_FREQ 45% tj@52.468C VDD_GPU_SOC 2171mW/2171mW VDD_CPU_CV 1203mW/1203mW"
+	// Orin Nano / NX only report the combined CPU, GPU, and CV power
+	nano := "RAM 6185/7620MB GR3D_FREQ 63%@[621] tj@53.968C VDD_IN 12479mW/12479mW VDD_CPU_GPU_CV 4667mW/4667mW VDD_SOC 2817mW/2817mW"
+
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	parser := gm.getJetsonParser()
+	require.True(t, parser([]byte(agx)))
+	assert.Empty(t, gm.GpuDataMap["0"].PowerLabel)
+	assert.InDelta(t, 2.171, gm.GpuDataMap["0"].Power, 0.01)
+
+	gm = &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	parser = gm.getJetsonParser()
+	require.True(t, parser([]byte(nano)))
+	require.True(t, parser([]byte(nano)))
+	assert.Equal(t, "CPU+GPU+CV", gm.GpuDataMap["0"].PowerLabel)
+	assert.InDelta(t, 9.334, gm.GpuDataMap["0"].Power, 0.01)
+	assert.Equal(t, "CPU+GPU+CV", gm.GetCurrentData()["0"].PowerLabel)
+}
+
+func TestJetsonThermalZones(t *testing.T) {
+	tests := []struct {
+		name      string
+		samples   []string
+		wantZones map[string]float64
+	}{
+		{
+			name: "orin",
+			samples: []string{
+				"11-15-2024 08:38:09 RAM 6185/7620MB (lfb 8x2MB) SWAP 851/3810MB (cached 1MB) CPU [15%@729,11%@729,14%@729,13%@729,11%@729,8%@729] EMC_FREQ 43%@2133 GR3D_FREQ 63%@[621] NVDEC off NVJPG off NVJPG1 off VIC off OFA off APE 200 cpu@53.968C soc2@52.437C soc0@50.75C gpu@53.343C tj@53.968C soc1@51.656C VDD_IN 12479mW/12479mW VDD_CPU_GPU_CV 4667mW/4667mW VDD_SOC 2817mW/2817mW",
+				"11-15-2024 08:38:10 RAM 6185/7620MB (lfb 8x2MB) SWAP 851/3810MB (cached 1MB) CPU [15%@729,11%@729,14%@729,13%@729,11%@729,8%@729] EMC_FREQ 43%@2133 GR3D_FREQ 63%@[621] NVDEC off NVJPG off NVJPG1 off VIC off OFA off APE 200 cpu@55.968C soc2@52.437C soc0@52.75C gpu@55.343C tj@55.968C soc1@51.656C VDD_IN 12479mW/12479mW VDD_CPU_GPU_CV 4667mW/4667mW VDD_SOC 2817mW/2817mW",
+			},
+			wantZones: map[string]float64{
+				"cpu":  54.97,
+				"soc0": 51.75,
+				"soc1": 51.66,
+				"soc2": 52.44,
+				"gpu":  54.34,
+				"tj":   54.97,
+			},
+		},
+		{
+			name: "xavier",
+			samples: []string{
+				"RAM 2827/15692MB (lfb 2405x4MB) SWAP 0/7846MB (cached 0MB) CPU [2%@1190,1%@1190,0%@1190,0%@1190,off,off,off,off] EMC_FREQ 0% GR3D_FREQ 0% AO@37.5C GPU@37C Tdiode@39.75C PMIC@100C AUX@36.5C CPU@38C thermal@37.55C Tboard@37C GPU 0/0 CPU 155/155 SOC 931/931 CV 0/0 VDDRQ 155/155 SYS5V 1768/1768",
+			},
+			wantZones: map[string]float64{
+				"AO":      37.5,
+				"GPU":     37,
+				"Tdiode":  39.75,
+				"PMIC":    100,
+				"AUX":     36.5,
+				"CPU":     38,
+				"thermal": 37.55,
+				"Tboard":  37,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gm := &GPUManager{
+				GpuDataMap: make(map[string]*system.GPUData),
+			}
+			parser := gm.getJetsonParser()
+			for _, sample := range tt.samples {
+				assert.True(t, parser([]byte(sample)))
+			}
+
+			result := gm.GetCurrentData()
+			got := result["0"].ThermalZones
+			require.Len(t, got, len(tt.wantZones))
+			for zone, want := range tt.wantZones {
+				assert.InDelta(t, want, got[zone], 0.01, "zone %s", zone)
+			}
+
+			// averages carry over as a single sample after reset
+			publishGPUData(gm)
+			for zone, want := range tt.wantZones {
+				assert.InDelta(t, want, gm.GpuDataMap["0"].ThermalZones[zone], 0.01, "zone %s", zone)
+			}
+		})
+	}
+}
+
+func TestDetectJetsonModel(t *testing.T) {
+	setJetsonModelPath(t, filepath.Join(t.TempDir(), "model"))
+
+	// no device tree
+	assert.Empty(t, detectJetsonModel())
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	gm.getJetsonParser()
+	assert.Equal(t, "GPU", gm.GpuDataMap["0"].Name)
+
+	// the model is null terminated in the device tree
+	require.NoError(t, os.WriteFile(jetsonModelPath, []byte("NVIDIA Orin NX 16GB\x00"), 0o444))
+	assert.Equal(t, "NVIDIA Orin NX 16GB", detectJetsonModel())
+	ClearGPUDetectionCache()
+	gm = &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	parser := gm.getJetsonParser()
+	assert.Equal(t, "NVIDIA Orin NX 16GB", gm.GpuDataMap["0"].Name)
+	assert.True(t, parser([]byte("RAM 6185/7620MB GR3D_FREQ 63%@[621] tj@53.968C VDD_CPU_GPU_CV 4667mW/4667mW")))
+	assert.Equal(t, "NVIDIA Orin NX 16GB", gm.GetCurrentData()["0"].Name)
+}
+
+func TestParseAmdPCIeBandwidth(t *testing.T) {
+	input := `{
+		"card0": {
+			"GUID": "34756",
+			"Temperature (Sensor edge) (C)": "47.0",
+			"Current Socket Graphics Package Power (W)": "9.215",
+			"GPU use (%)": "0",
+			"VRAM Total Memory (B)": "536870912",
+			"VRAM Total Used Memory (B)": "482263040",
+			"Card Series": "Rembrandt [Radeon 680M]"
+		},
+		"card1": {
+			"GUID": "38294",
+			"Temperature (Sensor edge) (C)": "49.0",
+			"Average Graphics Package Power (W)": "19.0",
+			"GPU use (%)": "20.3",
+			"VRAM Total Memory (B)": "25753026560",
+			"VRAM Total Used Memory (B)": "794341376",
+			"Card Series": "Navi 31 [Radeon RX 7900 XT]",
+			"Estimated maximum PCIe bandwidth over the last second (Tx) (MB/s)": "1520.256",
+			"Estimated maximum PCIe bandwidth over the last second (Rx) (MB/s)": "87.5"
+		}
+	}`
+
+	gm := &GPUManager{
+		GpuDataMap: make(map[string]*system.GPUData),
+	}
+	require.True(t, gm.parseAmdData([]byte(input)))
+
+	// bandwidth fields absent
+	assert.Equal(t, 0.0, gm.GpuDataMap["34756"].PCIeTxBandwidth)
+	assert.Equal(t, 0.0, gm.GpuDataMap["34756"].PCIeRxBandwidth)
+
+	assert.InDelta(t, 1520.256, gm.GpuDataMap["38294"].PCIeTxBandwidth, 0.001)
+	assert.InDelta(t, 87.5, gm.GpuDataMap["38294"].PCIeRxBandwidth, 0.001)
+
+	result := gm.GetCurrentData()
+	assert.Equal(t, 1520.26, result["38294"].PCIeTxBandwidth)
+	assert.Equal(t, 87.5, result["38294"].PCIeRxBandwidth)
+}
+
+func TestParseAmdXGMIBandwidth(t *testing.T) {
+	input := `{
+		"card0": {
+			"GUID": "11045",
+			"Temperature (Sensor edge) (C)": "41.0",
+			"Current Socket Graphics Package Power (W)": "412.0",
+			"GPU use (%)": "87",
+			"VRAM Total Memory (B)": "206141652992",
+			"VRAM Total Used Memory (B)": "112742891520",
+			"Card Series": "AMD Instinct MI300X",
+			"XGMI read bandwidth (MB/s)": "48213.375",
+			"XGMI write bandwidth (MB/s)": "47109.9"
+		},
+		"card1": {
+			"GUID": "28765",
+			"Temperature (Sensor edge) (C)": "52.0",
+			"Average Graphics Package Power (W)": "281.0",
+			"GPU use (%)": "64",
+			"VRAM Total Memory (B)": "68702699520",
+			"VRAM Total Used Memory (B)": "30064771072",
+			"Card Series": "AMD Instinct MI210"
+		}
+	}`
+
+	gm := &GPUManager{
+		GpuDataMap: make(map[string]*system.GPUData),
+	}
+	require.True(t, gm.parseAmdData([]byte(input)))
+
+	// older ROCm versions leave out the XGMI keys
+	assert.Equal(t, 0.0, gm.GpuDataMap["28765"].XGMIReadBW)
+	assert.Equal(t, 0.0, gm.GpuDataMap["28765"].XGMIWriteBW)
+
+	result := gm.GetCurrentData()
+	assert.Equal(t, 48213.38, result["11045"].XGMIReadBW)
+	assert.Equal(t, 47109.9, result["11045"].XGMIWriteBW)
+	assert.True(t, gpuDataChanged(result["11045"], result["28765"], defaultDiffEpsilon))
+}
+
+func TestParseAmdPerformanceLevel(t *testing.T) {
+	logs := captureLogs(t)
+
+	fixture := func(level string) []byte {
+		return []byte(`{
+			"card0": {
+				"GUID": "11045",
+				"Temperature (Sensor edge) (C)": "41.0",
+				"Average Graphics Package Power (W)": "212.0",
+				"GPU use (%)": "87",
+				"Card Series": "AMD Instinct MI210",
+				"Performance Level": "` + level + `"
+			}
+		}`)
+	}
+
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	require.True(t, gm.parseAmdData(fixture("auto")))
+	assert.Equal(t, "auto", gm.GpuDataMap["11045"].PerformanceLevel)
+	assert.NotContains(t, logs.String(), "performance level")
+
+	require.True(t, gm.parseAmdData(fixture("manual")))
+	require.True(t, gm.parseAmdData(fixture("manual")))
+	assert.Equal(t, "manual", gm.GetCurrentData()["11045"].PerformanceLevel)
+	assert.Equal(t, 1, strings.Count(logs.String(), "level=WARN msg=\"AMD GPU performance level is manual\""), "warned once when set to manual")
+
+	// not reported
+	require.True(t, gm.parseAmdData(fixture("")))
+	assert.Empty(t, gm.GpuDataMap["11045"].PerformanceLevel)
+}
+
+func TestAmdPartitions(t *testing.T) {
+	// MI300X partitions in CPX mode, and an MI210 that does not support partitioning
+	partitionOutput := `{
+		"card0": {"GUID": "45412", "Compute Partition": "CPX", "Memory Partition": "NPS4"},
+		"card1": {"GUID": "28672", "Compute Partition": "CPX", "Memory Partition": "NPS4"},
+		"card2": {"GUID": "11045", "Compute Partition": "N/A", "Memory Partition": "N/A"}
+	}`
+	partitions, err := parseAmdPartitions([]byte(partitionOutput))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]RocmSmiJson{
+		"45412": {ComputePartition: "CPX", MemoryPartition: "NPS4"},
+		"28672": {ComputePartition: "CPX", MemoryPartition: "NPS4"},
+	}, partitions)
+
+	_, err = parseAmdPartitions([]byte("not json"))
+	assert.Error(t, err)
+
+	logs := captureLogs(t)
+
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData), amdParts: partitions}
+	input := []byte(`{
+		"card0": {"GUID": "45412", "Card Series": "AMD Instinct MI300X", "GPU use (%)": "12", "Temperature (Sensor edge) (C)": "38.0"},
+		"card2": {"GUID": "11045", "Card Series": "AMD Instinct MI210", "GPU use (%)": "3", "Temperature (Sensor edge) (C)": "41.0"}
+	}`)
+	require.True(t, gm.parseAmdData(input))
+	require.True(t, gm.parseAmdData(input))
+
+	data := gm.GetCurrentData()
+	assert.Equal(t, "CPX", data["45412"].ComputePartition)
+	assert.Equal(t, "NPS4", data["45412"].MemoryPartition)
+	assert.Empty(t, data["11045"].ComputePartition)
+	assert.Equal(t, 1, strings.Count(logs.String(), "AMD GPU partition"), "logged once when the GPU appears")
+}
+
+func TestAggregationWindow(t *testing.T) {
+	gm := &GPUManager{
+		opts:       GPUManagerOptions{AggregationWindow: time.Minute},
+		GpuDataMap: make(map[string]*system.GPUData),
+	}
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA GeForce RTX 3080, 50, 5000, 10000, 90, 300")))
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA GeForce RTX 3080, 50, 5000, 10000, 70, 300")))
+	assert.False(t, gm.aggregates["0"].windowStart.IsZero())
+
+	// samples within the window are averaged
+	result := gm.GetCurrentData()
+	assert.InDelta(t, 80.0, result["0"].Usage, 0.01)
+
+	// move the window into the past so existing samples are stale
+	gm.Lock()
+	base := gm.beginUpdate()
+	gm.aggregates["0"].windowStart = time.Now().Add(-2 * time.Minute)
+	gm.publishSnapshot(base)
+	gm.Unlock()
+
+	// stale samples are excluded, and the GPU is still reported with its latest readings
+	result = gm.GetCurrentData()
+	require.Contains(t, result, "0")
+	assert.InDelta(t, 70.0, result["0"].Usage, 0.01)
+	assert.InDelta(t, 300.0, result["0"].Power, 0.01)
+	assert.InDelta(t, 50.0, result["0"].Temperature, 0.01)
+	assert.InDelta(t, 5000/1.024, result["0"].MemoryUsed, 0.01)
+
+	// a new sample starts a fresh window and discards the stale ones
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA GeForce RTX 3080, 50, 5000, 10000, 10, 100")))
+	assert.Equal(t, 1.0, gm.GpuDataMap["0"].Count)
+	assert.WithinDuration(t, time.Now(), gm.aggregates["0"].windowStart, time.Second)
+
+	result = gm.GetCurrentData()
+	assert.InDelta(t, 10.0, result["0"].Usage, 0.01)
+	assert.InDelta(t, 100.0, result["0"].Power, 0.01)
+}
+
+func TestGPUSmoothing(t *testing.T) {
+	sample := func(gm *GPUManager, usage int) {
+		line := fmt.Sprintf("0, NVIDIA GeForce RTX 4080, 55, 4096, 16376, %d, 120, 0, 320", usage)
+		require.True(t, gm.parseNvidiaData([]byte(line)))
+	}
+
+	// one sample per request: idle, a single spike, then idle again
+	usages := []int{0, 100, 0, 0, 0, 0}
+	smoothed := &GPUManager{
+		opts:       GPUManagerOptions{SmoothingAlpha: 0.3},
+		GpuDataMap: make(map[string]*system.GPUData),
+	}
+	raw := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	var smoothedUsage, rawUsage []float64
+	for _, usage := range usages {
+		sample(smoothed, usage)
+		sample(raw, usage)
+		smoothedUsage = append(smoothedUsage, smoothed.GetCurrentData()["0"].Usage)
+		rawUsage = append(rawUsage, raw.GetCurrentData()["0"].Usage)
+	}
+	assert.Equal(t, []float64{0, 50, 25, 12.5, 6.25, 3.13}, rawUsage)
+	assert.Equal(t, []float64{0, 15, 18, 16.35, 13.32, 10.26}, smoothedUsage)
+	for _, usage := range smoothedUsage {
+		assert.Less(t, usage, 20.0)
+	}
+
+	// steady values are unchanged
+	assert.Equal(t, 120.0, smoothed.GetCurrentData()["0"].Power)
+
+	// the first value is reported as is
+	fresh := &GPUManager{
+		opts:       GPUManagerOptions{SmoothingAlpha: 0.3},
+		GpuDataMap: make(map[string]*system.GPUData),
+	}
+	sample(fresh, 80)
+	assert.Equal(t, 80.0, fresh.GetCurrentData()["0"].Usage)
+}
+
+func TestAggregationWindowDisabled(t *testing.T) {
+	gm := &GPUManager{
+		GpuDataMap: make(map[string]*system.GPUData),
+	}
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA GeForce RTX 3080, 50, 5000, 10000, 90, 300")))
+	assert.Nil(t, gm.aggregates["0"], "no window state without a window")
+
+	result := gm.GetCurrentData()
+	assert.InDelta(t, 90.0, result["0"].Usage, 0.01)
+}
+
+func TestDetectNvidiaColumns(t *testing.T) {
+	tests := []struct {
+		name        string
+		unsupported []string // fields rejected by the driver
+		wantMissing []string // fields left out of the query
+		wantProbes  int
+	}{
+		{name: "all fields supported", wantProbes: 1},
+		{
+			name:        "no encoder sessions",
+			unsupported: []string{"encoder.stats.sessionCount"},
+			wantMissing: []string{"encoder.stats.sessionCount"},
+		},
+		{
+			name:        "no BAR1",
+			unsupported: []string{"bar1.memory.free"},
+			wantMissing: []string{"memory.free", "bar1.memory.free", "bar1.memory.total"},
+		},
+		{
+			name:        "no power limit",
+			unsupported: []string{"power.limit"},
+			wantMissing: []string{"power.limit"},
+		},
+		{
+			name:        "no PCIe link",
+			unsupported: []string{"pcie.link.gen.current"},
+			wantMissing: []string{"pcie.link.gen.current", "pcie.link.width.current"},
+		},
+		{
+			name:        "no ECC errors",
+			unsupported: []string{"ecc.errors.uncorrected.volatile.total"},
+			wantMissing: []string{"ecc.errors.uncorrected.volatile.total"},
+		},
+		{
+			name:        "no memory utilization",
+			unsupported: []string{"utilization.memory"},
+			wantMissing: []string{"utilization.memory"},
+		},
+		{
+			name:        "no max power limit",
+			unsupported: []string{"power.max_limit"},
+			wantMissing: []string{"power.max_limit"},
+		},
+		{
+			name:        "no reserved memory",
+			unsupported: []string{"memory.reserved"},
+			wantMissing: []string{"memory.reserved"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probes := 0
+			// like nvidia-smi, fail the whole query if any field is unsupported
+			probe := func(query string) bool {
+				probes++
+				fields := strings.Split(strings.TrimPrefix(query, "--query-gpu="), ",")
+				return !slices.ContainsFunc(fields, func(field string) bool {
+					return slices.Contains(tt.unsupported, field)
+				})
+			}
+			fields := detectNvidiaColumns(probe)
+			for _, field := range allNvidiaFields() {
+				if slices.Contains(tt.wantMissing, field) {
+					assert.NotContains(t, fields, field)
+				} else {
+					assert.Contains(t, fields, field)
+				}
+			}
+			if tt.wantProbes > 0 {
+				assert.Equal(t, tt.wantProbes, probes)
+			}
+			// the base columns always come first, in the order parsed
+			assert.Equal(t, nvidiaColumns[0].fields, fields[:len(nvidiaColumns[0].fields)])
+		})
+	}
+
+	t.Run("columns after a missing field", func(t *testing.T) {
+		fields := slices.DeleteFunc(allNvidiaFields(), func(field string) bool {
+			return field == "encoder.stats.sessionCount"
+		})
+		gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData), nvidiaCols: newNvidiaColumnIndex(fields)}
+		require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA Tesla K80, 40, 12, 11441, 0, 60.5, 149.00, 3, 16, 0, 0, 149.00, 0")))
+		gpu := gm.GpuDataMap["0"]
+		assert.Zero(t, gpu.EncoderSessions)
+		assert.Equal(t, 149.0, gpu.PowerLimit)
+		assert.Equal(t, uint8(3), gpu.PCIeGen)
+		assert.Equal(t, uint8(16), gpu.PCIeWidth)
+	})
+}
+
+func TestParseNvidiaEncoderSessions(t *testing.T) {
+	gm := &GPUManager{
+		GpuDataMap: make(map[string]*system.GPUData),
+	}
+	input := "0, NVIDIA GeForce RTX 4090, 52, 3120, 24564, 35, 180.5, 3\n1, NVIDIA Tesla V100-PCIE-16GB, 40, 12, 16384, 0, 25.0, [N/A]\n2, NVIDIA A10, 45, 19676, 23028, 0, 58.98"
+	require.True(t, gm.parseNvidiaData([]byte(input)))
+
+	assert.Equal(t, uint32(3), gm.GpuDataMap["0"].EncoderSessions)
+	assert.Equal(t, uint32(0), gm.GpuDataMap["1"].EncoderSessions, "N/A is stored as 0")
+	assert.Equal(t, uint32(0), gm.GpuDataMap["2"].EncoderSessions, "missing field is stored as 0")
+	assert.InDelta(t, 25.0, gm.GpuDataMap["1"].Power, 0.01)
+}
+
+func TestWatchGPUsRestartsCollector(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	scriptPath := filepath.Join(dir, "nvidia-smi")
+
+	// driver unloaded: nvidia-smi returns no valid data so the collector exits
+	require.NoError(t, os.WriteFile(scriptPath, []byte("#!/bin/sh\necho \"NVIDIA-SMI has failed\""), 0755))
+
+	gm := &GPUManager{
+		GpuDataMap: make(map[string]*system.GPUData),
+	}
+	gm.ctx, gm.cancel = context.WithCancel(context.Background())
+	defer gm.Stop(context.Background())
+	require.NoError(t, gm.detectGPUs())
+	gm.startCollectors()
+	assert.Eventually(t, func() bool {
+		return gm.activeCollectors.Load() == 0
+	}, time.Second, 5*time.Millisecond, "collector should exit")
+
+	// driver reloaded
+	script := `#!/bin/sh
+echo "0, NVIDIA Test GPU, 50, 1024, 4096, 25, 100"`
+	require.NoError(t, os.WriteFile(scriptPath, []byte(script), 0755))
+
+	gm.wg.Add(1)
+	go func() {
+		defer gm.wg.Done()
+		gm.watchGPUs(10 * time.Millisecond)
+	}()
+
+	assert.Eventually(t, func() bool {
+		gm.Lock()
+		defer gm.Unlock()
+		_, ok := gm.GpuDataMap["0"]
+		return ok
+	}, time.Second, 5*time.Millisecond, "watcher should restart the collector")
+	assert.Positive(t, gm.activeCollectors.Load())
+}
+
+func TestWatchGPUsPersistentFailure(t *testing.T) {
+	logs := captureLogs(t)
+
+	// nvidia-smi is installed but there is no driver, so every call fails
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	calls := filepath.Join(dir, "calls")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$@\" >> %s\necho \"NVIDIA-SMI has failed because it couldn't communicate with the NVIDIA driver.\"\nexit 9\n", calls)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, nvidiaSmiCmd), []byte(script), 0755))
+
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	gm.ctx, gm.cancel = context.WithCancel(context.Background())
+	gm.wg.Add(1)
+	go func() {
+		defer gm.wg.Done()
+		gm.watchGPUs(10 * time.Millisecond)
+	}()
+	// checks every 10ms
+	time.Sleep(200 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, gm.Stop(ctx))
+
+	output, err := os.ReadFile(calls)
+	require.NoError(t, err)
+	probes := strings.Split(strings.TrimSpace(string(output)), "\n")
+	assert.GreaterOrEqual(t, len(probes), 5, "checks keep the interval after failures")
+	assert.LessOrEqual(t, len(probes), 20)
+	for _, args := range probes {
+		assert.Equal(t, "-L", args, "only the probe runs, collectors are not restarted")
+	}
+	assert.NotContains(t, logs.String(), "GPU collectors stopped")
+	assert.Empty(t, gm.CollectorStats())
+}
+
+func TestTemperatureThresholdLogging(t *testing.T) {
+	buf := captureLogs(t)
+
+	newManager := func(opts GPUManagerOptions) *GPUManager {
+		gm := &GPUManager{
+			opts:       opts,
+			GpuDataMap: make(map[string]*system.GPUData),
+		}
+		require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA GeForce RTX 3080, 72, 5000, 10000, 30, 200")))
+		return gm
+	}
+
+	// warning only
+	newManager(GPUManagerOptions{TempWarnThreshold: 0, TempCritThreshold: 100}).GetCurrentData()
+	assert.Contains(t, buf.String(), `level=WARN msg="GPU temperature warning" gpu="GeForce RTX 3080" temp=72`)
+	assert.NotContains(t, buf.String(), "GPU temperature critical")
+
+	// critical takes precedence over warning
+	buf.Reset()
+	newManager(GPUManagerOptions{TempWarnThreshold: 0, TempCritThreshold: 0}).GetCurrentData()
+	assert.Contains(t, buf.String(), `level=ERROR msg="GPU temperature critical" gpu="GeForce RTX 3080" temp=72`)
+	assert.NotContains(t, buf.String(), "GPU temperature warning")
+
+	// below default thresholds
+	buf.Reset()
+	newManager(GPUManagerOptions{TempWarnThreshold: defaultTempWarnThreshold, TempCritThreshold: defaultTempCritThreshold}).GetCurrentData()
+	assert.NotContains(t, buf.String(), "GPU temperature")
+
+	// only changes of level are logged, not every request above a threshold
+	buf.Reset()
+	gm := newManager(GPUManagerOptions{TempWarnThreshold: 70, TempCritThreshold: 90})
+	for range 3 {
+		gm.GetCurrentData()
+	}
+	assert.Equal(t, 1, strings.Count(buf.String(), "GPU temperature warning"))
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA GeForce RTX 3080, 95, 5000, 10000, 30, 200")))
+	gm.GetCurrentData()
+	gm.GetCurrentData()
+	assert.Equal(t, 1, strings.Count(buf.String(), "GPU temperature critical"))
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA GeForce RTX 3080, 60, 5000, 10000, 30, 200")))
+	gm.GetCurrentData()
+	gm.GetCurrentData()
+	assert.Equal(t, 1, strings.Count(buf.String(), "GPU temperature normal"))
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA GeForce RTX 3080, 75, 5000, 10000, 30, 200")))
+	gm.GetCurrentData()
+	assert.Equal(t, 2, strings.Count(buf.String(), "GPU temperature warning"), "logged again after recovering")
+}
+
+func TestGetCurrentDataDiff(t *testing.T) {
+	gm := &GPUManager{
+		opts:       GPUManagerOptions{TempWarnThreshold: 100, TempCritThreshold: 100},
+		GpuDataMap: make(map[string]*system.GPUData),
+	}
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA A10, 45, 19676, 23028, 20, 58.98\n1, NVIDIA A100, 38, 74, 40960, 10, 36.79")))
+
+	// everything is new compared to an empty previous snapshot
+	prev := gm.GetCurrentDataDiff(nil)
+	require.Len(t, prev, 2)
+
+	// GPU 0 changes within epsilon, GPU 1 changes usage
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA A10, 45, 19676, 23028, 20.05, 58.98\n1, NVIDIA A100, 38, 74, 40960, 50, 36.79")))
+	diff := gm.GetCurrentDataDiff(prev)
+	assert.NotContains(t, diff, "0", "unchanged GPU should be omitted")
+	require.Contains(t, diff, "1")
+	assert.InDelta(t, 30.0, diff["1"].Usage, 0.01)
+
+	// custom epsilon, with usage in the same histogram range
+	gm.opts.DiffEpsilon = 50
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA A10, 60, 19676, 23028, 20, 58.98\n1, NVIDIA A100, 38, 74, 40960, 19, 36.79")))
+	assert.Empty(t, gm.GetCurrentDataDiff(prev))
+
+	// the usage histogram is compared too
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA A10, 45, 19676, 23028, 20, 58.98\n1, NVIDIA A100, 38, 74, 40960, 50, 36.79")))
+	diff = gm.GetCurrentDataDiff(prev)
+	assert.Contains(t, diff, "1")
+	assert.NotContains(t, diff, "0")
+}
+
+func TestGpuDataChanged(t *testing.T) {
+	base := system.GPUData{Name: "A10", Temperature: 45, Usage: 20, ThermalZones: map[string]float64{"cpu": 50}}
+
+	same := base
+	same.ThermalZones = map[string]float64{"cpu": 50.05}
+	assert.False(t, gpuDataChanged(base, same, 0.1))
+
+	renamed := base
+	renamed.Name = "A10 0"
+	assert.True(t, gpuDataChanged(base, renamed, 0.1))
+
+	newZone := base
+	newZone.ThermalZones = map[string]float64{"gpu": 50}
+	assert.True(t, gpuDataChanged(base, newZone, 0.1))
+
+	hotter := base
+	hotter.Temperature = 45.2
+	assert.True(t, gpuDataChanged(base, hotter, 0.1))
+
+	// a change to any field is detected, except those that change with every sample
+	gpuType := reflect.TypeFor[system.GPUData]()
+	for i := range gpuType.NumField() {
+		field := gpuType.Field(i)
+		if !field.IsExported() || slices.Contains(gpuDataChangeIgnored, field.Name) {
+			continue
+		}
+		changed := base
+		fillNonZero(reflect.ValueOf(&changed).Elem().Field(i))
+		assert.True(t, gpuDataChanged(base, changed, 0.1), "%s is not compared", field.Name)
+	}
+	later := base
+	later.LastUpdated = time.Now()
+	assert.False(t, gpuDataChanged(base, later, 0.1))
+}
+
+func TestGPUCommandEnv(t *testing.T) {
+	t.Setenv("LD_PRELOAD", "/tmp/evil.so")
+	t.Setenv("NVIDIA_VISIBLE_DEVICES", "all")
+	t.Setenv("HOME", "/home/beszel")
+	t.Setenv("DISPLAY", ":0")
+
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "env.txt")
+	script := filepath.Join(dir, "nvidia-smi")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\nenv > "+envFile+"\necho \"0, NVIDIA Test GPU, 50, 1024, 4096, 25, 100\""), 0755))
+
+	collector := gpuCollector{
+		name:  script,
+		parse: func([]byte) bool { return true },
+	}
+	require.NoError(t, collector.collect(context.Background()))
+
+	content, err := os.ReadFile(envFile)
+	require.NoError(t, err)
+	env := string(content)
+	assert.NotContains(t, env, "LD_PRELOAD")
+	assert.NotContains(t, env, "NVIDIA_VISIBLE_DEVICES")
+	assert.Contains(t, env, "PATH="+gpuCmdPath+"\n")
+	assert.Contains(t, env, "HOME=/home/beszel\n")
+	assert.Contains(t, env, "DISPLAY=:0\n")
+}
+
+func TestCollectorStats(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "gpu-tool")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\necho valid\necho valid\necho invalid\necho valid\n"), 0755))
+
+	gm := &GPUManager{}
+	value, _ := gm.collectors.LoadOrStore(script, &gpuCollector{name: script})
+	collector := value.(*gpuCollector)
+	collector.parse = func(line []byte) bool { return string(line) == "valid" }
+
+	// stops at the first invalid line
+	assert.ErrorIs(t, collector.collect(context.Background()), errNoValidData)
+	stats := gm.CollectorStats()
+	require.Contains(t, stats, script)
+	assert.Equal(t, uint64(2), stats[script].SuccessfulParses)
+	assert.Equal(t, uint64(1), stats[script].FailedParses)
+	assert.Equal(t, errNoValidData.Error(), stats[script].LastError)
+
+	// counters accumulate across runs and the last error is kept
+	collector.parse = func([]byte) bool { return true }
+	require.NoError(t, collector.collect(context.Background()))
+	stats = gm.CollectorStats()
+	assert.Equal(t, uint64(6), stats[script].SuccessfulParses)
+	assert.Equal(t, uint64(1), stats[script].FailedParses)
+	assert.Equal(t, errNoValidData.Error(), stats[script].LastError)
+
+	// command errors are recorded without counting a parse
+	missing := &gpuCollector{name: filepath.Join(dir, "missing"), parse: func([]byte) bool { return true }}
+	require.Error(t, missing.collect(context.Background()))
+	assert.Equal(t, uint64(0), missing.stats().FailedParses)
+	assert.NotEmpty(t, missing.stats().LastError)
+}
+
+func TestMemoryFragmentation(t *testing.T) {
+	tests := []struct {
+		name             string
+		largestFreeBlock float64
+		totalFree        float64
+		expected         float64
+	}{
+		{"unfragmented", 8192, 8192, 0},
+		{"half fragmented", 4096, 8192, 0.5},
+		{"no free memory", 0, 0, 0},
+		{"no free block", 0, 8192, 1},
+		{"block larger than free memory", 16384, 8192, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, memoryFragmentation(tt.largestFreeBlock, tt.totalFree))
+		})
+	}
+}
+
+func TestParseNvidiaMemoryFragmentation(t *testing.T) {
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+
+	// resizable BAR covering all of VRAM with 3/4 of free memory mappable
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA A100, 50, 8192, 40960, 30, 200, 0, 400, 4, 16, 0, 12, 400, 0, 32768, 24576, 65536")))
+	assert.Equal(t, 0.25, gm.GpuDataMap["0"].MemoryFragmentation)
+
+	// 256 MiB BAR1 aperture is ignored
+	require.True(t, gm.parseNvidiaData([]byte("1, NVIDIA GeForce RTX 3080, 50, 2048, 10240, 30, 200, 0, 320, 4, 16, [N/A], 12, 370, 0, 8192, 200, 256")))
+	assert.Equal(t, 0.0, gm.GpuDataMap["1"].MemoryFragmentation)
+
+	// BAR1 fields not queried
+	require.True(t, gm.parseNvidiaData([]byte("2, NVIDIA GeForce RTX 3080, 50, 2048, 10240, 30, 200, 0, 320")))
+	assert.Equal(t, 0.0, gm.GpuDataMap["2"].MemoryFragmentation)
+}
+
+func TestParseNvidiaMemoryReserved(t *testing.T) {
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA GeForce RTX 4090, 50, 3172, 24564, 30, 200, 0, 450, 4, 16, [N/A], 12, 600, 398")))
+	gpu := gm.GetCurrentData()["0"]
+	assert.Equal(t, 388.67, gpu.MemoryReserved)
+	assert.InDelta(t, gpu.MemoryTotal, gpu.MemoryAvailable+gpu.MemoryUsed+gpu.MemoryReserved, 0.02)
+
+	// memory.reserved not queried
+	require.True(t, gm.parseNvidiaData([]byte("1, NVIDIA GeForce RTX 3080, 50, 2048, 10240, 30, 200, 0, 320")))
+	assert.Zero(t, gm.GpuDataMap["1"].MemoryReserved)
+	assert.Zero(t, gm.GpuDataMap["1"].MemoryAvailable)
+}
+
+func TestParseNvidiaCopyEngineUsage(t *testing.T) {
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+
+	// memory mostly allocated but rarely accessed
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA A100, 50, 39936, 40960, 30, 200, 0, 400, 4, 16, 0, 3")))
+	gpu := gm.GetCurrentData()["0"]
+	assert.Equal(t, 3.0, gpu.CopyEngineUsage)
+	assert.Equal(t, 39000.0, gpu.MemoryUsed)
+
+	// little memory allocated but busy copying
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA A100, 50, 1024, 40960, 30, 200, 0, 400, 4, 16, 0, 87.5")))
+	gpu = gm.GetCurrentData()["0"]
+	assert.Equal(t, 87.5, gpu.CopyEngineUsage)
+	assert.Equal(t, 1000.0, gpu.MemoryUsed)
+
+	// not queried
+	require.True(t, gm.parseNvidiaData([]byte("1, NVIDIA GeForce RTX 3080, 50, 2048, 10240, 30, 200, 0, 320")))
+	assert.Zero(t, gm.GpuDataMap["1"].CopyEngineUsage)
+}
+
+func TestParseNvidiaMaxPowerLimit(t *testing.T) {
+	logs := captureLogs(t)
+
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	// power cap lowered to 60W on a board limited to 75W by the slot
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA RTX A2000, 61, 2048, 6138, 92, 60.5, 0, 60.00, 4, 16, 0, 40, 75.00")))
+	gpu := gm.GpuDataMap["0"]
+	assert.Equal(t, 60.0, gpu.PowerLimit)
+	assert.Equal(t, 75.0, gpu.MaxPowerLimit)
+	assert.NotContains(t, logs.String(), "GPU power near max limit")
+
+	// above 95% of the max limit
+	line := []byte("0, NVIDIA RTX A2000, 61, 2048, 6138, 92, 71.3, 0, 75.00, 4, 16, 0, 40, 75.00")
+	require.True(t, gm.parseNvidiaData(line))
+	require.True(t, gm.parseNvidiaData(line))
+	assert.Equal(t, 1, strings.Count(logs.String(), "level=WARN msg=\"GPU power near max limit\""), "warned once while near the limit")
+
+	// warned again after dropping below the threshold
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA RTX A2000, 61, 2048, 6138, 92, 30.2, 0, 75.00, 4, 16, 0, 40, 75.00")))
+	require.True(t, gm.parseNvidiaData(line))
+	assert.Equal(t, 2, strings.Count(logs.String(), "GPU power near max limit"))
+	assert.Equal(t, 75.0, gm.GetCurrentData()["0"].MaxPowerLimit)
+
+	// max limit not supported
+	require.True(t, gm.parseNvidiaData([]byte("1, NVIDIA GeForce GT 1030, 45, 512, 2048, 99, 29.8, 0, [N/A], 3, 4, [N/A], 10, [N/A]")))
+	assert.Zero(t, gm.GpuDataMap["1"].MaxPowerLimit)
+	assert.Equal(t, 2, strings.Count(logs.String(), "GPU power near max limit"))
+}
+
+func TestParseNvidiaPCIeLink(t *testing.T) {
+	logs := captureLogs(t)
+
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	line := []byte("0, NVIDIA RTX A6000, 61, 21340, 49140, 92, 281.4, 0, 300.00, 4, 16")
+	require.True(t, gm.parseNvidiaData(line))
+	assert.Equal(t, uint8(4), gm.GpuDataMap["0"].PCIeGen)
+	assert.Equal(t, uint8(16), gm.GpuDataMap["0"].PCIeWidth)
+	assert.Equal(t, 1, strings.Count(logs.String(), "GPU PCIe link"), "logged when the GPU appears")
+
+	require.True(t, gm.parseNvidiaData(line))
+	assert.Equal(t, 1, strings.Count(logs.String(), "GPU PCIe link"), "not logged while unchanged")
+
+	// link downgraded, e.g. to save power at idle
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA RTX A6000, 45, 21340, 49140, 0, 25.1, 0, 300.00, 1, 16")))
+	assert.Equal(t, uint8(1), gm.GpuDataMap["0"].PCIeGen)
+	assert.Equal(t, 2, strings.Count(logs.String(), "GPU PCIe link"))
+	assert.Equal(t, uint8(1), gm.GetCurrentData()["0"].PCIeGen)
+
+	// not a PCIe device
+	require.True(t, gm.parseNvidiaData([]byte("1, NVIDIA H100 80GB HBM3, 38, 0, 81559, 0, 70.2, 0, 700.00, [N/A], [N/A]")))
+	assert.Zero(t, gm.GpuDataMap["1"].PCIeGen)
+	assert.Zero(t, gm.GpuDataMap["1"].PCIeWidth)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	t.Run("backoff", func(t *testing.T) {
+		policy := RetryPolicy{BackoffBase: time.Second, BackoffMax: 5 * time.Second}
+		assert.Equal(t, time.Second, policy.backoff(1))
+		assert.Equal(t, 2*time.Second, policy.backoff(2))
+		assert.Equal(t, 4*time.Second, policy.backoff(3))
+		assert.Equal(t, 5*time.Second, policy.backoff(4))
+		assert.Equal(t, 5*time.Second, policy.backoff(100))
+
+		assert.Equal(t, retryWaitTime, DefaultRetryPolicy.backoff(1))
+		assert.Equal(t, retryWaitTime, DefaultRetryPolicy.backoff(10))
+	})
+
+	t.Run("exhausted", func(t *testing.T) {
+		policy := RetryPolicy{MaxRetries: 2}
+		assert.False(t, policy.exhausted(2))
+		assert.True(t, policy.exhausted(3))
+		assert.False(t, DefaultRetryPolicy.exhausted(1000))
+	})
+
+	t.Run("collector stops after max retries", func(t *testing.T) {
+		dir := t.TempDir()
+		runs := filepath.Join(dir, "runs.txt")
+		script := filepath.Join(dir, "gpu-tool")
+		require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\necho run >> "+runs+"\nexit 1\n"), 0755))
+
+		collector := &gpuCollector{
+			name:  script,
+			parse: func([]byte) bool { return true },
+			retry: RetryPolicy{MaxRetries: 3, BackoffBase: time.Millisecond, BackoffMax: 4 * time.Millisecond},
+		}
+
+		done := make(chan struct{})
+		go func() {
+			collector.start(context.Background())
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("collector did not stop")
+		}
+
+		content, err := os.ReadFile(runs)
+		require.NoError(t, err)
+		// first attempt plus MaxRetries retries
+		assert.Equal(t, 4, strings.Count(string(content), "run"))
+		assert.Equal(t, uint64(0), collector.stats().SuccessfulParses)
+	})
+
+	t.Run("options override collector policy", func(t *testing.T) {
+		policy := RetryPolicy{MaxRetries: 1, BackoffBase: time.Millisecond}
+		gm := &GPUManager{
+			GpuDataMap: make(map[string]*system.GPUData),
+			opts:       GPUManagerOptions{RetryPolicies: map[string]RetryPolicy{rocmSmiCmd: policy}},
+		}
+		// rocm-smi is not installed, so the collector fails and stops after one retry
+		gm.startCollector(rocmSmiCmd)
+		value, ok := gm.collectors.Load(rocmSmiCmd)
+		require.True(t, ok)
+		assert.Equal(t, policy, value.(*gpuCollector).retry)
+		assert.Eventually(t, func() bool {
+			return gm.activeCollectors.Load() == 0
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestParsePowerLimit(t *testing.T) {
+	t.Run("nvidia", func(t *testing.T) {
+		gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+		input := "0, NVIDIA GeForce RTX 4090, 52, 3120, 24564, 35, 180.5, 0, 450.00\n1, NVIDIA Tesla V100-PCIE-16GB, 40, 12, 16384, 0, 25.0, 0, [N/A]"
+		require.True(t, gm.parseNvidiaData([]byte(input)))
+
+		gpu := gm.GpuDataMap["0"]
+		assert.Equal(t, 450.0, gpu.PowerLimit)
+		assert.LessOrEqual(t, gpu.Power, gpu.PowerLimit)
+		assert.Equal(t, 0.0, gm.GpuDataMap["1"].PowerLimit, "N/A is stored as 0")
+
+		data := gm.GetCurrentData()
+		assert.Equal(t, 450.0, data["0"].PowerLimit)
+		assert.LessOrEqual(t, data["0"].Power, data["0"].PowerLimit)
+	})
+
+	t.Run("amd", func(t *testing.T) {
+		gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+		input := `{
+			"card0": {
+				"GUID": "38294",
+				"Temperature (Sensor edge) (C)": "49.0",
+				"Average Graphics Package Power (W)": "119.0",
+				"Max Graphics Package Power (W)": "315.0",
+				"GPU use (%)": "20.3",
+				"VRAM Total Memory (B)": "25753026560",
+				"VRAM Total Used Memory (B)": "794341376",
+				"Card Series": "Navi 31 [Radeon RX 7900 XT]"
+			}
+		}`
+		require.True(t, gm.parseAmdData([]byte(input)))
+
+		gpu := gm.GpuDataMap["38294"]
+		assert.Equal(t, 315.0, gpu.PowerLimit)
+		assert.LessOrEqual(t, gpu.Power, gpu.PowerLimit)
+	})
+}
+
+func TestGPUManagerStop(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	script := "#!/bin/sh\nwhile true; do echo 'RAM 1000/2000MB GR3D_FREQ 50% tj@50C VDD_GPU_SOC 1000mW'; sleep 0.05; done\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, tegraStatsCmd), []byte(script), 0755))
+
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	gm.ctx, gm.cancel = context.WithCancel(context.Background())
+	gm.startCollector(tegraStatsCmd)
+	gm.wg.Add(1)
+	go func() {
+		defer gm.wg.Done()
+		gm.watchGPUs(10 * time.Millisecond)
+	}()
+
+	// wait for the collector to parse some data
+	assert.Eventually(t, func() bool {
+		return gm.CollectorStats()[tegraStatsCmd].SuccessfulParses > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, gm.Stop(ctx))
+	assert.Equal(t, int32(0), gm.activeCollectors.Load())
+	// the killed command is not recorded as a collector error
+	assert.Empty(t, gm.CollectorStats()[tegraStatsCmd].LastError)
+}
+
+func TestAmdCollectorFailure(t *testing.T) {
+	const rocmInput = `{"card0": {"GUID": "34756", "Temperature (Sensor edge) (C)": "49.0", "Current Socket Graphics Package Power (W)": "28.159", "GPU use (%)": "40", "VRAM Total Memory (B)": "536870912", "VRAM Total Used Memory (B)": "445550592", "Card Series": "Rembrandt [Radeon 680M]"}}`
+
+	t.Run("error is propagated and cleared on recovery", func(t *testing.T) {
+		gm := &GPUManager{GpuDataMap: map[string]*system.GPUData{
+			"0": {Name: "NVIDIA A100", Temperature: 60, Usage: 90, Count: 1},
+		}}
+		require.True(t, gm.parseAmdData([]byte(rocmInput)))
+
+		gm.markAmdFailed(fmt.Errorf("exit status 1"))
+		data := gm.GetCurrentData()
+		amd := data["34756"]
+		assert.Equal(t, "Rembrandt [Radeon 680M]", amd.Name)
+		assert.Equal(t, "exit status 1", amd.Error)
+		assert.Zero(t, amd.Temperature)
+		assert.Zero(t, amd.Usage)
+		assert.Zero(t, amd.Power)
+		assert.Zero(t, amd.MemoryUsed)
+		assert.Empty(t, data["0"].Error, "Nvidia GPUs are not affected")
+		assert.Equal(t, 90.0, data["0"].Usage)
+
+		// the error is still reported on later requests
+		assert.Equal(t, "exit status 1", gm.GetCurrentData()["34756"].Error)
+
+		require.True(t, gm.parseAmdData([]byte(rocmInput)))
+		amd = gm.GetCurrentData()["34756"]
+		assert.Empty(t, amd.Error)
+		assert.InDelta(t, 49.0, amd.Temperature, 0.01)
+		assert.InDelta(t, 40.0, amd.Usage, 0.01)
+		assert.False(t, gm.amdFailed)
+	})
+
+	t.Run("error is kept outside the aggregation window", func(t *testing.T) {
+		gm := &GPUManager{
+			GpuDataMap: make(map[string]*system.GPUData),
+			opts:       GPUManagerOptions{AggregationWindow: time.Millisecond},
+		}
+		require.True(t, gm.parseAmdData([]byte(rocmInput)))
+		gm.markAmdFailed(fmt.Errorf("exit status 1"))
+		time.Sleep(5 * time.Millisecond)
+		assert.Equal(t, "exit status 1", gm.GetCurrentData()["34756"].Error)
+	})
+
+	t.Run("collector marks GPUs after retries are exhausted", func(t *testing.T) {
+		dir := t.TempDir()
+		t.Setenv("PATH", dir)
+		script := "#!/bin/sh\necho 'rocm-smi: driver not loaded' >&2\nexit 1\n"
+		require.NoError(t, os.WriteFile(filepath.Join(dir, rocmSmiCmd), []byte(script), 0755))
+
+		gm := &GPUManager{
+			GpuDataMap: make(map[string]*system.GPUData),
+			opts: GPUManagerOptions{RetryPolicies: map[string]RetryPolicy{
+				rocmSmiCmd: {MaxRetries: 1, BackoffBase: time.Millisecond},
+			}},
+		}
+		require.True(t, gm.parseAmdData([]byte(rocmInput)))
+		gm.startCollector(rocmSmiCmd)
+		require.Eventually(t, func() bool {
+			return gm.activeCollectors.Load() == 0
+		}, 5*time.Second, 10*time.Millisecond)
+
+		amd := gm.GetCurrentData()["34756"]
+		assert.Equal(t, "exit status 1", amd.Error)
+		assert.Zero(t, amd.Temperature)
+	})
+}
+
+func TestGPULastUpdated(t *testing.T) {
+	parsers := []struct {
+		name  string
+		id    string
+		parse func(gm *GPUManager) bool
+	}{
+		{"nvidia", "0", func(gm *GPUManager) bool {
+			return gm.parseNvidiaData([]byte("0, NVIDIA A100-SXM4-40GB, 52, 3120, 40960, 35, 180.5, 0, 400.00"))
+		}},
+		{"amd", "34756", func(gm *GPUManager) bool {
+			return gm.parseAmdData([]byte(`{"card0": {"GUID": "34756", "Temperature (Sensor edge) (C)": "49.0", "GPU use (%)": "40", "Card Series": "Rembrandt [Radeon 680M]"}}`))
+		}},
+		{"jetson", "0", func(gm *GPUManager) bool {
+			return gm.getJetsonParser()([]byte("RAM 1024/4096MB GR3D_FREQ 80% tj@70C VDD_GPU_SOC 1000mW"))
+		}},
+	}
+	for _, tt := range parsers {
+		t.Run(tt.name, func(t *testing.T) {
+			gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+			before := time.Now()
+			require.True(t, tt.parse(gm))
+			first := gm.GpuDataMap[tt.id].LastUpdated
+			assert.False(t, first.Before(before), "timestamp should be set by the parser")
+			assert.WithinDuration(t, time.Now(), first, time.Second)
+
+			data := gm.GetCurrentData()
+			assert.Equal(t, first, data[tt.id].LastUpdated, "GetCurrentData should copy the timestamp")
+
+			time.Sleep(2 * time.Millisecond)
+			require.True(t, tt.parse(gm))
+			assert.True(t, gm.GetCurrentData()[tt.id].LastUpdated.After(first))
+		})
+	}
+}
+
+func TestGPUUsageHistogram(t *testing.T) {
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	sample := func(usage int) {
+		line := fmt.Sprintf("0, NVIDIA A100-SXM4-40GB, 52, 3120, 40960, %d, 180.5, 0, 400.00", usage)
+		require.True(t, gm.parseNvidiaData([]byte(line)))
+	}
+	for _, usage := range []int{0, 0, 50, 100, 100, 100, 9, 10, 99} {
+		sample(usage)
+	}
+
+	data := gm.GetCurrentData()["0"]
+	assert.Equal(t, [10]uint8{3, 1, 0, 0, 0, 1, 0, 0, 0, 4}, data.UsageHistogram)
+	assert.InDelta(t, 52.0, data.Usage, 0.01, "average is still reported")
+
+	// histogram is reset after each request
+	sample(50)
+	data = gm.GetCurrentData()["0"]
+	assert.Equal(t, [10]uint8{5: 1}, data.UsageHistogram)
+	assert.Equal(t, [10]uint8{}, gm.GetCurrentData()["0"].UsageHistogram)
+
+	t.Run("buckets", func(t *testing.T) {
+		gpu := &system.GPUData{}
+		for _, usage := range []float64{-1, 0, 9.99, 10, 50, 89.9, 90, 100, 150} {
+			addUsageSample(gpu, usage)
+		}
+		assert.Equal(t, [10]uint8{3, 1, 0, 0, 0, 1, 0, 0, 1, 3}, gpu.UsageHistogram)
+
+		for range 300 {
+			addUsageSample(gpu, 100)
+		}
+		assert.Equal(t, uint8(255), gpu.UsageHistogram[9], "buckets saturate instead of overflowing")
+	})
+
+	t.Run("encoded only when set", func(t *testing.T) {
+		encoded, err := json.Marshal(system.GPUData{UsageHistogram: [10]uint8{0, 2, 5: 1}})
+		require.NoError(t, err)
+		assert.Contains(t, string(encoded), `"uh":[0,2,0,0,0,1,0,0,0,0]`)
+		encoded, err = json.Marshal(system.GPUData{})
+		require.NoError(t, err)
+		assert.NotContains(t, string(encoded), `"uh"`)
+	})
+}
+
+func TestParseAmdMemoryTemperature(t *testing.T) {
+	// rocm-smi on an RDNA3 card (edge, junction, and memory sensors) and a CDNA card without a memory sensor
+	input := `{
+		"card0": {
+			"GUID": "11902",
+			"Temperature (Sensor edge) (C)": "61.0",
+			"Temperature (Sensor junction) (C)": "78.0",
+			"Temperature (Sensor memory) (C)": "88.0",
+			"GPU use (%)": "97",
+			"Average Graphics Package Power (W)": "301.0",
+			"Card Series": "Navi 31 [Radeon RX 7900 XTX]"
+		},
+		"card1": {
+			"GUID": "52081",
+			"Temperature (Sensor edge) (C)": "45.0",
+			"Temperature (Sensor junction) (C)": "52.0",
+			"GPU use (%)": "64",
+			"Average Graphics Package Power (W)": "410.0",
+			"Card Series": "Aldebaran/MI200 [Instinct MI210]"
+		}
+	}`
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	require.True(t, gm.parseAmdData([]byte(input)))
+
+	data := gm.GetCurrentData()
+	rdna := data["11902"]
+	assert.Equal(t, 61.0, rdna.Temperature)
+	assert.Equal(t, 88.0, rdna.MemoryTemp)
+	assert.Greater(t, rdna.MemoryTemp, rdna.Temperature, "memory runs hotter than edge on RDNA3")
+	assert.Zero(t, data["52081"].MemoryTemp, "CDNA cards have no memory sensor")
+
+	encoded, err := json.Marshal(rdna)
+	require.NoError(t, err)
+	assert.Contains(t, string(encoded), `"mtp":88`)
+	encoded, err = json.Marshal(data["52081"])
+	require.NoError(t, err)
+	assert.NotContains(t, string(encoded), `"mtp"`)
+}
+
+func TestGetCurrentDataPrecision(t *testing.T) {
+	precision := func(p int) *int { return &p }
+	tests := []struct {
+		name      string
+		precision *int
+		want      float64
+	}{
+		{"default", nil, 67.46},
+		{"two decimals", precision(2), 67.46},
+		{"one decimal", precision(1), 67.5},
+		{"integer", precision(0), 67},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gm := &GPUManager{
+				GpuDataMap: map[string]*system.GPUData{
+					"0": {Name: "RTX 4090", Temperature: 67.456, Usage: 67.456, Power: 67.456, MemoryFragmentation: 0.456, Count: 1},
+				},
+				opts: GPUManagerOptions{StatsPrecision: tt.precision},
+			}
+			publishGPUData(gm)
+			gpu := gm.GetCurrentData()["0"]
+			assert.Equal(t, tt.want, gpu.Temperature)
+			assert.Equal(t, tt.want, gpu.Usage)
+			assert.Equal(t, tt.want, gpu.Power)
+			assert.Equal(t, 0.46, gpu.MemoryFragmentation, "a ratio keeps two decimals")
+		})
+	}
+}
+
+func TestOverTempEvents(t *testing.T) {
+	gm := &GPUManager{
+		GpuDataMap: make(map[string]*system.GPUData),
+		opts:       GPUManagerOptions{TempWarnThreshold: 85, TempCritThreshold: 95},
+	}
+	parse := func(temps ...int) {
+		for _, temp := range temps {
+			require.True(t, gm.parseNvidiaData(fmt.Appendf(nil, "0, NVIDIA GeForce RTX 4090, %d, 1024, 24564, 50, 200.00", temp)))
+		}
+	}
+
+	// each rise above the threshold is one event, however long it lasts
+	parse(70, 90, 92, 80, 88)
+	gpu := gm.GetCurrentData()["0"]
+	assert.Equal(t, uint32(2), gpu.OverTempEvents)
+	assert.Equal(t, 88.0, gpu.Temperature)
+
+	// reset after each report, and still above the threshold is not a new event
+	assert.Zero(t, gm.GetCurrentData()["0"].OverTempEvents)
+	parse(91)
+	assert.Zero(t, gm.GetCurrentData()["0"].OverTempEvents)
+	parse(70, 86)
+	assert.Equal(t, uint32(1), gm.GetCurrentData()["0"].OverTempEvents)
+
+	publishGPUData(gm)
+	gm.setTemperature(gm.GpuDataMap["0"], 60)
+	gm.setTemperature(gm.GpuDataMap["0"], 85)
+	assert.Zero(t, gm.GpuDataMap["0"].OverTempEvents, "at the threshold is not above it")
+}
+
+func TestTemperatureRange(t *testing.T) {
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	parse := func(temps ...int) {
+		for _, temp := range temps {
+			require.True(t, gm.parseNvidiaData(fmt.Appendf(nil, "0, NVIDIA GeForce RTX 4090, %d, 1024, 24564, 50, 200.00", temp)))
+		}
+	}
+
+	parse(64, 58, 71, 66, 62)
+	gpu := gm.GetCurrentData()["0"]
+	assert.Equal(t, 62.0, gpu.Temperature, "latest reading")
+	assert.Equal(t, 58.0, gpu.TemperatureMin)
+	assert.Equal(t, 71.0, gpu.TemperatureMax)
+
+	// each report starts a new range
+	parse(65, 67)
+	gpu = gm.GetCurrentData()["0"]
+	assert.Equal(t, 65.0, gpu.TemperatureMin)
+	assert.Equal(t, 67.0, gpu.TemperatureMax)
+	gpu = gm.GetCurrentData()["0"]
+	assert.Zero(t, gpu.TemperatureMin, "no readings since the last report")
+	assert.Zero(t, gpu.TemperatureMax)
+
+	// readings from an unavailable sensor are ignored
+	parse(0, 60)
+	assert.Equal(t, 60.0, gm.GetCurrentData()["0"].TemperatureMin)
+}
@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"cmp"
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Topology keys in `rocm-smi --showid --showtopo --json` output, e.g.
+//
+//	"system": {"(Topology) Link type between DRM devices 0 and 1": "XGMI", ...}
+var amdTopologyLinkPattern = regexp.MustCompile(`^\(Topology\) Link type between DRM devices (\d+) and (\d+)$`)
+
+// amdLinkTypes maps rocm-smi link types to GPULink.LinkType values
+var amdLinkTypes = map[string]string{
+	"PCIE": "PCIe",
+	"XGMI": "xGMI",
+}
+
+// detectAmdTopology returns the links between AMD GPUs from rocm-smi, or nil if
+// there is only one GPU. Topology doesn't change at runtime, so it is only read once.
+func detectAmdTopology() []system.GPULink {
+	output, err := newGPUCommand(rocmSmiCmd, "--showid", "--showtopo", "--json").Output()
+	if err != nil {
+		slog.Debug("AMD GPU topology", "err", err)
+		return nil
+	}
+	links, err := parseAmdTopology(output)
+	if err != nil {
+		slog.Debug("AMD GPU topology", "err", err)
+		return nil
+	}
+	slog.Debug("AMD GPU topology", "links", links)
+	return links
+}
+
+// parseAmdTopology parses the output of `rocm-smi --showid --showtopo --json`.
+// GPUs are identified by GUID, matching the GpuDataMap keys, or by DRM device
+// index if the GUID is missing.
+func parseAmdTopology(output []byte) ([]system.GPULink, error) {
+	var topology map[string]map[string]string
+	if err := json.Unmarshal(output, &topology); err != nil {
+		return nil, err
+	}
+	gpuID := func(index string) string {
+		if guid := topology["card"+index]["GUID"]; guid != "" {
+			return guid
+		}
+		return index
+	}
+	var links []system.GPULink
+	for key, value := range topology["system"] {
+		matches := amdTopologyLinkPattern.FindStringSubmatch(key)
+		if matches == nil || matches[1] == matches[2] {
+			continue
+		}
+		linkType, ok := amdLinkTypes[strings.ToUpper(value)]
+		if !ok {
+			linkType = value
+		}
+		links = append(links, system.GPULink{
+			SrcID:    gpuID(matches[1]),
+			DstID:    gpuID(matches[2]),
+			LinkType: linkType,
+		})
+	}
+	slices.SortFunc(links, func(a, b system.GPULink) int {
+		return cmp.Or(cmp.Compare(a.SrcID, b.SrcID), cmp.Compare(a.DstID, b.DstID))
+	})
+	return links, nil
+}
+
+// Topology returns the links between GPUs detected at startup
+func (gm *GPUManager) Topology() []system.GPULink {
+	return gm.topology
+}
+
+// attachGPUTopology adds the GPU topology to stats for the first request of each
+// SSH session, since it doesn't change and only needs to be sent once
+func (a *Agent) attachGPUTopology(sessionID string, stats *system.Stats) {
+	a.attachMu.Lock()
+	defer a.attachMu.Unlock()
+	if a.gpuManager == nil || len(a.gpuManager.Topology()) == 0 || a.gpuTopoSession == sessionID {
+		return
+	}
+	stats.GPUTopology = a.gpuManager.Topology()
+	a.gpuTopoSession = sessionID
+}
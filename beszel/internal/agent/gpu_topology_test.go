@@ -0,0 +1,114 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// output of `rocm-smi --showid --showtopo --json` on a system with two MI210s
+// connected by an xGMI bridge
+const amdTopologyOutput = `{
+	"card0": {
+		"Device Name": "Aldebaran/MI200 [Instinct MI210]",
+		"Device ID": "0x740f",
+		"GUID": "52081"
+	},
+	"card1": {
+		"Device Name": "Aldebaran/MI200 [Instinct MI210]",
+		"Device ID": "0x740f",
+		"GUID": "17734"
+	},
+	"system": {
+		"(Topology) Weight between DRM devices 0 and 1": "15",
+		"(Topology) Weight between DRM devices 1 and 0": "15",
+		"(Topology) Hops between DRM devices 0 and 1": "1",
+		"(Topology) Hops between DRM devices 1 and 0": "1",
+		"(Topology) Link type between DRM devices 0 and 0": "XGMI",
+		"(Topology) Link type between DRM devices 0 and 1": "XGMI",
+		"(Topology) Link type between DRM devices 1 and 0": "XGMI",
+		"(Topology) Link type between DRM devices 1 and 1": "XGMI",
+		"(Topology) Numa Node of DRM device 0": "0",
+		"(Topology) Numa Node of DRM device 1": "0"
+	}
+}`
+
+func TestParseAmdTopology(t *testing.T) {
+	links, err := parseAmdTopology([]byte(amdTopologyOutput))
+	require.NoError(t, err)
+	assert.Equal(t, []system.GPULink{
+		{SrcID: "17734", DstID: "52081", LinkType: "xGMI"},
+		{SrcID: "52081", DstID: "17734", LinkType: "xGMI"},
+	}, links)
+
+	// PCIe links, and DRM device indexes when the GUID is missing
+	links, err = parseAmdTopology([]byte(`{
+		"card0": {"GUID": "52081"},
+		"card1": {},
+		"system": {
+			"(Topology) Link type between DRM devices 0 and 1": "PCIE",
+			"(Topology) Link type between DRM devices 1 and 0": "PCIE"
+		}
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, []system.GPULink{
+		{SrcID: "1", DstID: "52081", LinkType: "PCIe"},
+		{SrcID: "52081", DstID: "1", LinkType: "PCIe"},
+	}, links)
+
+	// a single GPU has no links
+	links, err = parseAmdTopology([]byte(`{"card0": {"GUID": "52081"}, "system": {}}`))
+	require.NoError(t, err)
+	assert.Empty(t, links)
+
+	_, err = parseAmdTopology([]byte("ERROR: GPU[0] : Unable to get topology"))
+	assert.Error(t, err)
+}
+
+func TestDetectAmdTopology(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "output.json"), []byte(amdTopologyOutput), 0o644))
+	script := "#!/bin/sh\n[ \"$*\" = \"--showid --showtopo --json\" ] || exit 1\n/bin/cat " + filepath.Join(dir, "output.json") + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, rocmSmiCmd), []byte(script), 0o755))
+
+	links := detectAmdTopology()
+	assert.Len(t, links, 2)
+	for _, link := range links {
+		assert.Equal(t, "xGMI", link.LinkType)
+	}
+}
+
+func TestAttachGPUTopology(t *testing.T) {
+	links, err := parseAmdTopology([]byte(amdTopologyOutput))
+	require.NoError(t, err)
+	a := &Agent{gpuManager: &GPUManager{topology: links}}
+
+	// only the first stats of each session include the topology
+	var stats system.Stats
+	a.attachGPUTopology("session1", &stats)
+	assert.Equal(t, links, stats.GPUTopology)
+	stats = system.Stats{}
+	a.attachGPUTopology("session1", &stats)
+	assert.Nil(t, stats.GPUTopology)
+	a.attachGPUTopology("session2", &stats)
+	assert.Equal(t, links, stats.GPUTopology)
+
+	encoded, err := json.Marshal(stats)
+	require.NoError(t, err)
+	assert.Contains(t, string(encoded), `"gt":[{"s":"17734","d":"52081","t":"xGMI"}`)
+
+	// nothing to attach without AMD GPUs
+	stats = system.Stats{}
+	(&Agent{}).attachGPUTopology("session1", &stats)
+	(&Agent{gpuManager: &GPUManager{}}).attachGPUTopology("session1", &stats)
+	assert.Nil(t, stats.GPUTopology)
+}
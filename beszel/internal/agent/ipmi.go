@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ipmitoolCmd     = "ipmitool"
+	ipmitoolTimeout = 10 * time.Second
+)
+
+// sensor types read with `ipmitool sdr type`. Current includes PSU power readings.
+var ipmiSensorTypes = []string{"Fan", "Temperature", "Current"}
+
+// IPMICollector reads fan, temperature, and power sensors from the BMC with ipmitool
+type IPMICollector struct {
+	// run executes ipmitool with the given arguments and returns its output
+	run func(ctx context.Context, args ...string) ([]byte, error)
+}
+
+// newIPMICollector returns a collector if BESZEL_ENABLE_IPMI is "true" and ipmitool
+// is installed, or nil otherwise. ipmitool usually requires root.
+func newIPMICollector() *IPMICollector {
+	if enabled, _ := GetEnvFallback("BESZEL_AGENT_ENABLE_IPMI", "BESZEL_ENABLE_IPMI"); enabled != "true" {
+		return nil
+	}
+	path, err := exec.LookPath(ipmitoolCmd)
+	if err != nil {
+		slog.Warn("IPMI enabled but ipmitool not found", "err", err)
+		return nil
+	}
+	return &IPMICollector{
+		run: func(ctx context.Context, args ...string) ([]byte, error) {
+			ctx, cancel := context.WithTimeout(ctx, ipmitoolTimeout)
+			defer cancel()
+			return exec.CommandContext(ctx, path, args...).Output()
+		},
+	}
+}
+
+// Collect returns the current readings of all supported sensor types, stopping
+// ipmitool if ctx is done
+func (c *IPMICollector) Collect(ctx context.Context) []system.IPMISensor {
+	if c == nil {
+		return nil
+	}
+	var sensors []system.IPMISensor
+	for _, sensorType := range ipmiSensorTypes {
+		output, err := c.run(ctx, "-c", "sdr", "type", sensorType)
+		if err != nil {
+			slog.Debug("IPMI", "type", sensorType, "err", err)
+			continue
+		}
+		sensors = append(sensors, parseIPMISdr(sensorType, output)...)
+	}
+	return sensors
+}
+
+// parseIPMISdr parses the CSV output of `ipmitool -c sdr type <type>`, e.g.
+//
+//	FAN1,30h,ok,29.1,3600 RPM
+//	Pwr Consumption,77h,ok,7.1,140 Watts
+//
+// Sensors without a numeric reading, such as "No Reading" or "Disabled", are skipped.
+func parseIPMISdr(sensorType string, output []byte) []system.IPMISensor {
+	var sensors []system.IPMISensor
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		record, err := csv.NewReader(strings.NewReader(scanner.Text())).Read()
+		if err != nil || len(record) < 5 {
+			continue
+		}
+		valueStr, unit, _ := strings.Cut(strings.TrimSpace(record[4]), " ")
+		value, err := strconv.ParseFloat(valueStr, 64)
+		if err != nil {
+			continue
+		}
+		sensors = append(sensors, system.IPMISensor{
+			Name:   strings.TrimSpace(record[0]),
+			Type:   sensorType,
+			Value:  value,
+			Unit:   strings.TrimSpace(unit),
+			Status: strings.TrimSpace(record[2]),
+		})
+	}
+	return sensors
+}
@@ -0,0 +1,85 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIPMISdr(t *testing.T) {
+	output := `FAN1,30h,ok,29.1,3600 RPM
+FAN2,31h,ns,29.2,No Reading
+"Fan, Rear",32h,cr,29.3,600 RPM
+malformed line
+`
+	assert.Equal(t, []system.IPMISensor{
+		{Name: "FAN1", Type: "Fan", Value: 3600, Unit: "RPM", Status: "ok"},
+		{Name: "Fan, Rear", Type: "Fan", Value: 600, Unit: "RPM", Status: "cr"},
+	}, parseIPMISdr("Fan", []byte(output)))
+}
+
+func TestIPMICollectorCollect(t *testing.T) {
+	outputs := map[string]string{
+		"Fan":         "FAN1,30h,ok,29.1,3600 RPM\n",
+		"Temperature": "Inlet Temp,04h,ok,7.1,23 degrees C\nExhaust Temp,01h,ok,7.1,35 degrees C\n",
+		"Current":     "Pwr Consumption,77h,ok,7.1,140 Watts\n",
+	}
+	var calls []string
+	collector := &IPMICollector{
+		run: func(_ context.Context, args ...string) ([]byte, error) {
+			calls = append(calls, strings.Join(args, " "))
+			sensorType := args[len(args)-1]
+			if sensorType == "Current" && len(calls) > 3 {
+				return nil, errors.New("bmc timeout")
+			}
+			return []byte(outputs[sensorType]), nil
+		},
+	}
+
+	sensors := collector.Collect(context.Background())
+	assert.Equal(t, []string{"-c sdr type Fan", "-c sdr type Temperature", "-c sdr type Current"}, calls)
+	assert.Equal(t, []system.IPMISensor{
+		{Name: "FAN1", Type: "Fan", Value: 3600, Unit: "RPM", Status: "ok"},
+		{Name: "Inlet Temp", Type: "Temperature", Value: 23, Unit: "degrees C", Status: "ok"},
+		{Name: "Exhaust Temp", Type: "Temperature", Value: 35, Unit: "degrees C", Status: "ok"},
+		{Name: "Pwr Consumption", Type: "Current", Value: 140, Unit: "Watts", Status: "ok"},
+	}, sensors)
+
+	// failed sensor types are skipped
+	sensors = collector.Collect(context.Background())
+	assert.Len(t, sensors, 3)
+}
+
+func TestNewIPMICollector(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+
+	// disabled by default
+	assert.Nil(t, newIPMICollector())
+
+	// enabled but ipmitool missing
+	t.Setenv("BESZEL_ENABLE_IPMI", "true")
+	assert.Nil(t, newIPMICollector())
+
+	// enabled with ipmitool installed
+	script := "#!/bin/sh\necho \"FAN1,30h,ok,29.1,3600 RPM\"\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ipmitoolCmd), []byte(script), 0755))
+	collector := newIPMICollector()
+	require.NotNil(t, collector)
+	output, err := collector.run(context.Background(), "-c", "sdr", "type", "Fan")
+	require.NoError(t, err)
+	assert.Equal(t, "FAN1,30h,ok,29.1,3600 RPM\n", string(output))
+
+	var nilCollector *IPMICollector
+	assert.Nil(t, nilCollector.Collect(context.Background()))
+}
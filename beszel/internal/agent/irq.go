@@ -0,0 +1,169 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"bufio"
+	"cmp"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	procInterrupts = "/proc/interrupts"
+	// maximum number of interrupts reported, keeping those with the highest rates
+	maxIRQRates = 64
+	// maximum number of numbered interrupts with their CPU affinity reported
+	maxIRQAffinity = 20
+)
+
+// IRQCollector computes interrupt rates from /proc/interrupts
+type IRQCollector struct {
+	path    string
+	irqDir  string              // /proc/irq, next to the interrupts file
+	prev    map[string]uint64   // counts from the previous collection keyed by interrupt name
+	numbers map[string][]string // IRQ numbers of each named interrupt from the previous collection
+	time    time.Time           // time of the previous collection
+}
+
+// newIRQCollector returns a collector for the interrupts file at path, or nil
+// if it can't be read (e.g. not on Linux)
+func newIRQCollector(path string) *IRQCollector {
+	if _, err := os.Stat(path); err != nil {
+		slog.Debug("IRQ rates", "err", err)
+		return nil
+	}
+	return &IRQCollector{path: path, irqDir: filepath.Join(filepath.Dir(path), "irq")}
+}
+
+// Collect returns the interrupts per second of each interrupt since the previous
+// call, summed across CPUs. It returns nil on the first call.
+func (c *IRQCollector) Collect() map[string]float64 {
+	if c == nil {
+		return nil
+	}
+	file, err := os.Open(c.path)
+	if err != nil {
+		slog.Debug("IRQ rates", "err", err)
+		return nil
+	}
+	defer file.Close()
+	counts, numbers, err := parseInterrupts(file)
+	if err != nil {
+		slog.Debug("IRQ rates", "err", err)
+		return nil
+	}
+	c.numbers = numbers
+	return c.update(counts, time.Now())
+}
+
+// Affinity returns the CPUs that handle the numbered interrupts with the highest
+// rates, up to maxIRQAffinity, from /proc/irq/<N>/smp_affinity_list
+func (c *IRQCollector) Affinity(rates map[string]float64) []system.IRQAffinityEntry {
+	if c == nil {
+		return nil
+	}
+	var entries []system.IRQAffinityEntry
+	for _, name := range sortedByRate(rates) {
+		for _, irq := range c.numbers[name] {
+			if len(entries) == maxIRQAffinity {
+				return entries
+			}
+			cpus, err := os.ReadFile(filepath.Join(c.irqDir, irq, "smp_affinity_list"))
+			if err != nil {
+				continue
+			}
+			entries = append(entries, system.IRQAffinityEntry{
+				IRQ:     irq,
+				Name:    name,
+				CPUMask: strings.TrimSpace(string(cpus)),
+			})
+		}
+	}
+	return entries
+}
+
+// sortedByRate returns the interrupt names in rates, highest rate first
+func sortedByRate(rates map[string]float64) []string {
+	return slices.SortedFunc(maps.Keys(rates), func(a, b string) int {
+		return cmp.Or(cmp.Compare(rates[b], rates[a]), strings.Compare(a, b))
+	})
+}
+
+// update stores counts and returns the rates since the previous counts,
+// limited to the maxIRQRates highest
+func (c *IRQCollector) update(counts map[string]uint64, now time.Time) map[string]float64 {
+	prev, prevTime := c.prev, c.time
+	c.prev, c.time = counts, now
+	if prev == nil {
+		return nil
+	}
+	elapsed := now.Sub(prevTime).Seconds()
+	if elapsed <= 0 {
+		return nil
+	}
+	rates := make(map[string]float64, len(counts))
+	for name, count := range counts {
+		// skip new interrupts and counters that went backwards (e.g. device removed and re-added)
+		if prevCount, ok := prev[name]; ok && count >= prevCount {
+			rates[name] = twoDecimals(float64(count-prevCount) / elapsed)
+		}
+	}
+	if len(rates) > maxIRQRates {
+		for _, name := range sortedByRate(rates)[maxIRQRates:] {
+			delete(rates, name)
+		}
+	}
+	return rates
+}
+
+// parseInterrupts returns the count of each interrupt in /proc/interrupts summed
+// across CPUs, and the IRQ numbers of each numbered interrupt. Numbered
+// interrupts are keyed by device name (e.g. "eth0") and architecture interrupts
+// by their label (e.g. "LOC"). Interrupts with the same name are added together.
+//
+//	           CPU0       CPU1
+//	  0:         44          0   IO-APIC   2-edge      timer
+//	 24:     583911     102934   PCI-MSI 524288-edge      eth0
+//	LOC:    4893248    4711057   Local timer interrupts
+func parseInterrupts(r io.Reader) (counts map[string]uint64, numbers map[string][]string, err error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		return nil, nil, scanner.Err()
+	}
+	numCPUs := len(strings.Fields(scanner.Text()))
+	counts = make(map[string]uint64)
+	numbers = make(map[string][]string)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		label := strings.TrimSuffix(fields[0], ":")
+		var total uint64
+		i := 1
+		for ; i < len(fields) && i <= numCPUs; i++ {
+			count, err := strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				break
+			}
+			total += count
+		}
+		name := label
+		// numbered interrupts have the chip and hardware IRQ before the device name
+		if rest := fields[i:]; len(rest) >= 2 {
+			if _, err := strconv.Atoi(label); err == nil {
+				name = rest[len(rest)-1]
+				numbers[name] = append(numbers[name], label)
+			}
+		}
+		counts[name] += total
+	}
+	return counts, numbers, scanner.Err()
+}
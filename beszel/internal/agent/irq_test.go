@@ -0,0 +1,169 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// /proc/interrupts with 10 interrupts across 4 CPUs
+const interruptsFixture = `           CPU0       CPU1       CPU2       CPU3
+  0:         44          0          0          0   IO-APIC   2-edge      timer
+  8:          0          0          1          0   IO-APIC   8-edge      rtc0
+  9:          0         12          0          0   IO-APIC   9-fasteoi   acpi
+ 24:     583911     102934       4021        117   PCI-MSI 524288-edge      eth0
+ 25:       1200       3400       5600       7800   PCI-MSI 327680-edge      nvme0q0
+ 26:          0          0          0          0   PCI-MSI 1048576-edge      eth1
+NMI:          5          5          5          5   Non-maskable interrupts
+LOC:    4893248    4711057    4698873    4721005   Local timer interrupts
+RES:      21345      18790      17654      16001   Rescheduling interrupts
+ERR:          0
+`
+
+// the fixture 10 seconds later
+const interruptsFixtureLater = `           CPU0       CPU1       CPU2       CPU3
+  0:         44          0          0          0   IO-APIC   2-edge      timer
+  8:          0          0          1          0   IO-APIC   8-edge      rtc0
+  9:          0         22          0          0   IO-APIC   9-fasteoi   acpi
+ 24:     683911     112934       4021        122   PCI-MSI 524288-edge      eth0
+ 25:       1300       3400       5600       7800   PCI-MSI 327680-edge      nvme0q0
+ 26:          0          0          0          0   PCI-MSI 1048576-edge      eth1
+NMI:          5          5          5          5   Non-maskable interrupts
+LOC:    4903248    4721057    4708873    4731005   Local timer interrupts
+RES:      21355      18790      17654      16001   Rescheduling interrupts
+ERR:          0
+`
+
+func TestParseInterrupts(t *testing.T) {
+	counts, numbers, err := parseInterrupts(strings.NewReader(interruptsFixture))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint64{
+		"timer":   44,
+		"rtc0":    1,
+		"acpi":    12,
+		"eth0":    583911 + 102934 + 4021 + 117,
+		"nvme0q0": 1200 + 3400 + 5600 + 7800,
+		"eth1":    0,
+		"NMI":     20,
+		"LOC":     4893248 + 4711057 + 4698873 + 4721005,
+		"RES":     21345 + 18790 + 17654 + 16001,
+		"ERR":     0,
+	}, counts)
+	assert.Equal(t, map[string][]string{
+		"timer":   {"0"},
+		"rtc0":    {"8"},
+		"acpi":    {"9"},
+		"eth0":    {"24"},
+		"nvme0q0": {"25"},
+		"eth1":    {"26"},
+	}, numbers, "architecture interrupts have no number")
+}
+
+func TestIRQCollector(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "interrupts")
+	require.NoError(t, os.WriteFile(path, []byte(interruptsFixture), 0644))
+	c := newIRQCollector(path)
+	require.NotNil(t, c)
+	assert.Nil(t, c.Collect(), "first collection has no previous counts")
+
+	// move the previous collection 10 seconds back
+	c.time = time.Now().Add(-10 * time.Second)
+	require.NoError(t, os.WriteFile(path, []byte(interruptsFixtureLater), 0644))
+	rates := c.Collect()
+	require.Len(t, rates, 10)
+	// the elapsed time is slightly over 10 seconds, depending on how long the test takes
+	assert.InEpsilon(t, 11_000.5, rates["eth0"], 0.01)
+	assert.InEpsilon(t, 4_000.0, rates["LOC"], 0.01)
+	assert.InEpsilon(t, 10.0, rates["nvme0q0"], 0.01)
+	assert.InEpsilon(t, 1.0, rates["acpi"], 0.01)
+	assert.InEpsilon(t, 1.0, rates["RES"], 0.01)
+	assert.Zero(t, rates["timer"])
+	assert.Zero(t, rates["eth1"])
+
+	t.Run("missing file", func(t *testing.T) {
+		c := newIRQCollector(filepath.Join(t.TempDir(), "missing"))
+		assert.Nil(t, c)
+		assert.Nil(t, c.Collect())
+	})
+}
+
+func TestIRQCollectorUpdate(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	c := &IRQCollector{}
+	assert.Nil(t, c.update(map[string]uint64{"eth0": 100, "nvme0q0": 50}, start))
+
+	rates := c.update(map[string]uint64{"eth0": 300, "nvme0q0": 40, "eth1": 10}, start.Add(4*time.Second))
+	assert.Equal(t, map[string]float64{"eth0": 50}, rates, "new and reset counters are skipped")
+
+	t.Run("limits to highest rates", func(t *testing.T) {
+		prev := make(map[string]uint64)
+		cur := make(map[string]uint64)
+		for i := range 100 {
+			name := fmt.Sprintf("irq%d", i)
+			prev[name] = 0
+			cur[name] = uint64(i)
+		}
+		c := &IRQCollector{}
+		c.update(prev, start)
+		rates := c.update(cur, start.Add(time.Second))
+		require.Len(t, rates, maxIRQRates)
+		assert.Contains(t, rates, "irq99")
+		assert.Contains(t, rates, "irq36")
+		assert.NotContains(t, rates, "irq35")
+	})
+}
+
+func TestIRQAffinity(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "interrupts")
+	require.NoError(t, os.WriteFile(path, []byte(interruptsFixture), 0644))
+	for irq, cpus := range map[string]string{"0": "0", "9": "1", "24": "0-3", "25": "2,6\n"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "irq", irq), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "irq", irq, "smp_affinity_list"), []byte(cpus), 0644))
+	}
+	c := newIRQCollector(path)
+	require.NotNil(t, c)
+	assert.Equal(t, filepath.Join(dir, "irq"), c.irqDir)
+	c.Collect()
+	c.time = time.Now().Add(-10 * time.Second)
+	require.NoError(t, os.WriteFile(path, []byte(interruptsFixtureLater), 0644))
+	rates := c.Collect()
+
+	// highest rate first, skipping LOC and RES which have no number and eth1 which has no affinity file
+	assert.Equal(t, []system.IRQAffinityEntry{
+		{IRQ: "24", Name: "eth0", CPUMask: "0-3"},
+		{IRQ: "25", Name: "nvme0q0", CPUMask: "2,6"},
+		{IRQ: "9", Name: "acpi", CPUMask: "1"},
+		{IRQ: "0", Name: "timer", CPUMask: "0"},
+	}, c.Affinity(rates))
+
+	t.Run("limited to the busiest", func(t *testing.T) {
+		c := &IRQCollector{irqDir: filepath.Join(t.TempDir(), "irq"), numbers: make(map[string][]string)}
+		rates := make(map[string]float64)
+		for i := range 30 {
+			irq := strconv.Itoa(i + 100)
+			name := "eth0-rx-" + strconv.Itoa(i)
+			c.numbers[name] = []string{irq}
+			rates[name] = float64(i)
+			require.NoError(t, os.MkdirAll(filepath.Join(c.irqDir, irq), 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(c.irqDir, irq, "smp_affinity_list"), []byte(strconv.Itoa(i%8)), 0644))
+		}
+		entries := c.Affinity(rates)
+		require.Len(t, entries, maxIRQAffinity)
+		assert.Equal(t, "eth0-rx-29", entries[0].Name)
+		assert.Equal(t, "eth0-rx-10", entries[maxIRQAffinity-1].Name)
+	})
+
+	assert.Nil(t, (*IRQCollector)(nil).Affinity(rates))
+}
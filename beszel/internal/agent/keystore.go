@@ -0,0 +1,75 @@
+package agent
+
+import (
+	"crypto/subtle"
+	"sync"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// SecureKeyStore holds public keys in SSH wire format so they can be zeroed by
+// Close when no longer needed, rather than staying in memory (and core dumps)
+// until the garbage collector reuses it. The wipe is best-effort: it only covers
+// the store's own copies. The parsed keys it was created from, e.g.
+// ServerOptions.Keys, and copies made by the SSH library while authenticating
+// are not wiped, since gossh.PublicKey values can't be zeroed.
+type SecureKeyStore struct {
+	mu   sync.RWMutex
+	keys [][]byte
+}
+
+// NewSecureKeyStore returns a store holding copies of keys
+func NewSecureKeyStore(keys []gossh.PublicKey) *SecureKeyStore {
+	s := &SecureKeyStore{keys: make([][]byte, 0, len(keys))}
+	for _, key := range keys {
+		s.keys = append(s.keys, key.Marshal())
+	}
+	return s
+}
+
+// Contains returns true if key is in the store. It always returns false
+// after Close.
+func (s *SecureKeyStore) Contains(key gossh.PublicKey) bool {
+	if s == nil {
+		return false
+	}
+	marshaled := key.Marshal()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, stored := range s.keys {
+		if subtle.ConstantTimeCompare(stored, marshaled) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of keys in the store
+func (s *SecureKeyStore) Len() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.keys)
+}
+
+// Close zeroes the keys and empties the store. It is safe to call more than once.
+func (s *SecureKeyStore) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.wipe()
+	return nil
+}
+
+// wipe zeroes the key bytes and drops them. Callers must hold the write lock.
+func (s *SecureKeyStore) wipe() {
+	for _, key := range s.keys {
+		clear(key)
+	}
+	clear(s.keys)
+	s.keys = nil
+}
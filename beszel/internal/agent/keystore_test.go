@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestPublicKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pubKey, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	sshPubKey, err := ssh.NewPublicKey(pubKey)
+	require.NoError(t, err)
+	return sshPubKey
+}
+
+func TestSecureKeyStore(t *testing.T) {
+	key, otherKey := newTestPublicKey(t), newTestPublicKey(t)
+	store := NewSecureKeyStore([]ssh.PublicKey{key})
+	assert.Equal(t, 1, store.Len())
+	assert.True(t, store.Contains(key))
+	assert.False(t, store.Contains(otherKey))
+
+	// a key parsed again from its authorized_keys line matches
+	parsed, err := ParseKeys(string(ssh.MarshalAuthorizedKey(key)))
+	require.NoError(t, err)
+	assert.True(t, store.Contains(parsed[0]))
+
+	var nilStore *SecureKeyStore
+	assert.False(t, nilStore.Contains(key))
+	assert.NoError(t, nilStore.Close())
+}
+
+func TestSecureKeyStoreClose(t *testing.T) {
+	keys := []ssh.PublicKey{newTestPublicKey(t), newTestPublicKey(t)}
+	store := NewSecureKeyStore(keys)
+	// keep the key slices, the store drops them on close
+	stored := slices.Clone(store.keys)
+	require.Len(t, stored, 2)
+	assert.Equal(t, keys[0].Marshal(), stored[0])
+
+	require.NoError(t, store.Close())
+	for _, key := range stored {
+		assert.NotEmpty(t, key)
+		assert.Equal(t, make([]byte, len(key)), key, "key bytes should be zeroed")
+	}
+	assert.Zero(t, store.Len())
+	assert.False(t, store.Contains(keys[0]))
+	assert.NoError(t, store.Close(), "closing twice should not fail")
+}
+
+func TestReloadKeysWipesReplacedKeys(t *testing.T) {
+	oldStore := NewSecureKeyStore([]ssh.PublicKey{newTestPublicKey(t)})
+	stored := oldStore.keys[0]
+	agent := &Agent{}
+	agent.keys.Store(&keySet{current: oldStore})
+
+	agent.ReloadKeys([]ssh.PublicKey{newTestPublicKey(t)})
+	assert.Equal(t, make([]byte, len(stored)), stored)
+	assert.Zero(t, oldStore.Len())
+
+	current := agent.keys.Load().current
+	require.NoError(t, agent.Shutdown(context.Background()))
+	assert.Zero(t, current.Len(), "shutdown should wipe the current keys")
+}
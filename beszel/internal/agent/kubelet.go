@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	defaultKubeletAddr = "https://localhost:10250"
+	kubeletTimeout     = 4 * time.Second
+	// service account credentials mounted into pods
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// KubeletCollector reads pod CPU and memory usage from the kubelet summary API
+type KubeletCollector struct {
+	addr     string
+	token    string
+	client   *http.Client
+	numCores int
+}
+
+// kubeletSummary is the subset of the kubelet /stats/summary response used by the agent
+type kubeletSummary struct {
+	Pods []struct {
+		PodRef struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"podRef"`
+		CPU *struct {
+			UsageNanoCores uint64 `json:"usageNanoCores"`
+		} `json:"cpu"`
+		Memory *struct {
+			WorkingSetBytes uint64 `json:"workingSetBytes"`
+		} `json:"memory"`
+	} `json:"pods"`
+}
+
+// newKubeletCollector returns a collector if BESZEL_KUBELET_ADDR or BESZEL_KUBELET_TOKEN
+// is set, or nil otherwise. The token defaults to the pod's service account token.
+func newKubeletCollector() *KubeletCollector {
+	addr, addrSet := GetEnvFallback("BESZEL_AGENT_KUBELET_ADDR", "BESZEL_KUBELET_ADDR")
+	token, tokenSet := GetEnvFallback("BESZEL_AGENT_KUBELET_TOKEN", "BESZEL_KUBELET_TOKEN")
+	if !addrSet && !tokenSet {
+		return nil
+	}
+	if addr == "" {
+		addr = defaultKubeletAddr
+	}
+	if token == "" {
+		if content, err := os.ReadFile(serviceAccountDir + "/token"); err == nil {
+			token = strings.TrimSpace(string(content))
+		}
+	}
+
+	tlsConfig := &tls.Config{}
+	// kubelet serving certificates are often self-signed
+	if insecure, _ := GetEnvFallback("BESZEL_AGENT_KUBELET_INSECURE_SKIP_VERIFY", "BESZEL_KUBELET_INSECURE_SKIP_VERIFY"); insecure == "true" {
+		tlsConfig.InsecureSkipVerify = true
+	} else if ca, err := os.ReadFile(serviceAccountDir + "/ca.crt"); err == nil {
+		pool, _ := x509.SystemCertPool()
+		if pool == nil {
+			pool = x509.NewCertPool()
+		}
+		pool.AppendCertsFromPEM(ca)
+		tlsConfig.RootCAs = pool
+	}
+
+	slog.Info("Kubelet", "addr", addr)
+	return &KubeletCollector{
+		addr:  strings.TrimSuffix(addr, "/"),
+		token: token,
+		client: &http.Client{
+			Timeout:   kubeletTimeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+		numCores: runtime.NumCPU(),
+	}
+}
+
+// Collect returns the CPU and memory usage of each pod on the node
+func (c *KubeletCollector) Collect(ctx context.Context) ([]system.KubePodStat, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.addr+"/stats/summary", nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kubelet returned %s", resp.Status)
+	}
+
+	var summary kubeletSummary
+	if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		return nil, err
+	}
+
+	pods := make([]system.KubePodStat, 0, len(summary.Pods))
+	for _, pod := range summary.Pods {
+		stat := system.KubePodStat{
+			Name:      pod.PodRef.Name,
+			Namespace: pod.PodRef.Namespace,
+		}
+		// percent of total host CPU, like container stats
+		if pod.CPU != nil && c.numCores > 0 {
+			stat.Cpu = twoDecimals(float64(pod.CPU.UsageNanoCores) / float64(c.numCores) / 1e7)
+		}
+		if pod.Memory != nil {
+			stat.Mem = bytesToMegabytes(float64(pod.Memory.WorkingSetBytes))
+		}
+		pods = append(pods, stat)
+	}
+	return pods, nil
+}
@@ -0,0 +1,82 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const kubeletSummaryFixture = `{
+	"node": {"nodeName": "node-1"},
+	"pods": [
+		{
+			"podRef": {"name": "nginx-7d9c", "namespace": "default", "uid": "a1"},
+			"cpu": {"time": "2025-01-01T00:00:00Z", "usageNanoCores": 500000000},
+			"memory": {"time": "2025-01-01T00:00:00Z", "workingSetBytes": 52428800, "usageBytes": 60000000}
+		},
+		{
+			"podRef": {"name": "coredns-5d78", "namespace": "kube-system", "uid": "b2"}
+		}
+	]
+}`
+
+func TestKubeletCollector(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stats/summary" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(kubeletSummaryFixture))
+	}))
+	defer server.Close()
+
+	collector := &KubeletCollector{
+		addr:     server.URL,
+		token:    "test-token",
+		client:   server.Client(),
+		numCores: 4,
+	}
+	pods, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []system.KubePodStat{
+		{Name: "nginx-7d9c", Namespace: "default", Cpu: 12.5, Mem: 50},
+		{Name: "coredns-5d78", Namespace: "kube-system"},
+	}, pods)
+
+	collector.token = "wrong-token"
+	_, err = collector.Collect(context.Background())
+	assert.ErrorContains(t, err, "401")
+}
+
+func TestNewKubeletCollector(t *testing.T) {
+	assert.Nil(t, newKubeletCollector())
+
+	t.Setenv("BESZEL_KUBELET_TOKEN", "secret")
+	collector := newKubeletCollector()
+	require.NotNil(t, collector)
+	assert.Equal(t, defaultKubeletAddr, collector.addr)
+	assert.Equal(t, "secret", collector.token)
+
+	t.Setenv("BESZEL_KUBELET_ADDR", "https://10.0.0.5:10250/")
+	collector = newKubeletCollector()
+	require.NotNil(t, collector)
+	assert.Equal(t, "https://10.0.0.5:10250", collector.addr)
+
+	// the BESZEL_AGENT_ prefix takes precedence
+	t.Setenv("BESZEL_AGENT_KUBELET_ADDR", "https://10.0.0.6:10250")
+	collector = newKubeletCollector()
+	require.NotNil(t, collector)
+	assert.Equal(t, "https://10.0.0.6:10250", collector.addr)
+}
@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// key of the Mali collector, which reads sysfs instead of running a tool
+	maliCollectorName = "mali"
+	maliInterval      = 4 * time.Second
+	// prefix of the device tree compatible strings of Mali GPUs, e.g. "arm,mali-bifrost"
+	maliCompatiblePrefix = "arm,mali"
+)
+
+// Mali files relative to maliRoot
+var (
+	maliRoot        = "/"
+	maliDevfreqPath = "sys/class/devfreq"
+	maliThermalPath = "sys/class/thermal"
+)
+
+// MaliCollector reads the clock and utilisation of an ARM Mali GPU from its
+// devfreq device, for SoCs such as MediaTek Dimensity that have no GPU tool
+type MaliCollector struct {
+	name            string // e.g. "Mali Valhall", from the device tree
+	freqPath        string
+	maxFreqPath     string
+	utilisationPath string // empty if the driver doesn't report utilisation
+	tempPath        string // temp of the GPU thermal zone, empty if there is none
+}
+
+// newMaliCollector returns a collector for the Mali GPU under root, or nil if
+// there is none
+func newMaliCollector(root string) *MaliCollector {
+	devfreq, compatible := findMaliDevfreq(filepath.Join(root, maliDevfreqPath))
+	if devfreq == "" {
+		return nil
+	}
+	c := &MaliCollector{
+		name:        maliName(compatible),
+		freqPath:    filepath.Join(devfreq, "cur_freq"),
+		maxFreqPath: filepath.Join(devfreq, "max_freq"),
+		tempPath:    findThermalZone(filepath.Join(root, maliThermalPath), rockchipThermalZone),
+	}
+	// utilisation is reported by the Arm kbase driver, in the GPU device or its mali0 child
+	for _, path := range []string{"device/utilisation", "device/mali0/utilisation"} {
+		if _, err := os.Stat(filepath.Join(devfreq, path)); err == nil {
+			c.utilisationPath = filepath.Join(devfreq, path)
+			break
+		}
+	}
+	return c
+}
+
+// detectMali returns true if a devfreq device is a Mali GPU
+func detectMali() bool {
+	devfreq, _ := findMaliDevfreq(filepath.Join(maliRoot, maliDevfreqPath))
+	return devfreq != ""
+}
+
+// findMaliDevfreq returns the devfreq directory of the first Mali GPU and its
+// compatible string, or empty strings if there is none
+func findMaliDevfreq(devfreqPath string) (string, string) {
+	devices, _ := filepath.Glob(filepath.Join(devfreqPath, "*"))
+	for _, device := range devices {
+		compatible, err := os.ReadFile(filepath.Join(device, "device", "of_node", "compatible"))
+		if err != nil {
+			continue
+		}
+		// device tree string lists are null separated
+		for value := range strings.SplitSeq(string(compatible), "\x00") {
+			if strings.HasPrefix(value, maliCompatiblePrefix) {
+				return device, value
+			}
+		}
+	}
+	return "", ""
+}
+
+// maliName returns the GPU name for a compatible string, e.g. "Mali Bifrost" for
+// "arm,mali-bifrost". The model number is not in the device tree.
+func maliName(compatible string) string {
+	family, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(compatible, maliCompatiblePrefix), "-"), "-")
+	if family == "" {
+		return "Mali"
+	}
+	return "Mali " + strings.ToUpper(family[:1]) + family[1:]
+}
+
+// read returns a sample of the Mali files as "name value" lines for the parser
+// from getMaliParser. Only the current clock is required.
+func (c *MaliCollector) read() ([]byte, error) {
+	freq, err := os.ReadFile(c.freqPath)
+	if err != nil {
+		return nil, err
+	}
+	var sample bytes.Buffer
+	fmt.Fprintf(&sample, "freq %s\n", bytes.TrimSpace(freq))
+	for name, path := range map[string]string{"max_freq": c.maxFreqPath, "utilisation": c.utilisationPath, "temp": c.tempPath} {
+		if path == "" {
+			continue
+		}
+		if value, err := os.ReadFile(path); err == nil {
+			fmt.Fprintf(&sample, "%s %s\n", name, bytes.TrimSpace(value))
+		}
+	}
+	return sample.Bytes(), nil
+}
+
+// getMaliParser returns a function to parse the samples of a MaliCollector and
+// update the GPUData map. Usage is zero if the driver doesn't report utilisation.
+func (gm *GPUManager) getMaliParser(name string) func(output []byte) bool {
+	return func(output []byte) bool {
+		values := parseSysfsSample(output)
+		freq, err := strconv.ParseFloat(values["freq"], 64)
+		if err != nil {
+			gm.errorLog.add(maliCollectorName, output)
+			return false
+		}
+
+		gm.Lock()
+		defer gm.Unlock()
+		defer gm.publishSnapshot(gm.beginUpdate())
+		// SoCs have a single GPU
+		gpu, ok := gm.GpuDataMap["0"]
+		if !ok {
+			gpu = &system.GPUData{Name: name}
+			gm.GpuDataMap["0"] = gpu
+		}
+		now := time.Now()
+		gm.rollWindow("0", gpu, now)
+		gpu.LastUpdated = now
+		// devfreq reports clocks in Hz
+		gpu.Frequency = freq / 1e6
+		if maxFreq, err := strconv.ParseFloat(values["max_freq"], 64); err == nil {
+			gpu.MaxFrequency = maxFreq / 1e6
+		}
+		usage, _ := strconv.ParseFloat(strings.TrimSuffix(values["utilisation"], "%"), 64)
+		gm.addUsage("0", gpu, usage)
+		// millidegrees Celsius
+		if temp, err := strconv.ParseFloat(values["temp"], 64); err == nil {
+			gm.setTemperature(gpu, temp/1000)
+		}
+		gpu.Count++
+		return true
+	}
+}
@@ -0,0 +1,122 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// maliFixture is the sysfs of a MediaTek MT8192 board, with Cortex-A55 and
+// Cortex-A76 cores and a Mali-G57 GPU. The memory controller also uses devfreq.
+var maliFixture = map[string]string{
+	"sys/class/devfreq/10012000.dvfsrc/cur_freq":                  "3200000000\n",
+	"sys/class/devfreq/10012000.dvfsrc/device/of_node/compatible": "mediatek,mt8192-dvfsrc\x00",
+	"sys/class/devfreq/13000000.gpu/cur_freq":                     "700000000\n",
+	"sys/class/devfreq/13000000.gpu/max_freq":                     "950000000\n",
+	"sys/class/devfreq/13000000.gpu/device/of_node/compatible":    "mediatek,mt8192-mali\x00arm,mali-valhall-jm\x00",
+	"sys/class/devfreq/13000000.gpu/device/utilisation":           "62\n",
+	"sys/class/thermal/thermal_zone0/type":                        "cpu-big0-thermal\n",
+	"sys/class/thermal/thermal_zone0/temp":                        "51000\n",
+	"sys/class/thermal/thermal_zone6/type":                        "gpu-thermal\n",
+	"sys/class/thermal/thermal_zone6/temp":                        "44500\n",
+}
+
+func TestMaliCollector(t *testing.T) {
+	root := t.TempDir()
+	writeRockchipFiles(t, root, maliFixture)
+	c := newMaliCollector(root)
+	require.NotNil(t, c)
+	assert.Equal(t, "Mali Valhall", c.name)
+
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	parse := gm.getMaliParser(c.name)
+	for range 2 {
+		sample, err := c.read()
+		require.NoError(t, err)
+		require.True(t, parse(sample))
+	}
+	gpu := gm.GetCurrentData()["0"]
+	assert.Equal(t, "Mali Valhall", gpu.Name)
+	assert.Equal(t, 62.0, gpu.Usage)
+	assert.Equal(t, 700.0, gpu.Frequency)
+	assert.Equal(t, 950.0, gpu.MaxFrequency)
+	assert.Equal(t, 44.5, gpu.Temperature)
+
+	// utilisation is only reported by the Arm kbase driver
+	require.NoError(t, os.Remove(filepath.Join(root, "sys/class/devfreq/13000000.gpu/device/utilisation")))
+	c = newMaliCollector(root)
+	assert.Empty(t, c.utilisationPath)
+	gm = &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	parse = gm.getMaliParser(c.name)
+	sample, err := c.read()
+	require.NoError(t, err)
+	require.True(t, parse(sample))
+	gpu = gm.GetCurrentData()["0"]
+	assert.Zero(t, gpu.Usage)
+	assert.Equal(t, 700.0, gpu.Frequency)
+
+	assert.False(t, parse([]byte("utilisation 62\n")), "the clock is required")
+}
+
+func TestFindMaliDevfreq(t *testing.T) {
+	root := t.TempDir()
+	assert.Nil(t, newMaliCollector(root))
+
+	// memory controller only
+	writeRockchipFiles(t, root, map[string]string{
+		"sys/class/devfreq/10012000.dvfsrc/device/of_node/compatible": "mediatek,mt8192-dvfsrc\x00",
+	})
+	assert.Nil(t, newMaliCollector(root))
+
+	for compatible, name := range map[string]string{
+		"arm,mali-bifrost\x00":     "Mali Bifrost",
+		"arm,mali-valhall-csf\x00": "Mali Valhall",
+		"arm,mali-t860\x00":        "Mali T860",
+		"arm,mali\x00":             "Mali",
+	} {
+		writeRockchipFiles(t, root, map[string]string{
+			"sys/class/devfreq/ff9a0000.gpu/device/of_node/compatible": compatible,
+		})
+		c := newMaliCollector(root)
+		require.NotNil(t, c, compatible)
+		assert.Equal(t, name, c.name, compatible)
+		assert.Equal(t, filepath.Join(root, "sys/class/devfreq/ff9a0000.gpu/cur_freq"), c.freqPath)
+	}
+}
+
+func TestMaliCollectorStart(t *testing.T) {
+	root := t.TempDir()
+	writeRockchipFiles(t, root, maliFixture)
+	origRoot, origRockchipRoot := maliRoot, rockchipRoot
+	maliRoot, rockchipRoot = root, root
+	defer func() { maliRoot, rockchipRoot = origRoot, origRockchipRoot }()
+	t.Setenv("PATH", "")
+
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	require.NoError(t, gm.detectGPUs())
+	assert.True(t, gm.mali)
+	gm.ctx, gm.cancel = context.WithCancel(context.Background())
+	gm.startCollectors()
+	defer gm.Stop(context.Background())
+	require.Eventually(t, func() bool {
+		gm.Lock()
+		defer gm.Unlock()
+		return len(gm.GpuDataMap) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "Mali Valhall", gm.GetCurrentData()["0"].Name)
+
+	// Rockchip GPUs are read by the Rockchip collector instead
+	writeRockchipFiles(t, root, map[string]string{rockchipUtilisationPath: "45\n"})
+	require.NoError(t, gm.detectGPUs())
+	assert.True(t, gm.rockchip)
+	assert.False(t, gm.mali)
+}
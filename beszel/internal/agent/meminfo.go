@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"bufio"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const procMeminfo = "/proc/meminfo"
+
+// collectMeminfo sets memory mapped, shared, and page cache usage from /proc/meminfo
+func collectMeminfo(systemStats *system.Stats) {
+	file, err := os.Open(procMeminfo)
+	if err != nil {
+		// not on Linux
+		return
+	}
+	defer file.Close()
+	if err := setMeminfoStats(systemStats, file); err != nil {
+		slog.Debug("Meminfo", "err", err)
+	}
+}
+
+// setMeminfoStats sets the Mapped and Shmem values of /proc/meminfo, and the page
+// cache that can be reclaimed, which is Buffers and Cached without Shmem since
+// shared memory is counted as cache but can't be dropped
+//
+//	Buffers:          412360 kB
+//	Cached:          9317424 kB
+//	Mapped:          1183840 kB
+//	Shmem:            623512 kB
+func setMeminfoStats(systemStats *system.Stats, r io.Reader) error {
+	values := make(map[string]uint64, 4)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// values are in kB, apart from page counts which are not used
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch key := strings.TrimSuffix(fields[0], ":"); key {
+		case "Buffers", "Cached", "Mapped", "Shmem":
+			value, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return err
+			}
+			values[key] = value * 1024
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	systemStats.MappedMemoryMB = bytesToMegabytes(float64(values["Mapped"]))
+	systemStats.SharedMemoryMB = bytesToMegabytes(float64(values["Shmem"]))
+	pageCache := values["Buffers"] + values["Cached"]
+	systemStats.PageCacheMB = bytesToMegabytes(float64(pageCache - min(values["Shmem"], pageCache)))
+	return nil
+}
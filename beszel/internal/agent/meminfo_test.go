@@ -0,0 +1,52 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const meminfoFixture = `MemTotal:       32803468 kB
+MemFree:        14211084 kB
+MemAvailable:   23840112 kB
+Buffers:          412360 kB
+Cached:          9317424 kB
+SwapCached:            0 kB
+Active:          6120836 kB
+Inactive:        9884612 kB
+Shmem:            623512 kB
+KReclaimable:     610344 kB
+Slab:             902528 kB
+SReclaimable:     610344 kB
+Mapped:          1183840 kB
+HugePages_Total:       0
+HugePages_Free:        0
+Hugepagesize:       2048 kB
+`
+
+func TestSetMeminfoStats(t *testing.T) {
+	var stats system.Stats
+	require.NoError(t, setMeminfoStats(&stats, strings.NewReader(meminfoFixture)))
+	assert.Equal(t, 1156.09, stats.MappedMemoryMB)
+	assert.Equal(t, 608.9, stats.SharedMemoryMB)
+	// (412360 + 9317424 - 623512) kB
+	assert.Equal(t, 8892.84, stats.PageCacheMB)
+
+	t.Run("missing fields", func(t *testing.T) {
+		var stats system.Stats
+		require.NoError(t, setMeminfoStats(&stats, strings.NewReader("MemTotal:       32803468 kB\n")))
+		assert.Zero(t, stats.MappedMemoryMB)
+		assert.Zero(t, stats.PageCacheMB)
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		var stats system.Stats
+		assert.Error(t, setMeminfoStats(&stats, strings.NewReader("Mapped:   x kB\n")))
+	})
+}
@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"sync"
+	"time"
+)
+
+// number of latency samples kept per subsystem
+const latencySamples = 60
+
+// collectionMetrics tracks how long each stats subsystem takes to collect
+type collectionMetrics struct {
+	stats sync.Map // map[string]*collectionStats
+}
+
+// collectionStats is a ring buffer of the most recent collection latencies
+type collectionStats struct {
+	sync.Mutex
+	samples [latencySamples]time.Duration
+	next    int // index of the next sample to write
+	count   int // number of samples written, up to latencySamples
+}
+
+// RecordLatency stores the collection latency for a subsystem
+func (m *collectionMetrics) RecordLatency(subsystem string, d time.Duration) {
+	value, _ := m.stats.LoadOrStore(subsystem, &collectionStats{})
+	stats := value.(*collectionStats)
+	stats.Lock()
+	defer stats.Unlock()
+	stats.samples[stats.next] = d
+	stats.next = (stats.next + 1) % latencySamples
+	stats.count = min(stats.count+1, latencySamples)
+}
+
+// GetLatencies returns the recorded latencies for each subsystem, oldest first
+func (m *collectionMetrics) GetLatencies() map[string][]time.Duration {
+	latencies := make(map[string][]time.Duration)
+	m.stats.Range(func(key, value any) bool {
+		stats := value.(*collectionStats)
+		stats.Lock()
+		defer stats.Unlock()
+		samples := make([]time.Duration, 0, stats.count)
+		start := (stats.next - stats.count + latencySamples) % latencySamples
+		for i := range stats.count {
+			samples = append(samples, stats.samples[(start+i)%latencySamples])
+		}
+		latencies[key.(string)] = samples
+		return true
+	})
+	return latencies
+}
+
+// track records the time elapsed until the returned function is called
+func (m *collectionMetrics) track(subsystem string) func() {
+	start := time.Now()
+	return func() {
+		m.RecordLatency(subsystem, time.Since(start))
+	}
+}
@@ -0,0 +1,165 @@
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCollectionMetrics(t *testing.T) {
+	t.Run("records latencies oldest first", func(t *testing.T) {
+		var m collectionMetrics
+		m.RecordLatency("cpu", 1*time.Millisecond)
+		m.RecordLatency("cpu", 2*time.Millisecond)
+		m.RecordLatency("disk", 5*time.Millisecond)
+
+		latencies := m.GetLatencies()
+		assert.Equal(t, []time.Duration{1 * time.Millisecond, 2 * time.Millisecond}, latencies["cpu"])
+		assert.Equal(t, []time.Duration{5 * time.Millisecond}, latencies["disk"])
+	})
+
+	t.Run("keeps only the most recent samples", func(t *testing.T) {
+		var m collectionMetrics
+		for i := range latencySamples + 10 {
+			m.RecordLatency("cpu", time.Duration(i))
+		}
+
+		latencies := m.GetLatencies()["cpu"]
+		require.Len(t, latencies, latencySamples)
+		assert.Equal(t, time.Duration(10), latencies[0])
+		assert.Equal(t, time.Duration(latencySamples+9), latencies[latencySamples-1])
+	})
+
+	t.Run("track records elapsed time", func(t *testing.T) {
+		var m collectionMetrics
+		done := m.track("network")
+		time.Sleep(5 * time.Millisecond)
+		done()
+
+		latencies := m.GetLatencies()["network"]
+		require.Len(t, latencies, 1)
+		assert.GreaterOrEqual(t, latencies[0], 5*time.Millisecond)
+	})
+}
+
+func TestDiagnosticsCommand(t *testing.T) {
+	_, privKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(privKey)
+	require.NoError(t, err)
+
+	agent := NewAgent()
+	agent.metrics.RecordLatency("cpu", 3*time.Millisecond)
+
+	addr := "127.0.0.1:45995"
+	go agent.StartServer(ServerOptions{
+		Network: "tcp",
+		Addr:    addr,
+		Keys:    []ssh.PublicKey{signer.PublicKey()},
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "a",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         4 * time.Second,
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+
+	output, err := session.Output(diagnosticsCommand)
+	require.NoError(t, err)
+
+	var diagnostics Diagnostics
+	require.NoError(t, json.Unmarshal(output, &diagnostics))
+	assert.Equal(t, []time.Duration{3 * time.Millisecond}, diagnostics.Latencies["cpu"])
+	assert.Greater(t, diagnostics.Runtime.Goroutines, 0)
+	assert.Greater(t, diagnostics.Runtime.HeapAlloc, uint64(0))
+	assert.WithinDuration(t, agent.startTime, diagnostics.Agent.StartTime, 0)
+}
+
+func TestCollectionCycles(t *testing.T) {
+	agent := NewAgent()
+	t.Cleanup(func() { agent.Shutdown(context.Background()) })
+	assert.WithinDuration(t, time.Now(), agent.Diagnostics().StartTime, 5*time.Second)
+	cycles := agent.Diagnostics().CollectionCycles
+
+	gatherStats(t, agent, "session-1")
+	diagnostics := agent.Diagnostics()
+	assert.Equal(t, cycles+1, diagnostics.CollectionCycles)
+	assert.Greater(t, diagnostics.TotalCollectionTime, time.Duration(0))
+
+	// the primary session always collects
+	gatherStats(t, agent, "session-1")
+	assert.Equal(t, cycles+2, agent.Diagnostics().CollectionCycles)
+	assert.GreaterOrEqual(t, agent.Diagnostics().TotalCollectionTime, diagnostics.TotalCollectionTime)
+
+	// other sessions get the cached stats, which are not collections
+	gatherStats(t, agent, "session-2")
+	assert.Equal(t, cycles+2, agent.Diagnostics().CollectionCycles)
+}
+
+func TestRuntimeStats(t *testing.T) {
+	agent := &Agent{}
+	runtime.GC()
+	stats := agent.RuntimeStats()
+	assert.Greater(t, stats.Goroutines, 0)
+	assert.Greater(t, stats.HeapAlloc, uint64(0))
+	assert.Greater(t, stats.NumGC, uint32(0))
+	assert.Greater(t, stats.LastPauseNs, uint64(0))
+}
+
+func TestActiveConnections(t *testing.T) {
+	_, privKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(privKey)
+	require.NoError(t, err)
+
+	agent := NewAgent()
+	assert.Equal(t, int64(0), agent.ActiveConnections())
+
+	addr := "127.0.0.1:45997"
+	go agent.StartServer(ServerOptions{
+		Network: "tcp",
+		Addr:    addr,
+		Keys:    []ssh.PublicKey{signer.PublicKey()},
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "a",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         4 * time.Second,
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+
+	// the diagnostics are collected while the session is active
+	output, err := session.Output(diagnosticsCommand)
+	require.NoError(t, err)
+	var diagnostics Diagnostics
+	require.NoError(t, json.Unmarshal(output, &diagnostics))
+	assert.Equal(t, int64(1), diagnostics.ActiveConnections)
+
+	// the counter is decremented once the session exits
+	assert.Eventually(t, func() bool {
+		return agent.ActiveConnections() == 0
+	}, time.Second, 10*time.Millisecond)
+}
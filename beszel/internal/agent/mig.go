@@ -0,0 +1,223 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"bufio"
+	"bytes"
+	"context"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const migInterval = 5 * time.Second
+
+// MIG device lines in `nvidia-smi -L` output, which follow the line of their GPU, e.g.
+//
+//	GPU 0: NVIDIA A100-SXM4-40GB (UUID: GPU-5c89852c-d268-c3f3-1b07-005d5ae1dc3f)
+//	  MIG 3g.20gb     Device  0: (UUID: MIG-GPU-5c89852c-d268-c3f3-1b07-005d5ae1dc3f/1/0)
+var nvidiaMigDevicePattern = regexp.MustCompile(`^\s+MIG (\S+)\s+Device\s+(\d+):`)
+
+// nvidiaMigInstance is a row of the "MIG devices" table of `nvidia-smi`
+type nvidiaMigInstance struct {
+	gpu, gi, ci, device     string  // GPU index, GPU and compute instance ids, and MIG device index
+	memoryUsed, memoryTotal float64 // MiB
+}
+
+// MIGCollector polls the MIG instances of Nvidia GPUs in MIG mode and reports
+// each instance as a GPU with its own memory usage, keyed by "gpu/gi/ci". The
+// query fields of nvidia-smi only cover physical GPUs, and the utilization,
+// temperature and power of the instances are not reported by nvidia-smi at all.
+type MIGCollector struct {
+	gm   *GPUManager
+	run  func(ctx context.Context, args ...string) ([]byte, error)
+	gpus map[string]bool     // indexes of the GPUs in MIG mode
+	ids  map[string]struct{} // instances added to GpuDataMap, so removed ones are deleted
+}
+
+func newMIGCollector(gm *GPUManager, gpus []string) *MIGCollector {
+	c := &MIGCollector{
+		gm:   gm,
+		gpus: make(map[string]bool, len(gpus)),
+		run: func(ctx context.Context, args ...string) ([]byte, error) {
+			return newGPUCommandContext(ctx, nvidiaSmiCmd, args...).Output()
+		},
+	}
+	for _, index := range gpus {
+		c.gpus[index] = true
+	}
+	return c
+}
+
+// detectMIGMode returns the indexes of the Nvidia GPUs with MIG mode enabled
+func detectMIGMode() []string {
+	output, err := newGPUCommand(nvidiaSmiCmd, "--query-gpu=index,mig.mode.current", "--format=csv,noheader").Output()
+	if err != nil {
+		return nil
+	}
+	return parseNvidiaMigModes(output)
+}
+
+// parseNvidiaMigModes parses the output of
+// `nvidia-smi --query-gpu=index,mig.mode.current --format=csv,noheader` and
+// returns the indexes of the GPUs with MIG mode enabled. GPUs without MIG
+// support report [N/A].
+func parseNvidiaMigModes(output []byte) []string {
+	var gpus []string
+	for line := range strings.Lines(string(output)) {
+		index, mode, ok := strings.Cut(strings.TrimSpace(line), ",")
+		if ok && strings.TrimSpace(mode) == "Enabled" {
+			gpus = append(gpus, strings.TrimSpace(index))
+		}
+	}
+	return gpus
+}
+
+// start polls the MIG instances until ctx is cancelled
+func (c *MIGCollector) start(ctx context.Context) {
+	for {
+		// instances can be created and destroyed while the GPU is running, so
+		// their profiles are listed again with each poll
+		devices, err := c.run(ctx, "-L")
+		var table []byte
+		if err == nil {
+			table, err = c.run(ctx)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Debug("MIG", "err", err)
+		} else {
+			c.update(parseNvidiaMigDevices(devices), parseNvidiaMigInstances(table), time.Now())
+		}
+		if !sleepContext(ctx, migInterval) {
+			return
+		}
+	}
+}
+
+// parseNvidiaMigDevices parses the output of `nvidia-smi -L` and returns the
+// profile of each MIG device, e.g. "3g.20gb", keyed by GPU index and MIG device index
+func parseNvidiaMigDevices(output []byte) map[[2]string]string {
+	profiles := make(map[[2]string]string)
+	var gpuIndex string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if matches := nvidiaGpuListPattern.FindStringSubmatch(line); matches != nil {
+			gpuIndex = matches[1]
+			continue
+		}
+		if matches := nvidiaMigDevicePattern.FindStringSubmatch(line); matches != nil && gpuIndex != "" {
+			profiles[[2]string{gpuIndex, matches[2]}] = matches[1]
+		}
+	}
+	return profiles
+}
+
+// parseNvidiaMigInstances parses the "MIG devices" table of `nvidia-smi` output,
+// which has two lines per instance, e.g.
+//
+//	| GPU  GI  CI  MIG |                   Memory-Usage |        Vol|      Shared           |
+//	|      ID  ID  Dev |                     BAR1-Usage | SM     Unc| CE ENC DEC OFA JPG    |
+//	|==================+================================+===========+=======================|
+//	|  0    1   0   0  |            3862MiB / 20096MiB  | 42      0 |  3   0    2    0    0 |
+//	|                  |               2MiB / 32767MiB  |           |                       |
+//
+// Only the first line, with the ids and memory usage, is parsed.
+func parseNvidiaMigInstances(output []byte) []nvidiaMigInstance {
+	var instances []nvidiaMigInstance
+	inTable := false
+	for line := range strings.Lines(string(output)) {
+		if strings.Contains(line, "MIG devices:") {
+			inTable = true
+			continue
+		}
+		// the processes table follows and has the same id columns
+		if !inTable || strings.Contains(line, "Processes:") {
+			inTable = false
+			continue
+		}
+		cells := strings.Split(line, "|")
+		if len(cells) < 3 {
+			continue
+		}
+		ids := strings.Fields(cells[1])
+		if len(ids) != 4 || !allDigits(ids) {
+			continue
+		}
+		usedValue, totalValue, ok := strings.Cut(cells[2], "/")
+		if !ok {
+			continue
+		}
+		used, errUsed := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(usedValue), "MiB"), 64)
+		total, errTotal := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(totalValue), "MiB"), 64)
+		if errUsed != nil || errTotal != nil {
+			continue
+		}
+		instances = append(instances, nvidiaMigInstance{
+			gpu: ids[0], gi: ids[1], ci: ids[2], device: ids[3],
+			memoryUsed: used, memoryTotal: total,
+		})
+	}
+	return instances
+}
+
+// allDigits returns true if every value is a non-negative integer
+func allDigits(values []string) bool {
+	for _, value := range values {
+		if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// update sets the data of each MIG instance, removes instances that no longer
+// exist, and sets the number of instances on their GPUs. Instances are only
+// added once their GPU has been reported by nvidia-smi, since they are named after it.
+func (c *MIGCollector) update(profiles map[[2]string]string, instances []nvidiaMigInstance, now time.Time) {
+	gm := c.gm
+	gm.Lock()
+	defer gm.Unlock()
+	defer gm.publishSnapshot(gm.beginUpdate())
+	ids := make(map[string]struct{}, len(instances))
+	counts := make(map[string]int, len(c.gpus))
+	for _, instance := range instances {
+		parent, ok := gm.GpuDataMap[instance.gpu]
+		if !ok || !c.gpus[instance.gpu] {
+			continue
+		}
+		id := instance.gpu + "/" + instance.gi + "/" + instance.ci
+		ids[id] = struct{}{}
+		counts[instance.gpu]++
+		gpu, ok := gm.GpuDataMap[id]
+		if !ok {
+			// memory is the only value, so there is a single sample to average
+			gpu = &system.GPUData{Count: 1}
+			gm.GpuDataMap[id] = gpu
+		}
+		gpu.Name = parent.Name + " MIG"
+		if profile := profiles[[2]string{instance.gpu, instance.device}]; profile != "" {
+			gpu.Name += " " + profile
+		}
+		gpu.LastUpdated = now
+		gpu.MemoryUsed = instance.memoryUsed / mebibytesInAMegabyte
+		gpu.MemoryTotal = instance.memoryTotal / mebibytesInAMegabyte
+	}
+	for id := range c.ids {
+		if _, ok := ids[id]; !ok {
+			delete(gm.GpuDataMap, id)
+			delete(gm.aggregates, id)
+		}
+	}
+	c.ids = ids
+	for index := range c.gpus {
+		if parent, ok := gm.GpuDataMap[index]; ok {
+			parent.MIGInstances = counts[index]
+		}
+	}
+}
@@ -0,0 +1,154 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// `nvidia-smi -L` on an A100 node where GPU 0 is split into three MIG instances
+// and GPU 1 has MIG mode disabled
+const migListFixture = `GPU 0: NVIDIA A100-SXM4-40GB (UUID: GPU-5c89852c-d268-c3f3-1b07-005d5ae1dc3f)
+  MIG 3g.20gb     Device  0: (UUID: MIG-4f8a9c1e-7b2d-5e6f-8a9b-0c1d2e3f4a5b)
+  MIG 2g.10gb     Device  1: (UUID: MIG-9a1f2c3d-4e5f-5a6b-8c7d-0e1f2a3b4c5d)
+  MIG 1g.5gb      Device  2: (UUID: MIG-2b3c4d5e-6f7a-5b8c-9d0e-1f2a3b4c5d6e)
+GPU 1: NVIDIA A100-SXM4-40GB (UUID: GPU-0c9a7a1e-0e6f-5a3e-2d1c-2b0a9f0a5f11)
+`
+
+// `nvidia-smi` on the same node
+const migTableFixture = `Mon Oct 14 10:21:37 2024
++---------------------------------------------------------------------------------------+
+| NVIDIA-SMI 535.104.05             Driver Version: 535.104.05   CUDA Version: 12.2     |
+|-----------------------------------------+----------------------+----------------------+
+| GPU  Name                 Persistence-M | Bus-Id        Disp.A | Volatile Uncorr. ECC |
+| Fan  Temp   Perf          Pwr:Usage/Cap |         Memory-Usage | GPU-Util  Compute M. |
+|                                         |                      |               MIG M. |
+|=========================================+======================+======================|
+|   0  NVIDIA A100-SXM4-40GB          On  | 00000000:07:00.0 Off |                   On |
+| N/A   32C    P0              59W / 400W |   3900MiB / 40960MiB |     N/A      Default |
+|                                         |                      |              Enabled |
++-----------------------------------------+----------------------+----------------------+
+|   1  NVIDIA A100-SXM4-40GB          On  | 00000000:0F:00.0 Off |                    0 |
+| N/A   30C    P0              54W / 400W |      4MiB / 40960MiB |      0%      Default |
+|                                         |                      |             Disabled |
++-----------------------------------------+----------------------+----------------------+
+
++---------------------------------------------------------------------------------------+
+| MIG devices:                                                                          |
++------------------+--------------------------------+-----------+-----------------------+
+| GPU  GI  CI  MIG |                   Memory-Usage |        Vol|      Shared           |
+|      ID  ID  Dev |                     BAR1-Usage | SM     Unc| CE ENC DEC OFA JPG    |
+|                  |                                |        ECC|                       |
+|==================+================================+===========+=======================|
+|  0    1   0   0  |            3862MiB / 20096MiB  | 42      0 |  3   0    2    0    0 |
+|                  |               2MiB / 32767MiB  |           |                       |
++------------------+--------------------------------+-----------+-----------------------+
+|  0    5   0   1  |              25MiB /  9984MiB  | 28      0 |  2   0    1    0    0 |
+|                  |               0MiB / 16383MiB  |           |                       |
++------------------+--------------------------------+-----------+-----------------------+
+|  0   13   0   2  |              13MiB /  4864MiB  | 14      0 |  1   0    0    0    0 |
+|                  |               0MiB /  8191MiB  |           |                       |
++------------------+--------------------------------+-----------+-----------------------+
+
++---------------------------------------------------------------------------------------+
+| Processes:                                                                            |
+|  GPU   GI   CI        PID   Type   Process name                            GPU Memory |
+|        ID   ID                                                             Usage      |
+|=======================================================================================|
+|    0    1    0      41822      C   python                                     3840MiB |
++---------------------------------------------------------------------------------------+
+`
+
+func TestParseNvidiaMigModes(t *testing.T) {
+	output := []byte("0, Enabled\n1, Disabled\n2, Enabled\n3, [N/A]\n")
+	assert.Equal(t, []string{"0", "2"}, parseNvidiaMigModes(output))
+	assert.Empty(t, parseNvidiaMigModes([]byte("0, [N/A]\n")))
+}
+
+func TestParseNvidiaMigDevices(t *testing.T) {
+	assert.Equal(t, map[[2]string]string{
+		{"0", "0"}: "3g.20gb",
+		{"0", "1"}: "2g.10gb",
+		{"0", "2"}: "1g.5gb",
+	}, parseNvidiaMigDevices([]byte(migListFixture)))
+}
+
+func TestParseNvidiaMigInstances(t *testing.T) {
+	assert.Equal(t, []nvidiaMigInstance{
+		{gpu: "0", gi: "1", ci: "0", device: "0", memoryUsed: 3862, memoryTotal: 20096},
+		{gpu: "0", gi: "5", ci: "0", device: "1", memoryUsed: 25, memoryTotal: 9984},
+		{gpu: "0", gi: "13", ci: "0", device: "2", memoryUsed: 13, memoryTotal: 4864},
+	}, parseNvidiaMigInstances([]byte(migTableFixture)))
+	assert.Empty(t, parseNvidiaMigInstances(nil))
+}
+
+func TestDetectMIGMode(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	script := "#!/bin/sh\necho \"0, Disabled\"\necho \"1, Enabled\"\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "nvidia-smi"), []byte(script), 0755))
+	assert.Equal(t, []string{"1"}, detectMIGMode())
+}
+
+func TestMIGCollector(t *testing.T) {
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	// in MIG mode, utilization is N/A for the physical GPU
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA A100-SXM4-40GB, 32, 3900, 40960, [N/A], 59.00\n1, NVIDIA A100-SXM4-40GB, 30, 4, 40960, 0, 54.00")))
+
+	c := newMIGCollector(gm, []string{"0"})
+	c.update(parseNvidiaMigDevices([]byte(migListFixture)), parseNvidiaMigInstances([]byte(migTableFixture)), time.Now())
+
+	data := gm.GetCurrentData()
+	require.Len(t, data, 5)
+	assert.Equal(t, 3, data["0"].MIGInstances)
+	assert.Equal(t, 0, data["1"].MIGInstances)
+	instance := data["0/1/0"]
+	assert.Equal(t, "A100-SXM4-40GB MIG 3g.20gb", instance.Name)
+	assert.InDelta(t, 3862/mebibytesInAMegabyte, instance.MemoryUsed, 0.01)
+	assert.InDelta(t, 20096/mebibytesInAMegabyte, instance.MemoryTotal, 0.01)
+	assert.Equal(t, "A100-SXM4-40GB MIG 2g.10gb", data["0/5/0"].Name)
+	assert.Equal(t, "A100-SXM4-40GB MIG 1g.5gb", data["0/13/0"].Name)
+
+	// destroyed instances are removed
+	instances := parseNvidiaMigInstances([]byte(migTableFixture))[:1]
+	c.update(parseNvidiaMigDevices([]byte(migListFixture)), instances, time.Now())
+	data = gm.GetCurrentData()
+	assert.Len(t, data, 3)
+	assert.Equal(t, 1, data["0"].MIGInstances)
+	assert.NotContains(t, data, "0/5/0")
+}
+
+func TestMIGCollectorStart(t *testing.T) {
+	gm := &GPUManager{GpuDataMap: map[string]*system.GPUData{"0": {Name: "A100-SXM4-40GB", Count: 1}}}
+	c := newMIGCollector(gm, []string{"0"})
+	c.run = func(_ context.Context, args ...string) ([]byte, error) {
+		if len(args) > 0 && args[0] == "-L" {
+			return []byte(migListFixture), nil
+		}
+		return []byte(migTableFixture), nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.start(ctx)
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		return len(gm.GetCurrentData()) == 4
+	}, time.Second, 10*time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("collector did not stop after cancel")
+	}
+}
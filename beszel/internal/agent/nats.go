@@ -0,0 +1,204 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// how often stats are published, matching the hub polling interval
+	natsPublishInterval = time.Minute
+	// number of messages kept while the NATS server is unreachable
+	natsBufferSize = 100
+	// wait before the first reconnect attempt, doubled for each failed attempt
+	natsBackoffBase = 500 * time.Millisecond
+	// upper limit for the wait between reconnect attempts
+	natsBackoffMax = 30 * time.Second
+	// session ID used to gather published stats, so they don't count as a hub session
+	natsSessionID = "nats"
+)
+
+// NATSPublisher publishes stats to a NATS server. Full stats are published to
+// beszel.stats.<hostname> and the data of each GPU to beszel.gpu.<hostname>.<id>.
+// Messages published while the server is unreachable are buffered and sent once
+// the connection is restored.
+type NATSPublisher struct {
+	conn     *nats.Conn
+	hostname string // hostname as a subject token
+	mu       sync.Mutex
+	pending  []natsMessage // unsent messages, oldest first, up to natsBufferSize
+}
+
+// natsMessage is a message waiting to be published
+type natsMessage struct {
+	subject string
+	data    []byte
+}
+
+// newNATSPublisher returns a publisher for the server in BESZEL_NATS_URL, or nil if it is not set
+func newNATSPublisher(hostname string) *NATSPublisher {
+	url, _ := GetEnvFallback("BESZEL_AGENT_NATS_URL", "BESZEL_NATS_URL")
+	if url == "" {
+		return nil
+	}
+	p, err := connectNATSPublisher(url, hostname)
+	if err != nil {
+		slog.Warn("NATS", "err", err)
+		return nil
+	}
+	return p
+}
+
+// connectNATSPublisher returns a publisher connected to url. If the server is
+// unreachable, it keeps trying to connect in the background.
+func connectNATSPublisher(url, hostname string) (*NATSPublisher, error) {
+	p := &NATSPublisher{hostname: natsToken(hostname)}
+	conn, err := nats.Connect(url,
+		nats.Name("beszel-agent "+hostname),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.CustomReconnectDelay(natsReconnectDelay),
+		// messages are buffered by the publisher instead, so the limit is a number of messages
+		nats.ReconnectBufSize(-1),
+		nats.ConnectHandler(p.flush),
+		nats.ReconnectHandler(p.flush),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				slog.Warn("NATS disconnected", "err", err)
+			}
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	p.conn = conn
+	return p, nil
+}
+
+// natsReconnectDelay returns the exponential backoff after the given number of
+// failed reconnect attempts
+func natsReconnectDelay(attempts int) time.Duration {
+	delay := natsBackoffBase << min(max(attempts-1, 0), 16)
+	return min(delay, natsBackoffMax)
+}
+
+// Publish publishes data, or buffers it if the server is unreachable
+func (p *NATSPublisher) Publish(data *system.CombinedData) {
+	if p == nil {
+		return
+	}
+	messages, err := p.messages(data)
+	if err != nil {
+		slog.Error("NATS", "err", err)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending = append(p.pending, messages...)
+	if p.conn.IsConnected() {
+		p.sendPending(p.conn)
+	}
+	p.trimPending()
+}
+
+// messages returns the stats message followed by a message for each GPU in order of id
+func (p *NATSPublisher) messages(data *system.CombinedData) ([]natsMessage, error) {
+	stats, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	messages := []natsMessage{{subject: "beszel.stats." + p.hostname, data: stats}}
+	for _, id := range slices.Sorted(maps.Keys(data.Stats.GPUData)) {
+		gpu, err := json.Marshal(data.Stats.GPUData[id])
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, natsMessage{subject: "beszel.gpu." + p.hostname + "." + natsToken(id), data: gpu})
+	}
+	return messages, nil
+}
+
+// flush sends the buffered messages once conn is connected
+func (p *NATSPublisher) flush(conn *nats.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n := len(p.pending); n > 0 {
+		slog.Info("NATS connected, sending buffered messages", "count", n)
+	}
+	p.sendPending(conn)
+}
+
+// sendPending publishes pending messages in order until one fails. It must be
+// called with the lock held.
+func (p *NATSPublisher) sendPending(conn *nats.Conn) {
+	for len(p.pending) > 0 {
+		msg := p.pending[0]
+		if err := conn.Publish(msg.subject, msg.data); err != nil {
+			slog.Debug("NATS publish", "subject", msg.subject, "err", err)
+			return
+		}
+		p.pending = p.pending[1:]
+	}
+	p.pending = nil
+}
+
+// trimPending drops the oldest messages beyond natsBufferSize. It must be called
+// with the lock held.
+func (p *NATSPublisher) trimPending() {
+	if dropped := len(p.pending) - natsBufferSize; dropped > 0 {
+		slog.Debug("NATS buffer full", "dropped", dropped)
+		p.pending = slices.Clone(p.pending[dropped:])
+	}
+}
+
+// Close flushes published messages and closes the connection
+func (p *NATSPublisher) Close() error {
+	if p == nil {
+		return nil
+	}
+	var err error
+	if p.conn.IsConnected() {
+		err = p.conn.Flush()
+	}
+	p.conn.Close()
+	return err
+}
+
+// natsToken replaces characters that separate or match subject tokens
+func natsToken(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// runNATSPublisher publishes stats every natsPublishInterval until ctx is cancelled
+func (a *Agent) runNATSPublisher(ctx context.Context) {
+	ticker := time.NewTicker(natsPublishInterval)
+	defer ticker.Stop()
+	for {
+		if stats, err := a.gatherStats(natsSessionID); err == nil {
+			a.nats.Publish(stats)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,132 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func natsTestData() *system.CombinedData {
+	return &system.CombinedData{
+		Stats: system.Stats{
+			Cpu: 12.5,
+			GPUData: map[string]system.GPUData{
+				"1": {Name: "RTX 4090", Usage: 57.2},
+				"0": {Name: "RTX 4090", Usage: 3.5},
+			},
+		},
+		Info: system.Info{Hostname: "web-01.example.com"},
+	}
+}
+
+// runTestNATSServer starts an in-process server on port, or a random port if port is -1
+func runTestNATSServer(t *testing.T, port int) *server.Server {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = port
+	s := natsserver.RunServer(&opts)
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+// subscribeNATS returns a channel receiving the messages of subject
+func subscribeNATS(t *testing.T, url, subject string) chan *nats.Msg {
+	conn, err := nats.Connect(url)
+	require.NoError(t, err)
+	t.Cleanup(conn.Close)
+	messages := make(chan *nats.Msg, natsBufferSize)
+	_, err = conn.ChanSubscribe(subject, messages)
+	require.NoError(t, err)
+	require.NoError(t, conn.Flush())
+	return messages
+}
+
+func receiveNATS(t *testing.T, messages chan *nats.Msg) *nats.Msg {
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+func TestNATSPublisher(t *testing.T) {
+	s := runTestNATSServer(t, -1)
+	messages := subscribeNATS(t, s.ClientURL(), "beszel.>")
+
+	p, err := connectNATSPublisher(s.ClientURL(), "web-01.example.com")
+	require.NoError(t, err)
+	defer p.Close()
+	p.Publish(natsTestData())
+
+	msg := receiveNATS(t, messages)
+	assert.Equal(t, "beszel.stats.web-01_example_com", msg.Subject)
+	var data system.CombinedData
+	require.NoError(t, json.Unmarshal(msg.Data, &data))
+	assert.Equal(t, 12.5, data.Stats.Cpu)
+	assert.Len(t, data.Stats.GPUData, 2)
+
+	for _, id := range []string{"0", "1"} {
+		msg := receiveNATS(t, messages)
+		assert.Equal(t, "beszel.gpu.web-01_example_com."+id, msg.Subject)
+		var gpu system.GPUData
+		require.NoError(t, json.Unmarshal(msg.Data, &gpu))
+		assert.Equal(t, natsTestData().Stats.GPUData[id], gpu)
+	}
+}
+
+func TestNATSPublisherReconnect(t *testing.T) {
+	s := runTestNATSServer(t, -1)
+	url, port := s.ClientURL(), s.Addr().(*net.TCPAddr).Port
+	p, err := connectNATSPublisher(url, "web-01")
+	require.NoError(t, err)
+	defer p.Close()
+
+	s.Shutdown()
+	require.Eventually(t, func() bool { return !p.conn.IsConnected() }, 2*time.Second, 10*time.Millisecond)
+	// 3 messages per cycle
+	for range 40 {
+		p.Publish(natsTestData())
+	}
+	p.mu.Lock()
+	assert.Len(t, p.pending, natsBufferSize, "only the most recent messages are kept")
+	p.mu.Unlock()
+
+	// subscribe before the publisher reconnects after its backoff
+	runTestNATSServer(t, port)
+	messages := subscribeNATS(t, url, "beszel.stats.>")
+	for range 33 {
+		assert.Equal(t, "beszel.stats.web-01", receiveNATS(t, messages).Subject)
+	}
+	p.mu.Lock()
+	assert.Empty(t, p.pending)
+	p.mu.Unlock()
+}
+
+func TestNATSReconnectDelay(t *testing.T) {
+	assert.Equal(t, 500*time.Millisecond, natsReconnectDelay(0))
+	assert.Equal(t, 500*time.Millisecond, natsReconnectDelay(1))
+	assert.Equal(t, 2*time.Second, natsReconnectDelay(3))
+	assert.Equal(t, 30*time.Second, natsReconnectDelay(10))
+	assert.Equal(t, 30*time.Second, natsReconnectDelay(1000))
+}
+
+func TestNewNATSPublisher(t *testing.T) {
+	t.Setenv("BESZEL_AGENT_NATS_URL", "")
+	t.Setenv("BESZEL_NATS_URL", "")
+	p := newNATSPublisher("web-01")
+	assert.Nil(t, p)
+	assert.NotPanics(t, func() { p.Publish(natsTestData()) })
+	assert.NoError(t, p.Close())
+}
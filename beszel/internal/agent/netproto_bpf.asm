@@ -0,0 +1,75 @@
+# TC program that counts packets per interface and IP protocol for EBPFNetCollector.
+# Written in BPF assembly so it can be built with llvm-mc alone (no clang or kernel
+# headers needed). Regenerate netproto_bpf.o with `go generate` after editing.
+#
+# Map key: ifindex << 32 | IP protocol number. Value: packet count.
+# Context offsets are from struct __sk_buff: protocol = 16, ifindex = 40.
+	.text
+	.section	tc,"ax",@progbits
+	.globl	count_protocols
+	.type	count_protocols,@function
+count_protocols:
+	r6 = r1
+	r2 = *(u32 *)(r6 + 16)
+	if r2 == 8 goto .Lipv4
+	if r2 == 56710 goto .Lipv6
+	goto .Lout
+.Lipv4:
+	r2 = 23
+	goto .Lload
+.Lipv6:
+	r2 = 20
+.Lload:
+	r1 = r6
+	r3 = r10
+	r3 += -16
+	r4 = 1
+	call 26
+	if r0 != 0 goto .Lout
+	r7 = *(u8 *)(r10 - 16)
+	r8 = *(u32 *)(r6 + 40)
+	r8 <<= 32
+	r8 |= r7
+	*(u64 *)(r10 - 8) = r8
+	r1 = counts ll
+	r2 = r10
+	r2 += -8
+	call 1
+	if r0 == 0 goto .Linit
+	r1 = 1
+	lock *(u64 *)(r0 + 0) += r1
+	goto .Lout
+.Linit:
+	r1 = 1
+	*(u64 *)(r10 - 24) = r1
+	r1 = counts ll
+	r2 = r10
+	r2 += -8
+	r3 = r10
+	r3 += -24
+	r4 = 1
+	call 2
+.Lout:
+	r0 = -1
+	exit
+.Lfunc_end0:
+	.size	count_protocols, .Lfunc_end0-count_protocols
+
+	.section	maps,"aw",@progbits
+	.globl	counts
+	.p2align	2
+counts:
+	.long	1
+	.long	8
+	.long	8
+	.long	4096
+	.long	0
+	.size	counts, 20
+	.type	counts,@object
+
+	.section	license,"aw",@progbits
+	.globl	_license
+_license:
+	.asciz	"Dual MIT/GPL"
+	.size	_license, 13
+	.type	_license,@object
@@ -2,12 +2,19 @@ package agent
 
 import (
 	"log/slog"
+	"regexp"
+	"slices"
 	"strings"
 	"time"
 
 	psutilNet "github.com/shirou/gopsutil/v4/net"
 )
 
+// netCounters holds the cumulative bytes of a network interface
+type netCounters struct {
+	sent, recv uint64
+}
+
 func (a *Agent) initializeNetIoStats() {
 	// reset valid network interfaces
 	a.netInterfaces = make(map[string]struct{}, 0)
@@ -25,6 +32,7 @@ func (a *Agent) initializeNetIoStats() {
 	// reset network I/O stats
 	a.netIoStats.BytesSent = 0
 	a.netIoStats.BytesRecv = 0
+	a.netCounters = make(map[string]netCounters)
 
 	// get intial network I/O stats
 	if netIO, err := psutilNet.IOCounters(true); err == nil {
@@ -45,14 +53,44 @@ func (a *Agent) initializeNetIoStats() {
 			slog.Info("Detected network interface", "name", v.Name, "sent", v.BytesSent, "recv", v.BytesRecv)
 			a.netIoStats.BytesSent += v.BytesSent
 			a.netIoStats.BytesRecv += v.BytesRecv
+			a.netCounters[v.Name] = netCounters{sent: v.BytesSent, recv: v.BytesRecv}
 			// store as a valid network interface
 			a.netInterfaces[v.Name] = struct{}{}
 		}
 	}
 }
 
+// netPatternsFromEnv compiles the comma separated regular expressions in the
+// first set variable of names. Invalid patterns panic so the agent doesn't start
+// with a filter the user didn't intend.
+func netPatternsFromEnv(names ...string) []*regexp.Regexp {
+	value, _ := GetEnvFallback(names...)
+	var patterns []*regexp.Regexp
+	for pattern := range strings.SplitSeq(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, regexp.MustCompile(pattern))
+		}
+	}
+	return patterns
+}
+
+// matchesAnyPattern returns true if name matches one of patterns
+func matchesAnyPattern(patterns []*regexp.Regexp, name string) bool {
+	return slices.ContainsFunc(patterns, func(pattern *regexp.Regexp) bool {
+		return pattern.MatchString(name)
+	})
+}
+
+// skipNetworkInterface returns true if stats should not be collected for the
+// interface. Interfaces matching NET_EXCLUDE are always skipped. If NET_INCLUDE
+// is set, only matching interfaces are collected, otherwise loopback, Docker,
+// and idle interfaces are skipped.
 func (a *Agent) skipNetworkInterface(v psutilNet.IOCountersStat) bool {
 	switch {
+	case matchesAnyPattern(a.netExclude, v.Name):
+		return true
+	case len(a.netInclude) > 0:
+		return !matchesAnyPattern(a.netInclude, v.Name)
 	case strings.HasPrefix(v.Name, "lo"),
 		strings.HasPrefix(v.Name, "docker"),
 		strings.HasPrefix(v.Name, "br-"),
@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"testing"
+
+	psutilNet "github.com/shirou/gopsutil/v4/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetPatternsFromEnv(t *testing.T) {
+	t.Setenv("BESZEL_NET_INCLUDE", "^bond[0-9]+$, ^vmnet")
+	patterns := netPatternsFromEnv("BESZEL_AGENT_NET_INCLUDE", "BESZEL_NET_INCLUDE")
+	require.Len(t, patterns, 2)
+	assert.Equal(t, "^bond[0-9]+$", patterns[0].String())
+	assert.Equal(t, "^vmnet", patterns[1].String())
+
+	assert.Empty(t, netPatternsFromEnv("BESZEL_AGENT_NET_EXCLUDE", "BESZEL_NET_EXCLUDE"))
+
+	// invalid patterns fail at startup rather than silently collecting the wrong interfaces
+	t.Setenv("BESZEL_NET_EXCLUDE", "^vmnet,bond[0-9")
+	assert.PanicsWithValue(t, "regexp: Compile(`bond[0-9`): error parsing regexp: missing closing ]: `[0-9`", func() {
+		netPatternsFromEnv("BESZEL_AGENT_NET_EXCLUDE", "BESZEL_NET_EXCLUDE")
+	})
+}
+
+func TestSkipNetworkInterface(t *testing.T) {
+	iface := func(name string) psutilNet.IOCountersStat {
+		return psutilNet.IOCountersStat{Name: name, BytesSent: 1024, BytesRecv: 2048}
+	}
+
+	// default exclusions
+	a := &Agent{}
+	assert.True(t, a.skipNetworkInterface(iface("lo")))
+	assert.True(t, a.skipNetworkInterface(iface("docker0")))
+	assert.True(t, a.skipNetworkInterface(iface("veth12ab")))
+	assert.True(t, a.skipNetworkInterface(psutilNet.IOCountersStat{Name: "eth1"}), "idle interfaces are skipped")
+	assert.False(t, a.skipNetworkInterface(iface("eth0")))
+	assert.False(t, a.skipNetworkInterface(iface("vmnet8")))
+
+	t.Setenv("BESZEL_NET_EXCLUDE", "^vmnet")
+	a.netExclude = netPatternsFromEnv("BESZEL_AGENT_NET_EXCLUDE", "BESZEL_NET_EXCLUDE")
+	assert.True(t, a.skipNetworkInterface(iface("vmnet8")))
+	assert.False(t, a.skipNetworkInterface(iface("eth0")))
+
+	// only included interfaces, still without excluded ones
+	t.Setenv("BESZEL_NET_INCLUDE", "^bond,^vmnet,^docker")
+	a.netInclude = netPatternsFromEnv("BESZEL_AGENT_NET_INCLUDE", "BESZEL_NET_INCLUDE")
+	assert.False(t, a.skipNetworkInterface(iface("bond0")))
+	assert.False(t, a.skipNetworkInterface(iface("docker0")), "include overrides the default exclusions")
+	assert.True(t, a.skipNetworkInterface(iface("eth0")))
+	assert.True(t, a.skipNetworkInterface(iface("vmnet8")), "exclude wins over include")
+}
@@ -0,0 +1,195 @@
+package agent
+
+import (
+	"beszel/internal/common"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	// number of notifications kept while the hub is unreachable
+	notifyBufferSize = 32
+	// limit for connecting to the hub, including the SSH handshake
+	notifyConnectTimeout = 10 * time.Second
+)
+
+// notifyRetryPolicy reconnects to the hub indefinitely, backing off up to a minute
+var notifyRetryPolicy = RetryPolicy{
+	BackoffBase: time.Second,
+	BackoffMax:  time.Minute,
+}
+
+// NotificationDialer keeps an SSH connection to the hub open and pushes critical
+// events over a common.NotificationChannel as soon as they are detected, instead
+// of waiting for the hub to poll. The hub's host key must be one of the keys the
+// agent accepts from the hub. Methods can be called on a nil NotificationDialer.
+type NotificationDialer struct {
+	addr    string
+	config  gossh.ClientConfig
+	pending chan common.Notification // notifications waiting to be sent, oldest dropped when full
+}
+
+// newNotificationDialer returns a dialer for the hub in BESZEL_HUB_ADDR, or nil if
+// it is not set. The agent authenticates as hostname with the private key in
+// BESZEL_HUB_NOTIFY_KEY, and accepts hub host keys for which hostKeys returns true.
+func newNotificationDialer(hostname string, hostKeys func(gossh.PublicKey) bool) *NotificationDialer {
+	addr, _ := GetEnvFallback("BESZEL_AGENT_HUB_ADDR", "BESZEL_HUB_ADDR")
+	if addr == "" {
+		return nil
+	}
+	keyPath, _ := GetEnvFallback("BESZEL_AGENT_HUB_NOTIFY_KEY", "BESZEL_HUB_NOTIFY_KEY")
+	signer, err := readNotifyKey(keyPath)
+	if err != nil {
+		slog.Warn("Hub notifications disabled", "err", err)
+		return nil
+	}
+	return &NotificationDialer{
+		addr:    addr,
+		config:  notifyClientConfig(hostname, signer, hostKeys),
+		pending: make(chan common.Notification, notifyBufferSize),
+	}
+}
+
+// readNotifyKey reads the private key the agent authenticates to the hub with
+func readNotifyKey(path string) (gossh.Signer, error) {
+	if path == "" {
+		return nil, errors.New("BESZEL_HUB_NOTIFY_KEY is not set")
+	}
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := gossh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// notifyClientConfig returns the SSH client config for connecting to the hub,
+// limited to the algorithms of the agent's SSH server
+func notifyClientConfig(user string, signer gossh.Signer, hostKeys func(gossh.PublicKey) bool) gossh.ClientConfig {
+	config := gossh.ClientConfig{
+		User: user,
+		Auth: []gossh.AuthMethod{gossh.PublicKeys(signer)},
+		HostKeyCallback: func(_ string, _ net.Addr, key gossh.PublicKey) error {
+			if hostKeys(key) {
+				return nil
+			}
+			return fmt.Errorf("hub host key %s is not an authorized key", gossh.FingerprintSHA256(key))
+		},
+		Timeout: notifyConnectTimeout,
+	}
+	config.KeyExchanges = common.DefaultKeyExchanges
+	config.MACs = common.DefaultMACs
+	config.Ciphers = common.DefaultCiphers
+	return config
+}
+
+// Notify queues an event to be sent to the hub without blocking. If the queue is
+// full because the hub is unreachable, the oldest notification is dropped.
+func (d *NotificationDialer) Notify(id string, severity int, msg string) {
+	if d == nil {
+		return
+	}
+	n := common.Notification{ID: id, Severity: severity, Message: msg, Time: time.Now().UTC()}
+	for {
+		select {
+		case d.pending <- n:
+			return
+		default:
+		}
+		select {
+		case dropped := <-d.pending:
+			slog.Debug("Hub notification dropped", "id", dropped.ID)
+		default:
+		}
+	}
+}
+
+// run sends queued notifications until ctx is cancelled, reconnecting with
+// backoff while the hub is unreachable
+func (d *NotificationDialer) run(ctx context.Context) {
+	failures := 0
+	var unsent *common.Notification
+	for {
+		connected, err := d.serve(ctx, &unsent)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			failures = 0
+		}
+		failures++
+		slog.Warn("Hub notifications disconnected", "addr", d.addr, "err", err)
+		if !sleepContext(ctx, notifyRetryPolicy.backoff(failures)) {
+			return
+		}
+	}
+}
+
+// serve connects to the hub and sends notifications until the connection fails
+// or ctx is cancelled. A notification that could not be sent is left in unsent to
+// be sent first after reconnecting. connected is true if the channel was opened.
+func (d *NotificationDialer) serve(ctx context.Context, unsent **common.Notification) (connected bool, err error) {
+	dialer := net.Dialer{Timeout: d.config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	conn.SetDeadline(time.Now().Add(d.config.Timeout))
+	clientConn, chans, reqs, err := gossh.NewClientConn(conn, d.addr, &d.config)
+	if err != nil {
+		return false, err
+	}
+	conn.SetDeadline(time.Time{})
+	client := gossh.NewClient(clientConn, chans, reqs)
+	defer client.Close()
+	channel, requests, err := client.OpenChannel(common.NotificationChannel, nil)
+	if err != nil {
+		return false, err
+	}
+	defer channel.Close()
+	go gossh.DiscardRequests(requests)
+	// detect a lost connection while no notifications are sent
+	closed := make(chan error, 1)
+	go func() { closed <- client.Wait() }()
+	slog.Debug("Hub notifications connected", "addr", d.addr)
+
+	for {
+		if *unsent == nil {
+			select {
+			case <-ctx.Done():
+				return true, ctx.Err()
+			case err := <-closed:
+				return true, err
+			case n := <-d.pending:
+				*unsent = &n
+			}
+		}
+		payload, err := json.Marshal(*unsent)
+		if err != nil {
+			return true, err
+		}
+		accepted, err := channel.SendRequest(common.NotificationRequest, true, payload)
+		if err != nil {
+			return true, err
+		}
+		if !accepted {
+			slog.Debug("Hub rejected notification", "id", (*unsent).ID)
+		}
+		*unsent = nil
+	}
+}
@@ -0,0 +1,200 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel/internal/common"
+	"cmp"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	sshServer "github.com/gliderlabs/ssh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// mockHub is an SSH server that accepts notification channels like the hub
+type mockHub struct {
+	hostKey       gossh.PublicKey
+	users         chan string
+	notifications chan common.Notification
+	server        *sshServer.Server
+}
+
+// newMockHub returns a hub accepting clientKey, which must be started with serve
+func newMockHub(t *testing.T, clientKey gossh.PublicKey) *mockHub {
+	hostSigner := newTestSigner(t)
+	hub := &mockHub{
+		hostKey:       hostSigner.PublicKey(),
+		users:         make(chan string, 10),
+		notifications: make(chan common.Notification, 100),
+	}
+	hub.server = &sshServer.Server{
+		PublicKeyHandler: func(_ sshServer.Context, key sshServer.PublicKey) bool {
+			return sshServer.KeysEqual(key, clientKey)
+		},
+		ChannelHandlers: map[string]sshServer.ChannelHandler{
+			common.NotificationChannel: func(_ *sshServer.Server, _ *gossh.ServerConn, newChan gossh.NewChannel, ctx sshServer.Context) {
+				channel, requests, err := newChan.Accept()
+				if err != nil {
+					return
+				}
+				defer channel.Close()
+				hub.users <- ctx.User()
+				for req := range requests {
+					var n common.Notification
+					ok := req.Type == common.NotificationRequest && json.Unmarshal(req.Payload, &n) == nil
+					if ok {
+						hub.notifications <- n
+					}
+					req.Reply(ok, nil)
+				}
+			},
+		},
+	}
+	hub.server.AddHostKey(hostSigner)
+	t.Cleanup(func() { hub.server.Close() })
+	return hub
+}
+
+// serve starts the hub on addr, or a random port if addr is empty, and returns its address
+func (hub *mockHub) serve(t *testing.T, addr string) string {
+	listener, err := net.Listen("tcp", cmp.Or(addr, "127.0.0.1:0"))
+	require.NoError(t, err)
+	go hub.server.Serve(listener)
+	return listener.Addr().String()
+}
+
+// receive returns the next notification sent to the hub
+func (hub *mockHub) receive(t *testing.T) common.Notification {
+	select {
+	case n := <-hub.notifications:
+		return n
+	case <-time.After(3 * time.Second):
+		t.Fatal("no notification received")
+		return common.Notification{}
+	}
+}
+
+// writeNotifyKey writes a new client key, points BESZEL_HUB_NOTIFY_KEY at it,
+// and returns its public key
+func writeNotifyKey(t *testing.T) gossh.PublicKey {
+	_, privKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	block, err := gossh.MarshalPrivateKey(privKey, "")
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600))
+	t.Setenv("BESZEL_HUB_NOTIFY_KEY", keyPath)
+	signer, err := gossh.NewSignerFromKey(privKey)
+	require.NoError(t, err)
+	return signer.PublicKey()
+}
+
+// trustKey returns a host key check accepting only key
+func trustKey(key gossh.PublicKey) func(gossh.PublicKey) bool {
+	return func(hostKey gossh.PublicKey) bool {
+		return sshServer.KeysEqual(hostKey, key)
+	}
+}
+
+// runNotificationDialer runs d until the test ends
+func runNotificationDialer(t *testing.T, d *NotificationDialer) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestNotificationDialer(t *testing.T) {
+	hub := newMockHub(t, writeNotifyKey(t))
+	t.Setenv("BESZEL_HUB_ADDR", hub.serve(t, ""))
+	d := newNotificationDialer("web-01", trustKey(hub.hostKey))
+	require.NotNil(t, d)
+	runNotificationDialer(t, d)
+
+	d.Notify("OOM_KILL", syslogError, "OOM killer ran 2 times")
+	n := hub.receive(t)
+	assert.Equal(t, "OOM_KILL", n.ID)
+	assert.Equal(t, syslogError, n.Severity)
+	assert.Equal(t, "OOM killer ran 2 times", n.Message)
+	assert.WithinDuration(t, time.Now(), n.Time, 5*time.Second)
+	assert.Equal(t, "web-01", <-hub.users)
+
+	// alerts are pushed even if syslog is disabled
+	t.Setenv("BESZEL_SYSLOG", "")
+	alerter := newSyslogAlerter("web-01", d)
+	require.NotNil(t, alerter)
+	alerter.ECCErrors("A100 0", 0)
+	alerter.ECCErrors("A100 0", 4)
+	n = hub.receive(t)
+	assert.Equal(t, "GPU_ECC", n.ID)
+	assert.Equal(t, syslogCritical, n.Severity)
+	assert.Equal(t, "GPU A100 0 uncorrected ECC errors increased by 4 to 4", n.Message)
+}
+
+func TestNotificationDialerReconnect(t *testing.T) {
+	const addr = "127.0.0.1:45999"
+	hub := newMockHub(t, writeNotifyKey(t))
+	t.Setenv("BESZEL_HUB_ADDR", addr)
+	d := newNotificationDialer("web-01", trustKey(hub.hostKey))
+	runNotificationDialer(t, d)
+
+	// queued while the hub is unreachable
+	d.Notify("GPU_TEMP", syslogWarning, "GPU 0 temperature 87.0°C")
+	d.Notify("GPU_TEMP", syslogCritical, "GPU 0 temperature 96.0°C")
+	time.Sleep(100 * time.Millisecond)
+	hub.serve(t, addr)
+	assert.Equal(t, "GPU 0 temperature 87.0°C", hub.receive(t).Message)
+	assert.Equal(t, "GPU 0 temperature 96.0°C", hub.receive(t).Message)
+}
+
+func TestNotificationDialerUntrustedHub(t *testing.T) {
+	hub := newMockHub(t, writeNotifyKey(t))
+	t.Setenv("BESZEL_HUB_ADDR", hub.serve(t, ""))
+	d := newNotificationDialer("web-01", trustKey(newTestSigner(t).PublicKey()))
+	var unsent *common.Notification
+	connected, err := d.serve(context.Background(), &unsent)
+	assert.False(t, connected)
+	assert.ErrorContains(t, err, "not an authorized key")
+	assert.Empty(t, hub.users)
+}
+
+func TestNotificationDialerBuffer(t *testing.T) {
+	d := &NotificationDialer{pending: make(chan common.Notification, notifyBufferSize)}
+	for i := range notifyBufferSize + 3 {
+		d.Notify("OOM_KILL", syslogError, strconv.Itoa(i))
+	}
+	assert.Len(t, d.pending, notifyBufferSize)
+	assert.Equal(t, "3", (<-d.pending).Message, "oldest notifications are dropped")
+}
+
+func TestNewNotificationDialerDisabled(t *testing.T) {
+	t.Setenv("BESZEL_AGENT_HUB_ADDR", "")
+	t.Setenv("BESZEL_HUB_ADDR", "")
+	d := newNotificationDialer("web-01", trustKey(nil))
+	assert.Nil(t, d)
+	assert.NotPanics(t, func() { d.Notify("OOM_KILL", syslogError, "OOM killer ran 1 times") })
+
+	// the client key is required
+	t.Setenv("BESZEL_HUB_ADDR", "hub.example.com:45876")
+	t.Setenv("BESZEL_AGENT_HUB_NOTIFY_KEY", "")
+	t.Setenv("BESZEL_HUB_NOTIFY_KEY", "")
+	assert.Nil(t, newNotificationDialer("web-01", trustKey(nil)))
+}
@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	nvlinkInterval = 4 * time.Second
+	// nvidia-smi reports NVLink counters in KiB
+	bytesInAKibibyte = 1024.0
+)
+
+// NVLink data counter lines in `nvidia-smi nvlink -g 0` output, e.g.
+//
+//	GPU 0: NVIDIA A100-SXM4-40GB (UUID: GPU-5c89852c-d268-c3f3-1b07-005d5ae1dc3f)
+//		 Link 0: Rx0: 52394112 KBytes, Tx0: 52394880 KBytes
+var nvlinkCounterPattern = regexp.MustCompile(`Link \d+: Rx0: (\d+) KBytes, Tx0: (\d+) KBytes`)
+
+// nvlinkCounters holds the cumulative data received and sent over all links of a GPU
+type nvlinkCounters struct {
+	rx, tx uint64 // KiB
+}
+
+// NVLinkCollector polls NVLink data counters and sets the throughput on GPUManager data
+type NVLinkCollector struct {
+	gm   *GPUManager
+	run  func(ctx context.Context) ([]byte, error)
+	prev map[string]nvlinkCounters // counters from the previous poll keyed by GPU index
+	time time.Time                 // time of the previous poll
+}
+
+func newNVLinkCollector(gm *GPUManager) *NVLinkCollector {
+	return &NVLinkCollector{
+		gm: gm,
+		run: func(ctx context.Context) ([]byte, error) {
+			return newGPUCommandContext(ctx, nvidiaSmiCmd, "nvlink", "-g", "0").Output()
+		},
+	}
+}
+
+// detectNvidiaNVLink returns true if any GPU has active NVLink links
+func detectNvidiaNVLink() bool {
+	output, err := newGPUCommand(nvidiaSmiCmd, "--query-gpu=nvlink.link.count", "--format=csv,noheader").Output()
+	if err != nil {
+		return false
+	}
+	for line := range strings.Lines(string(output)) {
+		if count, err := strconv.Atoi(strings.TrimSpace(line)); err == nil && count > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// start polls NVLink counters until ctx is cancelled
+func (c *NVLinkCollector) start(ctx context.Context) {
+	for {
+		output, err := c.run(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Debug("NVLink", "err", err)
+		} else {
+			c.update(parseNVLinkCounters(output), time.Now())
+		}
+		if !sleepContext(ctx, nvlinkInterval) {
+			return
+		}
+	}
+}
+
+// parseNVLinkCounters sums the data counters of all links for each GPU
+func parseNVLinkCounters(output []byte) map[string]nvlinkCounters {
+	counters := make(map[string]nvlinkCounters)
+	var id string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := nvidiaGpuListPattern.FindStringSubmatch(line); match != nil {
+			id = match[1]
+			continue
+		}
+		if id == "" {
+			continue
+		}
+		match := nvlinkCounterPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		rx, _ := strconv.ParseUint(match[1], 10, 64)
+		tx, _ := strconv.ParseUint(match[2], 10, 64)
+		gpu := counters[id]
+		gpu.rx += rx
+		gpu.tx += tx
+		counters[id] = gpu
+	}
+	return counters
+}
+
+// update sets NVLink throughput (MB/s) on each GPU from the change since the previous poll
+func (c *NVLinkCollector) update(counters map[string]nvlinkCounters, now time.Time) {
+	prev, prevTime := c.prev, c.time
+	c.prev, c.time = counters, now
+	if prev == nil {
+		return
+	}
+	seconds := now.Sub(prevTime).Seconds()
+	if seconds <= 0 {
+		return
+	}
+	c.gm.Lock()
+	defer c.gm.Unlock()
+	defer c.gm.publishSnapshot(c.gm.beginUpdate())
+	for id, cur := range counters {
+		last, ok := prev[id]
+		gpu, exists := c.gm.GpuDataMap[id]
+		// skip new GPUs and counter resets
+		if !ok || !exists || cur.rx < last.rx || cur.tx < last.tx {
+			continue
+		}
+		gpu.NVLinkRxBandwidth = bytesToMegabytes(float64(cur.rx-last.rx) * bytesInAKibibyte / seconds)
+		gpu.NVLinkTxBandwidth = bytesToMegabytes(float64(cur.tx-last.tx) * bytesInAKibibyte / seconds)
+	}
+}
@@ -0,0 +1,189 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"context"
+	"errors"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// `nvidia-smi nvlink -g 0` on a DGX A100 (8 GPUs, 12 links each)
+const nvlinkDGXA100Fixture = `GPU 0: NVIDIA A100-SXM4-40GB (UUID: GPU-5c89852c-d268-c3f3-1b07-005d5ae1dc3f)
+	 Link 0: Rx0: 50866024 KBytes, Tx0: 45061658 KBytes
+	 Link 1: Rx0: 53248078 KBytes, Tx0: 41620223 KBytes
+	 Link 2: Rx0: 42430558 KBytes, Tx0: 57981216 KBytes
+	 Link 3: Rx0: 43158480 KBytes, Tx0: 52270483 KBytes
+	 Link 4: Rx0: 59555120 KBytes, Tx0: 41946120 KBytes
+	 Link 5: Rx0: 57026717 KBytes, Tx0: 47204075 KBytes
+	 Link 6: Rx0: 41258145 KBytes, Tx0: 42883910 KBytes
+	 Link 7: Rx0: 54550734 KBytes, Tx0: 54031529 KBytes
+	 Link 8: Rx0: 42343959 KBytes, Tx0: 48075310 KBytes
+	 Link 9: Rx0: 43043823 KBytes, Tx0: 58490077 KBytes
+	 Link 10: Rx0: 54244500 KBytes, Tx0: 41983419 KBytes
+	 Link 11: Rx0: 58973477 KBytes, Tx0: 44154104 KBytes
+GPU 1: NVIDIA A100-SXM4-40GB (UUID: GPU-0f1e7a4b-3c2d-9b8a-7e6f-5d4c3b2a1908)
+	 Link 0: Rx0: 47490656 KBytes, Tx0: 59562129 KBytes
+	 Link 1: Rx0: 42075745 KBytes, Tx0: 59364361 KBytes
+	 Link 2: Rx0: 59647509 KBytes, Tx0: 53310388 KBytes
+	 Link 3: Rx0: 41663941 KBytes, Tx0: 47418275 KBytes
+	 Link 4: Rx0: 41563055 KBytes, Tx0: 58678574 KBytes
+	 Link 5: Rx0: 44468605 KBytes, Tx0: 49717675 KBytes
+	 Link 6: Rx0: 54063972 KBytes, Tx0: 44840397 KBytes
+	 Link 7: Rx0: 58142407 KBytes, Tx0: 43952451 KBytes
+	 Link 8: Rx0: 59156684 KBytes, Tx0: 50350932 KBytes
+	 Link 9: Rx0: 58799114 KBytes, Tx0: 46064171 KBytes
+	 Link 10: Rx0: 43457975 KBytes, Tx0: 59515263 KBytes
+	 Link 11: Rx0: 59166438 KBytes, Tx0: 46303905 KBytes
+GPU 2: NVIDIA A100-SXM4-40GB (UUID: GPU-a8b7c6d5-e4f3-a2b1-c0d9-e8f7a6b5c4d3)
+	 Link 0: Rx0: 52495588 KBytes, Tx0: 43269227 KBytes
+	 Link 1: Rx0: 58379254 KBytes, Tx0: 42106848 KBytes
+	 Link 2: Rx0: 58937057 KBytes, Tx0: 41999883 KBytes
+	 Link 3: Rx0: 46910827 KBytes, Tx0: 56656906 KBytes
+	 Link 4: Rx0: 57841570 KBytes, Tx0: 54347616 KBytes
+	 Link 5: Rx0: 50541029 KBytes, Tx0: 55623006 KBytes
+	 Link 6: Rx0: 59648195 KBytes, Tx0: 55206344 KBytes
+	 Link 7: Rx0: 52132690 KBytes, Tx0: 50058511 KBytes
+	 Link 8: Rx0: 48335812 KBytes, Tx0: 46031971 KBytes
+	 Link 9: Rx0: 48190519 KBytes, Tx0: 42746598 KBytes
+	 Link 10: Rx0: 59274461 KBytes, Tx0: 50074688 KBytes
+	 Link 11: Rx0: 57622670 KBytes, Tx0: 56613348 KBytes
+GPU 3: NVIDIA A100-SXM4-40GB (UUID: GPU-13579bdf-2468-ace0-1357-9bdf2468ace0)
+	 Link 0: Rx0: 51525131 KBytes, Tx0: 55060376 KBytes
+	 Link 1: Rx0: 49661588 KBytes, Tx0: 42456213 KBytes
+	 Link 2: Rx0: 43961630 KBytes, Tx0: 57177615 KBytes
+	 Link 3: Rx0: 54029873 KBytes, Tx0: 45535209 KBytes
+	 Link 4: Rx0: 51477488 KBytes, Tx0: 45099754 KBytes
+	 Link 5: Rx0: 56406879 KBytes, Tx0: 54149848 KBytes
+	 Link 6: Rx0: 41315577 KBytes, Tx0: 42604511 KBytes
+	 Link 7: Rx0: 58725914 KBytes, Tx0: 59227559 KBytes
+	 Link 8: Rx0: 50527619 KBytes, Tx0: 51412612 KBytes
+	 Link 9: Rx0: 51750036 KBytes, Tx0: 59943743 KBytes
+	 Link 10: Rx0: 56665640 KBytes, Tx0: 59458054 KBytes
+	 Link 11: Rx0: 55307710 KBytes, Tx0: 42307301 KBytes
+GPU 4: NVIDIA A100-SXM4-40GB (UUID: GPU-fedcba98-7654-3210-fedc-ba9876543210)
+	 Link 0: Rx0: 43140560 KBytes, Tx0: 49057659 KBytes
+	 Link 1: Rx0: 55908100 KBytes, Tx0: 42181037 KBytes
+	 Link 2: Rx0: 42035728 KBytes, Tx0: 50388699 KBytes
+	 Link 3: Rx0: 59392657 KBytes, Tx0: 54953222 KBytes
+	 Link 4: Rx0: 49549441 KBytes, Tx0: 52945012 KBytes
+	 Link 5: Rx0: 51643564 KBytes, Tx0: 40757086 KBytes
+	 Link 6: Rx0: 55491923 KBytes, Tx0: 51927396 KBytes
+	 Link 7: Rx0: 45638767 KBytes, Tx0: 43929082 KBytes
+	 Link 8: Rx0: 56565588 KBytes, Tx0: 41978182 KBytes
+	 Link 9: Rx0: 47321837 KBytes, Tx0: 49644615 KBytes
+	 Link 10: Rx0: 44339937 KBytes, Tx0: 48308575 KBytes
+	 Link 11: Rx0: 53351230 KBytes, Tx0: 53118095 KBytes
+GPU 5: NVIDIA A100-SXM4-40GB (UUID: GPU-2b4d6f80-1a3c-5e7f-9b0d-2f4a6c8e0b1d)
+	 Link 0: Rx0: 56660000 KBytes, Tx0: 42703859 KBytes
+	 Link 1: Rx0: 45582326 KBytes, Tx0: 55072228 KBytes
+	 Link 2: Rx0: 53476944 KBytes, Tx0: 58436144 KBytes
+	 Link 3: Rx0: 49322734 KBytes, Tx0: 44594478 KBytes
+	 Link 4: Rx0: 54445909 KBytes, Tx0: 58462304 KBytes
+	 Link 5: Rx0: 49342260 KBytes, Tx0: 53935038 KBytes
+	 Link 6: Rx0: 52038362 KBytes, Tx0: 52765491 KBytes
+	 Link 7: Rx0: 47742735 KBytes, Tx0: 45064065 KBytes
+	 Link 8: Rx0: 42784504 KBytes, Tx0: 45912885 KBytes
+	 Link 9: Rx0: 45076731 KBytes, Tx0: 47783180 KBytes
+	 Link 10: Rx0: 47829459 KBytes, Tx0: 40404769 KBytes
+	 Link 11: Rx0: 56272648 KBytes, Tx0: 59767704 KBytes
+GPU 6: NVIDIA A100-SXM4-40GB (UUID: GPU-9e8d7c6b-5a4f-3e2d-1c0b-a9f8e7d6c5b4)
+	 Link 0: Rx0: 46118411 KBytes, Tx0: 48816313 KBytes
+	 Link 1: Rx0: 49460025 KBytes, Tx0: 40137358 KBytes
+	 Link 2: Rx0: 44888088 KBytes, Tx0: 54057511 KBytes
+	 Link 3: Rx0: 57937896 KBytes, Tx0: 52390093 KBytes
+	 Link 4: Rx0: 59003258 KBytes, Tx0: 50690833 KBytes
+	 Link 5: Rx0: 44210796 KBytes, Tx0: 57297022 KBytes
+	 Link 6: Rx0: 41811700 KBytes, Tx0: 55322420 KBytes
+	 Link 7: Rx0: 58766045 KBytes, Tx0: 53166051 KBytes
+	 Link 8: Rx0: 53357000 KBytes, Tx0: 53387508 KBytes
+	 Link 9: Rx0: 53224473 KBytes, Tx0: 43474128 KBytes
+	 Link 10: Rx0: 56157224 KBytes, Tx0: 53436625 KBytes
+	 Link 11: Rx0: 42088690 KBytes, Tx0: 46395794 KBytes
+GPU 7: NVIDIA A100-SXM4-40GB (UUID: GPU-4a5b6c7d-8e9f-0a1b-2c3d-4e5f6a7b8c9d)
+	 Link 0: Rx0: 42259810 KBytes, Tx0: 47004930 KBytes
+	 Link 1: Rx0: 54784984 KBytes, Tx0: 45445991 KBytes
+	 Link 2: Rx0: 43688581 KBytes, Tx0: 51410307 KBytes
+	 Link 3: Rx0: 41764144 KBytes, Tx0: 43435289 KBytes
+	 Link 4: Rx0: 40007827 KBytes, Tx0: 59018102 KBytes
+	 Link 5: Rx0: 45075608 KBytes, Tx0: 58005935 KBytes
+	 Link 6: Rx0: 43404579 KBytes, Tx0: 52200724 KBytes
+	 Link 7: Rx0: 40855667 KBytes, Tx0: 42359399 KBytes
+	 Link 8: Rx0: 46977734 KBytes, Tx0: 52624162 KBytes
+	 Link 9: Rx0: 44984527 KBytes, Tx0: 48464365 KBytes
+	 Link 10: Rx0: 51656458 KBytes, Tx0: 52219297 KBytes
+	 Link 11: Rx0: 55909883 KBytes, Tx0: 44121901 KBytes
+`
+
+func TestParseNVLinkCounters(t *testing.T) {
+	counters := parseNVLinkCounters([]byte(nvlinkDGXA100Fixture))
+	require.Len(t, counters, 8)
+	assert.Equal(t, nvlinkCounters{rx: 600699615, tx: 575702124}, counters["0"])
+	assert.Equal(t, nvlinkCounters{rx: 551369802, tx: 596310402}, counters["7"])
+
+	assert.Empty(t, parseNVLinkCounters([]byte("GPU 0: NVIDIA GeForce RTX 3090 (UUID: GPU-1234)\n")))
+	assert.Empty(t, parseNVLinkCounters(nil))
+}
+
+func TestNVLinkCollectorUpdate(t *testing.T) {
+	gm := &GPUManager{
+		GpuDataMap: map[string]*system.GPUData{
+			"0": {Name: "A100-SXM4-40GB", Count: 1},
+			"7": {Name: "A100-SXM4-40GB", Count: 1},
+		},
+	}
+	c := &NVLinkCollector{gm: gm}
+	start := time.Now()
+	first := parseNVLinkCounters([]byte(nvlinkDGXA100Fixture))
+
+	// first poll only records the counters
+	c.update(first, start)
+	assert.Zero(t, gm.GpuDataMap["0"].NVLinkRxBandwidth)
+
+	second := maps.Clone(first)
+	second["0"] = nvlinkCounters{rx: first["0"].rx + 1_024_000, tx: first["0"].tx + 2_048_000}
+	// counter reset
+	second["7"] = nvlinkCounters{rx: 10, tx: 10}
+	c.update(second, start.Add(4*time.Second))
+
+	assert.Equal(t, 250.0, gm.GpuDataMap["0"].NVLinkRxBandwidth)
+	assert.Equal(t, 500.0, gm.GpuDataMap["0"].NVLinkTxBandwidth)
+	assert.Zero(t, gm.GpuDataMap["7"].NVLinkRxBandwidth)
+	assert.Zero(t, gm.GpuDataMap["7"].NVLinkTxBandwidth)
+
+	result := gm.GetCurrentData()
+	assert.Equal(t, 250.0, result["0"].NVLinkRxBandwidth)
+	assert.Equal(t, 500.0, result["0"].NVLinkTxBandwidth)
+}
+
+func TestNVLinkCollectorStart(t *testing.T) {
+	gm := &GPUManager{GpuDataMap: map[string]*system.GPUData{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	c := &NVLinkCollector{
+		gm: gm,
+		run: func(context.Context) ([]byte, error) {
+			calls++
+			cancel()
+			return nil, errors.New("nvidia-smi failed")
+		},
+	}
+	done := make(chan struct{})
+	go func() {
+		c.start(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("collector did not stop after cancel")
+	}
+	assert.Equal(t, 1, calls)
+}
@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"log/slog"
+	"time"
+)
+
+// AgentOption configures an Agent created by NewAgent. Options override the
+// settings read from environment variables.
+type AgentOption func(*Agent)
+
+// WithGPUManager uses gm for GPU data instead of detecting GPUs
+func WithGPUManager(gm *GPUManager) AgentOption {
+	return func(a *Agent) {
+		a.gpuManager = gm
+	}
+}
+
+// WithLogger sets the logger used for the agent's stats. Debug output, such as
+// the stats logged at startup, is enabled if l is enabled for debug.
+func WithLogger(l *slog.Logger) AgentOption {
+	return func(a *Agent) {
+		a.log = l
+	}
+}
+
+// WithCollectionInterval collects CPU, disk, and network stats in the background
+// every d, overriding CPU_INTERVAL, DISK_INTERVAL, and NETWORK_INTERVAL. A zero
+// interval collects them on each request.
+func WithCollectionInterval(d time.Duration) AgentOption {
+	return func(a *Agent) {
+		for _, s := range []*subsystem{a.cpuStats, a.diskStats, a.netStats} {
+			s.interval = max(d, 0)
+		}
+	}
+}
+
+// WithPrewarmInterval gathers stats in the background every d, overriding
+// PREWARM_INTERVAL, so hub requests are answered without waiting for collection.
+// Zero disables pre-warming.
+func WithPrewarmInterval(d time.Duration) AgentOption {
+	return func(a *Agent) {
+		a.prewarmInterval = max(d, 0)
+	}
+}
+
+// WithCollectionTimeout bounds how long a stats request waits for collection,
+// overriding COLLECTION_TIMEOUT. Zero disables the timeout.
+func WithCollectionTimeout(d time.Duration) AgentOption {
+	return func(a *Agent) {
+		a.collectionTimeout = max(d, 0)
+	}
+}
+
+// WithStatsBuffer keeps up to n stats samples while the hub is unreachable,
+// overriding BESZEL_BUFFER_SIZE. Zero disables buffering.
+func WithStatsBuffer(n int) AgentOption {
+	return func(a *Agent) {
+		a.statsBuffer = nil
+		if n > 0 {
+			a.statsBuffer = NewStatsRingBuffer(n)
+		}
+	}
+}
+
+// logger returns the logger set with WithLogger, or the default logger
+func (a *Agent) logger() *slog.Logger {
+	if a.log == nil {
+		return slog.Default()
+	}
+	return a.log
+}
@@ -0,0 +1,167 @@
+//go:build linux
+
+package agent
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// perfGroup is a pair of hardware counters on one CPU read together, so the
+// ratio between them stays accurate when the kernel multiplexes counters
+type perfGroup struct {
+	leader int // counts total events (references or instructions)
+	member int // counts misses
+}
+
+// PerfCollector reads LLC and branch miss rates from hardware perf counters
+type PerfCollector struct {
+	cache  []perfGroup
+	branch []perfGroup
+	// totals from the previous Collect, used to compute rates over the interval
+	prevCache  [2]uint64
+	prevBranch [2]uint64
+}
+
+// newPerfCollector returns a collector if BESZEL_ENABLE_PERF is "true", or nil otherwise.
+// System-wide counters require CAP_PERFMON or kernel.perf_event_paranoid <= 0.
+func newPerfCollector() *PerfCollector {
+	if enabled, _ := GetEnvFallback("BESZEL_AGENT_ENABLE_PERF", "BESZEL_ENABLE_PERF"); enabled != "true" {
+		return nil
+	}
+	c, err := openPerfCollector()
+	if err != nil {
+		slog.Warn("Perf counters unavailable", "err", err)
+		return nil
+	}
+	return c
+}
+
+// openPerfCollector opens cache and branch counter groups on every CPU
+func openPerfCollector() (*PerfCollector, error) {
+	c := &PerfCollector{}
+	var lastErr error
+	for cpu := range runtime.NumCPU() {
+		cache, err := openPerfGroup(cpu, unix.PERF_COUNT_HW_CACHE_REFERENCES, unix.PERF_COUNT_HW_CACHE_MISSES)
+		if err != nil {
+			// offline CPUs can't be opened
+			lastErr = err
+			continue
+		}
+		branch, err := openPerfGroup(cpu, unix.PERF_COUNT_HW_BRANCH_INSTRUCTIONS, unix.PERF_COUNT_HW_BRANCH_MISSES)
+		if err != nil {
+			cache.close()
+			lastErr = err
+			continue
+		}
+		c.cache = append(c.cache, cache)
+		c.branch = append(c.branch, branch)
+	}
+	if len(c.cache) == 0 {
+		return nil, fmt.Errorf("failed to open perf counters: %w", lastErr)
+	}
+	c.prevCache, _ = sumPerfGroups(c.cache)
+	c.prevBranch, _ = sumPerfGroups(c.branch)
+	return c, nil
+}
+
+// openPerfGroup opens a system-wide counter group on the given CPU
+func openPerfGroup(cpu int, total, misses uint64) (perfGroup, error) {
+	attr := unix.PerfEventAttr{
+		Type:        unix.PERF_TYPE_HARDWARE,
+		Size:        uint32(unsafe.Sizeof(unix.PerfEventAttr{})),
+		Config:      total,
+		Read_format: unix.PERF_FORMAT_GROUP,
+	}
+	leader, err := unix.PerfEventOpen(&attr, -1, cpu, -1, unix.PERF_FLAG_FD_CLOEXEC)
+	if err != nil {
+		return perfGroup{}, err
+	}
+	attr.Config = misses
+	member, err := unix.PerfEventOpen(&attr, -1, cpu, leader, unix.PERF_FLAG_FD_CLOEXEC)
+	if err != nil {
+		unix.Close(leader)
+		return perfGroup{}, err
+	}
+	return perfGroup{leader: leader, member: member}, nil
+}
+
+// read returns the total and miss counts since the group was opened
+func (g perfGroup) read() (total, misses uint64, err error) {
+	// PERF_FORMAT_GROUP layout: nr, then one value per counter
+	var buf [24]byte
+	n, err := unix.Read(g.leader, buf[:])
+	if err != nil {
+		return 0, 0, err
+	}
+	if n < len(buf) || binary.NativeEndian.Uint64(buf[0:]) != 2 {
+		return 0, 0, errors.New("unexpected perf group read")
+	}
+	return binary.NativeEndian.Uint64(buf[8:]), binary.NativeEndian.Uint64(buf[16:]), nil
+}
+
+func (g perfGroup) close() {
+	unix.Close(g.member)
+	unix.Close(g.leader)
+}
+
+// sumPerfGroups returns the total and miss counts of all groups
+func sumPerfGroups(groups []perfGroup) (sum [2]uint64, err error) {
+	for _, g := range groups {
+		total, misses, err := g.read()
+		if err != nil {
+			return sum, err
+		}
+		sum[0] += total
+		sum[1] += misses
+	}
+	return sum, nil
+}
+
+// missRate returns the percentage of misses between two samples
+func missRate(prev, cur [2]uint64) float64 {
+	if cur[0] <= prev[0] {
+		return 0
+	}
+	return float64(cur[1]-prev[1]) / float64(cur[0]-prev[0]) * 100
+}
+
+// Collect returns the LLC and branch miss rates (percent) since the last call
+func (c *PerfCollector) Collect() (llcMissRate, branchMissRate float64, err error) {
+	if c == nil {
+		return 0, 0, nil
+	}
+	cache, err := sumPerfGroups(c.cache)
+	if err != nil {
+		return 0, 0, err
+	}
+	branch, err := sumPerfGroups(c.branch)
+	if err != nil {
+		return 0, 0, err
+	}
+	llcMissRate = missRate(c.prevCache, cache)
+	branchMissRate = missRate(c.prevBranch, branch)
+	c.prevCache, c.prevBranch = cache, branch
+	return llcMissRate, branchMissRate, nil
+}
+
+// Close releases all perf counters
+func (c *PerfCollector) Close() error {
+	if c == nil {
+		return nil
+	}
+	for _, g := range c.cache {
+		g.close()
+	}
+	for _, g := range c.branch {
+		g.close()
+	}
+	c.cache, c.branch = nil, nil
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package agent
+
+import "log/slog"
+
+// PerfCollector is only supported on Linux
+type PerfCollector struct{}
+
+func newPerfCollector() *PerfCollector {
+	if enabled, _ := GetEnvFallback("BESZEL_AGENT_ENABLE_PERF", "BESZEL_ENABLE_PERF"); enabled == "true" {
+		slog.Warn("Perf counters are only supported on Linux")
+	}
+	return nil
+}
+
+func (c *PerfCollector) Collect() (llcMissRate, branchMissRate float64, err error) { return 0, 0, nil }
+
+func (c *PerfCollector) Close() error { return nil }
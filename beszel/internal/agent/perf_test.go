@@ -0,0 +1,61 @@
+//go:build testing && linux
+// +build testing,linux
+
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMissRate(t *testing.T) {
+	assert.Equal(t, 25.0, missRate([2]uint64{100, 10}, [2]uint64{200, 35}))
+	// no new events
+	assert.Equal(t, 0.0, missRate([2]uint64{100, 10}, [2]uint64{100, 10}))
+}
+
+func TestNewPerfCollectorDisabled(t *testing.T) {
+	t.Setenv("BESZEL_AGENT_ENABLE_PERF", "")
+	t.Setenv("BESZEL_ENABLE_PERF", "")
+	assert.Nil(t, newPerfCollector())
+
+	var c *PerfCollector
+	llc, branch, err := c.Collect()
+	assert.NoError(t, err)
+	assert.Zero(t, llc)
+	assert.Zero(t, branch)
+	assert.NoError(t, c.Close())
+}
+
+func TestPerfCollector(t *testing.T) {
+	c, err := openPerfCollector()
+	if err != nil {
+		t.Skipf("hardware perf counters not available: %v", err)
+	}
+	defer c.Close()
+
+	// tight loop with unpredictable branches and a working set larger than most caches
+	buf := make([]byte, 64<<20)
+	x := uint64(1)
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		for range 100_000 {
+			x ^= x << 13
+			x ^= x >> 7
+			x ^= x << 17
+			if x&1 == 0 {
+				buf[x%uint64(len(buf))]++
+			}
+		}
+	}
+
+	llc, branch, err := c.Collect()
+	require.NoError(t, err)
+	assert.Greater(t, llc, 0.0)
+	assert.Greater(t, branch, 0.0)
+	assert.LessOrEqual(t, llc, 100.0)
+	assert.LessOrEqual(t, branch, 100.0)
+}
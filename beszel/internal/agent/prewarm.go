@@ -0,0 +1,45 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"context"
+	"time"
+)
+
+// session ID used to gather pre-warmed stats
+const prewarmSessionID = "prewarm"
+
+// runPrewarm gathers stats every prewarmInterval until ctx is cancelled
+func (a *Agent) runPrewarm(ctx context.Context) {
+	ticker := time.NewTicker(a.prewarmInterval)
+	defer ticker.Stop()
+	for {
+		// copy since gatherStats returns the cache, which is overwritten by the next call
+		if gathered, err := a.gatherStats(prewarmSessionID); err == nil {
+			stats := *gathered
+			a.prewarmed.Store(&stats)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// requestStats returns the latest pre-warmed stats, or gathers stats for the
+// session if pre-warming is disabled or has not finished its first collection
+func (a *Agent) requestStats(sessionID string) (*system.CombinedData, error) {
+	prewarmed := a.prewarmed.Load()
+	if prewarmed == nil {
+		return a.gatherStats(sessionID)
+	}
+	stats := *prewarmed
+	// the topology and capabilities are sent once per session, which the pre-warmed stats don't know about
+	stats.Stats.GPUTopology = nil
+	stats.Capabilities = nil
+	a.attachGPUTopology(sessionID, &stats.Stats)
+	a.attachCapabilities(sessionID, &stats)
+	return &stats, nil
+}
@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestPrewarm(t *testing.T) {
+	_, privKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(privKey)
+	require.NoError(t, err)
+
+	agent := NewAgent(WithPrewarmInterval(time.Hour))
+	t.Cleanup(func() { agent.Shutdown(context.Background()) })
+	require.Eventually(t, func() bool {
+		return agent.prewarmed.Load() != nil
+	}, 5*time.Second, 10*time.Millisecond, "stats are gathered when the agent starts")
+
+	addr := "127.0.0.1:45998"
+	go agent.StartServer(ServerOptions{
+		Network: "tcp",
+		Addr:    addr,
+		Keys:    []ssh.PublicKey{signer.PublicKey()},
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	requestStats := func() system.CombinedData {
+		client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+			User:            "a",
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         4 * time.Second,
+		})
+		require.NoError(t, err)
+		defer client.Close()
+		session, err := client.NewSession()
+		require.NoError(t, err)
+		defer session.Close()
+		output, err := session.Output("")
+		require.NoError(t, err)
+		var stats system.CombinedData
+		require.NoError(t, json.Unmarshal(output, &stats))
+		return stats
+	}
+
+	// no snapshot yet, so stats are gathered on demand
+	hostname := agent.prewarmed.Load().Info.Hostname
+	agent.prewarmed.Store(nil)
+	assert.Equal(t, hostname, requestStats().Info.Hostname)
+
+	// the snapshot is sent as is
+	snapshot := &system.CombinedData{Info: system.Info{Hostname: "prewarmed"}, Stats: system.Stats{Cpu: 42}}
+	agent.prewarmed.Store(snapshot)
+	stats := requestStats()
+	assert.Equal(t, "prewarmed", stats.Info.Hostname)
+	assert.Equal(t, 42.0, stats.Stats.Cpu)
+}
+
+func TestPrewarmDisabled(t *testing.T) {
+	agent := NewAgent(WithPrewarmInterval(0))
+	t.Cleanup(func() { agent.Shutdown(context.Background()) })
+	assert.Nil(t, agent.prewarmed.Load())
+	stats, err := agent.requestStats("session")
+	require.NoError(t, err)
+	assert.NotEmpty(t, stats.Info.Hostname)
+	assert.Nil(t, agent.prewarmed.Load())
+}
@@ -0,0 +1,221 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"bufio"
+	"cmp"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// RemoteFSCollector reads I/O counters of NFS and CIFS mounts, which are not
+// in /proc/diskstats
+type RemoteFSCollector struct {
+	mountstats string // per mount NFS statistics, /proc/self/mountstats
+	cifsStats  string // per share CIFS statistics, /proc/fs/cifs/Stats
+	mounts     string // mounted filesystems, /proc/mounts, used to find CIFS mount points
+}
+
+// newRemoteFSCollector returns a collector for the proc filesystem at procPath,
+// or nil if mountstats can't be read (e.g. not on Linux)
+func newRemoteFSCollector(procPath string) *RemoteFSCollector {
+	c := &RemoteFSCollector{
+		mountstats: filepath.Join(procPath, "self", "mountstats"),
+		cifsStats:  filepath.Join(procPath, "fs", "cifs", "Stats"),
+		mounts:     filepath.Join(procPath, "mounts"),
+	}
+	if _, err := os.Stat(c.mountstats); err != nil {
+		slog.Debug("Remote filesystems", "err", err)
+		return nil
+	}
+	return c
+}
+
+// Collect returns the cumulative I/O counters of each NFS and CIFS mount,
+// sorted by mount point
+func (c *RemoteFSCollector) Collect() []system.RemoteFSEntry {
+	if c == nil {
+		return nil
+	}
+	var entries []system.RemoteFSEntry
+	if file, err := os.Open(c.mountstats); err == nil {
+		entries, err = parseNFSMountstats(file)
+		file.Close()
+		if err != nil {
+			slog.Debug("NFS stats", "err", err)
+		}
+	}
+	// the CIFS module is not loaded if there are no CIFS mounts
+	if file, err := os.Open(c.cifsStats); err == nil {
+		shares, err := parseCIFSStats(file)
+		file.Close()
+		if err != nil {
+			slog.Debug("CIFS stats", "err", err)
+		}
+		if len(shares) > 0 {
+			entries = append(entries, c.cifsEntries(shares)...)
+		}
+	}
+	slices.SortFunc(entries, func(a, b system.RemoteFSEntry) int {
+		return cmp.Compare(a.Mount, b.Mount)
+	})
+	return entries
+}
+
+// cifsEntries sets the mount point of each CIFS share from /proc/mounts, where
+// the share \\server\share is listed as //server/share
+func (c *RemoteFSCollector) cifsEntries(shares map[string]system.RemoteFSEntry) []system.RemoteFSEntry {
+	file, err := os.Open(c.mounts)
+	if err != nil {
+		slog.Debug("CIFS stats", "err", err)
+		return nil
+	}
+	defer file.Close()
+	var entries []system.RemoteFSEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || (fields[2] != "cifs" && fields[2] != "smb3") {
+			continue
+		}
+		share := strings.ReplaceAll(fields[0], "/", `\`)
+		if entry, ok := shares[share]; ok {
+			entry.Mount = unescapeMountPath(fields[1])
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// parseNFSMountstats returns the I/O counters of each NFS mount in
+// /proc/self/mountstats. Bytes are those transferred to and from the server,
+// and ops are READ and WRITE RPCs.
+//
+//	device 10.0.0.5:/export/data mounted on /mnt/data with fstype nfs4 statvers=1.1
+//		bytes:	1048576 524288 0 0 1056768 524288 258 128
+//		per-op statistics
+//		        READ: 120 120 0 21120 1056768 10 200 220 0
+//		       WRITE: 64 64 0 536870912 9216 5 300 310 0
+func parseNFSMountstats(r io.Reader) ([]system.RemoteFSEntry, error) {
+	var entries []system.RemoteFSEntry
+	var current *system.RemoteFSEntry
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "device" {
+			current = nil
+			// device <source> mounted on <mount> with fstype <type> ...
+			if len(fields) >= 8 && strings.HasPrefix(fields[7], "nfs") {
+				entries = append(entries, system.RemoteFSEntry{Mount: unescapeMountPath(fields[4]), Protocol: fields[7]})
+				current = &entries[len(entries)-1]
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		switch fields[0] {
+		case "bytes:":
+			// normal read, normal write, direct read, direct write, server read, server write, ...
+			if len(fields) >= 7 {
+				current.ReadBytes, _ = strconv.ParseUint(fields[5], 10, 64)
+				current.WriteBytes, _ = strconv.ParseUint(fields[6], 10, 64)
+			}
+		case "READ:":
+			if len(fields) >= 2 {
+				current.ReadOps, _ = strconv.ParseUint(fields[1], 10, 64)
+			}
+		case "WRITE:":
+			if len(fields) >= 2 {
+				current.WriteOps, _ = strconv.ParseUint(fields[1], 10, 64)
+			}
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// parseCIFSStats returns the I/O counters of each share in /proc/fs/cifs/Stats,
+// keyed by share (e.g. \\server\share). SMB2 and later report bytes on their
+// own line, SMB1 after the read and write counts.
+//
+//	Max requests in flight: 3
+//	1) \\fileserver\media
+//	SMBs: 2104
+//	Bytes read: 734003200  Bytes written: 10485760
+//	Reads: 700 total 0 failed
+//	Writes: 10 total 0 failed
+func parseCIFSStats(r io.Reader) (map[string]system.RemoteFSEntry, error) {
+	shares := make(map[string]system.RemoteFSEntry)
+	var share string
+	var entry system.RemoteFSEntry
+	save := func() {
+		if share != "" {
+			shares[share] = entry
+		}
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if strings.HasSuffix(fields[0], ")") && strings.HasPrefix(fields[1], `\\`) {
+			save()
+			share, entry = fields[1], system.RemoteFSEntry{Protocol: "cifs"}
+			continue
+		}
+		if share == "" {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "Bytes read:"):
+			// Bytes read: <n>  Bytes written: <n>
+			if len(fields) >= 6 {
+				entry.ReadBytes, _ = strconv.ParseUint(fields[2], 10, 64)
+				entry.WriteBytes, _ = strconv.ParseUint(fields[5], 10, 64)
+			}
+		case fields[0] == "Reads:":
+			entry.ReadOps, _ = strconv.ParseUint(fields[1], 10, 64)
+			// SMB1: Reads: <n> Bytes: <n>
+			if len(fields) >= 4 && fields[2] == "Bytes:" {
+				entry.ReadBytes, _ = strconv.ParseUint(fields[3], 10, 64)
+			}
+		case fields[0] == "Writes:":
+			entry.WriteOps, _ = strconv.ParseUint(fields[1], 10, 64)
+			if len(fields) >= 4 && fields[2] == "Bytes:" {
+				entry.WriteBytes, _ = strconv.ParseUint(fields[3], 10, 64)
+			}
+		}
+	}
+	save()
+	return shares, scanner.Err()
+}
+
+// unescapeMountPath decodes the octal escapes used for spaces and other
+// characters in mount paths, e.g. /mnt/my\040share
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+	var sb strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if b, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(b))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(path[i])
+	}
+	return sb.String()
+}
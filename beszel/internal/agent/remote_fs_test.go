@@ -0,0 +1,131 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// /proc/self/mountstats with an active NFSv4 mount, an NFSv3 mount with a space
+// in its path, and a local filesystem
+const mountstatsFixture = `device /dev/vda1 mounted on / with fstype ext4
+device proc mounted on /proc with fstype proc
+device 10.0.0.5:/export/data mounted on /mnt/data with fstype nfs4 statvers=1.1
+	opts:	rw,vers=4.2,rsize=1048576,wsize=1048576,namlen=255,acregmin=3,acregmax=60,hard,proto=tcp,timeo=600,retrans=2,sec=sys
+	age:	86400
+	caps:	caps=0x3ffbffff,wtmult=512,dtsize=1048576,bsize=0,namlen=255
+	sec:	flavor=1,pseudoflavor=1
+	events:	5321 82210 112 1433 2011 1310 96542 12288 0 81 12288 0 0 44 0 0 0 0 0 0 0 0 0 0 0 0 0
+	bytes:	2147483648 536870912 0 0 2151677952 536870912 525312 131072
+	RPC iostats version: 1.1  p/v: 100003/4 (nfs)
+	xprt:	tcp 0 0 1 0 12 48210 48210 0 48212 0 2 0 1
+	per-op statistics
+	        NULL: 1 1 0 44 24 0 0 0 0
+	        READ: 2052 2052 0 361152 2151940096 22 6042 6120 0
+	       WRITE: 512 512 0 537010176 81920 10 9001 9050 0
+	      COMMIT: 12 12 0 2112 1392 0 40 41 0
+
+device 10.0.0.5:/export/backups mounted on /mnt/nightly\040backups with fstype nfs statvers=1.1
+	bytes:	0 4096 0 0 0 4096 0 1
+	per-op statistics
+	        READ: 0 0 0 0 0 0 0 0 0
+	       WRITE: 1 1 0 4264 136 0 2 2 0
+`
+
+// /proc/fs/cifs/Stats with an SMB3 share and an SMB1 share
+const cifsStatsFixture = `Resources in use
+CIFS Session: 2
+Share (unique mount targets): 3
+SMB Request/Response Buffer: 2 Pool size: 6
+SMB Small Req/Resp Buffer: 2 Pool size: 30
+Operations (MIDs): 0
+
+0 session 0 share reconnects
+Total vfs operations: 2104 maximum at one time: 2
+
+Max requests in flight: 3
+1) \\fileserver\media
+SMBs: 2104
+Bytes read: 734003200  Bytes written: 10485760
+Open files: 2 total (local), 2 open on server
+TreeConnects: 1 total 0 failed
+Creates: 20 total 0 failed
+Closes: 18 total 0 failed
+Reads: 700 total 0 failed
+Writes: 10 total 0 failed
+2) \\nas\legacy
+SMBs: 95 Oplocks breaks: 0
+Reads:  40 Bytes: 163840
+Writes: 3 Bytes: 12288
+Flushes: 0
+3) \\fileserver\unmounted
+SMBs: 1
+`
+
+const remoteMountsFixture = `/dev/vda1 / ext4 rw,relatime 0 0
+10.0.0.5:/export/data /mnt/data nfs4 rw,relatime 0 0
+//fileserver/media /mnt/media cifs rw,relatime,vers=3.1.1 0 0
+//nas/legacy /mnt/legacy\040share cifs rw,relatime,vers=1.0 0 0
+`
+
+func TestParseNFSMountstats(t *testing.T) {
+	entries, err := parseNFSMountstats(strings.NewReader(mountstatsFixture))
+	require.NoError(t, err)
+	assert.Equal(t, []system.RemoteFSEntry{
+		{Mount: "/mnt/data", Protocol: "nfs4", ReadOps: 2052, WriteOps: 512, ReadBytes: 2151677952, WriteBytes: 536870912},
+		{Mount: "/mnt/nightly backups", Protocol: "nfs", WriteOps: 1, WriteBytes: 4096},
+	}, entries)
+}
+
+func TestParseCIFSStats(t *testing.T) {
+	shares, err := parseCIFSStats(strings.NewReader(cifsStatsFixture))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]system.RemoteFSEntry{
+		`\\fileserver\media`:     {Protocol: "cifs", ReadOps: 700, WriteOps: 10, ReadBytes: 734003200, WriteBytes: 10485760},
+		`\\nas\legacy`:           {Protocol: "cifs", ReadOps: 40, WriteOps: 3, ReadBytes: 163840, WriteBytes: 12288},
+		`\\fileserver\unmounted`: {Protocol: "cifs"},
+	}, shares)
+}
+
+func TestRemoteFSCollector(t *testing.T) {
+	proc := t.TempDir()
+	writeFile := func(path, content string) {
+		path = filepath.Join(proc, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	writeFile("self/mountstats", mountstatsFixture)
+	writeFile("mounts", remoteMountsFixture)
+
+	c := newRemoteFSCollector(proc)
+	require.NotNil(t, c)
+	// without the CIFS module
+	entries := c.Collect()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "/mnt/data", entries[0].Mount)
+
+	writeFile("fs/cifs/Stats", cifsStatsFixture)
+	entries = c.Collect()
+	mounts := make([]string, len(entries))
+	for i, entry := range entries {
+		mounts[i] = entry.Mount
+	}
+	assert.Equal(t, []string{"/mnt/data", "/mnt/legacy share", "/mnt/media", "/mnt/nightly backups"}, mounts)
+	assert.Equal(t, system.RemoteFSEntry{
+		Mount: "/mnt/media", Protocol: "cifs", ReadOps: 700, WriteOps: 10, ReadBytes: 734003200, WriteBytes: 10485760,
+	}, entries[2])
+
+	t.Run("missing proc", func(t *testing.T) {
+		c := newRemoteFSCollector(filepath.Join(t.TempDir(), "missing"))
+		assert.Nil(t, c)
+		assert.Nil(t, c.Collect())
+	})
+}
@@ -0,0 +1,239 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"bufio"
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	// limit for connecting to a remote GPU host, including the SSH handshake
+	remoteGPUConnectTimeout = 10 * time.Second
+)
+
+// remoteNvidiaSmiCommand returns the nvidia-smi command run on remote GPU hosts to query fields
+func remoteNvidiaSmiCommand(fields []string) string {
+	return nvidiaSmiCmd + " -l " + nvidiaSmiInterval + " " + nvidiaQuery(fields) + " --format=csv,noheader,nounits"
+}
+
+// remoteGPURetryPolicy reconnects to an unreachable host indefinitely, backing off up to a minute
+var remoteGPURetryPolicy = RetryPolicy{
+	BackoffBase: retryWaitTime,
+	BackoffMax:  time.Minute,
+}
+
+// RemoteGPUCollector collects Nvidia GPU data from hosts that are only reachable
+// over SSH, by running nvidia-smi on each host and parsing its output like the
+// local nvidia-smi collector. Remote GPU names are prefixed with the host.
+type RemoteGPUCollector struct {
+	config ssh.ClientConfig // shared client config, User is set per host
+	hosts  []*remoteGPUHost
+}
+
+// remoteGPUHost is a host from BESZEL_REMOTE_GPU_HOSTS
+type remoteGPUHost struct {
+	user string
+	addr string      // host:port to connect to
+	name string      // host without the port, used in GPU names and ids
+	gm   *GPUManager // accumulates the GPU data parsed from the host
+}
+
+// newRemoteGPUCollector returns a collector for the user@host entries in
+// BESZEL_REMOTE_GPU_HOSTS, or nil if it is not set. The private key is read from
+// BESZEL_REMOTE_GPU_KEY and host keys are verified with BESZEL_REMOTE_GPU_KNOWN_HOSTS,
+// defaulting to ~/.ssh/id_ed25519 and ~/.ssh/known_hosts.
+func newRemoteGPUCollector(alerter *SyslogAlerter) *RemoteGPUCollector {
+	hostList, _ := GetEnvFallback("BESZEL_AGENT_REMOTE_GPU_HOSTS", "BESZEL_REMOTE_GPU_HOSTS")
+	if strings.TrimSpace(hostList) == "" {
+		return nil
+	}
+	c, err := remoteGPUCollectorFromEnv(hostList, alerter)
+	if err != nil {
+		slog.Warn("Remote GPU", "err", err)
+		return nil
+	}
+	return c
+}
+
+// remoteGPUCollectorFromEnv reads the SSH credentials and returns a collector for hostList
+func remoteGPUCollectorFromEnv(hostList string, alerter *SyslogAlerter) (*RemoteGPUCollector, error) {
+	home, _ := os.UserHomeDir()
+	keyPath, _ := GetEnvFallback("BESZEL_AGENT_REMOTE_GPU_KEY", "BESZEL_REMOTE_GPU_KEY")
+	keyPath = cmp.Or(keyPath, filepath.Join(home, ".ssh", "id_ed25519"))
+	knownHostsPath, _ := GetEnvFallback("BESZEL_AGENT_REMOTE_GPU_KNOWN_HOSTS", "BESZEL_REMOTE_GPU_KNOWN_HOSTS")
+	knownHostsPath = cmp.Or(knownHostsPath, filepath.Join(home, ".ssh", "known_hosts"))
+
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyPath, err)
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &RemoteGPUCollector{config: ssh.ClientConfig{
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         remoteGPUConnectTimeout,
+	}}
+	for spec := range strings.SplitSeq(hostList, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		host, err := parseRemoteGPUHost(spec)
+		if err != nil {
+			return nil, err
+		}
+		host.gm = &GPUManager{
+			GpuDataMap: make(map[string]*system.GPUData),
+			namePrefix: host.name + " ",
+			opts: GPUManagerOptions{
+				TempWarnThreshold: defaultTempWarnThreshold,
+				TempCritThreshold: defaultTempCritThreshold,
+				Alerter:           alerter,
+			},
+		}
+		c.hosts = append(c.hosts, host)
+	}
+	return c, nil
+}
+
+// parseRemoteGPUHost parses a user@host entry, where host may include a port
+func parseRemoteGPUHost(spec string) (*remoteGPUHost, error) {
+	user, addr, ok := strings.Cut(spec, "@")
+	if !ok || user == "" || addr == "" {
+		return nil, fmt.Errorf("invalid remote GPU host %q, expected user@host", spec)
+	}
+	name, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		name, addr = addr, net.JoinHostPort(addr, "22")
+	}
+	return &remoteGPUHost{user: user, addr: addr, name: name}, nil
+}
+
+// start collects data from each host until ctx is cancelled, reconnecting after failures
+func (c *RemoteGPUCollector) start(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, host := range c.hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			host.run(ctx, c.config)
+		}()
+	}
+	wg.Wait()
+}
+
+// run keeps nvidia-smi running on the host until ctx is cancelled
+func (h *remoteGPUHost) run(ctx context.Context, config ssh.ClientConfig) {
+	failures := 0
+	for {
+		err := h.collect(ctx, config)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			failures++
+			slog.Warn("Remote GPU failed, reconnecting", "host", h.addr, "err", err)
+		} else {
+			failures = 0
+		}
+		if !sleepContext(ctx, remoteGPURetryPolicy.backoff(failures)) {
+			return
+		}
+	}
+}
+
+// collect connects to the host and parses the output of nvidia-smi until it
+// exits, the connection is lost, or ctx is cancelled
+func (h *remoteGPUHost) collect(ctx context.Context, config ssh.ClientConfig) error {
+	config.User = h.user
+	dialer := net.Dialer{Timeout: config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", h.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// closing the connection ends the session and unblocks reads
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	conn.SetDeadline(time.Now().Add(config.Timeout))
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, h.addr, &config)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+	client := ssh.NewClient(clientConn, chans, reqs)
+	defer client.Close()
+
+	// the driver on the host may not support all fields, and may change between connections
+	fields := detectNvidiaColumns(func(query string) bool {
+		session, err := client.NewSession()
+		if err != nil {
+			return false
+		}
+		defer session.Close()
+		return session.Run(nvidiaSmiCmd+" "+query+" --format=csv,noheader,nounits") == nil
+	})
+	h.gm.Lock()
+	h.gm.nvidiaCols = newNvidiaColumnIndex(fields)
+	h.gm.Unlock()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := session.Start(remoteNvidiaSmiCommand(fields)); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, cmdBufferSize), bufio.MaxScanTokenSize)
+	for scanner.Scan() {
+		if !h.gm.parseNvidiaData(scanner.Bytes()) {
+			return errNoValidData
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("scanner error: %w", err)
+	}
+	return session.Wait()
+}
+
+// GetCurrentData returns the averaged data of all remote GPUs since the last call,
+// keyed by host and GPU index, e.g. "gpu-node-1/0"
+func (c *RemoteGPUCollector) GetCurrentData() map[string]system.GPUData {
+	if c == nil {
+		return nil
+	}
+	data := make(map[string]system.GPUData)
+	for _, host := range c.hosts {
+		for id, gpu := range host.gm.GetCurrentData() {
+			data[host.name+"/"+id] = gpu
+		}
+	}
+	return data
+}
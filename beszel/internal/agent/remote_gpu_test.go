@@ -0,0 +1,182 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sshServer "github.com/gliderlabs/ssh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// nvidia-smi output of a DGX node with two GPUs
+const remoteNvidiaSmiFixture = `0, NVIDIA A100-SXM4-40GB, 52, 10240, 40960, 87, 312.45, 0, 400.00, 4, 16, 0, 41, 400.00
+1, NVIDIA A100-SXM4-40GB, 38, 512, 40960, 2, 58.10, 0, 400.00, 4, 16, 0, 0, 400.00
+`
+
+// remoteGPUTestServer is a mock SSH server that replies to commands with the nvidia-smi fixture
+type remoteGPUTestServer struct {
+	addr      string
+	hostKey   ssh.PublicKey
+	clientKey ed25519.PrivateKey // the only client key accepted
+	commands  chan [2]string     // user and command of each nvidia-smi -l session
+	probes    atomic.Int32       // sessions that only probe the queried fields
+}
+
+// newRemoteGPUTestServer starts a server on a random port
+func newRemoteGPUTestServer(t *testing.T) *remoteGPUTestServer {
+	hostSigner := newTestSigner(t)
+	_, clientKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	clientSigner, err := ssh.NewSignerFromKey(clientKey)
+	require.NoError(t, err)
+
+	s := &remoteGPUTestServer{hostKey: hostSigner.PublicKey(), clientKey: clientKey, commands: make(chan [2]string, 10)}
+	srv := &sshServer.Server{
+		Handler: func(session sshServer.Session) {
+			// field probes exit right away, and all fields are supported
+			if !strings.Contains(session.RawCommand(), " -l ") {
+				s.probes.Add(1)
+				session.Exit(0)
+				return
+			}
+			s.commands <- [2]string{session.User(), session.RawCommand()}
+			io.WriteString(session, remoteNvidiaSmiFixture)
+			// nvidia-smi -l keeps running until the connection is closed
+			<-session.Context().Done()
+		},
+		PublicKeyHandler: func(_ sshServer.Context, key sshServer.PublicKey) bool {
+			return sshServer.KeysEqual(key, clientSigner.PublicKey())
+		},
+	}
+	srv.AddHostKey(hostSigner)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.Close() })
+	s.addr = listener.Addr().String()
+	return s
+}
+
+// newTestSigner returns a signer for a new ed25519 key
+func newTestSigner(t *testing.T) ssh.Signer {
+	_, privKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(privKey)
+	require.NoError(t, err)
+	return signer
+}
+
+// setRemoteGPUCredentials writes the server's client key and a known_hosts file
+// trusting hostKey for the server, and points the environment at them
+func setRemoteGPUCredentials(t *testing.T, server *remoteGPUTestServer, hostKey ssh.PublicKey) {
+	dir := t.TempDir()
+	block, err := ssh.MarshalPrivateKey(server.clientKey, "")
+	require.NoError(t, err)
+	keyPath := filepath.Join(dir, "id_ed25519")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600))
+	knownHostsPath := filepath.Join(dir, "known_hosts")
+	require.NoError(t, os.WriteFile(knownHostsPath, []byte(knownhosts.Line([]string{server.addr}, hostKey)+"\n"), 0600))
+	t.Setenv("BESZEL_REMOTE_GPU_KEY", keyPath)
+	t.Setenv("BESZEL_REMOTE_GPU_KNOWN_HOSTS", knownHostsPath)
+}
+
+func TestParseRemoteGPUHost(t *testing.T) {
+	host, err := parseRemoteGPUHost("admin@gpu-node-1")
+	require.NoError(t, err)
+	assert.Equal(t, &remoteGPUHost{user: "admin", addr: "gpu-node-1:22", name: "gpu-node-1"}, host)
+
+	host, err = parseRemoteGPUHost("root@10.0.0.5:2222")
+	require.NoError(t, err)
+	assert.Equal(t, &remoteGPUHost{user: "root", addr: "10.0.0.5:2222", name: "10.0.0.5"}, host)
+
+	for _, spec := range []string{"gpu-node-1", "@gpu-node-1", "admin@"} {
+		_, err := parseRemoteGPUHost(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestNewRemoteGPUCollectorDisabled(t *testing.T) {
+	t.Setenv("BESZEL_AGENT_REMOTE_GPU_HOSTS", "")
+	t.Setenv("BESZEL_REMOTE_GPU_HOSTS", "")
+	c := newRemoteGPUCollector(nil)
+	assert.Nil(t, c)
+	assert.Nil(t, c.GetCurrentData())
+
+	// missing private key
+	t.Setenv("BESZEL_REMOTE_GPU_HOSTS", "admin@gpu-node-1")
+	t.Setenv("BESZEL_REMOTE_GPU_KEY", filepath.Join(t.TempDir(), "missing"))
+	assert.Nil(t, newRemoteGPUCollector(nil))
+}
+
+func TestRemoteGPUCollector(t *testing.T) {
+	server := newRemoteGPUTestServer(t)
+	setRemoteGPUCredentials(t, server, server.hostKey)
+	t.Setenv("BESZEL_REMOTE_GPU_HOSTS", "gpu@"+server.addr)
+
+	c := newRemoteGPUCollector(nil)
+	require.NotNil(t, c)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.start(ctx)
+		close(done)
+	}()
+
+	select {
+	case session := <-server.commands:
+		assert.Equal(t, "gpu", session[0])
+		assert.Equal(t, remoteNvidiaSmiCommand(allNvidiaFields()), session[1])
+		assert.Equal(t, int32(1), server.probes.Load(), "all fields are probed at once")
+	case <-time.After(2 * time.Second):
+		t.Fatal("nvidia-smi not run on the remote host")
+	}
+	require.Eventually(t, func() bool {
+		c.hosts[0].gm.Lock()
+		defer c.hosts[0].gm.Unlock()
+		return len(c.hosts[0].gm.GpuDataMap) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+
+	data := c.GetCurrentData()
+	require.Len(t, data, 2)
+	gpu := data["127.0.0.1/0"]
+	assert.Equal(t, "127.0.0.1 A100-SXM4-40GB 0", gpu.Name)
+	assert.Equal(t, 52.0, gpu.Temperature)
+	assert.Equal(t, 87.0, gpu.Usage)
+	assert.Equal(t, 312.45, gpu.Power)
+	assert.Equal(t, "127.0.0.1 A100-SXM4-40GB 1", data["127.0.0.1/1"].Name)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("collector did not stop after cancel")
+	}
+}
+
+func TestRemoteGPUUnknownHostKey(t *testing.T) {
+	server := newRemoteGPUTestServer(t)
+	// known_hosts trusts a different key for the server
+	setRemoteGPUCredentials(t, server, newTestSigner(t).PublicKey())
+
+	c, err := remoteGPUCollectorFromEnv("gpu@"+server.addr, nil)
+	require.NoError(t, err)
+	err = c.hosts[0].collect(context.Background(), c.config)
+	var keyErr *knownhosts.KeyError
+	assert.ErrorAs(t, err, &keyErr)
+	assert.Empty(t, server.commands)
+}
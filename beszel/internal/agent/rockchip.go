@@ -0,0 +1,145 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// key of the Rockchip collector, which reads sysfs instead of running a tool
+	rockchipCollectorName = "rockchip"
+	rockchipInterval      = 4 * time.Second
+	// GPU of the RK3588, the only Rockchip SoC with the GPU at fb000000
+	rockchipGPUName = "Mali-G610"
+	// type of the thermal zone of the GPU, also used by other SoCs
+	rockchipThermalZone = "gpu-thermal"
+)
+
+// Rockchip files relative to rockchipRoot. The NPU load is only readable by
+// root, from debugfs.
+var (
+	rockchipRoot            = "/"
+	rockchipUtilisationPath = "sys/devices/platform/fb000000.gpu/mali0/utilisation"
+	rockchipFreqPath        = "sys/class/devfreq/fb000000.gpu/cur_freq"
+	rockchipNPULoadPath     = "sys/kernel/debug/rknpu/load"
+	rockchipThermalPath     = "sys/class/thermal"
+)
+
+// NPU load per core, e.g. "NPU load:  Core0: 12%, Core1:  0%, Core2:  0%,", or
+// "NPU load:  35%" with older drivers
+var rockchipNPULoadPattern = regexp.MustCompile(`(\d+)%`)
+
+// RockchipCollector reads the stats of the Mali GPU and NPU of Rockchip SoCs such
+// as the RK3588 (Orange Pi 5, Rock 5B), which have no GPU management tool
+type RockchipCollector struct {
+	utilisationPath string
+	freqPath        string
+	npuLoadPath     string
+	tempPath        string // temp of the GPU thermal zone, empty if there is none
+}
+
+// newRockchipCollector returns a collector for the files under root
+func newRockchipCollector(root string) *RockchipCollector {
+	return &RockchipCollector{
+		utilisationPath: filepath.Join(root, rockchipUtilisationPath),
+		freqPath:        filepath.Join(root, rockchipFreqPath),
+		npuLoadPath:     filepath.Join(root, rockchipNPULoadPath),
+		tempPath:        findThermalZone(filepath.Join(root, rockchipThermalPath), rockchipThermalZone),
+	}
+}
+
+// detectRockchip returns true if the Mali GPU utilisation of a Rockchip SoC can be read
+func detectRockchip() bool {
+	_, err := os.Stat(filepath.Join(rockchipRoot, rockchipUtilisationPath))
+	return err == nil
+}
+
+// findThermalZone returns the temp file of the thermal zone of the given type,
+// or an empty string if there is none
+func findThermalZone(thermalPath, zoneType string) string {
+	zones, _ := filepath.Glob(filepath.Join(thermalPath, "thermal_zone*"))
+	for _, zone := range zones {
+		if name, err := os.ReadFile(filepath.Join(zone, "type")); err == nil && strings.TrimSpace(string(name)) == zoneType {
+			return filepath.Join(zone, "temp")
+		}
+	}
+	return ""
+}
+
+// read returns a sample of the Rockchip files as "name value" lines for
+// parseRockchipData. Only the GPU utilisation is required.
+func (c *RockchipCollector) read() ([]byte, error) {
+	utilisation, err := os.ReadFile(c.utilisationPath)
+	if err != nil {
+		return nil, err
+	}
+	var sample bytes.Buffer
+	fmt.Fprintf(&sample, "utilisation %s\n", bytes.TrimSpace(utilisation))
+	for name, path := range map[string]string{"freq": c.freqPath, "npu": c.npuLoadPath, "temp": c.tempPath} {
+		if path == "" {
+			continue
+		}
+		if value, err := os.ReadFile(path); err == nil {
+			fmt.Fprintf(&sample, "%s %s\n", name, bytes.TrimSpace(value))
+		}
+	}
+	return sample.Bytes(), nil
+}
+
+// parseSysfsSample returns the values of a sample of "name value" lines
+func parseSysfsSample(output []byte) map[string]string {
+	values := make(map[string]string)
+	for line := range strings.Lines(string(output)) {
+		name, value, _ := strings.Cut(strings.TrimSpace(line), " ")
+		values[name] = strings.TrimSpace(value)
+	}
+	return values
+}
+
+// parseRockchipData parses a sample read by RockchipCollector and updates the GPUData map
+func (gm *GPUManager) parseRockchipData(output []byte) bool {
+	values := parseSysfsSample(output)
+	usage, err := strconv.ParseFloat(strings.TrimSuffix(values["utilisation"], "%"), 64)
+	if err != nil {
+		gm.errorLog.add(rockchipCollectorName, output)
+		return false
+	}
+
+	gm.Lock()
+	defer gm.Unlock()
+	defer gm.publishSnapshot(gm.beginUpdate())
+	// the SoC has a single GPU
+	gpu, ok := gm.GpuDataMap["0"]
+	if !ok {
+		gpu = &system.GPUData{Name: rockchipGPUName}
+		gm.GpuDataMap["0"] = gpu
+	}
+	now := time.Now()
+	gm.rollWindow("0", gpu, now)
+	gpu.LastUpdated = now
+	gm.addUsage("0", gpu, usage)
+	if freq, err := strconv.ParseFloat(values["freq"], 64); err == nil {
+		gpu.Frequency = freq / 1e6
+	}
+	// millidegrees Celsius
+	if temp, err := strconv.ParseFloat(values["temp"], 64); err == nil {
+		gm.setTemperature(gpu, temp/1000)
+	}
+	if loads := rockchipNPULoadPattern.FindAllStringSubmatch(values["npu"], -1); len(loads) > 0 {
+		var total float64
+		for _, load := range loads {
+			value, _ := strconv.ParseFloat(load[1], 64)
+			total += value
+		}
+		gpu.NPUUsage = total / float64(len(loads))
+	}
+	gpu.Count++
+	return true
+}
@@ -0,0 +1,106 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeRockchipFiles writes files relative to root, creating their directories
+func writeRockchipFiles(t *testing.T, root string, files map[string]string) {
+	for path, content := range files {
+		path = filepath.Join(root, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+// rockchipFixture is the sysfs of an RK3588 board
+var rockchipFixture = map[string]string{
+	rockchipUtilisationPath:                "45\n",
+	rockchipFreqPath:                       "800000000\n",
+	rockchipNPULoadPath:                    "NPU load:  Core0: 12%, Core1:  6%, Core2:  0%,\n",
+	"sys/class/thermal/thermal_zone0/type": "soc-thermal\n",
+	"sys/class/thermal/thermal_zone0/temp": "40000\n",
+	"sys/class/thermal/thermal_zone5/type": "gpu-thermal\n",
+	"sys/class/thermal/thermal_zone5/temp": "47250\n",
+	"sys/class/thermal/thermal_zone6/type": "npu-thermal\n",
+	"sys/class/thermal/thermal_zone6/temp": "46000\n",
+}
+
+func TestRockchipCollector(t *testing.T) {
+	root := t.TempDir()
+	writeRockchipFiles(t, root, rockchipFixture)
+	c := newRockchipCollector(root)
+	assert.Equal(t, filepath.Join(root, "sys/class/thermal/thermal_zone5/temp"), c.tempPath)
+
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	for range 2 {
+		sample, err := c.read()
+		require.NoError(t, err)
+		require.True(t, gm.parseRockchipData(sample))
+	}
+	gpu := gm.GetCurrentData()["0"]
+	assert.Equal(t, rockchipGPUName, gpu.Name)
+	assert.Equal(t, 45.0, gpu.Usage)
+	assert.Equal(t, 800.0, gpu.Frequency)
+	assert.Equal(t, 6.0, gpu.NPUUsage)
+	assert.Equal(t, 47.25, gpu.Temperature)
+}
+
+func TestRockchipCollectorOptionalFiles(t *testing.T) {
+	// NPU load is missing without root, and older drivers report a single load
+	root := t.TempDir()
+	writeRockchipFiles(t, root, map[string]string{rockchipUtilisationPath: "30\n"})
+	c := newRockchipCollector(root)
+	assert.Empty(t, c.tempPath)
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	sample, err := c.read()
+	require.NoError(t, err)
+	require.True(t, gm.parseRockchipData(sample))
+	gpu := gm.GetCurrentData()["0"]
+	assert.Equal(t, 30.0, gpu.Usage)
+	assert.Zero(t, gpu.Frequency)
+	assert.Zero(t, gpu.NPUUsage)
+	assert.Zero(t, gpu.Temperature)
+
+	require.True(t, gm.parseRockchipData([]byte("utilisation 30\nnpu NPU load:  35%\n")))
+	assert.Equal(t, 35.0, gm.GetCurrentData()["0"].NPUUsage)
+
+	// the GPU utilisation is required
+	_, err = newRockchipCollector(t.TempDir()).read()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.False(t, gm.parseRockchipData([]byte("freq 800000000\n")))
+}
+
+func TestRockchipCollectorStart(t *testing.T) {
+	root := t.TempDir()
+	writeRockchipFiles(t, root, rockchipFixture)
+	origRoot := rockchipRoot
+	rockchipRoot = root
+	defer func() { rockchipRoot = origRoot }()
+	t.Setenv("PATH", "")
+
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData)}
+	require.NoError(t, gm.detectGPUs())
+	assert.True(t, gm.rockchip)
+	gm.ctx, gm.cancel = context.WithCancel(context.Background())
+	gm.startCollectors()
+	defer gm.Stop(context.Background())
+
+	require.Eventually(t, func() bool {
+		gm.Lock()
+		defer gm.Unlock()
+		return len(gm.GpuDataMap) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(1), gm.CollectorStats()[rockchipCollectorName].SuccessfulParses)
+}
@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"bufio"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const procSchedstat = "/proc/schedstat"
+
+// SchedStatCollector computes runqueue latency from /proc/schedstat
+type SchedStatCollector struct {
+	path string
+	prev uint64    // total run delay across CPUs from the previous collection (ns)
+	time time.Time // time of the previous collection
+}
+
+// newSchedStatCollector returns a collector for the schedstat file at path, or
+// nil if it can't be read (e.g. not on Linux or schedstats disabled in the kernel)
+func newSchedStatCollector(path string) *SchedStatCollector {
+	if _, err := os.Stat(path); err != nil {
+		slog.Debug("Scheduler stats", "err", err)
+		return nil
+	}
+	return &SchedStatCollector{path: path}
+}
+
+// Collect returns the time tasks spent waiting on a runqueue since the previous
+// call. It returns nil on the first call.
+func (c *SchedStatCollector) Collect() *system.SchedLatency {
+	if c == nil {
+		return nil
+	}
+	file, err := os.Open(c.path)
+	if err != nil {
+		slog.Debug("Scheduler stats", "err", err)
+		return nil
+	}
+	defer file.Close()
+	runDelay, err := parseSchedstat(file)
+	if err != nil {
+		slog.Debug("Scheduler stats", "err", err)
+		return nil
+	}
+	return c.update(runDelay, time.Now())
+}
+
+// update stores the total run delay and returns the latency since the previous one
+func (c *SchedStatCollector) update(runDelay uint64, now time.Time) *system.SchedLatency {
+	prev, prevTime := c.prev, c.time
+	c.prev, c.time = runDelay, now
+	elapsed := now.Sub(prevTime).Seconds()
+	// skip the first collection and counters that went backwards (e.g. CPU taken offline)
+	if prevTime.IsZero() || elapsed <= 0 || runDelay < prev {
+		return nil
+	}
+	waitMs := float64(runDelay-prev) / float64(time.Millisecond)
+	return &system.SchedLatency{RunqueueLatencyMs: twoDecimals(waitMs / elapsed)}
+}
+
+// parseSchedstat returns the time tasks spent waiting to run in /proc/schedstat,
+// summed across CPUs, in nanoseconds. Each cpu line has the yld_count, legacy
+// expired count, schedule() calls, idle schedules, wakeups, local wakeups,
+// rq_cpu_time (time spent running), run_delay (time spent waiting) and the
+// number of timeslices.
+//
+//	version 15
+//	timestamp 4295109824
+//	cpu0 0 0 2843071 1102386 1473601 866342 412840217365 61294857112 1739853
+//	domain0 00000003 ...
+func parseSchedstat(r io.Reader) (runDelay uint64, err error) {
+	scanner := bufio.NewScanner(r)
+	// domain lines with many fields can be longer than the default buffer
+	scanner.Buffer(make([]byte, 0, 4096), 1024*1024)
+	cpus := 0
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 9 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		delay, err := strconv.ParseUint(fields[8], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		runDelay += delay
+		cpus++
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	if cpus == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return runDelay, nil
+}
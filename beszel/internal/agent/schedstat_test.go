@@ -0,0 +1,72 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// /proc/schedstat with 4 CPUs, domain lines shortened
+const schedstatFixture = `version 15
+timestamp 4295109824
+cpu0 0 0 2843071 1102386 1473601 866342 412840217365 61294857112 1739853
+domain0 00000003 1204 1187 9 2010 8 0 0 1187 0 0 0 0 0 0 0 0 0
+cpu1 0 0 2611203 1009844 1392117 801203 398117520031 58822140089 1601388
+domain0 00000003 1170 1151 11 1993 8 0 0 1151 0 0 0 0 0 0 0 0 0
+cpu2 0 0 2733010 1050122 1421009 833440 405220117842 60117452230 1682911
+domain0 0000000c 1188 1170 7 2004 9 0 0 1170 0 0 0 0 0 0 0 0 0
+cpu3 0 0 2690554 1031208 1409872 820117 401733900210 59765549569 1659346
+domain0 0000000c 1191 1175 8 1998 7 0 0 1175 0 0 0 0 0 0 0 0 0
+`
+
+// the fixture 10 seconds later, with 1.2s of run delay across the CPUs
+const schedstatFixtureLater = `version 15
+timestamp 4295112324
+cpu0 0 0 2843991 1102711 1474102 866590 422840217365 61794857112 1740411
+cpu1 0 0 2612004 1010110 1392580 801433 408117520031 59122140089 1601902
+cpu2 0 0 2733871 1050399 1421488 833671 415220117842 60217452230 1683440
+cpu3 0 0 2691390 1031487 1410344 820350 411733900210 60065549569 1659880
+`
+
+func TestParseSchedstat(t *testing.T) {
+	runDelay, err := parseSchedstat(strings.NewReader(schedstatFixture))
+	require.NoError(t, err)
+	assert.EqualValues(t, 61294857112+58822140089+60117452230+59765549569, runDelay)
+
+	_, err = parseSchedstat(strings.NewReader("version 15\ntimestamp 4295109824\n"))
+	assert.Error(t, err, "no cpu lines")
+	_, err = parseSchedstat(strings.NewReader("cpu0 0 0 1 1 1 1 1 x 1\n"))
+	assert.Error(t, err)
+}
+
+func TestSchedStatCollector(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedstat")
+	require.NoError(t, os.WriteFile(path, []byte(schedstatFixture), 0644))
+	c := newSchedStatCollector(path)
+	require.NotNil(t, c)
+	assert.Nil(t, c.Collect(), "no latency on the first collection")
+
+	start := c.time
+	later, err := parseSchedstat(strings.NewReader(schedstatFixtureLater))
+	require.NoError(t, err)
+	// 500ms + 300ms + 100ms + 300ms over 10 seconds
+	assert.Equal(t, &system.SchedLatency{RunqueueLatencyMs: 120}, c.update(later, start.Add(10*time.Second)))
+
+	// counter went backwards
+	assert.Nil(t, c.update(later-1, start.Add(20*time.Second)))
+
+	t.Run("missing file", func(t *testing.T) {
+		c := newSchedStatCollector(filepath.Join(t.TempDir(), "schedstat"))
+		assert.Nil(t, c)
+		assert.Nil(t, c.Collect())
+	})
+}
@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// schemaCommand is the SSH command that returns the JSON Schema of the stats response
+const schemaCommand = "BESZEL_SCHEMA"
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// jsonSchema is a JSON Schema (draft 2020-12) document or subschema. Only the
+// keywords needed to describe Go types encoded with encoding/json are included.
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 any                    `json:"type,omitempty"` // a type name, or a list of names for nullable values
+	Format               string                 `json:"format,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties any                    `json:"additionalProperties,omitempty"` // false or a schema
+	Items                *jsonSchema            `json:"items,omitempty"`
+	AnyOf                []*jsonSchema          `json:"anyOf,omitempty"`
+	Defs                 map[string]*jsonSchema `json:"$defs,omitempty"`
+}
+
+// StatsJSONSchema returns a JSON Schema document describing the stats response
+// sent to the hub, generated from system.CombinedData
+func (a *Agent) StatsJSONSchema() ([]byte, error) {
+	defs := make(map[string]*jsonSchema)
+	root := schemaForType(reflect.TypeFor[system.CombinedData](), defs)
+	root.Schema = "https://json-schema.org/draft/2020-12/schema"
+	root.Title = "Beszel agent stats"
+	root.Defs = defs
+	return json.MarshalIndent(root, "", "  ")
+}
+
+// schemaForType returns the schema of values of type t as encoded by encoding/json.
+// Structs are added to defs, keyed by package and type name, and referenced.
+func schemaForType(t reflect.Type, defs map[string]*jsonSchema) *jsonSchema {
+	if t == timeType {
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := 0.0
+		return &jsonSchema{Type: "integer", Minimum: &zero}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Slice:
+		// nil slices are encoded as null
+		return &jsonSchema{Type: []string{"array", "null"}, Items: schemaForType(t.Elem(), defs)}
+	case reflect.Array:
+		return &jsonSchema{Type: "array", Items: schemaForType(t.Elem(), defs)}
+	case reflect.Map:
+		return &jsonSchema{Type: []string{"object", "null"}, AdditionalProperties: schemaForType(t.Elem(), defs)}
+	case reflect.Pointer:
+		return &jsonSchema{AnyOf: []*jsonSchema{schemaForType(t.Elem(), defs), {Type: "null"}}}
+	case reflect.Struct:
+		name := t.String()
+		if _, exists := defs[name]; !exists {
+			// add a placeholder first so recursive types terminate
+			defs[name] = &jsonSchema{}
+			*defs[name] = *schemaForStruct(t, defs)
+		}
+		return &jsonSchema{Ref: "#/$defs/" + name}
+	default:
+		// interfaces can hold any value
+		return &jsonSchema{}
+	}
+}
+
+// schemaForStruct returns an object schema with the exported fields of t.
+// Fields without omitempty or omitzero are always encoded, so they are required.
+func schemaForStruct(t reflect.Type, defs map[string]*jsonSchema) *jsonSchema {
+	schema := &jsonSchema{
+		Type:                 "object",
+		Properties:           make(map[string]*jsonSchema),
+		AdditionalProperties: false,
+	}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			// embedded struct fields are encoded as fields of the outer struct
+			embedded := schemaForStruct(field.Type, defs)
+			maps.Copy(schema.Properties, embedded.Properties)
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = schemaForType(field.Type, defs)
+		if !strings.Contains(options, "omitempty") && !strings.Contains(options, "omitzero") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
+
+// handleSchema writes the stats JSON Schema to the session
+func (a *Agent) handleSchema(s ssh.Session) {
+	schema, err := a.StatsJSONSchema()
+	if err == nil {
+		_, err = s.Write(append(schema, '\n'))
+	}
+	if err != nil {
+		slog.Error("Error writing stats schema", "err", err)
+		s.Exit(1)
+		return
+	}
+	s.Exit(0)
+}
@@ -0,0 +1,170 @@
+package agent
+
+import (
+	"beszel/internal/entities/container"
+	"beszel/internal/entities/system"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// validateJSONSchema checks value, decoded from JSON, against the subset of
+// JSON Schema keywords produced by StatsJSONSchema
+func validateJSONSchema(schema, root map[string]any, value any, path string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		def, ok := root["$defs"].(map[string]any)[strings.TrimPrefix(ref, "#/$defs/")].(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: unresolved $ref %s", path, ref)
+		}
+		return validateJSONSchema(def, root, value, path)
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		for _, sub := range anyOf {
+			if validateJSONSchema(sub.(map[string]any), root, value, path) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: no anyOf schema matches %v", path, value)
+	}
+	if schemaType, ok := schema["type"]; ok {
+		var types []string
+		switch schemaType := schemaType.(type) {
+		case string:
+			types = []string{schemaType}
+		case []any:
+			for _, t := range schemaType {
+				types = append(types, t.(string))
+			}
+		}
+		if !slices.Contains(types, jsonTypeOf(value)) &&
+			!(jsonTypeOf(value) == "integer" && slices.Contains(types, "number")) {
+			return fmt.Errorf("%s: %v is not of type %v", path, value, types)
+		}
+	}
+	if minimum, ok := schema["minimum"].(float64); ok {
+		if number, ok := value.(float64); ok && number < minimum {
+			return fmt.Errorf("%s: %v is less than %v", path, number, minimum)
+		}
+	}
+	switch value := value.(type) {
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := value[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		for key, item := range value {
+			sub, ok := properties[key].(map[string]any)
+			if !ok {
+				switch additional := schema["additionalProperties"].(type) {
+				case bool:
+					return fmt.Errorf("%s: unexpected property %q", path, key)
+				case map[string]any:
+					sub = additional
+				default:
+					continue
+				}
+			}
+			if err := validateJSONSchema(sub, root, item, path+"."+key); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range value {
+				if err := validateJSONSchema(items, root, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// jsonTypeOf returns the JSON Schema type name of a decoded JSON value
+func jsonTypeOf(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// decodeSchemaTestJSON decodes encoded JSON into a generic value
+func decodeSchemaTestJSON(t *testing.T, encoded []byte) map[string]any {
+	t.Helper()
+	var value map[string]any
+	require.NoError(t, json.Unmarshal(encoded, &value))
+	return value
+}
+
+func TestStatsJSONSchema(t *testing.T) {
+	agent := &Agent{}
+	encodedSchema, err := agent.StatsJSONSchema()
+	require.NoError(t, err)
+	schema := decodeSchemaTestJSON(t, encodedSchema)
+	assert.Equal(t, "https://json-schema.org/draft/2020-12/schema", schema["$schema"])
+	assert.Equal(t, "#/$defs/system.CombinedData", schema["$ref"])
+	defs := schema["$defs"].(map[string]any)
+	assert.Contains(t, defs, "system.Stats")
+	assert.Contains(t, defs, "container.Stats")
+
+	validate := func(t *testing.T, stats *system.CombinedData) error {
+		var buf bytes.Buffer
+		require.NoError(t, statsEncoder.Encode(&buf, stats, system.EncodingJSON))
+		return validateJSONSchema(schema, schema, decodeSchemaTestJSON(t, buf.Bytes()), "$")
+	}
+
+	t.Run("real stats", func(t *testing.T) {
+		assert.NoError(t, validate(t, gatherStats(t, NewAgent(), "")))
+	})
+
+	t.Run("all optional fields set", func(t *testing.T) {
+		stats := deltaTestStats()
+		stats.Stats.Temperatures = map[string]float64{"cpu_thermal": 48.5}
+		stats.Stats.CPUTemps = []system.CPUTemp{{Label: "Package id 0", TempC: 52}}
+		stats.Stats.IRQRates = map[string]float64{"eth0": 1200.5}
+		stats.Stats.NetProtoStats = map[string]uint64{"eth0/tcp": 1024}
+		stats.Stats.KubePods = []system.KubePodStat{{Name: "web", Namespace: "default", Cpu: 1.5, Mem: 128}}
+		stats.Containers = []*container.Stats{{Name: "postgres", Cpu: 2.5, Mem: 512}}
+		stats.Tags = map[string]string{"env": "prod"}
+		assert.NoError(t, validate(t, stats))
+	})
+
+	t.Run("invalid payloads", func(t *testing.T) {
+		valid := decodeSchemaTestJSON(t, []byte(`{"protocol":1,"stats":{},"info":{},"container":null,"meta":{}}`))
+		assert.Error(t, validateJSONSchema(schema, schema, valid, "$"), "required fields are missing")
+
+		var buf bytes.Buffer
+		require.NoError(t, statsEncoder.Encode(&buf, deltaTestStats(), system.EncodingJSON))
+		payload := decodeSchemaTestJSON(t, buf.Bytes())
+		require.NoError(t, validateJSONSchema(schema, schema, payload, "$"))
+
+		payload["stats"].(map[string]any)["cpu"] = "12.5"
+		assert.Error(t, validateJSONSchema(schema, schema, payload, "$"), "wrong type")
+		payload["stats"].(map[string]any)["cpu"] = 12.5
+		payload["stats"].(map[string]any)["unknown"] = 1
+		assert.Error(t, validateJSONSchema(schema, schema, payload, "$"), "unknown property")
+	})
+}
@@ -78,6 +78,12 @@ func (a *Agent) updateTemperatures(systemStats *system.Stats) {
 	// reset high temp
 	a.systemInfo.DashboardTemp = 0
 
+	// per-core CPU temperatures from hwmon
+	systemStats.CPUTemps = a.cpuThermal.Collect()
+	if zenTemps := a.cpuThermal.ZenTemps(); zenTemps.Tdie > 0 {
+		systemStats.ZenCPUTemps = &zenTemps
+	}
+
 	// get sensor data
 	temps, _ := sensors.TemperaturesWithContext(a.sensorConfig.context)
 	slog.Debug("Temperature", "sensors", temps)
@@ -1,13 +1,21 @@
 package agent
 
 import (
+	"beszel"
 	"beszel/internal/common"
+	"beszel/internal/entities/system"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"os"
+	"os/user"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gliderlabs/ssh"
 	gossh "golang.org/x/crypto/ssh"
@@ -16,17 +24,71 @@ import (
 type ServerOptions struct {
 	Addr    string
 	Network string
-	Keys    []gossh.PublicKey
+	Keys    []gossh.PublicKey // Copied to a SecureKeyStore, the slice is not wiped
+	Version string            // SSH server version string, defaults to "SSH-2.0-beszel-agent-<version>"
+	// KeyRotationWindow is how long previous keys are still accepted after
+	// new keys are loaded with ReloadKeys
+	KeyRotationWindow time.Duration
+	// KeepAliveInterval is how often keepalive requests are sent while a session
+	// is open, e.g. while stats are collected. Defaults to 30 seconds, a negative
+	// value disables keepalives.
+	KeepAliveInterval time.Duration
+	// UnixSocketMode sets the permissions of the unix socket file. Defaults to 0600.
+	UnixSocketMode os.FileMode
+	// UnixSocketOwner changes the owner of the unix socket file if set, as
+	// user or user:group using names or numeric IDs
+	UnixSocketOwner string
+	// AllowMultipleSessions lets several hubs request stats at the same time.
+	// By default a stats session is rejected with exit code 429 while another is
+	// in progress, since hubs receiving the same deltas would diverge.
+	AllowMultipleSessions bool
+}
+
+const (
+	// default permissions of the unix socket file, only the agent user can connect
+	defaultUnixSocketMode    os.FileMode = 0o600
+	defaultKeepAliveInterval             = 30 * time.Second
+	keepAliveRequest                     = "keepalive@openssh.com"
+	// exit code of a stats session rejected because another is in progress, like HTTP 429
+	sessionBusyExitCode = 429
+)
+
+// requestSender sends SSH requests, implemented by ssh.Session
+type requestSender interface {
+	SendRequest(name string, wantReply bool, payload []byte) (bool, error)
+}
+
+// keySet holds the public keys accepted by the SSH server. During a key
+// rotation window, previous holds the keys that were replaced.
+type keySet struct {
+	current        *SecureKeyStore
+	previous       *SecureKeyStore
+	rotationWindow time.Duration
+}
+
+// close wipes the keys of the set
+func (k *keySet) close() {
+	k.current.Close()
+	k.previous.Close()
 }
 
 func (a *Agent) StartServer(opts ServerOptions) error {
 	slog.Info("Starting SSH server", "addr", opts.Addr, "network", opts.Network)
 
+	a.keys.Store(&keySet{current: NewSecureKeyStore(opts.Keys), rotationWindow: opts.KeyRotationWindow})
+
+	a.multiSession = opts.AllowMultipleSessions
+	a.keepAliveInterval = opts.KeepAliveInterval
+	if a.keepAliveInterval == 0 {
+		a.keepAliveInterval = defaultKeepAliveInterval
+	}
+
 	if opts.Network == "unix" {
 		// remove existing socket file if it exists
 		if err := os.Remove(opts.Addr); err != nil && !os.IsNotExist(err) {
 			return err
 		}
+		a.socketPath = opts.Addr
 	}
 
 	// start listening on the address
@@ -36,27 +98,34 @@ func (a *Agent) StartServer(opts ServerOptions) error {
 	}
 	defer ln.Close()
 
+	if opts.Network == "unix" {
+		if err := setUnixSocketPermissions(opts); err != nil {
+			return err
+		}
+	}
+
 	// base config (limit to allowed algorithms)
 	config := &gossh.ServerConfig{}
 	config.KeyExchanges = common.DefaultKeyExchanges
 	config.MACs = common.DefaultMACs
 	config.Ciphers = common.DefaultCiphers
+	config.ServerVersion = getServerVersion(opts.Version)
 
 	// set default handler
 	ssh.Handle(a.handleSession)
 
-	server := ssh.Server{
+	server := &ssh.Server{
+		Addr: ln.Addr().String(),
 		ServerConfigCallback: func(ctx ssh.Context) *gossh.ServerConfig {
 			return config
 		},
 		// check public key(s)
 		PublicKeyHandler: func(ctx ssh.Context, key ssh.PublicKey) bool {
-			for _, pubKey := range opts.Keys {
-				if ssh.KeysEqual(key, pubKey) {
-					return true
-				}
+			matched := a.isAuthorizedKey(key)
+			if a.auditLogger != nil {
+				a.auditLogger.LogAuth(ctx.RemoteAddr().String(), gossh.FingerprintSHA256(key), matched)
 			}
-			return false
+			return matched
 		},
 		// disable pty
 		PtyCallback: func(ctx ssh.Context, pty ssh.Pty) bool {
@@ -68,14 +137,189 @@ func (a *Agent) StartServer(opts ServerOptions) error {
 		},
 	}
 
+	a.server.Store(server)
+
 	// Start SSH server on the listener
-	return server.Serve(ln)
+	if err := server.Serve(ln); err != nil && !errors.Is(err, ssh.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// setUnixSocketPermissions sets the mode and owner of the unix socket file
+func setUnixSocketPermissions(opts ServerOptions) error {
+	mode := opts.UnixSocketMode
+	if mode == 0 {
+		mode = defaultUnixSocketMode
+	}
+	if err := os.Chmod(opts.Addr, mode); err != nil {
+		return fmt.Errorf("failed to set unix socket mode: %w", err)
+	}
+	if opts.UnixSocketOwner == "" {
+		return nil
+	}
+	uid, gid, err := lookupOwner(opts.UnixSocketOwner)
+	if err != nil {
+		return err
+	}
+	if err := os.Chown(opts.Addr, uid, gid); err != nil {
+		return fmt.Errorf("failed to set unix socket owner: %w", err)
+	}
+	return nil
+}
+
+// lookupOwner returns the user and group IDs for an owner in user or user:group
+// format. The group ID is -1 (unchanged) if no group is given.
+func lookupOwner(owner string) (uid, gid int, err error) {
+	userName, groupName, hasGroup := strings.Cut(owner, ":")
+	u, err := user.Lookup(userName)
+	if err != nil {
+		if u, err = user.LookupId(userName); err != nil {
+			return 0, 0, fmt.Errorf("unknown unix socket owner %q: %w", userName, err)
+		}
+	}
+	if uid, err = strconv.Atoi(u.Uid); err != nil {
+		return 0, 0, err
+	}
+	if !hasGroup {
+		return uid, -1, nil
+	}
+	g, err := user.LookupGroup(groupName)
+	if err != nil {
+		if g, err = user.LookupGroupId(groupName); err != nil {
+			return 0, 0, fmt.Errorf("unknown unix socket group %q: %w", groupName, err)
+		}
+	}
+	if gid, err = strconv.Atoi(g.Gid); err != nil {
+		return 0, 0, err
+	}
+	return uid, gid, nil
+}
+
+// ActiveConnections returns the number of SSH sessions currently being handled
+func (a *Agent) ActiveConnections() int64 {
+	return a.activeConns.Load()
+}
+
+// isAuthorizedKey checks the key against the current keys and, during a
+// rotation window, the previous keys
+func (a *Agent) isAuthorizedKey(key ssh.PublicKey) bool {
+	keys := a.keys.Load()
+	if keys == nil {
+		return false
+	}
+	return keys.current.Contains(key) || keys.previous.Contains(key)
+}
+
+// ReloadKeys replaces the accepted public keys. The previous keys are still
+// accepted until KeyRotationWindow expires, so the hub can be updated without
+// failed connections. The stored copies of keys that are no longer accepted
+// are wiped, see SecureKeyStore.
+func (a *Agent) ReloadKeys(keys []gossh.PublicKey) {
+	newKeys := &keySet{current: NewSecureKeyStore(keys)}
+	old := a.keys.Load()
+	if old != nil {
+		newKeys.rotationWindow = old.rotationWindow
+		if old.rotationWindow > 0 {
+			newKeys.previous = old.current
+		}
+	}
+	a.keys.Store(newKeys)
+	slog.Info("Reloaded SSH keys", "keys", len(keys), "window", newKeys.rotationWindow)
+
+	if old != nil {
+		old.previous.Close()
+		if newKeys.previous == nil {
+			old.current.Close()
+		}
+	}
+	if newKeys.previous.Len() == 0 {
+		return
+	}
+	time.AfterFunc(newKeys.rotationWindow, func() {
+		// drop previous keys unless the keys were reloaded again
+		if a.keys.CompareAndSwap(newKeys, &keySet{current: newKeys.current, rotationWindow: newKeys.rotationWindow}) {
+			newKeys.previous.Close()
+			slog.Info("Key rotation window expired")
+		}
+	})
+}
+
+// getServerVersion returns the SSH version string sent to clients during key exchange
+func getServerVersion(version string) string {
+	if version == "" {
+		version = "beszel-agent-" + beszel.Version
+	}
+	if !strings.HasPrefix(version, "SSH-2.0-") {
+		version = "SSH-2.0-" + version
+	}
+	return version
 }
 
 func (a *Agent) handleSession(s ssh.Session) {
 	slog.Debug("New session", "client", s.RemoteAddr())
-	stats := a.gatherStats(s.Context().SessionID())
-	if err := json.NewEncoder(s).Encode(stats); err != nil {
+	a.activeConns.Add(1)
+	defer a.activeConns.Add(-1)
+	if !a.sessions.add() {
+		s.Exit(1)
+		return
+	}
+	defer a.sessions.done()
+	if a.keepAliveInterval > 0 {
+		ctx, cancel := context.WithCancel(s.Context())
+		defer cancel()
+		go sendKeepAlives(ctx, s, a.keepAliveInterval)
+	}
+	switch s.RawCommand() {
+	case diagnosticsCommand:
+		a.handleDiagnostics(s)
+		return
+	case schemaCommand:
+		a.handleSchema(s)
+		return
+	}
+	if !a.multiSession {
+		if !a.activeSession.CompareAndSwap(false, true) {
+			slog.Warn("Rejected session, another stats session is in progress", "client", s.RemoteAddr())
+			io.WriteString(s.Stderr(), "another stats session is in progress\n")
+			s.Exit(sessionBusyExitCode)
+			return
+		}
+		defer a.activeSession.Store(false)
+	}
+	a.lastStatsRequest.Store(time.Now().UnixNano())
+	encoding := sessionEncoding(s)
+	encoder := json.NewEncoder(s)
+	// buffered stats are only sent as JSON
+	if wantsCatchUp(s) && encoding == system.EncodingJSON {
+		if err := a.replayBufferedStats(encoder); err != nil {
+			slog.Error("Error encoding buffered stats", "err", err)
+			s.Exit(1)
+			return
+		}
+	}
+	sessionID := s.Context().SessionID()
+	stats, err := a.requestStats(sessionID)
+	if err != nil {
+		// nothing has been collected yet, so there are no stats to send
+		slog.Error("Error gathering stats", "err", err)
+		s.Exit(1)
+		return
+	}
+	// deltas are JSON, but sent in place of protobuf since delta mode is set on
+	// the agent and deltas are usually smaller
+	if a.wantsDelta(s) {
+		var payload any
+		if payload, err = a.statsDelta(sessionID, stats); err != nil {
+			slog.Error("Error computing stats delta", "err", err)
+			s.Exit(1)
+			return
+		}
+		err = encoder.Encode(payload)
+	} else {
+		err = statsEncoder.Encode(s, stats, encoding)
+	}
+	if err != nil {
 		slog.Error("Error encoding stats", "err", err, "stats", stats)
 		s.Exit(1)
 		return
@@ -83,7 +327,27 @@ func (a *Agent) handleSession(s ssh.Session) {
 	s.Exit(0)
 }
 
+// sendKeepAlives sends a keepalive request every interval until ctx is done or
+// a request fails. The reply is ignored since clients may reject the request type.
+func sendKeepAlives(ctx context.Context, s requestSender, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.SendRequest(keepAliveRequest, true, nil); err != nil {
+				slog.Debug("Keepalive failed", "err", err)
+				return
+			}
+		}
+	}
+}
+
 // ParseKeys parses a string containing SSH public keys in authorized_keys format.
+// Any key type supported by x/crypto/ssh is accepted, including ed25519, RSA,
+// and ECDSA on the P-256, P-384, and P-521 curves.
 // It returns a slice of ssh.PublicKey and an error if any key fails to parse.
 func ParseKeys(input string) ([]gossh.PublicKey, error) {
 	var parsedKeys []gossh.PublicKey
@@ -103,14 +367,21 @@ func ParseKeys(input string) ([]gossh.PublicKey, error) {
 	return parsedKeys, nil
 }
 
+// ParseKeysFromFile reads an OpenSSH authorized_keys file and parses the keys it contains.
+// Options preceding a key, such as from="..." or no-pty, are ignored.
+func ParseKeysFromFile(path string) ([]gossh.PublicKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authorized keys file: %w", err)
+	}
+	return ParseKeys(string(content))
+}
+
 // GetAddress gets the address to listen on or connect to from environment variables or default value.
 func GetAddress(addr string) string {
-	if addr == "" {
-		addr, _ = GetEnv("LISTEN")
-	}
 	if addr == "" {
 		// Legacy PORT environment variable support
-		addr, _ = GetEnv("PORT")
+		addr, _ = GetEnvFallback("BESZEL_AGENT_LISTEN", "LISTEN", "BESZEL_AGENT_PORT", "PORT")
 	}
 	if addr == "" {
 		return ":45876"
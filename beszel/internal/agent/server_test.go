@@ -1,14 +1,23 @@
 package agent
 
 import (
+	"beszel"
+	"context"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	sshServer "github.com/gliderlabs/ssh"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/ssh"
@@ -287,3 +296,401 @@ func TestParseInvalidKey(t *testing.T) {
 		t.Fatalf("Expected error message to contain '%s', got: %v", expectedErrMsg, err)
 	}
 }
+
+// Test case 6: ECDSA keys on all curves supported by OpenSSH
+func TestParseECDSAKeys(t *testing.T) {
+	tests := []struct {
+		curve   elliptic.Curve
+		keyType string
+	}{
+		{elliptic.P256(), "ecdsa-sha2-nistp256"},
+		{elliptic.P384(), "ecdsa-sha2-nistp384"},
+		{elliptic.P521(), "ecdsa-sha2-nistp521"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keyType, func(t *testing.T) {
+			privKey, err := ecdsa.GenerateKey(tt.curve, rand.Reader)
+			require.NoError(t, err)
+			sshPubKey, err := ssh.NewPublicKey(&privKey.PublicKey)
+			require.NoError(t, err)
+			line := string(ssh.MarshalAuthorizedKey(sshPubKey))
+
+			keys, err := ParseKeys(line)
+			require.NoError(t, err)
+			require.Len(t, keys, 1)
+			assert.Equal(t, tt.keyType, keys[0].Type())
+			assert.True(t, sshServer.KeysEqual(sshPubKey, keys[0]))
+
+			// round trip: a fresh parse of the returned key is the same key
+			reparsed, err := ParseKeys(string(ssh.MarshalAuthorizedKey(keys[0])))
+			require.NoError(t, err)
+			require.Len(t, reparsed, 1)
+			assert.True(t, sshServer.KeysEqual(keys[0], reparsed[0]))
+
+			// the underlying key is still available through the ssh.CryptoPublicKey wrapper
+			cryptoKey, ok := keys[0].(ssh.CryptoPublicKey)
+			require.True(t, ok)
+			ecdsaKey, ok := cryptoKey.CryptoPublicKey().(*ecdsa.PublicKey)
+			require.True(t, ok)
+			assert.True(t, ecdsaKey.Equal(&privKey.PublicKey))
+
+			// a client with the private key can authenticate
+			agent := &Agent{}
+			agent.keys.Store(&keySet{current: NewSecureKeyStore(keys)})
+			signer, err := ssh.NewSignerFromKey(privKey)
+			require.NoError(t, err)
+			assert.True(t, agent.isAuthorizedKey(signer.PublicKey()))
+			assert.False(t, agent.isAuthorizedKey(newTestPublicKey(t)))
+		})
+	}
+}
+
+// Test case 7: authorized_keys file with options
+func TestParseKeysFromFile(t *testing.T) {
+	content := `# managed by config management
+ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIKCBM91kukN7hbvFKtbpEeo2JXjCcNxXcdBH7V7ADMBo hub@example
+
+from="10.0.0.0/8",command="echo hi there",no-pty ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIJDMtAOQfxDlCxe+A5lVbUY/DHxK1LAF2Z3AV0FYv36D
+`
+	filePath := filepath.Join(t.TempDir(), "authorized_keys")
+	require.NoError(t, os.WriteFile(filePath, []byte(content), 0600))
+
+	keys, err := ParseKeysFromFile(filePath)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "ssh-ed25519", keys[0].Type())
+	assert.Equal(t, "ssh-ed25519", keys[1].Type())
+
+	_, err = ParseKeysFromFile(filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorContains(t, err, "failed to read authorized keys file")
+}
+
+func TestServerVersion(t *testing.T) {
+	_, privKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(privKey)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		addr    string
+		version string
+		want    string
+	}{
+		{
+			name: "default version",
+			addr: "127.0.0.1:45990",
+			want: "SSH-2.0-beszel-agent-" + beszel.Version,
+		},
+		{
+			name:    "custom version",
+			addr:    "127.0.0.1:45991",
+			version: "SSH-2.0-beszel-custom",
+			want:    "SSH-2.0-beszel-custom",
+		},
+		{
+			name:    "custom version without prefix",
+			addr:    "127.0.0.1:45992",
+			version: "beszel-custom",
+			want:    "SSH-2.0-beszel-custom",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := NewAgent()
+			go agent.StartServer(ServerOptions{
+				Network: "tcp",
+				Addr:    tt.addr,
+				Keys:    []ssh.PublicKey{signer.PublicKey()},
+				Version: tt.version,
+			})
+			time.Sleep(100 * time.Millisecond)
+
+			client, err := ssh.Dial("tcp", tt.addr, &ssh.ClientConfig{
+				User:            "a",
+				Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				Timeout:         4 * time.Second,
+			})
+			require.NoError(t, err)
+			defer client.Close()
+
+			assert.Equal(t, tt.want, string(client.ServerVersion()))
+		})
+	}
+}
+
+func TestReloadKeysRotationWindow(t *testing.T) {
+	newKey := func() ssh.PublicKey {
+		pubKey, _, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+		sshPubKey, err := ssh.NewPublicKey(pubKey)
+		require.NoError(t, err)
+		return sshPubKey
+	}
+
+	t.Run("old and new keys accepted during window", func(t *testing.T) {
+		oldKey, rotatedKey := newKey(), newKey()
+		agent := &Agent{}
+		agent.keys.Store(&keySet{current: NewSecureKeyStore([]ssh.PublicKey{oldKey}), rotationWindow: 100 * time.Millisecond})
+
+		agent.ReloadKeys([]ssh.PublicKey{rotatedKey})
+		assert.True(t, agent.isAuthorizedKey(oldKey))
+		assert.True(t, agent.isAuthorizedKey(rotatedKey))
+
+		// old key is dropped after the window expires
+		assert.Eventually(t, func() bool {
+			return !agent.isAuthorizedKey(oldKey)
+		}, time.Second, 10*time.Millisecond)
+		assert.True(t, agent.isAuthorizedKey(rotatedKey))
+	})
+
+	t.Run("no window drops old keys immediately", func(t *testing.T) {
+		oldKey, rotatedKey := newKey(), newKey()
+		agent := &Agent{}
+		agent.keys.Store(&keySet{current: NewSecureKeyStore([]ssh.PublicKey{oldKey})})
+
+		agent.ReloadKeys([]ssh.PublicKey{rotatedKey})
+		assert.False(t, agent.isAuthorizedKey(oldKey))
+		assert.True(t, agent.isAuthorizedKey(rotatedKey))
+	})
+
+	t.Run("expired timer does not drop keys from a later reload", func(t *testing.T) {
+		firstKey, secondKey, thirdKey := newKey(), newKey(), newKey()
+		agent := &Agent{}
+		agent.keys.Store(&keySet{current: NewSecureKeyStore([]ssh.PublicKey{firstKey}), rotationWindow: 200 * time.Millisecond})
+
+		agent.ReloadKeys([]ssh.PublicKey{secondKey})
+		time.Sleep(100 * time.Millisecond)
+		agent.ReloadKeys([]ssh.PublicKey{thirdKey})
+
+		// first timer fires, but second key is still within its own window
+		time.Sleep(150 * time.Millisecond)
+		assert.True(t, agent.isAuthorizedKey(secondKey))
+		assert.True(t, agent.isAuthorizedKey(thirdKey))
+		assert.False(t, agent.isAuthorizedKey(firstKey))
+	})
+
+	t.Run("both keys connect during window", func(t *testing.T) {
+		_, oldPrivKey, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+		oldSigner, err := ssh.NewSignerFromKey(oldPrivKey)
+		require.NoError(t, err)
+		_, newPrivKey, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+		newSigner, err := ssh.NewSignerFromKey(newPrivKey)
+		require.NoError(t, err)
+
+		agent := NewAgent()
+		addr := "127.0.0.1:45993"
+		go agent.StartServer(ServerOptions{
+			Network:           "tcp",
+			Addr:              addr,
+			Keys:              []ssh.PublicKey{oldSigner.PublicKey()},
+			KeyRotationWindow: time.Minute,
+		})
+		time.Sleep(100 * time.Millisecond)
+
+		agent.ReloadKeys([]ssh.PublicKey{newSigner.PublicKey()})
+
+		for _, signer := range []ssh.Signer{oldSigner, newSigner} {
+			client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+				User:            "a",
+				Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				Timeout:         4 * time.Second,
+			})
+			require.NoError(t, err)
+			client.Close()
+		}
+	})
+}
+
+func TestShutdown(t *testing.T) {
+	_, privKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(privKey)
+	require.NoError(t, err)
+
+	agent := NewAgent()
+	addr := "127.0.0.1:45996"
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- agent.StartServer(ServerOptions{
+			Network: "tcp",
+			Addr:    addr,
+			Keys:    []ssh.PublicKey{signer.PublicKey()},
+		})
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// connected client that stays open like the hub
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "a",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         4 * time.Second,
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, agent.Shutdown(ctx))
+
+	select {
+	case err := <-serverErr:
+		assert.NoError(t, err, "StartServer should return nil after shutdown")
+	case <-time.After(5 * time.Second):
+		t.Fatal("StartServer did not return after shutdown")
+	}
+
+	// the idle connection is closed and new connections are refused
+	_, err = client.NewSession()
+	assert.Error(t, err)
+	_, err = net.DialTimeout("tcp", addr, time.Second)
+	assert.Error(t, err)
+}
+
+// fakeRequestSender records the time of each request and fails after failAfter requests
+type fakeRequestSender struct {
+	mu        sync.Mutex
+	names     []string
+	times     []time.Time
+	failAfter int
+}
+
+func (f *fakeRequestSender) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.names = append(f.names, name)
+	f.times = append(f.times, time.Now())
+	if f.failAfter > 0 && len(f.times) >= f.failAfter {
+		return false, errors.New("channel closed")
+	}
+	return false, nil
+}
+
+func (f *fakeRequestSender) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.times)
+}
+
+func TestSendKeepAlives(t *testing.T) {
+	const interval = 20 * time.Millisecond
+
+	t.Run("sends at the configured interval", func(t *testing.T) {
+		sender := &fakeRequestSender{}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		start := time.Now()
+		go func() {
+			sendKeepAlives(ctx, sender, interval)
+			close(done)
+		}()
+		require.Eventually(t, func() bool { return sender.count() >= 4 }, time.Second, time.Millisecond)
+		cancel()
+		<-done
+
+		sender.mu.Lock()
+		defer sender.mu.Unlock()
+		assert.GreaterOrEqual(t, sender.times[0].Sub(start), interval)
+		for i := 1; i < len(sender.times); i++ {
+			// ticker may deliver late but never early by more than a little scheduling jitter
+			assert.GreaterOrEqual(t, sender.times[i].Sub(sender.times[i-1]), interval/2)
+		}
+		for _, name := range sender.names {
+			assert.Equal(t, "keepalive@openssh.com", name)
+		}
+	})
+
+	t.Run("stops when a request fails", func(t *testing.T) {
+		sender := &fakeRequestSender{failAfter: 2}
+		done := make(chan struct{})
+		go func() {
+			sendKeepAlives(context.Background(), sender, interval)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("keepalives did not stop after a failed request")
+		}
+		assert.Equal(t, 2, sender.count())
+	})
+
+	t.Run("nothing sent if the session ends first", func(t *testing.T) {
+		sender := &fakeRequestSender{}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		sendKeepAlives(ctx, sender, interval)
+		assert.Zero(t, sender.count())
+	})
+}
+
+func TestSessionGuard(t *testing.T) {
+	_, privKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(privKey)
+	require.NoError(t, err)
+
+	// startAgent starts an agent listening on a unix socket and returns the socket
+	startAgent := func(allowMultiple bool) (*Agent, string) {
+		agent := NewAgent()
+		t.Cleanup(func() { agent.Shutdown(context.Background()) })
+		socket := filepath.Join(t.TempDir(), "beszel.sock")
+		go agent.StartServer(ServerOptions{
+			Network:               "unix",
+			Addr:                  socket,
+			Keys:                  []ssh.PublicKey{signer.PublicKey()},
+			AllowMultipleSessions: allowMultiple,
+		})
+		time.Sleep(100 * time.Millisecond)
+		return agent, socket
+	}
+	// requestStats returns the exit code and stderr of a stats session
+	requestStats := func(socket string) (int, string) {
+		client, err := ssh.Dial("unix", socket, &ssh.ClientConfig{
+			User:            "a",
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         4 * time.Second,
+		})
+		require.NoError(t, err)
+		defer client.Close()
+		session, err := client.NewSession()
+		require.NoError(t, err)
+		defer session.Close()
+		var stderr strings.Builder
+		session.Stderr = &stderr
+		_, err = session.Output("")
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitStatus(), stderr.String()
+		}
+		require.NoError(t, err)
+		return 0, stderr.String()
+	}
+
+	agent, socket := startAgent(false)
+	code, _ := requestStats(socket)
+	assert.Equal(t, 0, code)
+	assert.False(t, agent.activeSession.Load(), "released when the session ends")
+
+	// another hub's session is in progress
+	agent.activeSession.Store(true)
+	code, stderr := requestStats(socket)
+	assert.Equal(t, sessionBusyExitCode, code)
+	assert.Equal(t, "another stats session is in progress\n", stderr)
+	assert.True(t, agent.activeSession.Load(), "a rejected session doesn't release the guard")
+	agent.activeSession.Store(false)
+	code, _ = requestStats(socket)
+	assert.Equal(t, 0, code)
+
+	agent, socket = startAgent(true)
+	agent.activeSession.Store(true)
+	code, _ = requestStats(socket)
+	assert.Equal(t, 0, code, "the guard is disabled with AllowMultipleSessions")
+}
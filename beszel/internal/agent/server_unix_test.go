@@ -0,0 +1,160 @@
+//go:build linux
+
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// uid and gid of the nobody user, used to connect as a user other than the socket owner
+const nobodyID = 65534
+
+// TestUnixSocketConnectHelper is run as a subprocess by connectAsNobody. It exits
+// with 0 if it can connect to the socket and 3 if permission is denied.
+func TestUnixSocketConnectHelper(t *testing.T) {
+	socket := os.Getenv("BESZEL_TEST_CONNECT_SOCKET")
+	if socket == "" {
+		t.Skip("helper process")
+	}
+	conn, err := net.Dial("unix", socket)
+	if errors.Is(err, syscall.EACCES) {
+		os.Exit(3)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+	conn.Close()
+	os.Exit(0)
+}
+
+// connectAsNobody connects to the socket from a subprocess running as nobody
+// and returns its exit code
+func connectAsNobody(t *testing.T, dir, socket string) int {
+	// copy the test binary where nobody can execute it
+	exe, err := os.Executable()
+	require.NoError(t, err)
+	src, err := os.Open(exe)
+	require.NoError(t, err)
+	defer src.Close()
+	helper := filepath.Join(dir, "helper")
+	dst, err := os.OpenFile(helper, os.O_CREATE|os.O_WRONLY, 0o755)
+	require.NoError(t, err)
+	_, err = io.Copy(dst, src)
+	require.NoError(t, err)
+	require.NoError(t, dst.Close())
+	defer os.Remove(helper)
+
+	cmd := exec.Command(helper, "-test.run=^TestUnixSocketConnectHelper$")
+	cmd.Env = append(os.Environ(), "BESZEL_TEST_CONNECT_SOCKET="+socket)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: nobodyID, Gid: nobodyID},
+	}
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	require.NoError(t, err)
+	return 0
+}
+
+func TestStartServerUnixSocketPermissions(t *testing.T) {
+	pubKey, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	sshPubKey, err := ssh.NewPublicKey(pubKey)
+	require.NoError(t, err)
+
+	// the socket directory must be reachable by other users
+	dir := t.TempDir()
+	require.NoError(t, os.Chmod(filepath.Dir(dir), 0o755))
+	require.NoError(t, os.Chmod(dir, 0o755))
+
+	startServer := func(t *testing.T, opts ServerOptions) string {
+		opts.Network = "unix"
+		opts.Addr = filepath.Join(dir, filepath.Base(t.Name())+".sock")
+		opts.Keys = []ssh.PublicKey{sshPubKey}
+		agent := &Agent{}
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- agent.StartServer(opts)
+		}()
+		require.Eventually(t, func() bool {
+			return agent.server.Load() != nil
+		}, time.Second, 5*time.Millisecond)
+		t.Cleanup(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			agent.Shutdown(ctx)
+			assert.NoError(t, <-errChan)
+		})
+		return opts.Addr
+	}
+
+	t.Run("default", func(t *testing.T) {
+		socket := startServer(t, ServerOptions{})
+		info, err := os.Stat(socket)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+		if os.Geteuid() != 0 {
+			t.Skip("connecting as another user requires root")
+		}
+		assert.Equal(t, 3, connectAsNobody(t, dir, socket), "non-owner should be denied")
+	})
+
+	t.Run("mode", func(t *testing.T) {
+		socket := startServer(t, ServerOptions{UnixSocketMode: 0o666})
+		info, err := os.Stat(socket)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o666), info.Mode().Perm())
+
+		if os.Geteuid() != 0 {
+			t.Skip("connecting as another user requires root")
+		}
+		assert.Equal(t, 0, connectAsNobody(t, dir, socket), "non-owner should be allowed")
+	})
+
+	t.Run("owner", func(t *testing.T) {
+		if os.Geteuid() != 0 {
+			t.Skip("changing the owner requires root")
+		}
+		socket := startServer(t, ServerOptions{UnixSocketOwner: "65534:65534"})
+		info, err := os.Stat(socket)
+		require.NoError(t, err)
+		stat := info.Sys().(*syscall.Stat_t)
+		assert.Equal(t, uint32(nobodyID), stat.Uid)
+		assert.Equal(t, uint32(nobodyID), stat.Gid)
+		assert.Equal(t, 0, connectAsNobody(t, dir, socket), "new owner should be allowed")
+	})
+}
+
+func TestLookupOwner(t *testing.T) {
+	uid, gid, err := lookupOwner("root")
+	require.NoError(t, err)
+	assert.Equal(t, 0, uid)
+	assert.Equal(t, -1, gid)
+
+	uid, gid, err = lookupOwner("0:0")
+	require.NoError(t, err)
+	assert.Equal(t, 0, uid)
+	assert.Equal(t, 0, gid)
+
+	_, _, err = lookupOwner("no-such-user-beszel")
+	assert.Error(t, err)
+	_, _, err = lookupOwner("root:no-such-group-beszel")
+	assert.Error(t, err)
+}
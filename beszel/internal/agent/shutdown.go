@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// sessionGroup tracks in-flight SSH sessions so shutdown can wait for them
+type sessionGroup struct {
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	closed bool
+}
+
+// add registers a new session. Returns false if the agent is shutting down.
+func (g *sessionGroup) add() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return false
+	}
+	g.wg.Add(1)
+	return true
+}
+
+// done marks a session added with add as finished
+func (g *sessionGroup) done() {
+	g.wg.Done()
+}
+
+// close rejects new sessions and waits for in-flight sessions to finish or for ctx to be done
+func (g *sessionGroup) close(ctx context.Context) error {
+	g.mu.Lock()
+	g.closed = true
+	g.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown stops the agent. It closes the SSH listener, waits for in-flight
+// sessions to finish sending stats, closes remaining hub connections, the SNMP
+// server and the status page, stops the GPU collectors, closes the NATS
+// connection, wipes the accepted keys, and removes the Unix socket file if
+// applicable.
+// StartServer returns once the listener is closed.
+func (a *Agent) Shutdown(ctx context.Context) error {
+	var errs []error
+
+	if server := a.server.Load(); server != nil {
+		// Shutdown closes the listener right away but then waits for all connections
+		// to close, and the hub keeps its connection open between requests. So only
+		// wait for in-flight sessions here, then close the remaining connections.
+		go server.Shutdown(ctx)
+		if err := a.sessions.close(ctx); err != nil {
+			errs = append(errs, err)
+		}
+		if err := server.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if snmp := a.snmpServer.Load(); snmp != nil {
+		if err := snmp.conn.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if status := a.statusServer.Load(); status != nil {
+		if err := status.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if a.gpuManager != nil {
+		if err := a.gpuManager.Stop(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	a.stopBackgroundCollection()
+
+	if err := a.nats.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := a.ebpfNet.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := a.perf.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	// wipe the accepted keys now that no more connections are authenticated
+	if keys := a.keys.Load(); keys != nil {
+		keys.close()
+	}
+
+	// the listener normally removes the socket file when closed
+	if a.socketPath != "" {
+		if err := os.Remove(a.socketPath); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+
+	slog.Info("Agent stopped")
+	return errors.Join(errs...)
+}
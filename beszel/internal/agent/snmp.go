@@ -0,0 +1,237 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"cmp"
+	"errors"
+	"log/slog"
+	"maps"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+const (
+	// base of the OIDs in BESZEL-AGENT-MIB.txt. 99999 is a placeholder until an
+	// enterprise number is registered.
+	snmpBaseOID = ".1.3.6.1.4.1.99999"
+	// how long a stats snapshot answers requests, so walking the tree doesn't
+	// collect stats for every OID
+	snmpCacheTTL = 10 * time.Second
+	// session ID used to gather stats for SNMP requests
+	snmpSessionID = "snmp"
+)
+
+// snmpServer answers SNMP requests for the agent stats
+type snmpServer struct {
+	agent     *Agent
+	community string
+	conn      net.PacketConn
+	mu        sync.Mutex
+	values    []snmpValue // sorted by OID
+	updated   time.Time   // time values were last built
+}
+
+// snmpValue is a variable in the stats tree
+type snmpValue struct {
+	oid []int
+	pdu gosnmp.SnmpPDU
+}
+
+// StartSNMPServer answers SNMP v1 and v2c GET and GETNEXT requests for system
+// and GPU stats on the UDP address addr, for requests with the given community.
+// OIDs are defined in BESZEL-AGENT-MIB.txt. It returns once the agent is shut down.
+func (a *Agent) StartSNMPServer(addr, community string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	server := &snmpServer{agent: a, community: community, conn: conn}
+	a.snmpServer.Store(server)
+	slog.Info("Starting SNMP server", "addr", conn.LocalAddr())
+	err = server.serve()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// serve handles requests until the connection is closed
+func (s *snmpServer) serve() error {
+	buf := make([]byte, 65535)
+	decoder := &gosnmp.GoSNMP{}
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		request, err := decoder.SnmpDecodePacket(buf[:n])
+		if err != nil {
+			slog.Debug("Invalid SNMP request", "addr", addr, "err", err)
+			continue
+		}
+		response, err := s.handle(request)
+		if err != nil {
+			slog.Debug("SNMP request", "addr", addr, "err", err)
+			continue
+		}
+		if response == nil {
+			continue
+		}
+		if _, err := s.conn.WriteTo(response, addr); err != nil {
+			slog.Debug("SNMP response", "addr", addr, "err", err)
+		}
+	}
+}
+
+// handle returns the encoded response to a request, or nil if the request is ignored
+func (s *snmpServer) handle(request *gosnmp.SnmpPacket) ([]byte, error) {
+	// requests with the wrong community are dropped, as required by RFC 3584
+	if request.Version == gosnmp.Version3 || request.Community != s.community {
+		return nil, nil
+	}
+	if request.PDUType != gosnmp.GetRequest && request.PDUType != gosnmp.GetNextRequest {
+		return nil, nil
+	}
+	values := s.snapshot()
+	response := &gosnmp.SnmpPacket{
+		Version:   request.Version,
+		Community: request.Community,
+		PDUType:   gosnmp.GetResponse,
+		RequestID: request.RequestID,
+		Variables: make([]gosnmp.SnmpPDU, 0, len(request.Variables)),
+	}
+	for i, variable := range request.Variables {
+		oid, err := parseOID(variable.Name)
+		if err != nil {
+			return nil, err
+		}
+		var pdu gosnmp.SnmpPDU
+		if request.PDUType == gosnmp.GetRequest {
+			pdu = snmpGet(values, oid, variable.Name)
+		} else {
+			pdu = snmpGetNext(values, oid, variable.Name)
+		}
+		// SNMPv1 has no exception values, so a missing variable is an error
+		if request.Version == gosnmp.Version1 && pdu.Value == nil {
+			response.Error, response.ErrorIndex = gosnmp.NoSuchName, uint8(i+1)
+			response.Variables = request.Variables
+			break
+		}
+		response.Variables = append(response.Variables, pdu)
+	}
+	return response.MarshalMsg()
+}
+
+// snmpGet returns the variable at oid, or noSuchObject
+func snmpGet(values []snmpValue, oid []int, name string) gosnmp.SnmpPDU {
+	i, found := slices.BinarySearchFunc(values, oid, func(v snmpValue, oid []int) int {
+		return slices.Compare(v.oid, oid)
+	})
+	if !found {
+		return gosnmp.SnmpPDU{Name: name, Type: gosnmp.NoSuchObject}
+	}
+	return values[i].pdu
+}
+
+// snmpGetNext returns the first variable after oid, or endOfMibView
+func snmpGetNext(values []snmpValue, oid []int, name string) gosnmp.SnmpPDU {
+	i, found := slices.BinarySearchFunc(values, oid, func(v snmpValue, oid []int) int {
+		return slices.Compare(v.oid, oid)
+	})
+	if found {
+		i++
+	}
+	if i == len(values) {
+		return gosnmp.SnmpPDU{Name: name, Type: gosnmp.EndOfMibView}
+	}
+	return values[i].pdu
+}
+
+// snapshot returns the stats tree, gathering stats if the previous snapshot
+// is older than snmpCacheTTL
+func (s *snmpServer) snapshot() []snmpValue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil || time.Since(s.updated) > snmpCacheTTL {
+		// keep the previous values if there are no stats yet
+		if stats, err := s.agent.gatherStats(snmpSessionID); err == nil {
+			s.values = snmpValues(&stats.Stats)
+			s.updated = time.Now()
+		}
+	}
+	return s.values
+}
+
+// snmpValues returns the variables of the stats tree sorted by OID. Percentages,
+// temperatures, power, and bandwidth are Gauge32 values in hundredths, since
+// SNMP has no floating point type.
+//
+//	.1.1.0 - .1.6.0   system scalars: CPU, memory, disk, and bandwidth
+//	.2.1.<col>.<n>    GPU table, one row per GPU in order of id starting at 1
+func snmpValues(stats *system.Stats) []snmpValue {
+	var values []snmpValue
+	add := func(suffix string, typ gosnmp.Asn1BER, value any) {
+		name := snmpBaseOID + suffix
+		oid, _ := parseOID(name)
+		values = append(values, snmpValue{oid: oid, pdu: gosnmp.SnmpPDU{Name: name, Type: typ, Value: value}})
+	}
+	gauge := func(suffix string, value float64) {
+		add(suffix, gosnmp.Gauge32, snmpGauge(value))
+	}
+	gauge(".1.1.0", stats.Cpu*100)
+	gauge(".1.2.0", stats.MemPct*100)
+	gauge(".1.3.0", stats.MemUsed*1024)
+	gauge(".1.4.0", stats.DiskPct*100)
+	gauge(".1.5.0", stats.NetworkSent*100)
+	gauge(".1.6.0", stats.NetworkRecv*100)
+
+	ids := slices.SortedFunc(maps.Keys(stats.GPUData), func(a, b string) int {
+		// numeric ids (e.g. Nvidia indexes) in numeric order
+		x, errX := strconv.Atoi(a)
+		y, errY := strconv.Atoi(b)
+		if errX == nil && errY == nil {
+			return cmp.Compare(x, y)
+		}
+		return strings.Compare(a, b)
+	})
+	for row, id := range ids {
+		gpu := stats.GPUData[id]
+		index := "." + strconv.Itoa(row+1)
+		add(".2.1.1"+index, gosnmp.OctetString, gpu.Name)
+		gauge(".2.1.2"+index, gpu.Usage*100)
+		gauge(".2.1.3"+index, gpu.MemoryUsed)
+		gauge(".2.1.4"+index, gpu.MemoryTotal)
+		gauge(".2.1.5"+index, gpu.Temperature*100)
+		gauge(".2.1.6"+index, gpu.Power*100)
+	}
+	slices.SortFunc(values, func(a, b snmpValue) int {
+		return slices.Compare(a.oid, b.oid)
+	})
+	return values
+}
+
+// snmpGauge rounds value to a Gauge32, which can't be negative
+func snmpGauge(value float64) uint32 {
+	return uint32(min(max(math.Round(value), 0), math.MaxUint32))
+}
+
+// parseOID parses a dotted OID such as .1.3.6.1.4.1
+func parseOID(name string) ([]int, error) {
+	parts := strings.Split(strings.TrimPrefix(name, "."), ".")
+	oid := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, errors.New("invalid OID: " + name)
+		}
+		oid[i] = n
+	}
+	return oid, nil
+}
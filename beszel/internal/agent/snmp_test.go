@@ -0,0 +1,162 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func snmpTestStats() *system.Stats {
+	return &system.Stats{
+		Cpu:         12.34,
+		MemUsed:     9.5,
+		MemPct:      31.37,
+		DiskPct:     45.01,
+		NetworkSent: 0.18,
+		NetworkRecv: 1.42,
+		GPUData: map[string]system.GPUData{
+			"10": {Name: "RTX 4090 10", Usage: 57.2, MemoryUsed: 8192, MemoryTotal: 24564, Temperature: 61, Power: 281.45},
+			"2":  {Name: "RTX 4090 2", Usage: 3.5, MemoryUsed: 512, MemoryTotal: 24564, Temperature: 38, Power: 24},
+		},
+	}
+}
+
+// startTestSNMPServer serves values for stats on a random local port
+func startTestSNMPServer(t *testing.T, stats *system.Stats) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &snmpServer{community: "secret", conn: conn, values: snmpValues(stats), updated: time.Now()}
+	go server.serve()
+	t.Cleanup(func() { conn.Close() })
+	return conn.LocalAddr().String()
+}
+
+func newTestSNMPClient(t *testing.T, addr, community string) *gosnmp.GoSNMP {
+	host, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+	portNum, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	client := &gosnmp.GoSNMP{
+		Target:    host,
+		Port:      uint16(portNum),
+		Community: community,
+		Version:   gosnmp.Version2c,
+		Timeout:   time.Second,
+	}
+	require.NoError(t, client.Connect())
+	t.Cleanup(func() { client.Conn.Close() })
+	return client
+}
+
+func TestSNMPValues(t *testing.T) {
+	values := snmpValues(snmpTestStats())
+	var oids []string
+	for _, value := range values {
+		oids = append(oids, value.pdu.Name)
+	}
+	assert.Equal(t, []string{
+		".1.3.6.1.4.1.99999.1.1.0",
+		".1.3.6.1.4.1.99999.1.2.0",
+		".1.3.6.1.4.1.99999.1.3.0",
+		".1.3.6.1.4.1.99999.1.4.0",
+		".1.3.6.1.4.1.99999.1.5.0",
+		".1.3.6.1.4.1.99999.1.6.0",
+		// each column of the GPU table in turn
+		".1.3.6.1.4.1.99999.2.1.1.1",
+		".1.3.6.1.4.1.99999.2.1.1.2",
+		".1.3.6.1.4.1.99999.2.1.2.1",
+		".1.3.6.1.4.1.99999.2.1.2.2",
+		".1.3.6.1.4.1.99999.2.1.3.1",
+		".1.3.6.1.4.1.99999.2.1.3.2",
+		".1.3.6.1.4.1.99999.2.1.4.1",
+		".1.3.6.1.4.1.99999.2.1.4.2",
+		".1.3.6.1.4.1.99999.2.1.5.1",
+		".1.3.6.1.4.1.99999.2.1.5.2",
+		".1.3.6.1.4.1.99999.2.1.6.1",
+		".1.3.6.1.4.1.99999.2.1.6.2",
+	}, oids)
+	// GPU rows are in numeric order of id
+	assert.Equal(t, "RTX 4090 2", values[6].pdu.Value)
+	assert.Equal(t, uint32(1234), values[0].pdu.Value)
+	assert.Equal(t, uint32(9728), values[2].pdu.Value, "memory used in MB")
+
+	assert.Equal(t, uint32(0), snmpGauge(-1))
+	assert.Equal(t, uint32(4294967295), snmpGauge(1e12))
+}
+
+func TestSNMPServer(t *testing.T) {
+	addr := startTestSNMPServer(t, snmpTestStats())
+	client := newTestSNMPClient(t, addr, "secret")
+
+	t.Run("get", func(t *testing.T) {
+		result, err := client.Get([]string{".1.3.6.1.4.1.99999.1.1.0", ".1.3.6.1.4.1.99999.2.1.1.2", ".1.3.6.1.4.1.99999.1.9.0"})
+		require.NoError(t, err)
+		require.Len(t, result.Variables, 3)
+		assert.Equal(t, gosnmp.Gauge32, result.Variables[0].Type)
+		assert.Equal(t, uint(1234), result.Variables[0].Value)
+		assert.Equal(t, []byte("RTX 4090 10"), result.Variables[1].Value)
+		assert.Equal(t, gosnmp.NoSuchObject, result.Variables[2].Type)
+	})
+
+	t.Run("get next", func(t *testing.T) {
+		result, err := client.GetNext([]string{".1.3.6.1.4.1.99999.1.2", ".1.3.6.1.4.1.99999.2.1.6.2"})
+		require.NoError(t, err)
+		require.Len(t, result.Variables, 2)
+		assert.Equal(t, ".1.3.6.1.4.1.99999.1.2.0", result.Variables[0].Name)
+		assert.Equal(t, uint(3137), result.Variables[0].Value)
+		assert.Equal(t, gosnmp.EndOfMibView, result.Variables[1].Type)
+	})
+
+	t.Run("walk", func(t *testing.T) {
+		var gpuPower []uint
+		err := client.Walk(".1.3.6.1.4.1.99999.2.1.6", func(pdu gosnmp.SnmpPDU) error {
+			gpuPower = append(gpuPower, pdu.Value.(uint))
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []uint{2400, 28145}, gpuPower)
+	})
+
+	t.Run("wrong community", func(t *testing.T) {
+		client := newTestSNMPClient(t, addr, "public")
+		client.Timeout, client.Retries = 100*time.Millisecond, 0
+		_, err := client.Get([]string{".1.3.6.1.4.1.99999.1.1.0"})
+		assert.Error(t, err, "requests are dropped")
+	})
+}
+
+func TestStartSNMPServer(t *testing.T) {
+	agent := NewAgent()
+	done := make(chan error, 1)
+	go func() {
+		done <- agent.StartSNMPServer("127.0.0.1:0", "secret")
+	}()
+	require.Eventually(t, func() bool {
+		return agent.snmpServer.Load() != nil
+	}, time.Second, 10*time.Millisecond)
+
+	client := newTestSNMPClient(t, agent.snmpServer.Load().conn.LocalAddr().String(), "secret")
+	result, err := client.Get([]string{".1.3.6.1.4.1.99999.1.2.0"})
+	require.NoError(t, err)
+	require.Len(t, result.Variables, 1)
+	assert.Equal(t, gosnmp.Gauge32, result.Variables[0].Type)
+	assert.Greater(t, result.Variables[0].Value, uint(0), "memory is in use")
+
+	require.NoError(t, agent.Shutdown(context.Background()))
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("StartSNMPServer did not return after shutdown")
+	}
+}
@@ -0,0 +1,40 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sockstatFixture = `sockets: used 1312
+TCP: inuse 45 orphan 2 tw 12 alloc 60 mem 5
+UDP: inuse 8 mem 2
+UDPLITE: inuse 0
+RAW: inuse 1
+FRAG: inuse 0 memory 0
+`
+
+const sockstat6Fixture = `TCP6: inuse 9
+UDP6: inuse 3
+UDPLITE6: inuse 0
+RAW6: inuse 1
+FRAG6: inuse 0 memory 0
+`
+
+func TestParseSockstat(t *testing.T) {
+	var stats system.SocketStats
+	require.NoError(t, parseSockstat(strings.NewReader(sockstatFixture), &stats))
+	assert.Equal(t, system.SocketStats{Used: 1312, TCPInUse: 45, TCPOrphan: 2, TCPTimeWait: 12, UDPInUse: 8, RAWInUse: 1}, stats)
+
+	// IPv6 sockets are added
+	require.NoError(t, parseSockstat(strings.NewReader(sockstat6Fixture), &stats))
+	assert.Equal(t, system.SocketStats{Used: 1312, TCPInUse: 54, TCPOrphan: 2, TCPTimeWait: 12, UDPInUse: 11, RAWInUse: 2}, stats)
+
+	assert.Error(t, parseSockstat(strings.NewReader("TCP: inuse x\n"), &stats))
+}
@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"beszel/internal/common"
+	"beszel/internal/entities/system"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+const (
+	// default number of samples kept while the hub is unreachable
+	defaultStatsBufferSize = 60
+	// how often stats are buffered while the hub is unreachable, matching the hub polling interval
+	statsBufferInterval = time.Minute
+	// session ID used to gather buffered stats, so they don't count as a hub session
+	statsBufferSessionID = "stats-buffer"
+)
+
+// BufferedStats is a stats sample collected while the hub was unreachable
+type BufferedStats struct {
+	Time time.Time `json:"ts"`
+	system.CombinedData
+}
+
+// StatsRingBuffer keeps the most recent stats samples, overwriting the oldest when full
+type StatsRingBuffer struct {
+	mu      sync.Mutex
+	samples []BufferedStats
+	head    int // index of the next sample to write
+	count   int // number of samples stored, up to len(samples)
+}
+
+// NewStatsRingBuffer returns a buffer that holds up to capacity samples
+func NewStatsRingBuffer(capacity int) *StatsRingBuffer {
+	return &StatsRingBuffer{samples: make([]BufferedStats, capacity)}
+}
+
+// statsBufferSize returns the buffer capacity from BESZEL_BUFFER_SIZE, or the default
+func statsBufferSize() int {
+	value, exists := GetEnvFallback("BESZEL_AGENT_BUFFER_SIZE", "BESZEL_BUFFER_SIZE")
+	if !exists {
+		return defaultStatsBufferSize
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 0 {
+		slog.Warn("Invalid BESZEL_BUFFER_SIZE", "value", value)
+		return defaultStatsBufferSize
+	}
+	return size
+}
+
+// Push adds a sample, overwriting the oldest sample if the buffer is full
+func (b *StatsRingBuffer) Push(sample BufferedStats) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.samples) == 0 {
+		return
+	}
+	b.samples[b.head] = sample
+	b.head = (b.head + 1) % len(b.samples)
+	b.count = min(b.count+1, len(b.samples))
+}
+
+// Len returns the number of samples stored
+func (b *StatsRingBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.count
+}
+
+// Drain returns all samples, oldest first, and empties the buffer
+func (b *StatsRingBuffer) Drain() []BufferedStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	samples := make([]BufferedStats, 0, b.count)
+	start := (b.head - b.count + len(b.samples)) % max(len(b.samples), 1)
+	for i := range b.count {
+		samples = append(samples, b.samples[(start+i)%len(b.samples)])
+	}
+	clear(b.samples)
+	b.head, b.count = 0, 0
+	return samples
+}
+
+// runStatsBuffer buffers stats every statsBufferInterval while the hub is not
+// requesting them, until ctx is cancelled
+func (a *Agent) runStatsBuffer(ctx context.Context) {
+	ticker := time.NewTicker(statsBufferInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if now.Sub(time.Unix(0, a.lastStatsRequest.Load())) < statsBufferInterval {
+				continue
+			}
+			a.bufferStats(now)
+		}
+	}
+}
+
+// bufferStats gathers the current stats and adds them to the buffer
+func (a *Agent) bufferStats(now time.Time) {
+	stats, err := a.gatherStats(statsBufferSessionID)
+	if err != nil {
+		return
+	}
+	a.statsBuffer.Push(BufferedStats{Time: now, CombinedData: *stats})
+}
+
+// wantsCatchUp returns true if the hub asked for buffered stats
+func wantsCatchUp(s ssh.Session) bool {
+	return slices.Contains(s.Environ(), common.CatchUpEnv+"=1")
+}
+
+// replayBufferedStats writes all buffered samples, oldest first, and empties the buffer
+func (a *Agent) replayBufferedStats(enc *json.Encoder) error {
+	if a.statsBuffer == nil {
+		return nil
+	}
+	for _, sample := range a.statsBuffer.Drain() {
+		sample.ProtocolVersion = statsEncoder.Version()
+		if err := enc.Encode(sample); err != nil {
+			return err
+		}
+	}
+	return nil
+}
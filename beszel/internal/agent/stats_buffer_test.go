@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func bufferedSample(start time.Time, i int) BufferedStats {
+	return BufferedStats{
+		Time:         start.Add(time.Duration(i) * time.Minute),
+		CombinedData: system.CombinedData{Stats: system.Stats{Cpu: float64(i)}},
+	}
+}
+
+func TestStatsRingBuffer(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("returns samples oldest first", func(t *testing.T) {
+		b := NewStatsRingBuffer(5)
+		for i := range 3 {
+			b.Push(bufferedSample(start, i))
+		}
+		assert.Equal(t, 3, b.Len())
+		samples := b.Drain()
+		require.Len(t, samples, 3)
+		for i, sample := range samples {
+			assert.Equal(t, float64(i), sample.Stats.Cpu)
+		}
+		assert.Zero(t, b.Len())
+		assert.Empty(t, b.Drain())
+	})
+
+	t.Run("wraps around and keeps the most recent samples", func(t *testing.T) {
+		b := NewStatsRingBuffer(4)
+		for i := range 10 {
+			b.Push(bufferedSample(start, i))
+		}
+		assert.Equal(t, 4, b.Len())
+		samples := b.Drain()
+		require.Len(t, samples, 4)
+		for i, sample := range samples {
+			assert.Equal(t, float64(6+i), sample.Stats.Cpu)
+			assert.Equal(t, start.Add(time.Duration(6+i)*time.Minute), sample.Time)
+		}
+
+		// buffer is reusable after draining
+		b.Push(bufferedSample(start, 42))
+		samples = b.Drain()
+		require.Len(t, samples, 1)
+		assert.Equal(t, 42.0, samples[0].Stats.Cpu)
+	})
+
+	t.Run("zero capacity stores nothing", func(t *testing.T) {
+		b := NewStatsRingBuffer(0)
+		b.Push(bufferedSample(start, 1))
+		assert.Zero(t, b.Len())
+		assert.Empty(t, b.Drain())
+	})
+}
+
+func TestStatsBufferSize(t *testing.T) {
+	t.Setenv("BESZEL_BUFFER_SIZE", "120")
+	assert.Equal(t, 120, statsBufferSize())
+	t.Setenv("BESZEL_BUFFER_SIZE", "0")
+	assert.Equal(t, 0, statsBufferSize())
+	t.Setenv("BESZEL_BUFFER_SIZE", "lots")
+	assert.Equal(t, defaultStatsBufferSize, statsBufferSize())
+	// the BESZEL_AGENT_ prefix takes precedence
+	t.Setenv("BESZEL_AGENT_BUFFER_SIZE", "30")
+	assert.Equal(t, 30, statsBufferSize())
+}
+
+func TestReplayBufferedStats(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	agent := &Agent{statsBuffer: NewStatsRingBuffer(3)}
+	for i := range 5 {
+		agent.statsBuffer.Push(bufferedSample(start, i))
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, agent.replayBufferedStats(json.NewEncoder(&buf)))
+
+	// samples are written as consecutive JSON values, oldest first
+	decoder := json.NewDecoder(&buf)
+	var replayed []BufferedStats
+	for {
+		var sample BufferedStats
+		if err := decoder.Decode(&sample); err == io.EOF {
+			break
+		} else {
+			require.NoError(t, err)
+		}
+		replayed = append(replayed, sample)
+	}
+	require.Len(t, replayed, 3)
+	for i, sample := range replayed {
+		assert.Equal(t, float64(2+i), sample.Stats.Cpu)
+		assert.True(t, start.Add(time.Duration(2+i)*time.Minute).Equal(sample.Time))
+	}
+	assert.Zero(t, agent.statsBuffer.Len())
+
+	// disabled buffer writes nothing
+	buf.Reset()
+	require.NoError(t, (&Agent{}).replayBufferedStats(json.NewEncoder(&buf)))
+	assert.Zero(t, buf.Len())
+}
@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"beszel/internal/common"
+	"beszel/internal/entities/system"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// StatsEncoder writes stats responses in a specific protocol version
+type StatsEncoder interface {
+	// Version returns the protocol version written by the encoder
+	Version() int
+	// Encode writes stats to w in format, system.EncodingJSON or system.EncodingProtobuf
+	Encode(w io.Writer, stats *system.CombinedData, format string) error
+}
+
+// NewStatsEncoder returns an encoder for the given protocol version
+func NewStatsEncoder(version int) (StatsEncoder, error) {
+	switch version {
+	case 1:
+		return statsEncoderV1{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported protocol version: %d", version)
+	}
+}
+
+// sessionEncoding returns the stats encoding requested by the hub with
+// common.EncodingEnv, or JSON if the hub didn't request one
+func sessionEncoding(s ssh.Session) string {
+	if slices.Contains(s.Environ(), common.EncodingEnv+"="+system.EncodingProtobuf) {
+		return system.EncodingProtobuf
+	}
+	return system.EncodingJSON
+}
+
+// statsEncoder writes the stats responses sent to the hub
+var statsEncoder, _ = NewStatsEncoder(system.CurrentProtocolVersion)
+
+// statsEncoderV1 writes system.CombinedData with the version set
+type statsEncoderV1 struct{}
+
+func (statsEncoderV1) Version() int {
+	return 1
+}
+
+func (e statsEncoderV1) Encode(w io.Writer, stats *system.CombinedData, format string) error {
+	// copy so the cached stats are not modified
+	data := *stats
+	data.ProtocolVersion = e.Version()
+	return data.Encode(w, format)
+}
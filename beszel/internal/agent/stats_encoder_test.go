@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"beszel/internal/entities/system"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestNewStatsEncoder(t *testing.T) {
+	encoder, err := NewStatsEncoder(1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, encoder.Version())
+
+	for _, version := range []int{0, 2, -1} {
+		_, err := NewStatsEncoder(version)
+		assert.Error(t, err, "version %d", version)
+	}
+
+	assert.Equal(t, system.CurrentProtocolVersion, statsEncoder.Version())
+}
+
+func TestStatsEncoderV1(t *testing.T) {
+	encoder, err := NewStatsEncoder(1)
+	require.NoError(t, err)
+
+	stats := deltaTestStats()
+	var buf bytes.Buffer
+	require.NoError(t, encoder.Encode(&buf, stats, system.EncodingJSON))
+	assert.Zero(t, stats.ProtocolVersion, "stats passed in should not be modified")
+
+	// version is the first field so the hub can read it before the rest of the response
+	assert.True(t, bytes.HasPrefix(buf.Bytes(), []byte(`{"protocol":1,`)), buf.String())
+
+	var decoded system.CombinedData
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, 1, decoded.ProtocolVersion)
+	assert.Equal(t, stats.Info, decoded.Info)
+	assert.Equal(t, stats.Meta, decoded.Meta)
+}
+
+func TestStatsEncoderV1Protobuf(t *testing.T) {
+	encoder, err := NewStatsEncoder(1)
+	require.NoError(t, err)
+
+	stats := deltaTestStats()
+	var buf bytes.Buffer
+	require.NoError(t, encoder.Encode(&buf, stats, system.EncodingProtobuf))
+	assert.Zero(t, stats.ProtocolVersion, "stats passed in should not be modified")
+
+	// length prefix, then protocol_version (field 1, varint) as the first field
+	_, n := protowire.ConsumeVarint(buf.Bytes())
+	require.Greater(t, n, 0)
+	assert.Equal(t, []byte{0x08, 0x01}, buf.Bytes()[n:n+2])
+
+	var jsonBuf bytes.Buffer
+	require.NoError(t, encoder.Encode(&jsonBuf, stats, system.EncodingJSON))
+	assert.Less(t, buf.Len(), jsonBuf.Len())
+}
+
+func TestStatsDeltaProtocolVersion(t *testing.T) {
+	agent := &Agent{}
+	agent.EnableDeltaMode(true)
+
+	full, err := agent.statsDelta("session-a", deltaTestStats())
+	require.NoError(t, err)
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal(encodeDelta(t, full), &decoded))
+	assert.Equal(t, float64(1), decoded["protocol"])
+
+	// the version does not change, so deltas leave it out
+	delta, err := agent.statsDelta("session-a", deltaTestStats())
+	require.NoError(t, err)
+	assert.NotContains(t, string(encodeDelta(t, delta)), "protocol")
+}
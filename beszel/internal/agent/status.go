@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"beszel"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// statusTemplate is the status page. It refreshes itself so it can be left open.
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<title>Beszel Agent {{.Version}}</title>
+</head>
+<body>
+<h1>Beszel Agent</h1>
+<table>
+<tr><th align="left">Version</th><td>{{.Version}}</td></tr>
+<tr><th align="left">Uptime</th><td>{{.Uptime}}</td></tr>
+<tr><th align="left">Last collection</th><td>{{.LastCollection}}</td></tr>
+<tr><th align="left">GPUs</th><td>{{.GPUs}}</td></tr>
+<tr><th align="left">SSH server</th><td>{{.SSHAddr}}</td></tr>
+</table>
+<h2>Latest stats</h2>
+<pre>{{.Stats}}</pre>
+</body>
+</html>
+`))
+
+// statusPage is the data shown on the status page
+type statusPage struct {
+	Version        string
+	Uptime         time.Duration
+	LastCollection string
+	GPUs           int
+	SSHAddr        string
+	Stats          string // indented JSON of the last collected stats
+}
+
+// StartStatusPage serves a plain HTML status page on addr, to check on the agent
+// when the hub can't reach it over SSH. If BESZEL_STATUS_USER or BESZEL_STATUS_PASS
+// is set, requests must use HTTP basic auth. It returns once the agent is shut down.
+func (a *Agent) StartStatusPage(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	user, _ := GetEnvFallback("BESZEL_AGENT_STATUS_USER", "BESZEL_STATUS_USER")
+	pass, _ := GetEnvFallback("BESZEL_AGENT_STATUS_PASS", "BESZEL_STATUS_PASS")
+	if user == "" && pass == "" {
+		slog.Warn("Status page has no authentication, set BESZEL_STATUS_USER and BESZEL_STATUS_PASS to enable it")
+	}
+	server := &http.Server{
+		Handler:           a.statusHandler(user, pass),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	a.statusServer.Store(server)
+	slog.Info("Starting status page", "addr", ln.Addr())
+	if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// statusHandler returns the handler of the status page, requiring basic auth
+// with user and pass unless both are empty
+func (a *Agent) statusHandler(user, pass string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		if user != "" || pass != "" {
+			reqUser, reqPass, ok := r.BasicAuth()
+			// evaluate both comparisons so timing doesn't reveal which one failed
+			userOK := subtle.ConstantTimeCompare([]byte(reqUser), []byte(user)) == 1
+			passOK := subtle.ConstantTimeCompare([]byte(reqPass), []byte(pass)) == 1
+			if !ok || !userOK || !passOK {
+				w.Header().Set("WWW-Authenticate", `Basic realm="beszel-agent", charset="UTF-8"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := statusTemplate.Execute(w, a.statusPage()); err != nil {
+			slog.Debug("Status page", "err", err)
+		}
+	})
+	return mux
+}
+
+// statusPage returns the current status of the agent. Stats are not collected
+// for the page, the stats of the last collection are shown instead.
+func (a *Agent) statusPage() statusPage {
+	page := statusPage{
+		Version:        beszel.Version,
+		Uptime:         time.Since(a.startTime).Round(time.Second),
+		LastCollection: "never",
+		SSHAddr:        "not running",
+	}
+	if last := a.lastCollection.Load(); last != 0 {
+		page.LastCollection = time.Unix(0, last).Format(time.RFC3339)
+	}
+	if server := a.server.Load(); server != nil {
+		page.SSHAddr = server.Addr
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	if a.lastCollection.Load() == 0 {
+		page.Stats = "no stats collected yet"
+		return page
+	}
+	page.GPUs = len(a.cache.data.Stats.GPUData)
+	// the template escapes the stats, so the encoder doesn't need to
+	var stats strings.Builder
+	encoder := json.NewEncoder(&stats)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(a.cache.data); err != nil {
+		page.Stats = err.Error()
+	} else {
+		page.Stats = stats.String()
+	}
+	return page
+}
@@ -0,0 +1,80 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel"
+	"beszel/internal/entities/system"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// getStatusPage requests the status page from server with basic auth unless user is empty
+func getStatusPage(t *testing.T, server *httptest.Server, user, pass string) (int, string) {
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/", nil)
+	require.NoError(t, err)
+	if user != "" {
+		req.SetBasicAuth(user, pass)
+	}
+	resp, err := server.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestStatusPage(t *testing.T) {
+	a := &Agent{cache: NewSessionCache(time.Minute), startTime: time.Now().Add(-90 * time.Second)}
+	server := httptest.NewServer(a.statusHandler("", ""))
+	defer server.Close()
+
+	status, body := getStatusPage(t, server, "", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, beszel.Version)
+	assert.Contains(t, body, "1m30s")
+	assert.Contains(t, body, "never")
+	assert.Contains(t, body, "not running")
+	assert.Contains(t, body, "no stats collected yet")
+
+	a.cache.Set("", &system.CombinedData{
+		Info: system.Info{Hostname: "web-01<script>"},
+		Stats: system.Stats{GPUData: map[string]system.GPUData{
+			"0": {Name: "RTX 4090"},
+			"1": {Name: "RTX 4090"},
+		}},
+	})
+	a.lastCollection.Store(time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC).UnixNano())
+	_, body = getStatusPage(t, server, "", "")
+	assert.Contains(t, body, "<tr><th align=\"left\">GPUs</th><td>2</td></tr>")
+	assert.Contains(t, body, time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC).Local().Format(time.RFC3339))
+	assert.Contains(t, body, "&#34;h&#34;: &#34;web-01&lt;script&gt;&#34;", "stats are escaped once")
+
+	resp, err := server.Client().Get(server.URL + "/other")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestStatusPageAuth(t *testing.T) {
+	a := &Agent{cache: NewSessionCache(time.Minute), startTime: time.Now()}
+	server := httptest.NewServer(a.statusHandler("admin", "s3cret"))
+	defer server.Close()
+
+	status, body := getStatusPage(t, server, "admin", "s3cret")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, beszel.Version)
+
+	for _, creds := range [][2]string{{"", ""}, {"admin", "wrong"}, {"root", "s3cret"}} {
+		status, body := getStatusPage(t, server, creds[0], creds[1])
+		assert.Equal(t, http.StatusUnauthorized, status, creds)
+		assert.NotContains(t, body, beszel.Version, creds)
+	}
+}
@@ -0,0 +1,221 @@
+package agent
+
+import (
+	"beszel"
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// local syslog socket
+	syslogSocket = "/dev/log"
+	// app name of alert messages
+	syslogApp = "beszel-agent"
+	// structured data ID, using the placeholder enterprise number of BESZEL-AGENT-MIB.txt
+	syslogSDID = "beszel@99999"
+	// daemon facility
+	syslogFacility = 3
+	// kernel counters, including oom_kill
+	procVmstat = "/proc/vmstat"
+)
+
+// syslog severities used for alerts. The log/syslog package can't be used as it
+// only writes RFC 3164 messages and isn't available on Windows.
+const (
+	syslogCritical = 2
+	syslogError    = 3
+	syslogWarning  = 4
+)
+
+// SyslogAlerter forwards critical events to the local syslog socket as RFC 5424
+// messages, and pushes them to the hub if hub notifications are enabled. Each
+// alert is only sent when its condition starts or worsens, not on every
+// collection. Methods can be called on a nil SyslogAlerter.
+type SyslogAlerter struct {
+	mu         sync.Mutex
+	addr       string
+	conn       net.Conn
+	notifier   *NotificationDialer // pushes alerts to the hub, nil unless BESZEL_HUB_ADDR is set
+	hostname   string
+	tempLevels map[string]int    // syslog severity of the last temperature alert per GPU, 0 if none
+	eccErrors  map[string]uint64 // uncorrected ECC errors per GPU from the previous sample
+	oomKills   uint64            // OOM kills counted by the kernel at the previous check
+	oomChecked bool              // true once oomKills has a baseline
+}
+
+// newSyslogAlerter returns an alerter if BESZEL_SYSLOG is true or notifier is
+// set, or nil otherwise
+func newSyslogAlerter(hostname string, notifier *NotificationDialer) *SyslogAlerter {
+	enabled, _ := GetEnvFallback("BESZEL_AGENT_SYSLOG", "BESZEL_SYSLOG")
+	if enabled != "true" && notifier == nil {
+		return nil
+	}
+	s := &SyslogAlerter{hostname: hostname, notifier: notifier}
+	if enabled == "true" {
+		s.addr = syslogSocket
+	}
+	return s
+}
+
+// GPUTemperature sends an alert when a GPU temperature rises above the warning
+// or critical threshold
+func (s *SyslogAlerter) GPUTemperature(gpu string, temp float64, critical bool) {
+	if s == nil {
+		return
+	}
+	severity := syslogWarning
+	if critical {
+		severity = syslogCritical
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tempLevels == nil {
+		s.tempLevels = make(map[string]int)
+	}
+	// lower severity values are more severe
+	if last, ok := s.tempLevels[gpu]; ok && last <= severity {
+		return
+	}
+	s.tempLevels[gpu] = severity
+	s.send(severity, "GPU_TEMP", fmt.Sprintf("GPU %s temperature %.1f°C", gpu, temp))
+}
+
+// GPUTemperatureNormal resets the temperature alert of a GPU, so the next
+// threshold crossing is sent again
+func (s *SyslogAlerter) GPUTemperatureNormal(gpu string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tempLevels, gpu)
+}
+
+// ECCErrors sends an alert when the uncorrected ECC error count of a GPU increases
+func (s *SyslogAlerter) ECCErrors(gpu string, count uint64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.eccErrors == nil {
+		s.eccErrors = make(map[string]uint64)
+	}
+	prev, ok := s.eccErrors[gpu]
+	s.eccErrors[gpu] = count
+	if ok && count > prev {
+		s.send(syslogCritical, "GPU_ECC", fmt.Sprintf("GPU %s uncorrected ECC errors increased by %d to %d", gpu, count-prev, count))
+	}
+}
+
+// CheckOOMKills sends an alert if the kernel OOM killer ran since the previous check
+func (s *SyslogAlerter) CheckOOMKills() {
+	if s == nil {
+		return
+	}
+	file, err := os.Open(procVmstat)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	kills, err := parseOOMKills(file)
+	if err != nil {
+		slog.Debug("OOM kills", "err", err)
+		return
+	}
+	s.oomKillsChanged(kills)
+}
+
+// oomKillsChanged stores the OOM kill count and sends an alert if it increased
+func (s *SyslogAlerter) oomKillsChanged(kills uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, checked := s.oomKills, s.oomChecked
+	s.oomKills, s.oomChecked = kills, true
+	if checked && kills > prev {
+		s.send(syslogError, "OOM_KILL", fmt.Sprintf("OOM killer ran %d times", kills-prev))
+	}
+}
+
+// parseOOMKills returns the oom_kill counter in /proc/vmstat, added in Linux 4.13
+func parseOOMKills(r io.Reader) (uint64, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "oom_kill "); ok {
+			return strconv.ParseUint(value, 10, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("oom_kill not found")
+}
+
+// send pushes an alert to the hub and writes it to the syslog socket, connecting
+// first if needed. It must be called with the lock held.
+func (s *SyslogAlerter) send(severity int, msgID, msg string) {
+	slog.Debug("Syslog alert", "id", msgID, "msg", msg)
+	s.notifier.Notify(msgID, severity, msg)
+	// syslog is disabled if the alerter was only created for hub notifications
+	if s.addr == "" {
+		return
+	}
+	message := formatSyslogMessage(time.Now(), severity, s.hostname, msgID, msg)
+	// reconnect once, e.g. if the syslog daemon restarted
+	for range 2 {
+		if s.conn == nil {
+			conn, err := net.Dial("unixgram", s.addr)
+			if err != nil {
+				slog.Warn("Error connecting to syslog", "err", err)
+				return
+			}
+			s.conn = conn
+		}
+		if _, err := s.conn.Write([]byte(message)); err == nil {
+			return
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	slog.Warn("Error sending syslog alert", "id", msgID)
+}
+
+// formatSyslogMessage returns an RFC 5424 message with the hostname and agent
+// version as structured data, e.g.
+//
+//	<26>1 2026-10-16T11:05:00.000000Z web-01 beszel-agent 1234 GPU_TEMP [beszel@99999 hostname="web-01" version="0.11.1"] GPU 0 temperature 97.0°C
+func formatSyslogMessage(now time.Time, severity int, hostname, msgID, msg string) string {
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s [%s hostname=\"%s\" version=\"%s\"] %s",
+		syslogFacility*8+severity,
+		now.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		syslogNil(hostname),
+		syslogApp,
+		os.Getpid(),
+		msgID,
+		syslogSDID,
+		escapeSDParam(hostname),
+		escapeSDParam(beszel.Version),
+		msg,
+	)
+}
+
+// syslogNil returns value, or the nil value "-" of RFC 5424 header fields if it is empty
+func syslogNil(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// escapeSDParam escapes the characters that must be escaped in structured data values
+func escapeSDParam(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
@@ -0,0 +1,140 @@
+//go:build testing
+// +build testing
+
+package agent
+
+import (
+	"beszel"
+	"beszel/internal/entities/system"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSyslogAlerter returns an alerter writing to a unixgram socket and a
+// function that returns the messages it received
+func newTestSyslogAlerter(t *testing.T) (*SyslogAlerter, func() []string) {
+	addr := filepath.Join(t.TempDir(), "log")
+	conn, err := net.ListenPacket("unixgram", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	received := func() []string {
+		var messages []string
+		buf := make([]byte, 2048)
+		for {
+			conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return messages
+			}
+			messages = append(messages, string(buf[:n]))
+		}
+	}
+	return &SyslogAlerter{addr: addr, hostname: "web-01"}, received
+}
+
+func TestSyslogAlerterNil(t *testing.T) {
+	t.Setenv("BESZEL_AGENT_SYSLOG", "")
+	t.Setenv("BESZEL_SYSLOG", "")
+	alerter := newSyslogAlerter("web-01", nil)
+	require.Nil(t, alerter)
+	assert.NotPanics(t, func() {
+		alerter.GPUTemperature("0", 97, true)
+		alerter.GPUTemperatureNormal("0")
+		alerter.ECCErrors("0", 1)
+		alerter.CheckOOMKills()
+	})
+
+	t.Setenv("BESZEL_SYSLOG", "true")
+	assert.NotNil(t, newSyslogAlerter("web-01", nil))
+}
+
+func TestSyslogGPUTemperature(t *testing.T) {
+	alerter, received := newTestSyslogAlerter(t)
+
+	alerter.GPUTemperature("RTX 4090", 87, false)
+	messages := received()
+	require.Len(t, messages, 1)
+	pattern := `^<28>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}Z web-01 beszel-agent \d+ GPU_TEMP ` +
+		regexp.QuoteMeta(`[beszel@99999 hostname="web-01" version="`+beszel.Version+`"] GPU RTX 4090 temperature 87.0°C`) + `$`
+	assert.Regexp(t, pattern, messages[0])
+
+	// not repeated while above the threshold
+	alerter.GPUTemperature("RTX 4090", 88, false)
+	assert.Empty(t, received())
+
+	// sent again when critical
+	alerter.GPUTemperature("RTX 4090", 96, true)
+	messages = received()
+	require.Len(t, messages, 1)
+	assert.True(t, strings.HasPrefix(messages[0], "<26>1 "))
+	alerter.GPUTemperature("RTX 4090", 90, false)
+	assert.Empty(t, received(), "not sent when the temperature drops to warning")
+
+	// sent again after returning to normal
+	alerter.GPUTemperatureNormal("RTX 4090")
+	alerter.GPUTemperature("RTX 4090", 86, false)
+	assert.Len(t, received(), 1)
+}
+
+func TestSyslogECCErrors(t *testing.T) {
+	alerter, received := newTestSyslogAlerter(t)
+
+	// the first sample is a baseline
+	alerter.ECCErrors("A100 0", 2)
+	assert.Empty(t, received())
+	alerter.ECCErrors("A100 0", 2)
+	assert.Empty(t, received())
+
+	alerter.ECCErrors("A100 0", 5)
+	messages := received()
+	require.Len(t, messages, 1)
+	assert.Contains(t, messages[0], " GPU_ECC ")
+	assert.True(t, strings.HasSuffix(messages[0], "GPU A100 0 uncorrected ECC errors increased by 3 to 5"))
+}
+
+func TestSyslogOOMKills(t *testing.T) {
+	kills, err := parseOOMKills(strings.NewReader("pgmajfault 1234\noom_kill 7\nnuma_hit 9\n"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(7), kills)
+	_, err = parseOOMKills(strings.NewReader("pgmajfault 1234\n"))
+	assert.Error(t, err, "kernels before 4.13")
+
+	alerter, received := newTestSyslogAlerter(t)
+	alerter.oomKillsChanged(7)
+	assert.Empty(t, received(), "the first check is a baseline")
+	alerter.oomKillsChanged(9)
+	messages := received()
+	require.Len(t, messages, 1)
+	assert.True(t, strings.HasPrefix(messages[0], "<27>1 "))
+	assert.True(t, strings.HasSuffix(messages[0], " OOM killer ran 2 times"))
+}
+
+func TestSyslogReconnect(t *testing.T) {
+	alerter, received := newTestSyslogAlerter(t)
+	alerter.ECCErrors("0", 0)
+	alerter.ECCErrors("0", 1)
+	require.Len(t, received(), 1)
+
+	// syslog daemon restarted and recreated its socket
+	alerter.conn.Close()
+	alerter.ECCErrors("0", 2)
+	assert.Len(t, received(), 1)
+}
+
+func TestParseNvidiaECCErrors(t *testing.T) {
+	alerter, received := newTestSyslogAlerter(t)
+	gm := &GPUManager{GpuDataMap: make(map[string]*system.GPUData), opts: GPUManagerOptions{Alerter: alerter}}
+
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA A100, 50, 8192, 40960, 30, 200, 0, 400, 4, 16, 0\n1, NVIDIA GeForce RTX 3080, 50, 2048, 10240, 30, 200, 0, 320, 4, 16, [N/A]")))
+	require.True(t, gm.parseNvidiaData([]byte("0, NVIDIA A100, 50, 8192, 40960, 30, 200, 0, 400, 4, 16, 1\n1, NVIDIA GeForce RTX 3080, 50, 2048, 10240, 30, 200, 0, 320, 4, 16, [N/A]")))
+	messages := received()
+	require.Len(t, messages, 1)
+	assert.True(t, strings.HasSuffix(messages[0], "GPU A100 0 uncorrected ECC errors increased by 1 to 1"))
+}
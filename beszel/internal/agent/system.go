@@ -4,9 +4,13 @@ import (
 	"beszel"
 	"beszel/internal/entities/system"
 	"bufio"
+	"context"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
+	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -18,10 +22,49 @@ import (
 	psutilNet "github.com/shirou/gopsutil/v4/net"
 )
 
+// valid RFC 1123 hostname label: letters, digits, and hyphens, not starting or ending with a hyphen
+var hostnameLabelPattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// isValidHostname returns true if name is a valid RFC 1123 hostname
+func isValidHostname(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for label := range strings.SplitSeq(strings.TrimSuffix(name, "."), ".") {
+		if !hostnameLabelPattern.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// getHostname returns the hostname from BESZEL_AGENT_HOSTNAME or BESZEL_HOSTNAME if set
+// and valid, or the system hostname. The unprefixed HOSTNAME is ignored because shells
+// and container runtimes set it (Docker sets it to the container ID).
+func getHostname() string {
+	if name, exists := GetEnvFallback("BESZEL_AGENT_HOSTNAME", "BESZEL_HOSTNAME"); exists {
+		if isValidHostname(name) {
+			return name
+		}
+		slog.Warn("Invalid hostname, using system hostname", "value", name)
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
 // Sets initial / non-changing values about the host system
 func (a *Agent) initializeSystemInfo() {
 	a.systemInfo.AgentVersion = beszel.Version
-	a.systemInfo.Hostname, _ = os.Hostname()
+	a.systemInfo.Hostname = getHostname()
+
+	a.meta = system.AgentMeta{
+		Version:   beszel.Version,
+		BuildTime: beszel.BuildTime,
+		GoVersion: runtime.Version(),
+		GOARCH:    runtime.GOARCH,
+		GOOS:      runtime.GOOS,
+		Encodings: []string{system.EncodingJSON, system.EncodingProtobuf},
+	}
 
 	platform, _, version, _ := host.PlatformInformation()
 
@@ -66,18 +109,13 @@ func (a *Agent) initializeSystemInfo() {
 }
 
 // Returns current info, stats about the host system
-func (a *Agent) getSystemStats() system.Stats {
+func (a *Agent) getSystemStats(ctx context.Context) system.Stats {
 	systemStats := system.Stats{}
 
-	// cpu percent
-	cpuPct, err := cpu.Percent(0, false)
-	if err != nil {
-		slog.Error("Error getting cpu percent", "err", err)
-	} else if len(cpuPct) > 0 {
-		systemStats.Cpu = twoDecimals(cpuPct[0])
-	}
+	a.collectSubsystem(a.cpuStats, &systemStats)
 
 	// memory
+	trackMemory := a.metrics.track("memory")
 	if v, err := mem.VirtualMemory(); err == nil {
 		// swap
 		systemStats.Swap = bytesToGigabytes(v.SwapTotal)
@@ -104,7 +142,138 @@ func (a *Agent) getSystemStats() system.Stats {
 		systemStats.MemUsed = bytesToGigabytes(v.Used)
 		systemStats.MemPct = twoDecimals(v.UsedPercent)
 	}
+	collectMeminfo(&systemStats)
+	a.alerter.CheckOOMKills()
+	trackMemory()
+
+	a.collectSubsystem(a.diskStats, &systemStats)
+
+	a.collectSubsystem(a.netStats, &systemStats)
+
+	// temperatures
+	// TODO: maybe refactor to methods on systemStats
+	trackTemperatures := a.metrics.track("temperatures")
+	a.updateTemperatures(&systemStats)
+	trackTemperatures()
+
+	// IPMI sensors
+	if a.ipmi != nil {
+		trackIpmi := a.metrics.track("ipmi")
+		systemStats.IPMISensors = a.ipmi.Collect(ctx)
+		trackIpmi()
+	}
+
+	// Kubernetes pods
+	if a.kubelet != nil {
+		trackKubelet := a.metrics.track("kubelet")
+		if pods, err := a.kubelet.Collect(ctx); err == nil {
+			systemStats.KubePods = pods
+		} else {
+			slog.Debug("Kubelet stats", "err", err)
+		}
+		trackKubelet()
+	}
+
+	// GPU data
+	if a.gpuManager != nil || a.remoteGPU != nil {
+		trackGpu := a.metrics.track("gpu")
+		// reset high gpu percent
+		a.systemInfo.GpuPct = 0
+		// get current GPU data, including GPUs on remote hosts
+		gpuData := make(map[string]system.GPUData)
+		if a.gpuManager != nil {
+			maps.Copy(gpuData, a.gpuManager.GetCurrentData())
+		}
+		maps.Copy(gpuData, a.remoteGPU.GetCurrentData())
+		if len(gpuData) > 0 {
+			systemStats.GPUData = gpuData
+
+			// add temperatures
+			if systemStats.Temperatures == nil {
+				systemStats.Temperatures = make(map[string]float64, len(gpuData))
+			}
+			highestTemp := 0.0
+			for _, gpu := range gpuData {
+				if gpu.Temperature > 0 {
+					systemStats.Temperatures[gpu.Name] = gpu.Temperature
+					if a.sensorConfig.primarySensor == gpu.Name {
+						a.systemInfo.DashboardTemp = gpu.Temperature
+					}
+					if gpu.Temperature > highestTemp {
+						highestTemp = gpu.Temperature
+					}
+				}
+				// update high gpu percent for dashboard
+				a.systemInfo.GpuPct = max(a.systemInfo.GpuPct, gpu.Usage)
+			}
+			// use highest temp for dashboard temp if dashboard temp is unset
+			if a.systemInfo.DashboardTemp == 0 {
+				a.systemInfo.DashboardTemp = highestTemp
+			}
+		}
+		trackGpu()
+	}
+
+	// update base system info
+	a.systemInfo.Cpu = systemStats.Cpu
+	a.systemInfo.MemPct = systemStats.MemPct
+	a.systemInfo.DiskPct = systemStats.DiskPct
+	a.systemInfo.Uptime, _ = host.Uptime()
+	a.systemInfo.Bandwidth = twoDecimals(systemStats.NetworkSent + systemStats.NetworkRecv)
+	slog.Debug("sysinfo", "data", a.systemInfo)
+
+	return systemStats
+}
+
+// Returns the size of the ZFS ARC memory cache in bytes
+func getARCSize() (uint64, error) {
+	file, err := os.Open("/proc/spl/kstat/zfs/arcstats")
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	// Scan the lines
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "size") {
+			// Example line: size 4 15032385536
+			fields := strings.Fields(line)
+			if len(fields) < 3 {
+				return 0, err
+			}
+			// Return the size as uint64
+			return strconv.ParseUint(fields[2], 10, 64)
+		}
+	}
+
+	return 0, fmt.Errorf("failed to parse size field")
+}
 
+// Sets CPU usage, perf counter miss rates, interrupt rates, and runqueue latency
+func (a *Agent) collectCpu(systemStats *system.Stats) {
+	cpuPct, err := cpu.Percent(0, false)
+	if err != nil {
+		slog.Error("Error getting cpu percent", "err", err)
+	} else if len(cpuPct) > 0 {
+		systemStats.Cpu = twoDecimals(cpuPct[0])
+	}
+	if a.perf != nil {
+		if llc, branch, err := a.perf.Collect(); err == nil {
+			systemStats.LLCMissRate = twoDecimals(llc)
+			systemStats.BranchMissRate = twoDecimals(branch)
+		} else {
+			slog.Debug("Perf counters", "err", err)
+		}
+	}
+	systemStats.IRQRates = a.irq.Collect()
+	systemStats.IRQAffinity = a.irq.Affinity(systemStats.IRQRates)
+	systemStats.SchedLatency = a.schedStat.Collect()
+}
+
+// Sets root disk usage and I/O, and updates usage and I/O of all monitored filesystems
+func (a *Agent) collectDisk(systemStats *system.Stats) {
 	// disk usage
 	for _, stats := range a.fsStats {
 		if d, err := disk.Usage(stats.Mountpoint); err == nil {
@@ -133,8 +302,17 @@ func (a *Agent) getSystemStats() system.Stats {
 				continue
 			}
 			secondsElapsed := time.Since(stats.Time).Seconds()
-			readPerSecond := bytesToMegabytes(float64(d.ReadBytes-stats.TotalRead) / secondsElapsed)
-			writePerSecond := bytesToMegabytes(float64(d.WriteBytes-stats.TotalWrite) / secondsElapsed)
+			readDelta, readOk := counterDelta(stats.TotalRead, d.ReadBytes)
+			writeDelta, writeOk := counterDelta(stats.TotalWrite, d.WriteBytes)
+			if !readOk || !writeOk {
+				slog.Debug("Disk I/O counters reset", "name", d.Name)
+				stats.Time = time.Now()
+				stats.TotalRead = d.ReadBytes
+				stats.TotalWrite = d.WriteBytes
+				continue
+			}
+			readPerSecond := bytesToMegabytes(float64(readDelta) / secondsElapsed)
+			writePerSecond := bytesToMegabytes(float64(writeDelta) / secondsElapsed)
 			// check for invalid values and reset stats if so
 			if readPerSecond < 0 || writePerSecond < 0 || readPerSecond > 50_000 || writePerSecond > 50_000 {
 				slog.Warn("Invalid disk I/O. Resetting.", "name", d.Name, "read", readPerSecond, "write", writePerSecond)
@@ -154,7 +332,11 @@ func (a *Agent) getSystemStats() system.Stats {
 		}
 	}
 
-	// network stats
+	systemStats.RemoteFSStats = a.remoteFS.Collect()
+}
+
+// Sets network bandwidth and per-protocol packet counts
+func (a *Agent) collectNetwork(systemStats *system.Stats) {
 	if len(a.netInterfaces) == 0 {
 		// if no network interfaces, initialize again
 		// this is a fix if agent started before network is online (#466)
@@ -167,6 +349,9 @@ func (a *Agent) getSystemStats() system.Stats {
 		a.netIoStats.Time = time.Now()
 		bytesSent := uint64(0)
 		bytesRecv := uint64(0)
+		sentDelta := uint64(0)
+		recvDelta := uint64(0)
+		counters := make(map[string]netCounters, len(a.netInterfaces))
 		// sum all bytes sent and received
 		for _, v := range netIO {
 			// skip if not in valid network interfaces list
@@ -175,10 +360,23 @@ func (a *Agent) getSystemStats() system.Stats {
 			}
 			bytesSent += v.BytesSent
 			bytesRecv += v.BytesRecv
+			// deltas are computed per interface so a wrapped counter is detected
+			if prev, ok := a.netCounters[v.Name]; ok {
+				sent, sentOk := counterDelta(prev.sent, v.BytesSent)
+				recv, recvOk := counterDelta(prev.recv, v.BytesRecv)
+				// a reset interface is left out until the next sample
+				if sentOk && recvOk {
+					sentDelta += sent
+					recvDelta += recv
+				} else {
+					slog.Debug("Network counters reset", "name", v.Name)
+				}
+			}
+			counters[v.Name] = netCounters{sent: v.BytesSent, recv: v.BytesRecv}
 		}
 		// add to systemStats
-		sentPerSecond := float64(bytesSent-a.netIoStats.BytesSent) / secondsElapsed
-		recvPerSecond := float64(bytesRecv-a.netIoStats.BytesRecv) / secondsElapsed
+		sentPerSecond := float64(sentDelta) / secondsElapsed
+		recvPerSecond := float64(recvDelta) / secondsElapsed
 		networkSentPs := bytesToMegabytes(sentPerSecond)
 		networkRecvPs := bytesToMegabytes(recvPerSecond)
 		// add check for issue (#150) where sent is a massive number
@@ -198,79 +396,11 @@ func (a *Agent) getSystemStats() system.Stats {
 			// update netIoStats
 			a.netIoStats.BytesSent = bytesSent
 			a.netIoStats.BytesRecv = bytesRecv
+			a.netCounters = counters
 		}
 	}
-
-	// temperatures
-	// TODO: maybe refactor to methods on systemStats
-	a.updateTemperatures(&systemStats)
-
-	// GPU data
-	if a.gpuManager != nil {
-		// reset high gpu percent
-		a.systemInfo.GpuPct = 0
-		// get current GPU data
-		if gpuData := a.gpuManager.GetCurrentData(); len(gpuData) > 0 {
-			systemStats.GPUData = gpuData
-
-			// add temperatures
-			if systemStats.Temperatures == nil {
-				systemStats.Temperatures = make(map[string]float64, len(gpuData))
-			}
-			highestTemp := 0.0
-			for _, gpu := range gpuData {
-				if gpu.Temperature > 0 {
-					systemStats.Temperatures[gpu.Name] = gpu.Temperature
-					if a.sensorConfig.primarySensor == gpu.Name {
-						a.systemInfo.DashboardTemp = gpu.Temperature
-					}
-					if gpu.Temperature > highestTemp {
-						highestTemp = gpu.Temperature
-					}
-				}
-				// update high gpu percent for dashboard
-				a.systemInfo.GpuPct = max(a.systemInfo.GpuPct, gpu.Usage)
-			}
-			// use highest temp for dashboard temp if dashboard temp is unset
-			if a.systemInfo.DashboardTemp == 0 {
-				a.systemInfo.DashboardTemp = highestTemp
-			}
-		}
-	}
-
-	// update base system info
-	a.systemInfo.Cpu = systemStats.Cpu
-	a.systemInfo.MemPct = systemStats.MemPct
-	a.systemInfo.DiskPct = systemStats.DiskPct
-	a.systemInfo.Uptime, _ = host.Uptime()
-	a.systemInfo.Bandwidth = twoDecimals(systemStats.NetworkSent + systemStats.NetworkRecv)
-	slog.Debug("sysinfo", "data", a.systemInfo)
-
-	return systemStats
-}
-
-// Returns the size of the ZFS ARC memory cache in bytes
-func getARCSize() (uint64, error) {
-	file, err := os.Open("/proc/spl/kstat/zfs/arcstats")
-	if err != nil {
-		return 0, err
-	}
-	defer file.Close()
-
-	// Scan the lines
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "size") {
-			// Example line: size 4 15032385536
-			fields := strings.Fields(line)
-			if len(fields) < 3 {
-				return 0, err
-			}
-			// Return the size as uint64
-			return strconv.ParseUint(fields[2], 10, 64)
-		}
+	if a.ebpfNet != nil {
+		systemStats.NetProtoStats = a.ebpfNet.Collect()
 	}
-
-	return 0, fmt.Errorf("failed to parse size field")
+	systemStats.SocketStats = collectSocketStats()
 }
@@ -0,0 +1,33 @@
+package agent
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// tag keys are lowercase identifiers, e.g. datacenter or rack_id
+var tagKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// parseTags parses comma separated key=value pairs, e.g. "dc=fra1,rack=r12".
+// Invalid pairs are logged and skipped. Returns nil if there are no valid tags.
+func parseTags(value string) map[string]string {
+	var tags map[string]string
+	for pair := range strings.SplitSeq(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, val, found := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !found || !tagKeyPattern.MatchString(key) {
+			slog.Warn("Invalid tag, expected key=value with key matching [a-z][a-z0-9_]*", "tag", pair)
+			continue
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[key] = strings.TrimSpace(val)
+	}
+	return tags
+}
@@ -2,6 +2,8 @@ package agent
 
 import "math"
 
+// bytesToMegabytes converts bytes to MiB, reported as MB, rounded to two decimals.
+// Negative values are converted as-is.
 func bytesToMegabytes(b float64) float64 {
 	return twoDecimals(b / 1048576)
 }
@@ -10,6 +12,53 @@ func bytesToGigabytes(b uint64) float64 {
 	return twoDecimals(float64(b) / 1073741824)
 }
 
+// twoDecimals rounds value to two decimal places, with halves rounded away from
+// zero. NaN and infinite values can't be encoded as JSON, so they return 0.
 func twoDecimals(value float64) float64 {
-	return math.Round(value*100) / 100
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0
+	}
+	// the rounded value is a whole number of hundredths, so dividing gives the
+	// closest float to it, which formats with at most two decimals. Adding 0
+	// turns -0 into 0.
+	return math.Round(value*100)/100 + 0
+}
+
+// oneDecimal rounds value to one decimal place, like twoDecimals
+func oneDecimal(value float64) float64 {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0
+	}
+	return math.Round(value*10)/10 + 0
+}
+
+// noDecimals rounds value to a whole number, like twoDecimals
+func noDecimals(value float64) float64 {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0
+	}
+	return math.Round(value) + 0
+}
+
+// counterMax is the largest value of the kernel's I/O counters, which are unsigned
+// longs: 32 bits on 32-bit platforms and 64 bits otherwise
+var counterMax uint64 = math.MaxUint
+
+// counterDelta returns the increase of a cumulative counter from prev to curr.
+// If curr is less than prev, the counter either wrapped past counterMax or was
+// reset, e.g. when an interface is recreated or a container restarts. A wrap is
+// assumed if the wrapped increase is less than half the counter range. Otherwise
+// ok is false, and the sample should be dropped with curr as the new baseline.
+func counterDelta(prev, curr uint64) (delta uint64, ok bool) {
+	if curr >= prev {
+		return curr - prev, true
+	}
+	if prev > counterMax {
+		return 0, false
+	}
+	delta = counterMax - prev + curr + 1
+	if delta > counterMax/2 {
+		return 0, false
+	}
+	return delta, true
 }
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTwoDecimals(t *testing.T) {
+	tests := []struct {
+		name  string
+		value float64
+		want  string // JSON encoding of the result
+	}{
+		{"zero", 0, "0"},
+		{"hundred", 100, "100"},
+		{"just below hundred", 99.99999999, "100"},
+		{"half hundredth rounds up", 0.005, "0.01"},
+		{"below half hundredth rounds down", 0.0049, "0"},
+		{"two decimals unchanged", 12.34, "12.34"},
+		{"more decimals", 12.3456, "12.35"},
+		{"sum of small fractions", 0.1 + 0.2, "0.3"},
+		{"negative", -12.3456, "-12.35"},
+		{"negative half hundredth", -0.005, "-0.01"},
+		{"negative rounds to zero", -0.0049, "0"},
+		{"NaN", math.NaN(), "0"},
+		{"positive infinity", math.Inf(1), "0"},
+		{"negative infinity", math.Inf(-1), "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := twoDecimals(tt.value)
+			encoded, err := json.Marshal(got)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(encoded))
+		})
+	}
+}
+
+func TestTwoDecimalsFormatting(t *testing.T) {
+	// every result formats with at most two decimal places
+	for i := range 100_000 {
+		value := float64(i) / 997
+		formatted := strconv.FormatFloat(twoDecimals(value), 'f', -1, 64)
+		if _, decimals, ok := strings.Cut(formatted, "."); ok {
+			require.LessOrEqual(t, len(decimals), 2, "%v formatted as %s", value, formatted)
+		}
+	}
+}
+
+func TestBytesToMegabytes(t *testing.T) {
+	tests := []struct {
+		name  string
+		bytes float64
+		want  float64
+	}{
+		{"zero", 0, 0},
+		{"one byte rounds to zero", 1, 0},
+		{"one MiB", 1 << 20, 1},
+		{"one MB", 1_000_000, 0.95},
+		{"above uint32 max", 1 << 33, 8192},
+		// rocm-smi VRAM total of a 24 GB card
+		{"24 GB card", 25475809280, 24295.63},
+		{"largest exact float", 1 << 53, 8589934592},
+		// negative values are returned as-is so bad input is visible rather than hidden
+		{"negative", -(1 << 20), -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, bytesToMegabytes(tt.bytes))
+		})
+	}
+
+	t.Run("parsed from rocm-smi string", func(t *testing.T) {
+		value, err := strconv.ParseFloat("25475809280", 64)
+		require.NoError(t, err)
+		encoded, err := json.Marshal(bytesToMegabytes(value))
+		require.NoError(t, err)
+		assert.Equal(t, "24295.63", string(encoded))
+	})
+}
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		name       string
+		max        uint64 // counterMax of the platform
+		prev, curr uint64
+		want       uint64
+		ok         bool
+	}{
+		{"no change", math.MaxUint64, 1000, 1000, 0, true},
+		{"normal delta", math.MaxUint64, 1000, 5000, 4000, true},
+		{"from zero", math.MaxUint64, 0, 123456, 123456, true},
+		{"64-bit wrap", math.MaxUint64, math.MaxUint64 - 99, 400, 500, true},
+		{"64-bit wrap to zero", math.MaxUint64, math.MaxUint64, 0, 1, true},
+		{"64-bit counter above 32 bits", math.MaxUint64, math.MaxUint32 + 100, math.MaxUint32 + 600, 500, true},
+		{"64-bit reset below 32 bits", math.MaxUint64, math.MaxUint32 - 99, 400, 0, false},
+		{"64-bit reset", math.MaxUint64, 50 << 30, 1000, 0, false},
+		{"32-bit wrap", math.MaxUint32, math.MaxUint32 - 99, 400, 500, true},
+		{"32-bit wrap to zero", math.MaxUint32, math.MaxUint32, 0, 1, true},
+		{"32-bit reset", math.MaxUint32, 1 << 30, 400, 0, false},
+		{"32-bit counter above 32 bits", math.MaxUint32, math.MaxUint32 + 100, 400, 0, false},
+	}
+	origMax := counterMax
+	defer func() { counterMax = origMax }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counterMax = tt.max
+			delta, ok := counterDelta(tt.prev, tt.curr)
+			assert.Equal(t, tt.want, delta)
+			assert.Equal(t, tt.ok, ok)
+		})
+	}
+}
@@ -1,7 +1,31 @@
 package common
 
+import "time"
+
 var (
 	DefaultKeyExchanges = []string{"curve25519-sha256"}
 	DefaultMACs         = []string{"hmac-sha2-256-etm@openssh.com"}
 	DefaultCiphers      = []string{"chacha20-poly1305@openssh.com"}
 )
+
+// CatchUpEnv is set on SSH sessions by hubs that want stats buffered while they were unreachable
+const CatchUpEnv = "BESZEL_CATCH_UP"
+
+// EncodingEnv is set on SSH sessions by hubs that want stats in an encoding other than JSON, e.g. "protobuf".
+// Hubs only request encodings that the agent lists in AgentMeta.Encodings.
+const EncodingEnv = "BESZEL_ENC"
+
+// NotificationChannel is the type of the SSH channel that agents open to the hub at
+// BESZEL_HUB_ADDR, to push critical events without waiting for the next poll
+const NotificationChannel = "beszel-notify"
+
+// NotificationRequest is the channel request carrying a JSON encoded Notification
+const NotificationRequest = "notify"
+
+// Notification is a critical event detected by an agent, such as an OOM kill
+type Notification struct {
+	ID       string    `json:"id"`       // Event type: OOM_KILL, GPU_ECC, or GPU_TEMP
+	Severity int       `json:"severity"` // Syslog severity, 2 for critical
+	Message  string    `json:"msg"`
+	Time     time.Time `json:"time"`
+}
@@ -0,0 +1,111 @@
+package common
+
+import "math"
+
+const (
+	// DeltaEnv is set on SSH sessions by hubs that can apply delta stats payloads
+	DeltaEnv = "BESZEL_DELTA"
+	// DeltaKey marks a stats payload as a delta of the previous payload
+	DeltaKey = "delta"
+)
+
+// DiffJSON compares two decoded JSON objects and returns a JSON merge patch
+// (RFC 7386) with the values in cur that differ from prev. Numbers are only
+// treated as changed if they differ by more than threshold relative to the
+// previous value, so next is the state after applying the patch to prev,
+// which keeps the previous value of numbers that did not change enough.
+// Arrays are compared element by element but always replaced as a whole.
+func DiffJSON(prev, cur map[string]any, threshold float64) (patch, next map[string]any) {
+	patch = make(map[string]any)
+	next = make(map[string]any, len(cur))
+	for key, value := range cur {
+		if value == nil {
+			// null and missing values decode the same, so there is no need to send them
+			continue
+		}
+		old, exists := prev[key]
+		oldMap, oldIsMap := old.(map[string]any)
+		valueMap, valueIsMap := value.(map[string]any)
+		switch {
+		case !exists || old == nil:
+			patch[key] = value
+			next[key] = value
+		case oldIsMap && valueIsMap:
+			subPatch, subNext := DiffJSON(oldMap, valueMap, threshold)
+			if len(subPatch) > 0 {
+				patch[key] = subPatch
+			}
+			next[key] = subNext
+		case valueChanged(old, value, threshold):
+			patch[key] = value
+			next[key] = value
+		default:
+			next[key] = old
+		}
+	}
+	for key, old := range prev {
+		if _, exists := next[key]; !exists && old != nil {
+			patch[key] = nil
+		}
+	}
+	return patch, next
+}
+
+// valueChanged returns true if a and b differ, with numbers compared using the relative threshold
+func valueChanged(a, b any, threshold float64) bool {
+	switch a := a.(type) {
+	case float64:
+		b, ok := b.(float64)
+		if !ok {
+			return true
+		}
+		if a == 0 {
+			return b != 0
+		}
+		return math.Abs(b-a) > threshold*math.Abs(a)
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return true
+		}
+		for i := range a {
+			if valueChanged(a[i], b[i], threshold) {
+				return true
+			}
+		}
+		return false
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return true
+		}
+		for key, value := range a {
+			other, exists := b[key]
+			if !exists || valueChanged(value, other, threshold) {
+				return true
+			}
+		}
+		return false
+	default:
+		return a != b
+	}
+}
+
+// ApplyJSONPatch applies a JSON merge patch (RFC 7386) to base in place
+func ApplyJSONPatch(base, patch map[string]any) {
+	for key, value := range patch {
+		switch value := value.(type) {
+		case nil:
+			delete(base, key)
+		case map[string]any:
+			target, ok := base[key].(map[string]any)
+			if !ok {
+				target = make(map[string]any, len(value))
+				base[key] = target
+			}
+			ApplyJSONPatch(target, value)
+		default:
+			base[key] = value
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffJSON(t *testing.T) {
+	prev := map[string]any{
+		"cpu":     10.0,
+		"mem":     100.0,
+		"name":    "host",
+		"removed": 1.0,
+		"gpu":     map[string]any{"0": map[string]any{"u": 50.0, "p": 200.0}},
+		"list":    []any{1.0, 2.0},
+	}
+	cur := map[string]any{
+		"cpu":  10.05, // 0.5% change
+		"mem":  110.0,
+		"name": "host",
+		"new":  true,
+		"gpu":  map[string]any{"0": map[string]any{"u": 50.0, "p": 250.0}},
+		"list": []any{1.0, 2.001},
+	}
+
+	patch, next := DiffJSON(prev, cur, 0.01)
+	assert.Equal(t, map[string]any{
+		"mem":     110.0,
+		"new":     true,
+		"removed": nil,
+		"gpu":     map[string]any{"0": map[string]any{"p": 250.0}},
+	}, patch)
+	// values within the threshold keep their previous value
+	assert.Equal(t, 10.0, next["cpu"])
+	assert.Equal(t, []any{1.0, 2.0}, next["list"])
+
+	// applying the patch to prev gives next
+	ApplyJSONPatch(prev, patch)
+	assert.Equal(t, next, prev)
+
+	// no changes
+	patch, _ = DiffJSON(next, next, 0.01)
+	assert.Empty(t, patch)
+}
+
+func TestValueChanged(t *testing.T) {
+	assert.False(t, valueChanged(0.0, 0.0, 0.01))
+	assert.True(t, valueChanged(0.0, 0.001, 0.01))
+	assert.False(t, valueChanged(100.0, 100.9, 0.01))
+	assert.True(t, valueChanged(100.0, 98.9, 0.01))
+	assert.True(t, valueChanged("a", "b", 0.01))
+	assert.True(t, valueChanged("a", 1.0, 0.01))
+	assert.True(t, valueChanged([]any{1.0}, []any{1.0, 2.0}, 0.01))
+	assert.True(t, valueChanged([]any{map[string]any{"a": 1.0}}, []any{map[string]any{"b": 1.0}}, 0.01))
+	assert.False(t, valueChanged([]any{map[string]any{"a": 1.0}}, []any{map[string]any{"a": 1.001}}, 0.01))
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	base := map[string]any{"a": 1.0, "b": map[string]any{"c": 2.0, "d": 3.0}}
+	ApplyJSONPatch(base, map[string]any{
+		"a": nil,
+		"b": map[string]any{"c": nil, "e": 4.0},
+		"f": map[string]any{"g": 5.0},
+	})
+	assert.Equal(t, map[string]any{
+		"b": map[string]any{"d": 3.0, "e": 4.0},
+		"f": map[string]any{"g": 5.0},
+	}, base)
+}
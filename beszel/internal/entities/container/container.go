@@ -99,11 +99,11 @@ type prevNetStats struct {
 
 // Docker container stats
 type Stats struct {
-	Name        string       `json:"n"`
-	Cpu         float64      `json:"c"`
-	Mem         float64      `json:"m"`
-	NetworkSent float64      `json:"ns"`
-	NetworkRecv float64      `json:"nr"`
+	Name        string       `json:"n" protobuf:"1"`
+	Cpu         float64      `json:"c" protobuf:"2"`
+	Mem         float64      `json:"m" protobuf:"3"`
+	NetworkSent float64      `json:"ns" protobuf:"4"`
+	NetworkRecv float64      `json:"nr" protobuf:"5"`
 	PrevCpu     [2]uint64    `json:"-"`
 	PrevNet     prevNetStats `json:"-"`
 	PrevRead    time.Time    `json:"-"`
@@ -0,0 +1,517 @@
+package system
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Stats response encodings, negotiated by the hub with common.EncodingEnv
+const (
+	EncodingJSON     = "json"
+	EncodingProtobuf = "protobuf"
+)
+
+// Encode writes the stats to w in the given format. JSON is written as a single
+// value followed by a newline. Protobuf is written as a message prefixed with
+// its varint length, as read by protodelim.UnmarshalFrom, using the schema in
+// stats.proto.
+func (d *CombinedData) Encode(w io.Writer, format string) error {
+	switch format {
+	case EncodingJSON:
+		return json.NewEncoder(w).Encode(d)
+	case EncodingProtobuf:
+		message, err := appendProtoMessage(nil, reflect.ValueOf(d).Elem())
+		if err != nil {
+			return err
+		}
+		frame := make([]byte, 0, protowire.SizeVarint(uint64(len(message)))+len(message))
+		frame = protowire.AppendVarint(frame, uint64(len(message)))
+		_, err = w.Write(append(frame, message...))
+		return err
+	default:
+		return fmt.Errorf("unsupported stats encoding: %s", format)
+	}
+}
+
+// Decode reads stats written by Encode in the given format into d. Protobuf
+// fields that are unknown to this version are skipped.
+func (d *CombinedData) Decode(r io.Reader, format string) error {
+	switch format {
+	case EncodingJSON:
+		return json.NewDecoder(r).Decode(d)
+	case EncodingProtobuf:
+		br := bufio.NewReader(r)
+		length, err := binary.ReadUvarint(br)
+		if err != nil {
+			return err
+		}
+		// read rather than allocate length bytes up front, since it isn't trusted
+		message, err := io.ReadAll(io.LimitReader(br, int64(length)))
+		if err != nil {
+			return err
+		}
+		if uint64(len(message)) != length {
+			return io.ErrUnexpectedEOF
+		}
+		return consumeProtoMessage(message, reflect.ValueOf(d).Elem())
+	default:
+		return fmt.Errorf("unsupported stats encoding: %s", format)
+	}
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// protoField is a struct field encoded as the protobuf field num
+type protoField struct {
+	reflect.StructField
+	num protowire.Number
+}
+
+// protoFields returns the fields of struct type t that are encoded, with the
+// field numbers from their protobuf tags, e.g. `protobuf:"3"`. Fields without a
+// tag, like those not sent as JSON, are not encoded. Numbers must not change or be
+// reused once released, so removed fields leave a gap in the numbering.
+func protoFields(t reflect.Type) ([]protoField, error) {
+	var fields []protoField
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("protobuf")
+		if !field.IsExported() || tag == "" {
+			continue
+		}
+		num, err := strconv.Atoi(tag)
+		if err != nil || !protowire.Number(num).IsValid() {
+			return nil, fmt.Errorf("invalid protobuf field number %q on %s.%s", tag, t.Name(), field.Name)
+		}
+		fields = append(fields, protoField{StructField: field, num: protowire.Number(num)})
+	}
+	return fields, nil
+}
+
+// appendProtoMessage appends the fields of struct v that are not zero
+func appendProtoMessage(b []byte, v reflect.Value) ([]byte, error) {
+	fields, err := protoFields(v.Type())
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		value := v.FieldByIndex(field.Index)
+		if value.IsZero() {
+			continue
+		}
+		switch {
+		case value.Kind() == reflect.Map:
+			keys := value.MapKeys()
+			slices.SortFunc(keys, func(a, b reflect.Value) int { return cmp.Compare(a.String(), b.String()) })
+			for _, key := range keys {
+				entry, err := appendProtoValue(nil, 1, key)
+				if err == nil {
+					entry, err = appendProtoValue(entry, 2, value.MapIndex(key))
+				}
+				if err != nil {
+					return nil, err
+				}
+				b = protowire.AppendTag(b, field.num, protowire.BytesType)
+				b = protowire.AppendBytes(b, entry)
+			}
+		case isProtoList(value.Type()) && isProtoScalar(value.Type().Elem()):
+			// repeated numbers are packed
+			var packed []byte
+			for i := range value.Len() {
+				packed = appendProtoScalar(packed, value.Index(i))
+			}
+			b = protowire.AppendTag(b, field.num, protowire.BytesType)
+			b = protowire.AppendBytes(b, packed)
+		case isProtoList(value.Type()):
+			for i := range value.Len() {
+				if b, err = appendProtoValue(b, field.num, value.Index(i)); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			if b, err = appendProtoValue(b, field.num, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+// appendProtoValue appends a single field, even if it is zero
+func appendProtoValue(b []byte, num protowire.Number, v reflect.Value) ([]byte, error) {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v = reflect.Zero(v.Type().Elem())
+		} else {
+			v = v.Elem()
+		}
+	}
+	switch {
+	case isProtoScalar(v.Type()):
+		b = protowire.AppendTag(b, num, protoWireType(v.Type()))
+		return appendProtoScalar(b, v), nil
+	case v.Kind() == reflect.String:
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendString(b, v.String()), nil
+	default:
+		message, err := appendProtoMessage(nil, v)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, message), nil
+	}
+}
+
+// appendProtoScalar appends a number without a tag
+func appendProtoScalar(b []byte, v reflect.Value) []byte {
+	if v.Type() == timeType {
+		return protowire.AppendVarint(b, uint64(v.Interface().(time.Time).UnixNano()))
+	}
+	switch v.Kind() {
+	case reflect.Float64:
+		return protowire.AppendFixed64(b, math.Float64bits(v.Float()))
+	case reflect.Float32:
+		return protowire.AppendFixed32(b, math.Float32bits(float32(v.Float())))
+	case reflect.Bool:
+		return protowire.AppendVarint(b, protowire.EncodeBool(v.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return protowire.AppendVarint(b, uint64(v.Int()))
+	default:
+		return protowire.AppendVarint(b, v.Uint())
+	}
+}
+
+// isProtoScalar returns true if t is encoded as a protobuf number
+func isProtoScalar(t reflect.Type) bool {
+	if t == timeType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// isProtoList returns true if t is encoded as a repeated field
+func isProtoList(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice || t.Kind() == reflect.Array
+}
+
+func protoWireType(t reflect.Type) protowire.Type {
+	switch t.Kind() {
+	case reflect.Float64:
+		return protowire.Fixed64Type
+	case reflect.Float32:
+		return protowire.Fixed32Type
+	}
+	return protowire.VarintType
+}
+
+var errProtoWireType = errors.New("unexpected protobuf wire type")
+
+// consumeProtoMessage sets the fields of struct v from message b
+func consumeProtoMessage(b []byte, v reflect.Value) error {
+	structFields, err := protoFields(v.Type())
+	if err != nil {
+		return err
+	}
+	fields := make(map[protowire.Number]protoField, len(structFields))
+	for _, field := range structFields {
+		fields[field.num] = field
+	}
+	// next element of each array field
+	indexes := make(map[protowire.Number]int)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		field, ok := fields[num]
+		if !ok {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		value := v.FieldByIndex(field.Index)
+		switch {
+		case value.Kind() == reflect.Map:
+			var entry []byte
+			if entry, n, err = consumeProtoBytes(b, typ); err != nil {
+				return err
+			}
+			if value.IsNil() {
+				value.Set(reflect.MakeMap(value.Type()))
+			}
+			err = consumeProtoMapEntry(entry, value)
+		case isProtoList(value.Type()) && isProtoScalar(value.Type().Elem()) && typ == protowire.BytesType:
+			var packed []byte
+			if packed, n, err = consumeProtoBytes(b, typ); err != nil {
+				return err
+			}
+			for len(packed) > 0 && err == nil {
+				elem := reflect.New(value.Type().Elem()).Elem()
+				var m int
+				if m, err = consumeProtoScalar(packed, protoWireType(elem.Type()), elem); err == nil {
+					packed = packed[m:]
+					appendProtoElem(value, elem, indexes, num)
+				}
+			}
+		case isProtoList(value.Type()):
+			elem := reflect.New(value.Type().Elem()).Elem()
+			if n, err = consumeProtoValue(b, typ, elem); err == nil {
+				appendProtoElem(value, elem, indexes, num)
+			}
+		default:
+			n, err = consumeProtoValue(b, typ, value)
+		}
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", v.Type().Name(), field.Name, err)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// consumeProtoMapEntry adds the key in field 1 and value in field 2 of entry to map m
+func consumeProtoMapEntry(entry []byte, m reflect.Value) error {
+	key := reflect.New(m.Type().Key()).Elem()
+	elem := reflect.New(m.Type().Elem()).Elem()
+	for len(entry) > 0 {
+		num, typ, n := protowire.ConsumeTag(entry)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		entry = entry[n:]
+		var err error
+		switch num {
+		case 1:
+			n, err = consumeProtoValue(entry, typ, key)
+		case 2:
+			n, err = consumeProtoValue(entry, typ, elem)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, entry)
+		}
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		entry = entry[n:]
+	}
+	m.SetMapIndex(key, elem)
+	return nil
+}
+
+// appendProtoElem adds elem to slice or array list, using indexes to track the
+// length of arrays. Elements past the end of an array are dropped.
+func appendProtoElem(list, elem reflect.Value, indexes map[protowire.Number]int, num protowire.Number) {
+	if list.Kind() == reflect.Slice {
+		list.Set(reflect.Append(list, elem))
+		return
+	}
+	if i := indexes[num]; i < list.Len() {
+		list.Index(i).Set(elem)
+		indexes[num] = i + 1
+	}
+}
+
+// consumeProtoValue sets v from a single field value, returning its length
+func consumeProtoValue(b []byte, typ protowire.Type, v reflect.Value) (int, error) {
+	if v.Kind() == reflect.Pointer {
+		v.Set(reflect.New(v.Type().Elem()))
+		v = v.Elem()
+	}
+	if isProtoScalar(v.Type()) {
+		return consumeProtoScalar(b, typ, v)
+	}
+	value, n, err := consumeProtoBytes(b, typ)
+	if err != nil {
+		return 0, err
+	}
+	if v.Kind() == reflect.String {
+		v.SetString(string(value))
+		return n, nil
+	}
+	return n, consumeProtoMessage(value, v)
+}
+
+// consumeProtoBytes returns a length delimited value and its length with the prefix
+func consumeProtoBytes(b []byte, typ protowire.Type) ([]byte, int, error) {
+	if typ != protowire.BytesType {
+		return nil, 0, errProtoWireType
+	}
+	value, n := protowire.ConsumeBytes(b)
+	if n < 0 {
+		return nil, 0, protowire.ParseError(n)
+	}
+	return value, n, nil
+}
+
+// consumeProtoScalar sets v from a number without a tag, returning its length
+func consumeProtoScalar(b []byte, typ protowire.Type, v reflect.Value) (int, error) {
+	if typ != protoWireType(v.Type()) {
+		return 0, errProtoWireType
+	}
+	switch typ {
+	case protowire.Fixed64Type:
+		x, n := protowire.ConsumeFixed64(b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		v.SetFloat(math.Float64frombits(x))
+		return n, nil
+	case protowire.Fixed32Type:
+		x, n := protowire.ConsumeFixed32(b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		v.SetFloat(float64(math.Float32frombits(x)))
+		return n, nil
+	}
+	x, n := protowire.ConsumeVarint(b)
+	if n < 0 {
+		return 0, protowire.ParseError(n)
+	}
+	if v.Type() == timeType {
+		v.Set(reflect.ValueOf(time.Unix(0, int64(x)).UTC()))
+		return n, nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(protowire.DecodeBool(x))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(x))
+	default:
+		v.SetUint(x)
+	}
+	return n, nil
+}
+
+// ProtoSchema returns the protobuf schema of CombinedData, the content of stats.proto
+func ProtoSchema() (string, error) {
+	var sb strings.Builder
+	sb.WriteString("// Code generated by system.ProtoSchema. DO NOT EDIT.\n\n")
+	sb.WriteString("syntax = \"proto3\";\n\npackage beszel;\n")
+	written := make(map[reflect.Type]bool)
+	pending := []reflect.Type{reflect.TypeFor[CombinedData]()}
+	for len(pending) > 0 {
+		t := pending[0]
+		pending = pending[1:]
+		if written[t] {
+			continue
+		}
+		written[t] = true
+		fields, err := protoFields(t)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sb, "\nmessage %s {\n", protoMessageName(t))
+		for _, field := range fields {
+			fieldType := field.Type
+			var label string
+			switch {
+			case fieldType.Kind() == reflect.Map:
+				label = fmt.Sprintf("map<%s, %s>", protoTypeName(fieldType.Key()), protoTypeName(fieldType.Elem()))
+				fieldType = fieldType.Elem()
+			case isProtoList(fieldType):
+				fieldType = fieldType.Elem()
+				label = "repeated " + protoTypeName(fieldType)
+			default:
+				label = protoTypeName(fieldType)
+			}
+			fmt.Fprintf(&sb, "  %s %s = %d;", label, protoFieldName(field.Name), field.num)
+			if fieldType == timeType {
+				sb.WriteString(" // unix nanoseconds")
+			}
+			sb.WriteString("\n")
+			if fieldType.Kind() == reflect.Pointer {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct && fieldType != timeType {
+				pending = append(pending, fieldType)
+			}
+		}
+		sb.WriteString("}\n")
+	}
+	return sb.String(), nil
+}
+
+// protoTypeName returns the protobuf type of a single value of t
+func protoTypeName(t reflect.Type) string {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return "int64"
+	}
+	switch t.Kind() {
+	case reflect.Float64:
+		return "double"
+	case reflect.Float32:
+		return "float"
+	case reflect.Bool:
+		return "bool"
+	case reflect.String:
+		return "string"
+	case reflect.Int32, reflect.Int16, reflect.Int8:
+		return "int32"
+	case reflect.Int, reflect.Int64:
+		return "int64"
+	case reflect.Uint32, reflect.Uint16, reflect.Uint8:
+		return "uint32"
+	case reflect.Uint, reflect.Uint64:
+		return "uint64"
+	}
+	return protoMessageName(t)
+}
+
+// protoMessageName returns the message name of struct type t, prefixed with
+// the package name if it is not in this package, e.g. ContainerStats
+func protoMessageName(t reflect.Type) string {
+	pkg := path.Base(t.PkgPath())
+	if pkg == "system" {
+		return t.Name()
+	}
+	return strings.ToUpper(pkg[:1]) + pkg[1:] + t.Name()
+}
+
+// protoFieldName converts a Go field name to snake case, e.g. LLCMissRate to llc_miss_rate
+func protoFieldName(name string) string {
+	runes := []rune(name)
+	var sb strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				sb.WriteByte('_')
+			}
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+	return sb.String()
+}
@@ -0,0 +1,624 @@
+package system
+
+import (
+	"beszel/internal/entities/container"
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func encodeTestStats() *CombinedData {
+	return &CombinedData{
+		ProtocolVersion: CurrentProtocolVersion,
+		Stats: Stats{
+			Cpu:         12.34,
+			Mem:         31.27,
+			MemUsed:     9.81,
+			MemPct:      31.37,
+			DiskTotal:   915.6,
+			DiskUsed:    412.07,
+			DiskPct:     45.01,
+			NetworkSent: 0.18,
+			NetworkRecv: 1.42,
+			Temperatures: map[string]float64{
+				"nvme_composite": 41.85,
+				"k10temp_tctl":   52.12,
+			},
+			ExtraFs: map[string]*FsStats{
+				"sdb1": {DiskTotal: 3725.29, DiskUsed: 1201.4, FSType: "ext4"},
+			},
+			GPUData: map[string]GPUData{
+				"0": {
+					Name:           "RTX 4090",
+					Usage:          57.2,
+					UsageHistogram: [10]uint8{0, 0, 1, 0, 0, 3, 2, 0, 0, 0},
+					LastUpdated:    time.Unix(1700000000, 0).UTC(),
+				},
+			},
+		},
+		Info: Info{
+			Hostname:     "web-01",
+			Cores:        16,
+			CpuModel:     "AMD Ryzen 9 7950X",
+			Uptime:       1209600,
+			AgentVersion: "0.11.1",
+			Os:           Linux,
+		},
+		Containers: []*container.Stats{
+			{Name: "nginx", Cpu: 0.41, Mem: 27.3, NetworkSent: 0.01, NetworkRecv: 0.02},
+			{Name: "postgres", Cpu: 3.12, Mem: 412.8},
+		},
+		Meta: AgentMeta{Version: "0.11.1", GoVersion: "go1.24.2", GOARCH: "amd64", GOOS: "linux"},
+		Tags: map[string]string{"rack": "r12"},
+	}
+}
+
+// decodeProto returns the raw values of each field in a protobuf message
+func decodeProto(t *testing.T, b []byte) map[protowire.Number][][]byte {
+	fields := make(map[protowire.Number][][]byte)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		require.GreaterOrEqual(t, n, 0)
+		b = b[n:]
+		var value []byte
+		switch typ {
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			value = b[:n]
+		}
+		require.GreaterOrEqual(t, n, 0)
+		fields[num] = append(fields[num], value)
+		b = b[n:]
+	}
+	return fields
+}
+
+func TestEncodeJSON(t *testing.T) {
+	stats := encodeTestStats()
+	var buf bytes.Buffer
+	require.NoError(t, stats.Encode(&buf, EncodingJSON))
+
+	expected, err := json.Marshal(stats)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected)+"\n", buf.String())
+}
+
+func TestEncodeProtobuf(t *testing.T) {
+	stats := encodeTestStats()
+	var buf bytes.Buffer
+	require.NoError(t, stats.Encode(&buf, EncodingProtobuf))
+
+	// length prefixed message
+	length, n := protowire.ConsumeVarint(buf.Bytes())
+	require.Greater(t, n, 0)
+	message := buf.Bytes()[n:]
+	require.Len(t, message, int(length))
+
+	combined := decodeProto(t, message)
+	version, _ := protowire.ConsumeVarint(combined[1][0])
+	assert.EqualValues(t, CurrentProtocolVersion, version)
+	assert.Len(t, combined[4], 2, "one message per container")
+	assert.Equal(t, "nginx", string(decodeProto(t, combined[4][0])[1][0]))
+
+	info := decodeProto(t, combined[3][0])
+	assert.Equal(t, "web-01", string(info[1][0]))
+	cores, _ := protowire.ConsumeVarint(info[3][0])
+	assert.EqualValues(t, 16, cores)
+	assert.NotContains(t, info, protowire.Number(15), "zero values are left out")
+
+	statsFields := decodeProto(t, combined[2][0])
+	cpu, _ := protowire.ConsumeFixed64(statsFields[1][0])
+	assert.Equal(t, 12.34, math.Float64frombits(cpu))
+	// maps are sorted entries with the key in field 1 and the value in field 2
+	require.Len(t, statsFields[25], 2)
+	entry := decodeProto(t, statsFields[25][0])
+	assert.Equal(t, "k10temp_tctl", string(entry[1][0]))
+
+	gpu := decodeProto(t, decodeProto(t, statsFields[30][0])[2][0])
+	assert.Equal(t, "RTX 4090", string(gpu[1][0]))
+	assert.Equal(t, []byte{0, 0, 1, 0, 0, 3, 2, 0, 0, 0}, gpu[7][0], "histogram is packed")
+	lastUpdated, _ := protowire.ConsumeVarint(gpu[19][0])
+	assert.EqualValues(t, time.Unix(1700000000, 0).UnixNano(), lastUpdated)
+
+	var jsonBuf bytes.Buffer
+	require.NoError(t, stats.Encode(&jsonBuf, EncodingJSON))
+	assert.Less(t, buf.Len(), jsonBuf.Len())
+	t.Logf("protobuf %d bytes, JSON %d bytes", buf.Len(), jsonBuf.Len())
+
+	assert.Error(t, stats.Encode(&buf, "xml"))
+}
+
+func TestProtoSchema(t *testing.T) {
+	schema, err := os.ReadFile("stats.proto")
+	require.NoError(t, err)
+	want, err := ProtoSchema()
+	require.NoError(t, err)
+	assert.Equal(t, want, string(schema), "stats.proto is out of date, replace it with the output of ProtoSchema")
+}
+
+func TestProtoFieldsInvalidTag(t *testing.T) {
+	for _, typ := range []reflect.Type{
+		reflect.TypeFor[struct {
+			Name string `protobuf:"name"`
+		}](),
+		reflect.TypeFor[struct {
+			Name string `protobuf:"0"`
+		}](),
+	} {
+		_, err := protoFields(typ)
+		assert.Error(t, err)
+	}
+
+	type message struct {
+		Inner struct {
+			Value float64 `protobuf:"-1"`
+		} `protobuf:"1"`
+	}
+	v := message{}
+	v.Inner.Value = 1
+	_, err := appendProtoMessage(nil, reflect.ValueOf(v))
+	assert.Error(t, err, "errors in nested messages are returned")
+}
+
+func TestProtoFieldName(t *testing.T) {
+	for name, want := range map[string]string{
+		"Cpu":         "cpu",
+		"LLCMissRate": "llc_miss_rate",
+		"GPUData":     "gpu_data",
+		"FSType":      "fs_type",
+		"GOARCH":      "goarch",
+		"TempC":       "temp_c",
+	} {
+		assert.Equal(t, want, protoFieldName(name))
+	}
+}
+
+// releasedProtoFields are the field numbers of each message sent by released
+// agents. Numbers must never change, so new fields are added here and removed
+// fields are kept, which stops their numbers from being reused.
+var releasedProtoFields = map[string]map[string]protowire.Number{
+	"CombinedData": {
+		"protocol_version": 1, "stats": 2, "info": 3, "containers": 4, "meta": 5,
+		"tags": 6, "capabilities": 7,
+	},
+	"Stats": {
+		"cpu": 1, "max_cpu": 2, "llc_miss_rate": 3, "branch_miss_rate": 4, "irq_rates": 5,
+		"mem": 6, "mem_used": 7, "mem_pct": 8, "mem_buff_cache": 9, "mem_zfs_arc": 10,
+		"swap": 11, "swap_used": 12, "disk_total": 13, "disk_used": 14, "disk_pct": 15,
+		"disk_read_ps": 16, "disk_write_ps": 17, "max_disk_read_ps": 18, "max_disk_write_ps": 19, "network_sent": 20,
+		"network_recv": 21, "max_network_sent": 22, "max_network_recv": 23, "net_proto_stats": 24, "temperatures": 25,
+		"cpu_temps": 26, "ipmi_sensors": 27, "kube_pods": 28, "extra_fs": 29, "gpu_data": 30,
+		"gpu_topology": 31, "irq_affinity": 32, "remote_fs_stats": 33, "sched_latency": 34, "mapped_memory_mb": 35,
+		"shared_memory_mb": 36, "page_cache_mb": 37, "socket_stats": 38, "zen_cpu_temps": 39,
+	},
+	"Info": {
+		"hostname": 1, "kernel_version": 2, "cores": 3, "threads": 4, "cpu_model": 5,
+		"uptime": 6, "cpu": 7, "mem_pct": 8, "disk_pct": 9, "bandwidth": 10,
+		"agent_version": 11, "podman": 12, "gpu_pct": 13, "dashboard_temp": 14, "os": 15,
+	},
+	"ContainerStats": {
+		"name": 1, "cpu": 2, "mem": 3, "network_sent": 4, "network_recv": 5,
+	},
+	"AgentMeta": {
+		"version": 1, "build_time": 2, "go_version": 3, "goarch": 4, "goos": 5,
+		"encodings": 6,
+	},
+	"AgentCapabilities": {
+		"cpu_vulnerabilities": 1,
+	},
+	"CPUTemp": {
+		"label": 1, "temp_c": 2,
+	},
+	"IPMISensor": {
+		"name": 1, "type": 2, "value": 3, "unit": 4, "status": 5,
+	},
+	"KubePodStat": {
+		"name": 1, "namespace": 2, "cpu": 3, "mem": 4,
+	},
+	"FsStats": {
+		"disk_total": 4, "disk_used": 5, "disk_read_ps": 8, "disk_write_ps": 9, "max_disk_read_ps": 10,
+		"max_disk_write_ps": 11, "model": 12, "serial": 13, "fs_type": 14, "queue_depth": 15,
+	},
+	"GPUData": {
+		"name": 1, "memory_temp": 3, "memory_used": 4, "memory_total": 5, "usage": 6,
+		"usage_histogram": 7, "power": 8, "power_limit": 9, "thermal_zones": 10, "mig_instances": 11,
+		"pc_ie_tx_bandwidth": 12, "pc_ie_rx_bandwidth": 13, "nv_link_tx_bandwidth": 14, "nv_link_rx_bandwidth": 15, "encoder_sessions": 16,
+		"memory_fragmentation": 17, "error": 18, "last_updated": 19, "xgmi_read_bw": 22, "xgmi_write_bw": 23,
+		"pc_ie_gen": 24, "pc_ie_width": 25, "compute_partition": 29, "memory_partition": 30, "copy_engine_usage": 31,
+		"performance_level": 32, "max_power_limit": 33, "compute_mode": 34, "power_label": 35, "over_temp_events": 36,
+		"temperature_min": 37, "temperature_max": 38, "memory_reserved": 39, "memory_available": 40, "frequency": 41,
+		"npu_usage": 42, "max_frequency": 43,
+	},
+	"GPULink": {
+		"src_id": 1, "dst_id": 2, "link_type": 3,
+	},
+	"IRQAffinityEntry": {
+		"irq": 1, "name": 2, "cpu_mask": 3,
+	},
+	"RemoteFSEntry": {
+		"mount": 1, "protocol": 2, "read_ops": 3, "write_ops": 4, "read_bytes": 5,
+		"write_bytes": 6,
+	},
+	"SchedLatency": {
+		"runqueue_latency_ms": 1,
+	},
+	"SocketStats": {
+		"used": 1, "tcp_in_use": 2, "tcp_orphan": 3, "tcp_time_wait": 4, "udp_in_use": 5,
+		"raw_in_use": 6,
+	},
+	"ZenCPUTemps": {
+		"tdie": 1, "tccd": 2,
+	},
+}
+
+func TestProtoFieldNumbers(t *testing.T) {
+	written := make(map[reflect.Type]bool)
+	pending := []reflect.Type{reflect.TypeFor[CombinedData]()}
+	for len(pending) > 0 {
+		typ := pending[0]
+		pending = pending[1:]
+		if written[typ] {
+			continue
+		}
+		written[typ] = true
+		message := protoMessageName(typ)
+		released := releasedProtoFields[message]
+		fields, err := protoFields(typ)
+		require.NoError(t, err)
+		for _, field := range fields {
+			num := field.num
+			name := protoFieldName(field.Name)
+			want, ok := released[name]
+			if !ok {
+				t.Errorf("%s.%s is not in releasedProtoFields, add it with a number that isn't used", message, name)
+			} else {
+				assert.Equal(t, want, num, "%s.%s changed number, which breaks released hubs", message, name)
+			}
+			for other, otherNum := range released {
+				if otherNum == num && other != name {
+					t.Errorf("%s.%s reuses the number of %s", message, name, other)
+				}
+			}
+			fieldType := field.Type
+			for fieldType.Kind() == reflect.Pointer || fieldType.Kind() == reflect.Map || isProtoList(fieldType) {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct && fieldType != timeType {
+				pending = append(pending, fieldType)
+			}
+		}
+	}
+}
+
+// untaggedProtoFields returns the fields sent as JSON that have no protobuf tag,
+// in struct type t and every struct type reachable from it
+func untaggedProtoFields(t reflect.Type) []string {
+	var untagged []string
+	seen := make(map[reflect.Type]bool)
+	pending := []reflect.Type{t}
+	for len(pending) > 0 {
+		typ := pending[0]
+		pending = pending[1:]
+		if seen[typ] {
+			continue
+		}
+		seen[typ] = true
+		for i := range typ.NumField() {
+			field := typ.Field(i)
+			if !field.IsExported() || field.Tag.Get("json") == "-" {
+				continue
+			}
+			if field.Tag.Get("protobuf") == "" {
+				untagged = append(untagged, typ.Name()+"."+field.Name)
+			}
+			fieldType := field.Type
+			for fieldType.Kind() == reflect.Pointer || fieldType.Kind() == reflect.Map || isProtoList(fieldType) {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct && fieldType != timeType {
+				pending = append(pending, fieldType)
+			}
+		}
+	}
+	return untagged
+}
+
+func TestProtoTagsComplete(t *testing.T) {
+	assert.Empty(t, untaggedProtoFields(reflect.TypeFor[CombinedData]()),
+		"every field sent as JSON must have a protobuf tag, or it is dropped for hubs that request protobuf")
+
+	// fields without a tag are found in nested messages too
+	type inner struct {
+		Sent   float64 `json:"s"`
+		Hidden float64 `json:"-"`
+	}
+	type outer struct {
+		Inner  []inner `json:"i" protobuf:"1"`
+		Tagged string  `json:"t" protobuf:"2"`
+	}
+	assert.Equal(t, []string{"inner.Sent"}, untaggedProtoFields(reflect.TypeFor[outer]()))
+}
+
+func TestDecodeProtobuf(t *testing.T) {
+	stats := encodeTestStats()
+	var buf bytes.Buffer
+	require.NoError(t, stats.Encode(&buf, EncodingProtobuf))
+
+	var decoded CombinedData
+	require.NoError(t, decoded.Decode(bytes.NewReader(buf.Bytes()), EncodingProtobuf))
+	assert.Equal(t, *stats, decoded)
+
+	t.Run("unknown fields are skipped", func(t *testing.T) {
+		message, err := appendProtoMessage(nil, reflect.ValueOf(stats).Elem())
+		require.NoError(t, err)
+		message = protowire.AppendTag(message, 1000, protowire.BytesType)
+		message = protowire.AppendString(message, "from a newer agent")
+		frame := protowire.AppendVarint(nil, uint64(len(message)))
+
+		var decoded CombinedData
+		require.NoError(t, decoded.Decode(bytes.NewReader(append(frame, message...)), EncodingProtobuf))
+		assert.Equal(t, *stats, decoded)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		var decoded CombinedData
+		err := decoded.Decode(bytes.NewReader(buf.Bytes()[:buf.Len()-10]), EncodingProtobuf)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("wrong wire type", func(t *testing.T) {
+		// protocol_version as a string
+		message := protowire.AppendTag(nil, 1, protowire.BytesType)
+		message = protowire.AppendString(message, "1")
+		frame := protowire.AppendVarint(nil, uint64(len(message)))
+		var decoded CombinedData
+		assert.Error(t, decoded.Decode(bytes.NewReader(append(frame, message...)), EncodingProtobuf))
+	})
+}
+
+// statsProtoFieldPattern matches a field in stats.proto, e.g.
+// "  repeated CPUTemp cpu_temps = 26;" or "  map<string, double> temperatures = 25;"
+var statsProtoFieldPattern = regexp.MustCompile(`^\s+(repeated )?(?:map<(\w+), (\w+)>|(\w+)) (\w+) = (\d+);`)
+
+var statsProtoScalarTypes = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"double": descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	"float":  descriptorpb.FieldDescriptorProto_TYPE_FLOAT,
+	"bool":   descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"int32":  descriptorpb.FieldDescriptorProto_TYPE_INT32,
+	"int64":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	"uint32": descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	"uint64": descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+}
+
+// statsProtoField returns the descriptor of a field of the given type in stats.proto
+func statsProtoField(name string, num int32, typeName string, label descriptorpb.FieldDescriptorProto_Label) *descriptorpb.FieldDescriptorProto {
+	field := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(num), Label: label.Enum()}
+	if scalar, ok := statsProtoScalarTypes[typeName]; ok {
+		field.Type = scalar.Enum()
+	} else {
+		field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		field.TypeName = proto.String(typeName)
+	}
+	return field
+}
+
+// parseStatsProto builds the descriptor of stats.proto for the protobuf runtime.
+// It only handles the syntax written by ProtoSchema, so the schema can be checked
+// without protoc.
+func parseStatsProto(t *testing.T, schema string) protoreflect.FileDescriptor {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("stats.proto"),
+		Package: proto.String("beszel"),
+		Syntax:  proto.String("proto3"),
+	}
+	var message *descriptorpb.DescriptorProto
+	for line := range strings.Lines(schema) {
+		if name, ok := strings.CutPrefix(line, "message "); ok {
+			message = &descriptorpb.DescriptorProto{Name: proto.String(strings.TrimSuffix(strings.TrimSpace(name), " {"))}
+			file.MessageType = append(file.MessageType, message)
+			continue
+		}
+		match := statsProtoFieldPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		require.NotNil(t, message, line)
+		num, err := strconv.Atoi(match[6])
+		require.NoError(t, err)
+		name := match[5]
+		optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		switch {
+		case match[2] != "":
+			// maps are repeated entries of a nested message named after the field
+			var entryName string
+			for word := range strings.SplitSeq(name, "_") {
+				entryName += strings.ToUpper(word[:1]) + word[1:]
+			}
+			entryName += "Entry"
+			message.NestedType = append(message.NestedType, &descriptorpb.DescriptorProto{
+				Name:    proto.String(entryName),
+				Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				Field: []*descriptorpb.FieldDescriptorProto{
+					statsProtoField("key", 1, match[2], optional),
+					statsProtoField("value", 2, match[3], optional),
+				},
+			})
+			message.Field = append(message.Field, statsProtoField(name, int32(num), message.GetName()+"."+entryName, repeated))
+		case match[1] != "":
+			message.Field = append(message.Field, statsProtoField(name, int32(num), match[4], repeated))
+		default:
+			message.Field = append(message.Field, statsProtoField(name, int32(num), match[4], optional))
+		}
+	}
+	// message types are relative to the package
+	for _, message := range file.MessageType {
+		for _, nested := range append([]*descriptorpb.DescriptorProto{message}, message.NestedType...) {
+			for _, field := range nested.Field {
+				if field.TypeName != nil {
+					field.TypeName = proto.String(".beszel." + field.GetTypeName())
+				}
+			}
+		}
+	}
+	descriptor, err := protodesc.NewFile(file, nil)
+	require.NoError(t, err)
+	return descriptor
+}
+
+// fillProtoValue sets v and every encoded field in it to a non-zero value
+func fillProtoValue(t *testing.T, v reflect.Value) {
+	if v.Type() == timeType {
+		v.Set(reflect.ValueOf(time.Unix(1700000000, 0).UTC()))
+		return
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+		fillProtoValue(t, v.Elem())
+	case reflect.Struct:
+		fields, err := protoFields(v.Type())
+		require.NoError(t, err)
+		for _, field := range fields {
+			fillProtoValue(t, v.FieldByIndex(field.Index))
+		}
+	case reflect.Map:
+		key := reflect.New(v.Type().Key()).Elem()
+		elem := reflect.New(v.Type().Elem()).Elem()
+		fillProtoValue(t, key)
+		fillProtoValue(t, elem)
+		v.Set(reflect.MakeMap(v.Type()))
+		v.SetMapIndex(key, elem)
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 2, 2))
+		for i := range v.Len() {
+			fillProtoValue(t, v.Index(i))
+		}
+	case reflect.Array:
+		for i := range v.Len() {
+			fillProtoValue(t, v.Index(i))
+		}
+	case reflect.String:
+		v.SetString("value")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1.5)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(-7)
+	default:
+		v.SetUint(7)
+	}
+}
+
+// assertProtoFieldsSet checks that every field of m and its messages is set, and
+// that none of them has fields that are not in the schema
+func assertProtoFieldsSet(t *testing.T, m protoreflect.Message) {
+	assert.Empty(t, m.GetUnknown(), "%s has fields that are not in stats.proto", m.Descriptor().FullName())
+	fields := m.Descriptor().Fields()
+	for i := range fields.Len() {
+		field := fields.Get(i)
+		if !m.Has(field) {
+			t.Errorf("%s is not set", field.FullName())
+			continue
+		}
+		switch {
+		case field.IsMap():
+			if field.MapValue().Kind() == protoreflect.MessageKind {
+				m.Get(field).Map().Range(func(_ protoreflect.MapKey, value protoreflect.Value) bool {
+					assertProtoFieldsSet(t, value.Message())
+					return true
+				})
+			}
+		case field.IsList():
+			if field.Kind() == protoreflect.MessageKind {
+				list := m.Get(field).List()
+				for j := range list.Len() {
+					assertProtoFieldsSet(t, list.Get(j).Message())
+				}
+			}
+		case field.Kind() == protoreflect.MessageKind:
+			assertProtoFieldsSet(t, m.Get(field).Message())
+		}
+	}
+}
+
+// unframeProto returns the message of a length prefixed protobuf frame
+func unframeProto(t *testing.T, frame []byte) []byte {
+	length, n := protowire.ConsumeVarint(frame)
+	require.Greater(t, n, 0)
+	require.Len(t, frame[n:], int(length))
+	return frame[n:]
+}
+
+// TestProtoSchemaDecode decodes the output of Encode with the protobuf runtime,
+// using the types in stats.proto, as a client generated with protoc would
+func TestProtoSchemaDecode(t *testing.T) {
+	schema, err := os.ReadFile("stats.proto")
+	require.NoError(t, err)
+	combinedData := parseStatsProto(t, string(schema)).Messages().ByName("CombinedData")
+	require.NotNil(t, combinedData)
+
+	t.Run("values", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, encodeTestStats().Encode(&buf, EncodingProtobuf))
+		message := dynamicpb.NewMessage(combinedData)
+		require.NoError(t, proto.Unmarshal(unframeProto(t, buf.Bytes()), message))
+
+		get := func(m protoreflect.Message, name protoreflect.Name) protoreflect.Value {
+			field := m.Descriptor().Fields().ByName(name)
+			require.NotNil(t, field, name)
+			return m.Get(field)
+		}
+		assert.EqualValues(t, CurrentProtocolVersion, get(message, "protocol_version").Int())
+		assert.Equal(t, "web-01", get(get(message, "info").Message(), "hostname").String())
+		stats := get(message, "stats").Message()
+		assert.Equal(t, 12.34, get(stats, "cpu").Float())
+		assert.Equal(t, 52.12, get(stats, "temperatures").Map().Get(protoreflect.ValueOfString("k10temp_tctl").MapKey()).Float())
+		gpu := get(stats, "gpu_data").Map().Get(protoreflect.ValueOfString("0").MapKey()).Message()
+		assert.Equal(t, "RTX 4090", get(gpu, "name").String())
+		assert.EqualValues(t, 3, get(gpu, "usage_histogram").List().Get(5).Uint())
+		assert.Equal(t, time.Unix(1700000000, 0).UnixNano(), get(gpu, "last_updated").Int())
+		containers := get(message, "containers").List()
+		require.Equal(t, 2, containers.Len())
+		assert.Equal(t, "postgres", get(containers.Get(1).Message(), "name").String())
+		assert.Equal(t, "r12", get(message, "tags").Map().Get(protoreflect.ValueOfString("rack").MapKey()).String())
+	})
+
+	t.Run("every field", func(t *testing.T) {
+		var stats CombinedData
+		fillProtoValue(t, reflect.ValueOf(&stats).Elem())
+		var buf bytes.Buffer
+		require.NoError(t, stats.Encode(&buf, EncodingProtobuf))
+		message := dynamicpb.NewMessage(combinedData)
+		require.NoError(t, proto.Unmarshal(unframeProto(t, buf.Bytes()), message))
+		assertProtoFieldsSet(t, message)
+
+		// and what the protobuf runtime writes is decoded back
+		wire, err := proto.Marshal(message)
+		require.NoError(t, err)
+		var decoded CombinedData
+		require.NoError(t, decoded.Decode(bytes.NewReader(append(protowire.AppendVarint(nil, uint64(len(wire))), wire...)), EncodingProtobuf))
+		assert.Equal(t, stats, decoded)
+	})
+}
@@ -8,53 +8,174 @@ import (
 )
 
 type Stats struct {
-	Cpu            float64             `json:"cpu"`
-	MaxCpu         float64             `json:"cpum,omitempty"`
-	Mem            float64             `json:"m"`
-	MemUsed        float64             `json:"mu"`
-	MemPct         float64             `json:"mp"`
-	MemBuffCache   float64             `json:"mb"`
-	MemZfsArc      float64             `json:"mz,omitempty"` // ZFS ARC memory
-	Swap           float64             `json:"s,omitempty"`
-	SwapUsed       float64             `json:"su,omitempty"`
-	DiskTotal      float64             `json:"d"`
-	DiskUsed       float64             `json:"du"`
-	DiskPct        float64             `json:"dp"`
-	DiskReadPs     float64             `json:"dr"`
-	DiskWritePs    float64             `json:"dw"`
-	MaxDiskReadPs  float64             `json:"drm,omitempty"`
-	MaxDiskWritePs float64             `json:"dwm,omitempty"`
-	NetworkSent    float64             `json:"ns"`
-	NetworkRecv    float64             `json:"nr"`
-	MaxNetworkSent float64             `json:"nsm,omitempty"`
-	MaxNetworkRecv float64             `json:"nrm,omitempty"`
-	Temperatures   map[string]float64  `json:"t,omitempty"`
-	ExtraFs        map[string]*FsStats `json:"efs,omitempty"`
-	GPUData        map[string]GPUData  `json:"g,omitempty"`
+	Cpu            float64             `json:"cpu" protobuf:"1"`
+	MaxCpu         float64             `json:"cpum,omitempty" protobuf:"2"`
+	LLCMissRate    float64             `json:"llc,omitempty" protobuf:"3"` // Last level cache miss rate (%)
+	BranchMissRate float64             `json:"bmr,omitempty" protobuf:"4"` // Branch misprediction rate (%)
+	IRQRates       map[string]float64  `json:"irq,omitempty" protobuf:"5"` // Interrupts per second by name, highest 64 only
+	Mem            float64             `json:"m" protobuf:"6"`
+	MemUsed        float64             `json:"mu" protobuf:"7"`
+	MemPct         float64             `json:"mp" protobuf:"8"`
+	MemBuffCache   float64             `json:"mb" protobuf:"9"`
+	MemZfsArc      float64             `json:"mz,omitempty" protobuf:"10"` // ZFS ARC memory
+	Swap           float64             `json:"s,omitempty" protobuf:"11"`
+	SwapUsed       float64             `json:"su,omitempty" protobuf:"12"`
+	DiskTotal      float64             `json:"d" protobuf:"13"`
+	DiskUsed       float64             `json:"du" protobuf:"14"`
+	DiskPct        float64             `json:"dp" protobuf:"15"`
+	DiskReadPs     float64             `json:"dr" protobuf:"16"`
+	DiskWritePs    float64             `json:"dw" protobuf:"17"`
+	MaxDiskReadPs  float64             `json:"drm,omitempty" protobuf:"18"`
+	MaxDiskWritePs float64             `json:"dwm,omitempty" protobuf:"19"`
+	NetworkSent    float64             `json:"ns" protobuf:"20"`
+	NetworkRecv    float64             `json:"nr" protobuf:"21"`
+	MaxNetworkSent float64             `json:"nsm,omitempty" protobuf:"22"`
+	MaxNetworkRecv float64             `json:"nrm,omitempty" protobuf:"23"`
+	NetProtoStats  map[string]uint64   `json:"np,omitempty" protobuf:"24"` // Packets per interface and protocol ("eth0/tcp")
+	Temperatures   map[string]float64  `json:"t,omitempty" protobuf:"25"`
+	CPUTemps       []CPUTemp           `json:"ct,omitempty" protobuf:"26"`   // Per-package and per-core CPU temperatures
+	IPMISensors    []IPMISensor        `json:"ipmi,omitempty" protobuf:"27"` // BMC fan, temperature, and power sensors
+	KubePods       []KubePodStat       `json:"kp,omitempty" protobuf:"28"`   // Kubernetes pods on the node
+	ExtraFs        map[string]*FsStats `json:"efs,omitempty" protobuf:"29"`
+	GPUData        map[string]GPUData  `json:"g,omitempty" protobuf:"30"`
+	GPUTopology    []GPULink           `json:"gt,omitempty" protobuf:"31"`   // Links between GPUs, only sent in the first response of a connection
+	IRQAffinity    []IRQAffinityEntry  `json:"irqa,omitempty" protobuf:"32"` // CPUs handling the busiest numbered interrupts, highest 20 only
+	RemoteFSStats  []RemoteFSEntry     `json:"rfs,omitempty" protobuf:"33"`  // NFS and CIFS mount I/O
+	SchedLatency   *SchedLatency       `json:"sl,omitempty" protobuf:"34"`   // Runqueue wait time from /proc/schedstat
+	MappedMemoryMB float64             `json:"mmap,omitempty" protobuf:"35"` // Memory mapped files
+	SharedMemoryMB float64             `json:"mshm,omitempty" protobuf:"36"` // Shared memory and tmpfs
+	PageCacheMB    float64             `json:"mpc,omitempty" protobuf:"37"`  // Buffers and page cache that can be reclaimed
+	SocketStats    *SocketStats        `json:"sk,omitempty" protobuf:"38"`   // Open sockets by protocol from /proc/net/sockstat
+	ZenCPUTemps    *ZenCPUTemps        `json:"zt,omitempty" protobuf:"39"`   // AMD Zen die and CCD temperatures from k10temp
+}
+
+// CPU temperature sensor reading from hwmon
+type CPUTemp struct {
+	Label string  `json:"l" protobuf:"1"`
+	TempC float64 `json:"t" protobuf:"2"`
+}
+
+// Die and per-CCD temperatures of AMD Zen CPUs from the k10temp hwmon driver
+type ZenCPUTemps struct {
+	Tdie float64   `json:"d" protobuf:"1"`           // Die temperature in Celsius
+	Tccd []float64 `json:"c,omitempty" protobuf:"2"` // Temperature of each core complex die (CCD), up to 8
+}
+
+// Time tasks spent waiting for a CPU, a measure of CPU overcommit
+type SchedLatency struct {
+	RunqueueLatencyMs float64 `json:"rq" protobuf:"1"` // Milliseconds of runqueue wait per second, summed across CPUs
+}
+
+// Sockets in use system wide, to detect socket leaks before the file descriptor
+// limit is reached. IPv4 and IPv6 sockets are summed.
+type SocketStats struct {
+	Used        uint32 `json:"u" protobuf:"1"`             // All sockets, including Unix sockets
+	TCPInUse    uint32 `json:"tcp" protobuf:"2"`           // TCP sockets, including listening sockets
+	TCPOrphan   uint32 `json:"to,omitempty" protobuf:"3"`  // TCP sockets no longer attached to a file descriptor
+	TCPTimeWait uint32 `json:"tw,omitempty" protobuf:"4"`  // TCP sockets in TIME_WAIT
+	UDPInUse    uint32 `json:"udp" protobuf:"5"`           // UDP sockets
+	RAWInUse    uint32 `json:"raw,omitempty" protobuf:"6"` // Raw sockets
+}
+
+// CPUs that handle a hardware interrupt, from /proc/irq/<N>/smp_affinity_list
+type IRQAffinityEntry struct {
+	IRQ     string `json:"i" protobuf:"1"`
+	Name    string `json:"n" protobuf:"2"`
+	CPUMask string `json:"c" protobuf:"3"` // CPU list, e.g. "0-3,8"
+}
+
+// IPMI sensor reading from the BMC
+type IPMISensor struct {
+	Name   string  `json:"n" protobuf:"1"`
+	Type   string  `json:"t" protobuf:"2"`
+	Value  float64 `json:"v" protobuf:"3"`
+	Unit   string  `json:"u" protobuf:"4"`
+	Status string  `json:"s" protobuf:"5"`
+}
+
+// Kubernetes pod usage from the kubelet summary API
+type KubePodStat struct {
+	Name      string  `json:"n" protobuf:"1"`
+	Namespace string  `json:"ns" protobuf:"2"`
+	Cpu       float64 `json:"c" protobuf:"3"` // percent of total host CPU
+	Mem       float64 `json:"m" protobuf:"4"` // working set (MB)
+}
+
+// Interconnect between two GPUs
+type GPULink struct {
+	SrcID    string `json:"s" protobuf:"1"`
+	DstID    string `json:"d" protobuf:"2"`
+	LinkType string `json:"t" protobuf:"3"` // "PCIe" or "xGMI"
 }
 
 type GPUData struct {
-	Name        string  `json:"n"`
-	Temperature float64 `json:"-"`
-	MemoryUsed  float64 `json:"mu,omitempty"`
-	MemoryTotal float64 `json:"mt,omitempty"`
-	Usage       float64 `json:"u"`
-	Power       float64 `json:"p,omitempty"`
-	Count       float64 `json:"-"`
+	Name                string             `json:"n" protobuf:"1"`
+	Temperature         float64            `json:"-"`
+	MemoryTemp          float64            `json:"mtp,omitempty" protobuf:"3"` // AMD VRAM temperature (C), the thermal limit on RDNA3
+	MemoryUsed          float64            `json:"mu,omitempty" protobuf:"4"`
+	MemoryTotal         float64            `json:"mt,omitempty" protobuf:"5"`
+	Usage               float64            `json:"u" protobuf:"6"`
+	UsageHistogram      [10]uint8          `json:"uh,omitzero" protobuf:"7"` // Usage samples per 10% range (0-9%, ..., 90-100%) since the last request
+	Power               float64            `json:"p,omitempty" protobuf:"8"`
+	PowerLimit          float64            `json:"pl,omitempty" protobuf:"9"`   // Power cap (W)
+	ThermalZones        map[string]float64 `json:"tz,omitempty" protobuf:"10"`  // Jetson thermal zone temperatures
+	MIGInstances        int                `json:"mig,omitempty" protobuf:"11"` // Number of Nvidia MIG instances
+	PCIeTxBandwidth     float64            `json:"ptx,omitempty" protobuf:"12"` // PCIe sent bandwidth (MB/s)
+	PCIeRxBandwidth     float64            `json:"prx,omitempty" protobuf:"13"` // PCIe received bandwidth (MB/s)
+	NVLinkTxBandwidth   float64            `json:"ntx,omitempty" protobuf:"14"` // NVLink sent bandwidth, all links (MB/s)
+	NVLinkRxBandwidth   float64            `json:"nrx,omitempty" protobuf:"15"` // NVLink received bandwidth, all links (MB/s)
+	EncoderSessions     uint32             `json:"es,omitempty" protobuf:"16"`  // Active Nvidia encoder sessions
+	MemoryFragmentation float64            `json:"mf,omitempty" protobuf:"17"`  // Estimated memory fragmentation (0-1), from Nvidia BAR1
+	Error               string             `json:"err,omitempty" protobuf:"18"` // Set when the GPU's collector stopped, values are zero
+	LastUpdated         time.Time          `json:"lu,omitzero" protobuf:"19"`   // Time of the latest sample from the GPU's collector
+	Count               float64            `json:"-"`
+	XGMIReadBW          float64            `json:"xrx,omitempty" protobuf:"22"` // AMD Infinity Fabric read bandwidth, all links (MB/s)
+	XGMIWriteBW         float64            `json:"xtx,omitempty" protobuf:"23"` // AMD Infinity Fabric write bandwidth, all links (MB/s)
+	PCIeGen             uint8              `json:"pg,omitempty" protobuf:"24"`  // Current Nvidia PCIe link generation
+	PCIeWidth           uint8              `json:"pw,omitempty" protobuf:"25"`  // Current Nvidia PCIe link width (lanes)
+	ComputePartition    string             `json:"cpm,omitempty" protobuf:"29"` // AMD compute partition mode, e.g. "CPX"
+	MemoryPartition     string             `json:"mpm,omitempty" protobuf:"30"` // AMD memory partition mode, e.g. "NPS1"
+	CopyEngineUsage     float64            `json:"ceu,omitempty" protobuf:"31"` // Nvidia memory controller busy time (%), see parseNvidiaData
+	PerformanceLevel    string             `json:"pfl,omitempty" protobuf:"32"` // AMD power management mode, e.g. "auto" or "manual"
+	MaxPowerLimit       float64            `json:"mpl,omitempty" protobuf:"33"` // Highest power cap supported by the Nvidia board (W)
+	ComputeMode         string             `json:"cm,omitempty" protobuf:"34"`  // Nvidia compute mode, e.g. "Default" or "Exclusive_Process"
+	PowerLabel          string             `json:"pwl,omitempty" protobuf:"35"` // Domain of Power if not the GPU alone, "CPU+GPU+CV" on Jetson Orin Nano / NX
+	OverTempEvents      uint32             `json:"ote,omitempty" protobuf:"36"` // Times the temperature rose above the warning threshold since the last report
+	TemperatureMin      float64            `json:"tn,omitempty" protobuf:"37"`  // Lowest temperature reading since the last report (C)
+	TemperatureMax      float64            `json:"tx,omitempty" protobuf:"38"`  // Highest temperature reading since the last report (C)
+	MemoryReserved      float64            `json:"mr,omitempty" protobuf:"39"`  // Nvidia memory reserved by the driver, not included in MemoryUsed (MB)
+	MemoryAvailable     float64            `json:"ma,omitempty" protobuf:"40"`  // MemoryTotal minus MemoryUsed and MemoryReserved (MB)
+	Frequency           float64            `json:"f,omitempty" protobuf:"41"`   // Current Rockchip GPU clock (MHz)
+	NPUUsage            float64            `json:"npu,omitempty" protobuf:"42"` // Rockchip NPU load averaged over its cores (%)
+	MaxFrequency        float64            `json:"fm,omitempty" protobuf:"43"`  // Highest Mali GPU clock allowed by devfreq (MHz)
+}
+
+// Cumulative I/O counters of an NFS or CIFS mount
+type RemoteFSEntry struct {
+	Mount      string `json:"m" protobuf:"1"`
+	Protocol   string `json:"p" protobuf:"2"` // fstype, e.g. "nfs4" or "cifs"
+	ReadOps    uint64 `json:"ro" protobuf:"3"`
+	WriteOps   uint64 `json:"wo" protobuf:"4"`
+	ReadBytes  uint64 `json:"rb" protobuf:"5"`
+	WriteBytes uint64 `json:"wb" protobuf:"6"`
 }
 
 type FsStats struct {
 	Time           time.Time `json:"-"`
 	Root           bool      `json:"-"`
 	Mountpoint     string    `json:"-"`
-	DiskTotal      float64   `json:"d"`
-	DiskUsed       float64   `json:"du"`
+	DiskTotal      float64   `json:"d" protobuf:"4"`
+	DiskUsed       float64   `json:"du" protobuf:"5"`
 	TotalRead      uint64    `json:"-"`
 	TotalWrite     uint64    `json:"-"`
-	DiskReadPs     float64   `json:"r"`
-	DiskWritePs    float64   `json:"w"`
-	MaxDiskReadPS  float64   `json:"rm,omitempty"`
-	MaxDiskWritePS float64   `json:"wm,omitempty"`
+	DiskReadPs     float64   `json:"r" protobuf:"8"`
+	DiskWritePs    float64   `json:"w" protobuf:"9"`
+	MaxDiskReadPS  float64   `json:"rm,omitempty" protobuf:"10"`
+	MaxDiskWritePS float64   `json:"wm,omitempty" protobuf:"11"`
+	Model          string    `json:"mo,omitempty" protobuf:"12"` // Model of the backing disk
+	Serial         string    `json:"sn,omitempty" protobuf:"13"` // Serial number of the backing disk
+	FSType         string    `json:"ft,omitempty" protobuf:"14"` // Filesystem type, e.g. "ext4" or "tmpfs"
+	QueueDepth     uint32    `json:"qd,omitempty" protobuf:"15"` // Maximum requests queued for the backing disk
 }
 
 type NetIoStats struct {
@@ -74,26 +195,50 @@ const (
 )
 
 type Info struct {
-	Hostname      string  `json:"h"`
-	KernelVersion string  `json:"k,omitempty"`
-	Cores         int     `json:"c"`
-	Threads       int     `json:"t,omitempty"`
-	CpuModel      string  `json:"m"`
-	Uptime        uint64  `json:"u"`
-	Cpu           float64 `json:"cpu"`
-	MemPct        float64 `json:"mp"`
-	DiskPct       float64 `json:"dp"`
-	Bandwidth     float64 `json:"b"`
-	AgentVersion  string  `json:"v"`
-	Podman        bool    `json:"p,omitempty"`
-	GpuPct        float64 `json:"g,omitempty"`
-	DashboardTemp float64 `json:"dt,omitempty"`
-	Os            Os      `json:"os"`
+	Hostname      string  `json:"h" protobuf:"1"`
+	KernelVersion string  `json:"k,omitempty" protobuf:"2"`
+	Cores         int     `json:"c" protobuf:"3"`
+	Threads       int     `json:"t,omitempty" protobuf:"4"`
+	CpuModel      string  `json:"m" protobuf:"5"`
+	Uptime        uint64  `json:"u" protobuf:"6"`
+	Cpu           float64 `json:"cpu" protobuf:"7"`
+	MemPct        float64 `json:"mp" protobuf:"8"`
+	DiskPct       float64 `json:"dp" protobuf:"9"`
+	Bandwidth     float64 `json:"b" protobuf:"10"`
+	AgentVersion  string  `json:"v" protobuf:"11"`
+	Podman        bool    `json:"p,omitempty" protobuf:"12"`
+	GpuPct        float64 `json:"g,omitempty" protobuf:"13"`
+	DashboardTemp float64 `json:"dt,omitempty" protobuf:"14"`
+	Os            Os      `json:"os" protobuf:"15"`
 }
 
+// Version and build metadata of the agent
+type AgentMeta struct {
+	Version   string `json:"v" protobuf:"1"`
+	BuildTime string `json:"bt,omitempty" protobuf:"2"`
+	GoVersion string `json:"go" protobuf:"3"`
+	GOARCH    string `json:"arch" protobuf:"4"`
+	GOOS      string `json:"os" protobuf:"5"`
+	// Stats encodings the agent can send, so hubs only request ones it supports
+	Encodings []string `json:"enc,omitempty" protobuf:"6"`
+}
+
+// Static features of the agent's host, only sent in the first response of a connection
+type AgentCapabilities struct {
+	CPUVulnerabilities map[string]string `json:"cv" protobuf:"1"` // Mitigation status of CPU side channel vulnerabilities, e.g. "spectre_v2"
+}
+
+// CurrentProtocolVersion is the version of the stats response sent by the agent.
+// It is incremented with breaking changes to the response.
+const CurrentProtocolVersion = 1
+
 // Final data structure to return to the hub
 type CombinedData struct {
-	Stats      Stats              `json:"stats"`
-	Info       Info               `json:"info"`
-	Containers []*container.Stats `json:"container"`
+	ProtocolVersion int                `json:"protocol" protobuf:"1"` // Stats response format, see CurrentProtocolVersion
+	Stats           Stats              `json:"stats" protobuf:"2"`
+	Info            Info               `json:"info" protobuf:"3"`
+	Containers      []*container.Stats `json:"container" protobuf:"4"`
+	Meta            AgentMeta          `json:"meta" protobuf:"5"`
+	Tags            map[string]string  `json:"tags,omitempty" protobuf:"6"` // Labels set on the agent with BESZEL_TAGS
+	Capabilities    *AgentCapabilities `json:"caps,omitempty" protobuf:"7"` // Only sent in the first response of a connection
 }
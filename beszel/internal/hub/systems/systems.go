@@ -1,14 +1,20 @@
 package systems
 
 import (
+	"beszel"
 	"beszel/internal/common"
 	"beszel/internal/entities/system"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/blang/semver"
 	"github.com/goccy/go-json"
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/tools/store"
@@ -33,15 +39,18 @@ type SystemManager struct {
 }
 
 type System struct {
-	Id      string `db:"id"`
-	Host    string `db:"host"`
-	Port    string `db:"port"`
-	Status  string `db:"status"`
-	manager *SystemManager
-	client  *ssh.Client
-	data    *system.CombinedData
-	ctx     context.Context
-	cancel  context.CancelFunc
+	Id           string `db:"id"`
+	Host         string `db:"host"`
+	Port         string `db:"port"`
+	Status       string `db:"status"`
+	manager      *SystemManager
+	client       *ssh.Client
+	data         *system.CombinedData
+	ctx          context.Context
+	cancel       context.CancelFunc
+	agentVersion string // last agent version seen, used to avoid repeating warnings
+	encoding     string // stats encoding to request, as advertised by the agent in its last response
+	lastPayload  []byte // last stats JSON from the agent, the base for delta payloads
 }
 
 type hubLike interface {
@@ -341,19 +350,34 @@ func (sys *System) fetchDataFromAgent() (*system.CombinedData, error) {
 		if err != nil {
 			return nil, err
 		}
+		// ask for deltas of the previous stats, ignored by agents without delta mode
+		_ = session.Setenv(common.DeltaEnv, "1")
+		// stats are JSON until the agent advertises protobuf
+		if sys.encoding == system.EncodingProtobuf {
+			_ = session.Setenv(common.EncodingEnv, system.EncodingProtobuf)
+		}
 		if err := session.Shell(); err != nil {
 			return nil, err
 		}
 
-		// this is initialized in startUpdater, should never be nil
-		*sys.data = system.CombinedData{}
-		if err := json.NewDecoder(stdout).Decode(sys.data); err != nil {
+		payload, err := io.ReadAll(stdout)
+		if err != nil {
 			return nil, err
 		}
 		// wait for the session to complete
 		if err := session.Wait(); err != nil {
 			return nil, err
 		}
+		if err := sys.decodeStats(payload); err != nil {
+			if errors.Is(err, errNoDeltaBase) && attempt < maxRetries {
+				// a new connection makes the agent send full stats
+				sys.resetSSHClient()
+				continue
+			}
+			return nil, err
+		}
+		sys.encoding = statsEncoding(sys.data.Meta)
+		sys.checkAgentVersion()
 		return sys.data, nil
 	}
 
@@ -361,6 +385,87 @@ func (sys *System) fetchDataFromAgent() (*system.CombinedData, error) {
 	return nil, fmt.Errorf("failed to fetch data")
 }
 
+// statsEncoding returns the encoding to request from an agent with the given
+// metadata: protobuf if the agent advertises it, since it is smaller and faster
+// to decode, or JSON otherwise
+func statsEncoding(meta system.AgentMeta) string {
+	if slices.Contains(meta.Encodings, system.EncodingProtobuf) {
+		return system.EncodingProtobuf
+	}
+	return system.EncodingJSON
+}
+
+var errNoDeltaBase = errors.New("received stats delta without previous stats")
+
+// decodeStats decodes a stats payload from the agent into sys.data. Delta payloads
+// are applied to the previous payload from the same connection. Payloads that are
+// not JSON are protobuf, which starts with a varint length and field tag rather
+// than a JSON value.
+func (sys *System) decodeStats(payload []byte) error {
+	if !json.Valid(payload) {
+		*sys.data = system.CombinedData{}
+		sys.lastPayload = nil
+		return sys.data.Decode(bytes.NewReader(payload), system.EncodingProtobuf)
+	}
+	var marker struct {
+		Delta bool `json:"delta"`
+	}
+	if err := json.Unmarshal(payload, &marker); err != nil {
+		return err
+	}
+	if marker.Delta {
+		if sys.lastPayload == nil {
+			return errNoDeltaBase
+		}
+		var base, patch map[string]any
+		if err := json.Unmarshal(sys.lastPayload, &base); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(payload, &patch); err != nil {
+			return err
+		}
+		delete(patch, common.DeltaKey)
+		common.ApplyJSONPatch(base, patch)
+		var err error
+		if payload, err = json.Marshal(base); err != nil {
+			return err
+		}
+	}
+	// this is initialized in startUpdater, should never be nil
+	*sys.data = system.CombinedData{}
+	if err := json.Unmarshal(payload, sys.data); err != nil {
+		return err
+	}
+	sys.lastPayload = payload
+	return nil
+}
+
+// checkAgentVersion logs a warning when the agent is older than the hub.
+// The warning is only logged once per agent version.
+func (sys *System) checkAgentVersion() {
+	agentVersion := sys.data.Meta.Version
+	if agentVersion == "" {
+		// older agents do not send build metadata
+		agentVersion = sys.data.Info.AgentVersion
+	}
+	if agentVersion == sys.agentVersion {
+		return
+	}
+	sys.agentVersion = agentVersion
+	if isAgentOutdated(agentVersion) {
+		sys.manager.hub.Logger().Warn("Agent version is older than hub", "host", sys.Host, "agent", agentVersion, "hub", beszel.Version)
+	}
+}
+
+// isAgentOutdated returns true if the agent version is older than the hub version
+func isAgentOutdated(agentVersion string) bool {
+	v, err := semver.Parse(agentVersion)
+	if err != nil {
+		return false
+	}
+	return v.LT(semver.MustParse(beszel.Version))
+}
+
 // createSSHClientConfig initializes the ssh config for the system manager
 func (sm *SystemManager) createSSHClientConfig() error {
 	privateKey, err := sm.hub.GetSSHKey(sm.hub.DataDir())
@@ -393,6 +498,10 @@ func (s *System) createSSHClient() error {
 		host = net.JoinHostPort(host, s.Port)
 	}
 	var err error
+	// the agent sends full stats on a new connection, and may have been replaced
+	// by a version that doesn't support the encoding it advertised
+	s.lastPayload = nil
+	s.encoding = system.EncodingJSON
 	s.client, err = ssh.Dial(network, host, s.manager.sshConfig)
 	if err != nil {
 		return err
@@ -437,6 +546,7 @@ func (sys *System) resetSSHClient() {
 		sys.client.Close()
 	}
 	sys.client = nil
+	sys.lastPayload = nil
 }
 
 // deactivateAlerts finds all triggered alerts for a system and sets them to false
@@ -8,6 +8,7 @@ import (
 	"beszel/internal/entities/system"
 	"beszel/internal/hub/systems"
 	"beszel/internal/tests"
+	"bytes"
 	"fmt"
 	"sync"
 	"testing"
@@ -438,3 +439,32 @@ func TestSystemManagerIntegration(t *testing.T) {
 		assert.NoError(t, err)
 	})
 }
+
+func TestDecodeStats(t *testing.T) {
+	stats := &system.CombinedData{
+		ProtocolVersion: system.CurrentProtocolVersion,
+		Stats:           system.Stats{Cpu: 12.5, MemPct: 40.1, ExtraFs: map[string]*system.FsStats{"sdb1": {DiskTotal: 100}}},
+		Info:            system.Info{Hostname: "web-01", Cores: 8},
+		Containers:      []*container.Stats{{Name: "nginx", Cpu: 0.4}},
+	}
+
+	for _, format := range []string{system.EncodingJSON, system.EncodingProtobuf} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, stats.Encode(&buf, format))
+			decoded, err := systems.DecodeStats(buf.Bytes())
+			require.NoError(t, err)
+			assert.Equal(t, stats, decoded)
+		})
+	}
+
+	_, err := systems.DecodeStats(nil)
+	assert.Error(t, err, "empty payload")
+}
+
+func TestStatsEncoding(t *testing.T) {
+	// agents that don't advertise encodings only send JSON
+	assert.Equal(t, system.EncodingJSON, systems.StatsEncoding(system.AgentMeta{Version: "0.11.1"}))
+	assert.Equal(t, system.EncodingJSON, systems.StatsEncoding(system.AgentMeta{Encodings: []string{system.EncodingJSON}}))
+	assert.Equal(t, system.EncodingProtobuf, systems.StatsEncoding(system.AgentMeta{Encodings: []string{system.EncodingJSON, system.EncodingProtobuf}}))
+}
@@ -115,3 +115,17 @@ func (sm *SystemManager) SetSystemStatusInDB(systemID string, status string) boo
 
 	return true
 }
+
+// StatsEncoding returns the encoding requested from an agent with the given metadata
+// This is intended for testing
+func StatsEncoding(meta entities.AgentMeta) string {
+	return statsEncoding(meta)
+}
+
+// DecodeStats decodes a stats payload from an agent, as JSON or protobuf
+// This is intended for testing
+func DecodeStats(payload []byte) (*entities.CombinedData, error) {
+	sys := &System{data: &entities.CombinedData{}}
+	err := sys.decodeStats(payload)
+	return sys.data, err
+}
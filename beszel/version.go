@@ -4,3 +4,6 @@ const (
 	Version = "0.11.1"
 	AppName = "beszel"
 )
+
+// BuildTime is set at build time via -ldflags "-X beszel.BuildTime=..."
+var BuildTime string